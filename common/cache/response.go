@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/redis"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coocood/freecache"
+	cacheM "github.com/eko/gocache/lib/v4/cache"
+	"github.com/eko/gocache/lib/v4/marshaler"
+	"github.com/eko/gocache/lib/v4/store"
+	freecache_store "github.com/eko/gocache/store/freecache/v4"
+	redis_store "github.com/eko/gocache/store/redis/v4"
+	"github.com/spf13/viper"
+)
+
+// 响应缓存的内容较大，与通用缓存分开存储，未使用 Redis 时在首次使用时才分配内存
+var (
+	responseCache     *marshaler.Marshaler
+	responseCacheOnce sync.Once
+)
+
+func getResponseCache() *marshaler.Marshaler {
+	responseCacheOnce.Do(func() {
+		var client *cacheM.Cache[any]
+		if config.RedisEnabled {
+			client = cacheM.New[any](redis_store.NewRedis(redis.RDB))
+		} else {
+			size := viper.GetInt("response_cache_size")
+			if size <= 0 {
+				size = 64
+			}
+			client = cacheM.New[any](freecache_store.NewFreecache(freecache.NewCache(size * 1024 * 1024)))
+		}
+
+		responseCache = marshaler.New(client)
+	})
+
+	return responseCache
+}
+
+func GetResponseCache[T any](key string) (T, error) {
+	var val T
+	_, err := getResponseCache().Get(ctx, key, &val)
+	if err != nil {
+		if errors.Is(err, store.NotFound{}) {
+			return *new(T), CacheNotFound
+		}
+		return *new(T), err
+	}
+	return val, nil
+}
+
+func SetResponseCache(key string, value any, expiration time.Duration) error {
+	return getResponseCache().Set(ctx, key, value, store.WithExpiration(expiration))
+}
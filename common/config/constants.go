@@ -209,7 +209,16 @@ var UnifiedRequestResponseModelEnabled = false
 var ModelNameCaseInsensitiveEnabled = false
 
 var DefaultChannelWeight = uint(1)
-var RetryCooldownSeconds = 5
+var RetryCooldownSeconds = 5 // 渠道首次熔断的时长(秒)，连续熔断时按指数增长
+
+// 渠道熔断
+var CircuitBreakerFailureThreshold = 5 // 连续失败达到该次数时熔断，0 表示不按连续失败熔断
+var CircuitBreakerErrorRate = 50       // 统计窗口内错误率(%)达到该值时熔断，0 表示不按错误率熔断
+var CircuitBreakerMinRequests = 20     // 统计窗口内请求数达到该值后才计算错误率
+var CircuitBreakerWindowSeconds = 60   // 错误率统计窗口(秒)
+var CircuitBreakerMaxOpenSeconds = 600 // 熔断时长的上限(秒)
+var CircuitBreakerHalfOpenRequests = 3 // 熔断到期后放行的试探请求数，全部成功后恢复
+var CircuitBreakerNotifyEnabled = false
 
 var CFWorkerImageUrl = ""
 var CFWorkerImageKey = ""
@@ -235,6 +244,57 @@ var GeminiAPIEnabled = true
 // Claude
 var ClaudeAPIEnabled = true
 
+// Ollama
+var OllamaAPIEnabled = true
+
+// Batch API
+var BatchConcurrency = 5     // 批量任务每轮并发执行的请求数
+var BatchRPM = 60            // 批量任务每分钟最多执行的请求数，0 表示不限制
+var BatchMaxRequests = 50000 // 单个批量任务最多包含的请求数
+
+// Files API
+var FileMaxSize = 512 // 单个文件最大大小(MB)
+
+// Responses API
+var ResponsesStoreDays = 30 // 兼容模式下保存的响应保留天数，0 表示不清理
+
+// 响应缓存
+var ResponseCacheTTL = 3600     // 默认缓存时间(秒)
+var ResponseCacheMaxSize = 1024 // 单个响应最大缓存大小(KB)，超过则不缓存
+
+// 对冲请求
+var HedgeDelay = 2000    // 首个渠道在该时间(毫秒)内没有响应时，向第二个渠道发起同样的请求
+var HedgeMinDelay = 1000 // 令牌设置的等待时间(毫秒)下限，避免用户将每个请求都变成两次上游请求
+
+// 流式输出中途断开时在其他渠道续写的最大次数，0 表示不续写(默认关闭)
+var StreamFailoverTimes = 0
+
+// 结构化输出
+var StructuredOutputValidation = false // 是否校验 json_schema 格式的非流式响应(默认关闭)
+var StructuredOutputRepairTimes = 0    // 校验失败时在同一渠道上请求模型修复的次数
+
+// 网关代为执行的 MCP 工具
+var McpMaxIterations = 5   // 单次对话中最多执行工具的轮数，达到后要求模型直接给出回答
+var McpToolTimeout = 60    // 单次工具调用的默认超时时间(秒)
+var McpToolsCacheTTL = 300 // MCP 服务工具列表的缓存时间(秒)，连接在对话之间复用
+
+// 对话超出上下文长度时的裁剪
+var ContextFitKeepLast = 4            // keep_last 与 summarize 默认保留的最近轮数
+var ContextFitSummaryModel = ""       // 总结被裁剪内容使用的模型，为空时 summarize 退化为 keep_last
+var ContextFitSummaryMaxTokens = 1024 // 总结的最大输出长度
+
+// 影子流量
+var ShadowTimeout = 120        // 影子请求的超时时间(秒)
+var ShadowMaxConcurrent = 20   // 同时进行的影子请求上限，超过时不再复制流量
+var ShadowMaxResponseSize = 64 // 对比记录中保存的响应最大长度(KB)，超出部分被截断
+
+// 渠道并发已满时的排队
+var ChannelQueueTimeout = 30   // 所有渠道的并发都已满时排队等待的最长时间(秒)，0 表示不排队
+var ChannelQueueMaxSize = 1000 // 单个节点同时排队的请求上限，超过时直接返回错误
+
+// 自适应负载均衡
+var AdaptiveBalanceAlpha = 0.2 // 延迟与成功率 EWMA 的平滑系数(0-1)，越大越偏向最近的请求
+
 const (
 	RoleGuestUser  = 0
 	RoleCommonUser = 1
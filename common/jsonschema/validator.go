@@ -0,0 +1,392 @@
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 最多记录的错误数量，足够用于提示模型修复
+const maxErrors = 10
+
+// $ref 的最大展开深度，防止循环引用
+const maxRefDepth = 32
+
+// ValidationError 校验失败时返回，每条错误都带有 JSON 路径
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Errors, "; ")
+}
+
+// Validate 校验 value 是否符合 schema，schema 与 value 都是 json.Unmarshal 到 any 的结果
+// 支持结构化输出常用的关键字，format 等无法确定语义的关键字会被忽略
+func Validate(schema, value any) error {
+	v := &validator{root: schema}
+	v.validate(schema, value, "$", 0)
+	if len(v.errors) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Errors: v.errors}
+}
+
+type validator struct {
+	root   any
+	errors []string
+}
+
+func (v *validator) addError(path, format string, args ...any) {
+	if len(v.errors) < maxErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// matches 在不记录错误的情况下判断是否符合，用于 anyOf、oneOf 与 not
+func (v *validator) matches(schema, value any, depth int) bool {
+	child := &validator{root: v.root}
+	child.validate(schema, value, "$", depth)
+	return len(child.errors) == 0
+}
+
+func (v *validator) validate(schema, value any, path string, depth int) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.addError(path, "value is not allowed")
+		}
+		return
+	case map[string]any:
+		v.validateObjectSchema(s, value, path, depth)
+	}
+}
+
+func (v *validator) validateObjectSchema(schema map[string]any, value any, path string, depth int) {
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			v.addError(path, "$ref %s is nested too deeply", ref)
+			return
+		}
+		resolved, ok := v.resolveRef(ref)
+		if !ok {
+			v.addError(path, "cannot resolve $ref %s", ref)
+			return
+		}
+		v.validate(resolved, value, path, depth+1)
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		v.addError(path, "expected %s, got %s", typeNames(types), typeOf(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !containsValue(enum, value) {
+		v.addError(path, "value must be one of %s", formatValues(enum))
+	}
+
+	if constValue, ok := schema["const"]; ok && !equalValues(constValue, value) {
+		v.addError(path, "value must be %v", constValue)
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		v.validateObject(schema, val, path, depth)
+	case []any:
+		v.validateArray(schema, val, path, depth)
+	case string:
+		v.validateString(schema, val, path)
+	case float64:
+		v.validateNumber(schema, val, path)
+	}
+
+	v.validateCombinators(schema, value, path, depth)
+}
+
+func (v *validator) validateObject(schema map[string]any, value map[string]any, path string, depth int) {
+	properties, _ := schema["properties"].(map[string]any)
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, item := range required {
+			name, ok := item.(string)
+			if !ok {
+				continue
+			}
+			if _, exists := value[name]; !exists {
+				v.addError(path, "missing required property %q", name)
+			}
+		}
+	}
+
+	if minProperties, ok := toNumber(schema["minProperties"]); ok && float64(len(value)) < minProperties {
+		v.addError(path, "expected at least %v properties", minProperties)
+	}
+	if maxProperties, ok := toNumber(schema["maxProperties"]); ok && float64(len(value)) > maxProperties {
+		v.addError(path, "expected at most %v properties", maxProperties)
+	}
+
+	// 按属性名排序，保证错误信息的顺序稳定
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	additional, hasAdditional := schema["additionalProperties"]
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if propertySchema, ok := properties[name]; ok {
+			v.validate(propertySchema, value[name], propertyPath, depth)
+			continue
+		}
+
+		if !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			v.addError(path, "unexpected property %q", name)
+			continue
+		}
+		v.validate(additional, value[name], propertyPath, depth)
+	}
+}
+
+func (v *validator) validateArray(schema map[string]any, value []any, path string, depth int) {
+	if minItems, ok := toNumber(schema["minItems"]); ok && float64(len(value)) < minItems {
+		v.addError(path, "expected at least %v items", minItems)
+	}
+	if maxItems, ok := toNumber(schema["maxItems"]); ok && float64(len(value)) > maxItems {
+		v.addError(path, "expected at most %v items", maxItems)
+	}
+
+	prefixItems, _ := schema["prefixItems"].([]any)
+	// 旧版本草案中 items 为数组时与 prefixItems 含义相同
+	if tupleItems, ok := schema["items"].([]any); ok {
+		prefixItems = tupleItems
+	}
+
+	for i, item := range value {
+		itemPath := path + "[" + strconv.Itoa(i) + "]"
+		if i < len(prefixItems) {
+			v.validate(prefixItems[i], item, itemPath, depth)
+			continue
+		}
+
+		if items, ok := schema["items"]; ok {
+			if _, isTuple := items.([]any); !isTuple {
+				v.validate(items, item, itemPath, depth)
+			}
+		}
+	}
+
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := 0; i < len(value); i++ {
+			for j := i + 1; j < len(value); j++ {
+				if equalValues(value[i], value[j]) {
+					v.addError(path, "items %d and %d are identical", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateString(schema map[string]any, value string, path string) {
+	length := float64(utf8.RuneCountInString(value))
+	if minLength, ok := toNumber(schema["minLength"]); ok && length < minLength {
+		v.addError(path, "expected at least %v characters", minLength)
+	}
+	if maxLength, ok := toNumber(schema["maxLength"]); ok && length > maxLength {
+		v.addError(path, "expected at most %v characters", maxLength)
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		// RE2 不支持的正则直接跳过，避免误判
+		if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(value) {
+			v.addError(path, "value does not match pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(schema map[string]any, value float64, path string) {
+	if minimum, ok := toNumber(schema["minimum"]); ok && value < minimum {
+		v.addError(path, "value must be >= %v", minimum)
+	}
+	if maximum, ok := toNumber(schema["maximum"]); ok && value > maximum {
+		v.addError(path, "value must be <= %v", maximum)
+	}
+	if exclusiveMinimum, ok := toNumber(schema["exclusiveMinimum"]); ok && value <= exclusiveMinimum {
+		v.addError(path, "value must be > %v", exclusiveMinimum)
+	}
+	if exclusiveMaximum, ok := toNumber(schema["exclusiveMaximum"]); ok && value >= exclusiveMaximum {
+		v.addError(path, "value must be < %v", exclusiveMaximum)
+	}
+	if multipleOf, ok := toNumber(schema["multipleOf"]); ok && multipleOf > 0 {
+		quotient := value / multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.addError(path, "value must be a multiple of %v", multipleOf)
+		}
+	}
+}
+
+func (v *validator) validateCombinators(schema map[string]any, value any, path string, depth int) {
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			v.validate(sub, value, path, depth)
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.matches(sub, value, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.addError(path, "value does not match any schema in anyOf")
+		}
+	}
+
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if v.matches(sub, value, depth) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.addError(path, "value must match exactly one schema in oneOf, matched %d", count)
+		}
+	}
+
+	if not, ok := schema["not"]; ok && v.matches(not, value, depth) {
+		v.addError(path, "value must not match the schema in not")
+	}
+}
+
+// resolveRef 只支持文档内引用，如 #/$defs/step 或 #/definitions/step
+func (v *validator) resolveRef(ref string) (any, bool) {
+	if ref == "#" {
+		return v.root, true
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+
+	current := v.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]any:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []any:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func matchesType(types any, value any) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func matchesSingleType(name string, value any) bool {
+	switch name {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeOf(value) == name
+	}
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+func typeNames(types any) string {
+	if list, ok := types.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+
+	return fmt.Sprint(types)
+}
+
+func toNumber(value any) (float64, bool) {
+	number, ok := value.(float64)
+	return number, ok
+}
+
+func containsValue(values []any, value any) bool {
+	for _, item := range values {
+		if equalValues(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValues(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func formatValues(values []any) string {
+	items := make([]string, 0, len(values))
+	for _, value := range values {
+		items = append(items, fmt.Sprintf("%v", value))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
@@ -0,0 +1,208 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"done-hub/common/jsonschema"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, data string) any {
+	t.Helper()
+
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("invalid json %s: %s", data, err)
+	}
+
+	return value
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		value  string
+		errors []string
+	}{
+		{
+			name:   "integer accepts whole numbers",
+			schema: `{"type":"integer"}`,
+			value:  `3`,
+		},
+		{
+			name:   "integer accepts whole floats",
+			schema: `{"type":"integer"}`,
+			value:  `3.0`,
+		},
+		{
+			name:   "integer rejects fractions",
+			schema: `{"type":"integer"}`,
+			value:  `3.5`,
+			errors: []string{"$: expected integer, got number"},
+		},
+		{
+			name:   "number accepts fractions",
+			schema: `{"type":"number","minimum":1,"exclusiveMaximum":4}`,
+			value:  `3.5`,
+		},
+		{
+			name:   "number bounds",
+			schema: `{"type":"number","minimum":1,"exclusiveMaximum":4}`,
+			value:  `4`,
+			errors: []string{"$: value must be < 4"},
+		},
+		{
+			name:   "number rejects strings",
+			schema: `{"type":"number"}`,
+			value:  `"1"`,
+			errors: []string{"$: expected number, got string"},
+		},
+		{
+			name:   "type list allows null",
+			schema: `{"type":["string","null"]}`,
+			value:  `null`,
+		},
+		{
+			name:   "missing required property",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"required":["a","b"]}`,
+			value:  `{"a":"x"}`,
+			errors: []string{`$: missing required property "b"`},
+		},
+		{
+			name:   "additionalProperties false",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false}`,
+			value:  `{"a":"x","b":1,"c":2}`,
+			errors: []string{`$: unexpected property "b"`, `$: unexpected property "c"`},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":{"type":"integer"}}`,
+			value:  `{"a":"x","b":1,"c":"y"}`,
+			errors: []string{"$.c: expected integer, got string"},
+		},
+		{
+			name:   "additionalProperties absent allows anything",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}}}`,
+			value:  `{"a":"x","b":[1,2]}`,
+		},
+		{
+			name:   "$ref to $defs",
+			schema: `{"type":"object","properties":{"step":{"$ref":"#/$defs/step"}},"$defs":{"step":{"type":"object","properties":{"n":{"type":"integer"}},"required":["n"]}}}`,
+			value:  `{"step":{"n":"one"}}`,
+			errors: []string{"$.step.n: expected integer, got string"},
+		},
+		{
+			name:   "$ref to definitions",
+			schema: `{"type":"array","items":{"$ref":"#/definitions/item"},"definitions":{"item":{"type":"string"}}}`,
+			value:  `["a","b"]`,
+		},
+		{
+			name:   "recursive $ref",
+			schema: `{"$ref":"#/$defs/node","$defs":{"node":{"type":"object","properties":{"value":{"type":"integer"},"children":{"type":"array","items":{"$ref":"#/$defs/node"}}},"required":["value"]}}}`,
+			value:  `{"value":1,"children":[{"value":2,"children":[{"value":"x"}]}]}`,
+			errors: []string{"$.children[0].children[0].value: expected integer, got string"},
+		},
+		{
+			name:   "unresolvable $ref",
+			schema: `{"$ref":"#/$defs/missing"}`,
+			value:  `1`,
+			errors: []string{"$: cannot resolve $ref #/$defs/missing"},
+		},
+		{
+			name:   "anyOf matches one branch",
+			schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `2`,
+		},
+		{
+			name:   "anyOf matches no branch",
+			schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `true`,
+			errors: []string{"$: value does not match any schema in anyOf"},
+		},
+		{
+			name:   "oneOf matches exactly one",
+			schema: `{"oneOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `"a"`,
+		},
+		{
+			name:   "oneOf matches two",
+			schema: `{"oneOf":[{"type":"number"},{"type":"integer"}]}`,
+			value:  `2`,
+			errors: []string{"$: value must match exactly one schema in oneOf, matched 2"},
+		},
+		{
+			name:   "oneOf matches none",
+			schema: `{"oneOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `null`,
+			errors: []string{"$: value must match exactly one schema in oneOf, matched 0"},
+		},
+		{
+			name:   "prefixItems with items for the rest",
+			schema: `{"type":"array","prefixItems":[{"type":"string"},{"type":"integer"}],"items":{"type":"boolean"}}`,
+			value:  `["a",1,true,false]`,
+		},
+		{
+			name:   "prefixItems mismatch",
+			schema: `{"type":"array","prefixItems":[{"type":"string"},{"type":"integer"}],"items":{"type":"boolean"}}`,
+			value:  `[1,1,"x"]`,
+			errors: []string{"$[0]: expected string, got number", "$[2]: expected boolean, got string"},
+		},
+		{
+			name:   "tuple items from older drafts",
+			schema: `{"type":"array","items":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `["a","b","anything"]`,
+			errors: []string{"$[1]: expected integer, got string"},
+		},
+		{
+			name:   "items length limits",
+			schema: `{"type":"array","items":{"type":"integer"},"minItems":2,"uniqueItems":true}`,
+			value:  `[1]`,
+			errors: []string{"$: expected at least 2 items"},
+		},
+		{
+			name:   "enum",
+			schema: `{"type":"string","enum":["red","green"]}`,
+			value:  `"blue"`,
+			errors: []string{"$: value must be one of [red, green]"},
+		},
+		{
+			name:   "boolean false schema",
+			schema: `{"type":"object","properties":{"a":false}}`,
+			value:  `{"a":1}`,
+			errors: []string{"$.a: value is not allowed"},
+		},
+		{
+			name:   "unknown format is ignored",
+			schema: `{"type":"string","format":"email"}`,
+			value:  `"not an email"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := jsonschema.Validate(decode(t, c.schema), decode(t, c.value))
+			if len(c.errors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *jsonschema.ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, c.errors, validationErr.Errors)
+			}
+		})
+	}
+}
+
+func TestValidateLimitsErrors(t *testing.T) {
+	schema := decode(t, `{"type":"array","items":{"type":"string"}}`)
+	value := decode(t, `[1,2,3,4,5,6,7,8,9,10,11,12]`)
+
+	var validationErr *jsonschema.ValidationError
+	if assert.ErrorAs(t, jsonschema.Validate(schema, value), &validationErr) {
+		assert.Len(t, validationErr.Errors, 10)
+	}
+}
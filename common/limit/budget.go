@@ -0,0 +1,136 @@
+package limit
+
+import (
+	"context"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/redis"
+	_ "embed"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	budgetFormat = "{%s}:budget"
+	// 滑动窗口按该时间分桶统计，窗口边界的误差不超过一个分桶
+	budgetBucketSize = 5
+)
+
+var (
+	//go:embed budget.lua
+	budgetLuaScript string
+	budgetScript    = redis.NewScript(budgetLuaScript)
+)
+
+// 未启用 Redis 时在本节点内统计，key -> 分桶开始时间 -> 用量
+var (
+	memoryBudgets   = make(map[string]map[int64]int)
+	memoryBudgetsMu sync.Mutex
+)
+
+// BudgetLimiter 滑动窗口内的用量额度，与 SlidingWindowLimiter 不同的是每次可以记录任意数量(如 tokens)，
+// 并且可以在不占用额度的情况下查询已用量，或在请求结束后按实际用量修正预留的额度
+type BudgetLimiter struct {
+	limit  int           // 窗口内的额度上限
+	window time.Duration // 窗口大小
+}
+
+func NewBudgetLimiter(limit int, window time.Duration) *BudgetLimiter {
+	return &BudgetLimiter{
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Reserve 剩余额度足够时记录 n 的用量并返回 true
+func (l *BudgetLimiter) Reserve(keyPrefix string, n int) bool {
+	allowed, _ := l.run(keyPrefix, n)
+	return allowed
+}
+
+// Used 返回窗口内的已用量
+func (l *BudgetLimiter) Used(keyPrefix string) int {
+	_, used := l.run(keyPrefix, 0)
+	return used
+}
+
+// Adjust 修正用量，delta 可以为负数，不检查额度
+func (l *BudgetLimiter) Adjust(keyPrefix string, delta int) {
+	if delta == 0 {
+		return
+	}
+
+	unlimited := &BudgetLimiter{limit: math.MaxInt, window: l.window}
+	unlimited.run(keyPrefix, delta)
+}
+
+func (l *BudgetLimiter) run(keyPrefix string, n int) (bool, int) {
+	key := fmt.Sprintf(budgetFormat, keyPrefix)
+	now := time.Now().Unix()
+	windowSeconds := int64(l.window.Seconds())
+
+	if !config.RedisEnabled {
+		return l.runMemory(key, n, now, windowSeconds)
+	}
+
+	result, err := redis.ScriptRunCtx(context.Background(),
+		budgetScript,
+		[]string{key},
+		l.limit,          // ARGV[1]: 额度上限
+		windowSeconds,    // ARGV[2]: 窗口大小（秒）
+		now,              // ARGV[3]: 当前时间戳
+		n,                // ARGV[4]: 本次用量
+		budgetBucketSize, // ARGV[5]: 分桶大小（秒）
+	)
+	if err != nil {
+		// Redis 出错时放行，避免影响正常请求
+		logger.SysError(fmt.Sprintf("failed to run budget limiter %s: %s", key, err.Error()))
+		return true, 0
+	}
+
+	resultArray, ok := result.([]interface{})
+	if !ok || len(resultArray) < 2 {
+		return true, 0
+	}
+
+	allowed, _ := resultArray[0].(int64)
+	used, _ := resultArray[1].(int64)
+
+	return allowed == 1, int(used)
+}
+
+func (l *BudgetLimiter) runMemory(key string, n int, now, windowSeconds int64) (bool, int) {
+	memoryBudgetsMu.Lock()
+	defer memoryBudgetsMu.Unlock()
+
+	buckets := memoryBudgets[key]
+	used := 0
+	for bucket, amount := range buckets {
+		if bucket+budgetBucketSize <= now-windowSeconds {
+			delete(buckets, bucket)
+			continue
+		}
+		used += amount
+	}
+
+	if n > 0 && used+n > l.limit {
+		return false, used
+	}
+
+	if n != 0 {
+		if buckets == nil {
+			buckets = make(map[int64]int)
+			memoryBudgets[key] = buckets
+		}
+		buckets[now-now%budgetBucketSize] += n
+		used += n
+	}
+
+	if len(buckets) == 0 {
+		delete(memoryBudgets, key)
+	}
+
+	return true, used
+}
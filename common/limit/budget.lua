@@ -0,0 +1,39 @@
+-- KEYS[1] 作为按时间分桶保存用量的哈希表key
+-- ARGV[1] 作为窗口内的额度上限
+-- ARGV[2] 作为窗口大小(秒)
+-- ARGV[3] 作为当前时间戳(秒)
+-- ARGV[4] 作为本次用量，0 表示只查询，负数用于修正预留的用量
+-- ARGV[5] 作为分桶大小(秒)
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local amount = tonumber(ARGV[4])
+local bucketSize = tonumber(ARGV[5])
+local windowStart = now - window
+
+-- 1. 移除窗口外的分桶并统计窗口内的用量
+local used = 0
+local buckets = redis.call('HGETALL', KEYS[1])
+for i = 1, #buckets, 2 do
+  if tonumber(buckets[i]) + bucketSize <= windowStart then
+    redis.call('HDEL', KEYS[1], buckets[i])
+  else
+    used = used + tonumber(buckets[i + 1])
+  end
+end
+
+-- 2. 超出额度时不记录
+if amount > 0 and used + amount > limit then
+  return {0, used}
+end
+
+-- 3. 记录到当前分桶
+if amount ~= 0 then
+  local bucket = now - (now % bucketSize)
+  redis.call('HINCRBY', KEYS[1], bucket, amount)
+  redis.call('EXPIRE', KEYS[1], window * 2)
+  used = used + amount
+end
+
+return {1, used}
@@ -0,0 +1,164 @@
+package limit
+
+import (
+	"context"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/redis"
+	"done-hub/common/utils"
+	_ "embed"
+	"fmt"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	semaphoreFormat = "{%s}:semaphore"
+	// 租约有效期，持有期间定时续期，节点异常退出时租约到期后自动释放
+	semaphoreLeaseTTL = 60 * time.Second
+)
+
+var (
+	//go:embed semaphore.lua
+	semaphoreLuaScript string
+	semaphoreScript    = redis.NewScript(semaphoreLuaScript)
+
+	//go:embed semaphorecount.lua
+	semaphoreCountLuaScript string
+	semaphoreCountScript    = redis.NewScript(semaphoreCountLuaScript)
+)
+
+// 未启用 Redis 时在本节点内计数
+var (
+	memorySemaphores   = make(map[string]int)
+	memorySemaphoresMu sync.Mutex
+)
+
+// SemaphoreLease 占用的并发名额，使用完毕后需要调用 Release 归还
+type SemaphoreLease struct {
+	key     string
+	id      string
+	redis   bool
+	stop    chan struct{}
+	release sync.Once
+}
+
+// TryAcquireSemaphore 尝试占用一个并发名额，已达到上限时返回 nil
+// 启用 Redis 时名额在所有节点之间共享；Redis 出错时放行，避免影响正常请求
+func TryAcquireSemaphore(keyPrefix string, limit int) *SemaphoreLease {
+	key := fmt.Sprintf(semaphoreFormat, keyPrefix)
+
+	if !config.RedisEnabled {
+		memorySemaphoresMu.Lock()
+		defer memorySemaphoresMu.Unlock()
+
+		if memorySemaphores[key] >= limit {
+			return nil
+		}
+		memorySemaphores[key]++
+		return &SemaphoreLease{key: key}
+	}
+
+	lease := &SemaphoreLease{
+		key:   key,
+		id:    utils.GetUUID(),
+		redis: true,
+		stop:  make(chan struct{}),
+	}
+
+	result, err := redis.ScriptRunCtx(context.Background(),
+		semaphoreScript,
+		[]string{key},
+		limit,
+		time.Now().UnixMilli(),
+		semaphoreLeaseTTL.Milliseconds(),
+		lease.id,
+	)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to acquire semaphore %s: %s", key, err.Error()))
+		return &SemaphoreLease{}
+	}
+	if result.(int64) != 1 {
+		return nil
+	}
+
+	go lease.keepAlive()
+
+	return lease
+}
+
+// GetSemaphoreCount 返回当前占用的并发名额数量
+func GetSemaphoreCount(keyPrefix string) int {
+	key := fmt.Sprintf(semaphoreFormat, keyPrefix)
+
+	if !config.RedisEnabled {
+		memorySemaphoresMu.Lock()
+		defer memorySemaphoresMu.Unlock()
+
+		return memorySemaphores[key]
+	}
+
+	result, err := redis.ScriptRunCtx(context.Background(),
+		semaphoreCountScript,
+		[]string{key},
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return 0
+	}
+
+	count, _ := result.(int64)
+	return int(count)
+}
+
+// keepAlive 持有期间定时续期，请求时间可能远超租约有效期
+func (l *SemaphoreLease) keepAlive() {
+	ticker := time.NewTicker(semaphoreLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx := context.Background()
+			expireAt := time.Now().Add(semaphoreLeaseTTL).UnixMilli()
+			rdb := redis.GetRedisClient()
+			rdb.ZAddXX(ctx, l.key, goredis.Z{Score: float64(expireAt), Member: l.id})
+			rdb.PExpire(ctx, l.key, semaphoreLeaseTTL*2)
+		}
+	}
+}
+
+// Release 归还并发名额，可以重复调用
+func (l *SemaphoreLease) Release() {
+	if l == nil {
+		return
+	}
+
+	l.release.Do(func() {
+		// Redis 出错时放行的请求没有占用名额
+		if l.key == "" {
+			return
+		}
+
+		if !l.redis {
+			memorySemaphoresMu.Lock()
+			defer memorySemaphoresMu.Unlock()
+
+			if memorySemaphores[l.key] > 1 {
+				memorySemaphores[l.key]--
+			} else {
+				delete(memorySemaphores, l.key)
+			}
+			return
+		}
+
+		close(l.stop)
+		if err := redis.GetRedisClient().ZRem(context.Background(), l.key, l.id).Err(); err != nil {
+			logger.SysError(fmt.Sprintf("failed to release semaphore %s: %s", l.key, err.Error()))
+		}
+	})
+}
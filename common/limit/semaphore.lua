@@ -0,0 +1,19 @@
+-- KEYS[1] 作为存储并发租约的有序集合key，score 为租约的过期时间
+-- ARGV[1] 作为并发上限
+-- ARGV[2] 作为当前时间戳(毫秒)
+-- ARGV[3] 作为租约有效期(毫秒)
+-- ARGV[4] 作为租约 id
+
+-- 1. 移除已过期的租约(持有租约的节点异常退出时不会释放)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+
+-- 2. 判断是否还有名额
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+  return 0
+end
+
+-- 3. 添加租约并设置过期时间
+redis.call('ZADD', KEYS[1], tonumber(ARGV[2]) + tonumber(ARGV[3]), ARGV[4])
+redis.call('PEXPIRE', KEYS[1], tonumber(ARGV[3]) * 2)
+
+return 1
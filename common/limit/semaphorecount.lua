@@ -0,0 +1,5 @@
+-- KEYS[1] 作为存储并发租约的有序集合key
+-- ARGV[1] 作为当前时间戳(毫秒)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+return redis.call('ZCARD', KEYS[1])
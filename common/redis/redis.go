@@ -91,6 +91,16 @@ func ScriptRunCtx(ctx context.Context, script *redis.Script, keys []string, args
 	return script.Run(ctx, RDB, keys, args...).Result()
 }
 
+func RedisPublish(channel string, message string) error {
+	ctx := context.Background()
+	return RDB.Publish(ctx, channel, message).Err()
+}
+
+// RedisSubscribe 订阅频道，连接断开后会自动重连并重新订阅
+func RedisSubscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	return RDB.Subscribe(ctx, channels...)
+}
+
 func RedisExists(key string) (bool, error) {
 	ctx := context.Background()
 	exists, err := RDB.Exists(ctx, key).Result()
@@ -114,6 +124,8 @@ const (
 	StickySessionKeyPrefixGeminiCli = "sticky_session_gemini:"
 	// StickySessionKeyPrefixCodex Codex 渠道的 sticky session key 前缀
 	StickySessionKeyPrefixCodex = "sticky_session_codex:"
+	// StickySessionKeyPrefixAffinity 其他渠道按请求前缀生成的 sticky session key 前缀
+	StickySessionKeyPrefixAffinity = "sticky_session_prefix:"
 	// DefaultStickySessionTTL 默认 TTL（1小时）
 	DefaultStickySessionTTL = 1 * time.Hour
 )
@@ -129,7 +141,7 @@ func GetStickySessionKeyPrefix(channelType int) string {
 	case config.ChannelTypeCodex:
 		return StickySessionKeyPrefixCodex
 	default:
-		return StickySessionKeyPrefixClaudeCode // 默认使用 ClaudeCode 前缀
+		return StickySessionKeyPrefixAffinity // 其他渠道只在分组开启前缀亲和时使用
 	}
 }
 
@@ -0,0 +1,71 @@
+package requester
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"done-hub/types"
+)
+
+// WSBridge 用于协议与 OpenAI Realtime 不同的上游，一条消息可以转换为任意条消息并发往任意一端
+type WSBridge interface {
+	// Open 在开始转发前调用，用于向用户发送 session.created 等初始事件
+	Open(sender *WSSender) error
+	// HandleMessage 处理一条消息，返回需要计费的用量
+	HandleMessage(sender *WSSender, source MessageSource, messageType int, message []byte) (*types.UsageEvent, error)
+}
+
+// WSSender 两个方向的读协程都会写同一个连接，写操作需要加锁
+type WSSender struct {
+	userConn     *websocket.Conn
+	supplierConn *websocket.Conn
+	userLock     sync.Mutex
+	supplierLock sync.Mutex
+}
+
+func NewWSSender(userConn, supplierConn *websocket.Conn) *WSSender {
+	return &WSSender{
+		userConn:     userConn,
+		supplierConn: supplierConn,
+	}
+}
+
+func (s *WSSender) SendToUser(data any) error {
+	message, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteToUser(websocket.TextMessage, message)
+}
+
+func (s *WSSender) SendToSupplier(data any) error {
+	message, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.supplierLock.Lock()
+	defer s.supplierLock.Unlock()
+
+	return s.supplierConn.WriteMessage(websocket.TextMessage, message)
+}
+
+func (s *WSSender) WriteToUser(messageType int, message []byte) error {
+	s.userLock.Lock()
+	defer s.userLock.Unlock()
+
+	return s.userConn.WriteMessage(messageType, message)
+}
+
+// NewWSBridgeProxy 使用 WSBridge 转换两端消息的代理，连接的生命周期与 WSProxy 一致
+func NewWSBridgeProxy(userConn, supplierConn *websocket.Conn, timeout time.Duration, bridge WSBridge, usageHandler UsageHandler) *WSProxy {
+	proxy := NewWSProxy(userConn, supplierConn, timeout, nil, usageHandler)
+	proxy.bridge = bridge
+	proxy.sender = NewWSSender(userConn, supplierConn)
+
+	return proxy
+}
@@ -16,6 +16,8 @@ type WSProxy struct {
 	timeout        time.Duration
 	handler        MessageHandler
 	usageHandler   UsageHandler
+	bridge         WSBridge
+	sender         *WSSender
 	done           chan struct{}
 	userClosed     chan struct{}
 	supplierClosed chan struct{}
@@ -45,6 +47,12 @@ func NewWSProxy(userConn, supplierConn *websocket.Conn, timeout time.Duration, h
 }
 
 func (p *WSProxy) Start() {
+	if p.bridge != nil {
+		if err := p.bridge.Open(p.sender); err != nil {
+			logger.SysError(fmt.Sprintf("bridge open error: %s", err.Error()))
+		}
+	}
+
 	go p.transfer(p.userConn, p.supplierConn, UserMessage, p.userClosed)
 	go p.transfer(p.supplierConn, p.userConn, SupplierMessage, p.supplierClosed)
 }
@@ -81,6 +89,13 @@ func (p *WSProxy) transfer(src, dst *websocket.Conn, source MessageSource, close
 			return
 		}
 
+		if p.bridge != nil {
+			if !p.bridgeMessage(source, messageType, message) {
+				return
+			}
+			continue
+		}
+
 		if p.handler != nil {
 			shouldContinue, usage, newMessage, err := p.handler(source, messageType, message)
 			if err != nil {
@@ -117,3 +132,23 @@ func (p *WSProxy) transfer(src, dst *websocket.Conn, source MessageSource, close
 		}
 	}
 }
+
+// bridgeMessage 由 WSBridge 负责转换和发送消息，错误统一发送给用户
+func (p *WSProxy) bridgeMessage(source MessageSource, messageType int, message []byte) bool {
+	usage, err := p.bridge.HandleMessage(p.sender, source, messageType, message)
+	if err != nil {
+		p.sender.WriteToUser(websocket.TextMessage, []byte(err.Error()))
+		logger.SysError(fmt.Sprintf("source: %d, bridge error: %s", source, err.Error()))
+		return false
+	}
+
+	if usage != nil && p.usageHandler != nil {
+		if err := p.usageHandler(usage); err != nil {
+			p.sender.WriteToUser(websocket.TextMessage, []byte(err.Error()))
+			logger.SysError(fmt.Sprintf("source: %d, usageHandler error: %s", source, err.Error()))
+			return false
+		}
+	}
+
+	return true
+}
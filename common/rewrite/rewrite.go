@@ -0,0 +1,400 @@
+package rewrite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
+const (
+	OpSet     = "set"     // 设置字段，路径中缺失的对象会被自动创建
+	OpDefault = "default" // 字段不存在或为 null 时才设置
+	OpDelete  = "delete"  // 删除字段或数组元素
+	OpRename  = "rename"  // 在同一对象中把字段改名为 To
+)
+
+// Rule 一条改写规则，Path 使用 JSON 路径风格的选择器，例如 $.messages[*].name、$.metadata["user.id"]、$.tools[0]
+type Rule struct {
+	Phase  string   `json:"phase,omitempty"`  // request 或 response，默认为 request
+	Models []string `json:"models,omitempty"` // 生效的模型，支持 * 通配，为空时对所有模型生效
+	Op     string   `json:"op"`
+	Path   string   `json:"path"`
+	To     string   `json:"to,omitempty"`
+	Value  any      `json:"value,omitempty"`
+
+	segments []segment
+}
+
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// ParseRules 解析并校验 JSON 数组形式的规则，内容为空时返回 nil
+func ParseRules(text string) ([]*Rule, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	var rules []*Rule
+	if err := json.Unmarshal([]byte(text), &rules); err != nil {
+		return nil, fmt.Errorf("rewrite rules must be a JSON array: %s", err.Error())
+	}
+
+	for i, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d: rule is empty", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i+1, err.Error())
+		}
+	}
+
+	return rules, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Phase == "" {
+		r.Phase = PhaseRequest
+	}
+	if r.Phase != PhaseRequest && r.Phase != PhaseResponse {
+		return errors.New("phase must be request or response")
+	}
+
+	segments, err := parsePath(r.Path)
+	if err != nil {
+		return err
+	}
+
+	last := segments[len(segments)-1]
+	switch r.Op {
+	case OpSet, OpDefault, OpDelete:
+	case OpRename:
+		if last.isIndex || last.wildcard {
+			return errors.New("rename path must end with a field name")
+		}
+		if r.To == "" {
+			return errors.New("rename requires to")
+		}
+	default:
+		return errors.New("op must be set, default, delete or rename")
+	}
+
+	r.segments = segments
+	return nil
+}
+
+// Match 判断规则是否对指定阶段与模型生效
+func (r *Rule) Match(phase, modelName string) bool {
+	if r.Phase != phase {
+		return false
+	}
+	if len(r.Models) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.Models {
+		if matchModel(pattern, modelName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply 按顺序执行对当前阶段与模型生效的规则，直接修改 body，返回被修改的位置数量
+func Apply(rules []*Rule, phase, modelName string, body map[string]any) int {
+	if body == nil {
+		return 0
+	}
+
+	applied := 0
+	for _, rule := range rules {
+		if rule == nil || len(rule.segments) == 0 || !rule.Match(phase, modelName) {
+			continue
+		}
+
+		_, count := rule.apply(body, rule.segments)
+		applied += count
+	}
+
+	return applied
+}
+
+// apply 沿路径找到目标位置并执行操作，数组元素被删除时会产生新的切片，因此返回修改后的节点
+func (r *Rule) apply(node any, segments []segment) (any, int) {
+	seg := segments[0]
+	if len(segments) == 1 {
+		return r.applyLast(node, seg)
+	}
+
+	next := segments[1]
+	switch value := node.(type) {
+	case map[string]any:
+		if seg.isIndex {
+			return node, 0
+		}
+
+		keys := []string{seg.key}
+		if seg.wildcard {
+			keys = mapKeys(value)
+		}
+
+		applied := 0
+		for _, key := range keys {
+			child, exists := value[key]
+			if !exists || child == nil {
+				// 只为 set 与 default 创建缺失的对象，数组下标无法凭空创建
+				if seg.wildcard || !r.creates() || next.isIndex || next.wildcard {
+					continue
+				}
+				child = map[string]any{}
+			}
+
+			newChild, count := r.apply(child, segments[1:])
+			if count > 0 {
+				value[key] = newChild
+				applied += count
+			}
+		}
+		return value, applied
+
+	case []any:
+		if !seg.isIndex && !seg.wildcard {
+			return node, 0
+		}
+
+		indexes := arrayIndexes(value, seg)
+		applied := 0
+		for _, index := range indexes {
+			newChild, count := r.apply(value[index], segments[1:])
+			if count > 0 {
+				value[index] = newChild
+				applied += count
+			}
+		}
+		return value, applied
+	}
+
+	return node, 0
+}
+
+func (r *Rule) applyLast(node any, seg segment) (any, int) {
+	switch value := node.(type) {
+	case map[string]any:
+		if seg.isIndex {
+			return node, 0
+		}
+
+		keys := []string{seg.key}
+		if seg.wildcard {
+			keys = mapKeys(value)
+		}
+
+		applied := 0
+		for _, key := range keys {
+			current, exists := value[key]
+			switch r.Op {
+			case OpSet:
+				value[key] = cloneValue(r.Value)
+			case OpDefault:
+				if exists && current != nil {
+					continue
+				}
+				value[key] = cloneValue(r.Value)
+			case OpDelete:
+				if !exists {
+					continue
+				}
+				delete(value, key)
+			case OpRename:
+				if !exists || key == r.To {
+					continue
+				}
+				value[r.To] = current
+				delete(value, key)
+			}
+			applied++
+		}
+		return value, applied
+
+	case []any:
+		if !seg.isIndex && !seg.wildcard {
+			return node, 0
+		}
+
+		indexes := arrayIndexes(value, seg)
+		if len(indexes) == 0 {
+			return node, 0
+		}
+
+		switch r.Op {
+		case OpDelete:
+			if seg.wildcard {
+				return []any{}, len(value)
+			}
+			index := indexes[0]
+			result := make([]any, 0, len(value)-1)
+			result = append(result, value[:index]...)
+			return append(result, value[index+1:]...), 1
+		case OpSet, OpDefault:
+			applied := 0
+			for _, index := range indexes {
+				if r.Op == OpDefault && value[index] != nil {
+					continue
+				}
+				value[index] = cloneValue(r.Value)
+				applied++
+			}
+			return value, applied
+		}
+	}
+
+	return node, 0
+}
+
+func (r *Rule) creates() bool {
+	return r.Op == OpSet || r.Op == OpDefault
+}
+
+// parsePath 解析 $.a.b[0]["c.d"][*] 形式的路径，开头的 $ 可以省略
+func parsePath(path string) ([]segment, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	var segments []segment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			if i >= len(path) || path[i] == '.' || path[i] == '[' {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", path)
+			}
+			seg, err := parseBracket(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %s", path, err.Error())
+			}
+			segments = append(segments, seg)
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			key := path[i : i+end]
+			segments = append(segments, segment{key: key, wildcard: key == "*"})
+			i += end
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, errors.New("path is empty")
+	}
+
+	return segments, nil
+}
+
+func parseBracket(content string) (segment, error) {
+	content = strings.TrimSpace(content)
+	if content == "*" {
+		return segment{wildcard: true}, nil
+	}
+
+	if len(content) >= 2 && (content[0] == '"' || content[0] == '\'') && content[len(content)-1] == content[0] {
+		return segment{key: content[1 : len(content)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return segment{}, fmt.Errorf("unsupported selector [%s]", content)
+	}
+
+	return segment{index: index, isIndex: true}, nil
+}
+
+// arrayIndexes 返回命中的数组下标，负数下标从末尾开始计算
+func arrayIndexes(value []any, seg segment) []int {
+	if seg.wildcard {
+		indexes := make([]int, len(value))
+		for i := range value {
+			indexes[i] = i
+		}
+		return indexes
+	}
+
+	index := seg.index
+	if index < 0 {
+		index += len(value)
+	}
+	if index < 0 || index >= len(value) {
+		return nil
+	}
+
+	return []int{index}
+}
+
+func mapKeys(value map[string]any) []string {
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// cloneValue 同一个值可能被写入多个位置，复制后再写入避免后续规则修改时互相影响
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			result[key] = cloneValue(item)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = cloneValue(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// matchModel 支持任意位置的 * 通配，如 gpt-4*、*-preview、claude-*-sonnet
+func matchModel(pattern, name string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == name
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(name, part)
+		if index < 0 {
+			return false
+		}
+		name = name[index+len(part):]
+	}
+
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
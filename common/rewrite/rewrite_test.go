@@ -0,0 +1,238 @@
+package rewrite_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"done-hub/common/rewrite"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, data string) map[string]any {
+	t.Helper()
+
+	var value map[string]any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("invalid json %s: %s", data, err)
+	}
+
+	return value
+}
+
+func TestParseRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules string
+		count int
+		err   string
+	}{
+		{
+			name:  "empty text",
+			rules: "  ",
+		},
+		{
+			name:  "valid rules",
+			rules: `[{"op":"set","path":"$.a","value":1},{"op":"rename","path":"b","to":"c","phase":"response"}]`,
+			count: 2,
+		},
+		{
+			name:  "not an array",
+			rules: `{"op":"set","path":"$.a"}`,
+			err:   "rewrite rules must be a JSON array",
+		},
+		{
+			name:  "null rule",
+			rules: `[null]`,
+			err:   "rule 1: rule is empty",
+		},
+		{
+			name:  "unknown op",
+			rules: `[{"op":"set","path":"$.a"},{"op":"replace","path":"$.a"}]`,
+			err:   "rule 2: op must be set, default, delete or rename",
+		},
+		{
+			name:  "unknown phase",
+			rules: `[{"op":"set","path":"$.a","phase":"both"}]`,
+			err:   "rule 1: phase must be request or response",
+		},
+		{
+			name:  "empty path",
+			rules: `[{"op":"delete","path":"$"}]`,
+			err:   "rule 1: path is empty",
+		},
+		{
+			name:  "unclosed bracket",
+			rules: `[{"op":"delete","path":"$.a[0"}]`,
+			err:   `rule 1: invalid path ".a[0": missing ]`,
+		},
+		{
+			name:  "unsupported selector",
+			rules: `[{"op":"delete","path":"$.a[?(@.b)]"}]`,
+			err:   `rule 1: invalid path ".a[?(@.b)]": unsupported selector [?(@.b)]`,
+		},
+		{
+			name:  "rename to an index",
+			rules: `[{"op":"rename","path":"$.a[0]","to":"b"}]`,
+			err:   "rule 1: rename path must end with a field name",
+		},
+		{
+			name:  "rename without to",
+			rules: `[{"op":"rename","path":"$.a"}]`,
+			err:   "rule 1: rename requires to",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := rewrite.ParseRules(c.rules)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, rules, c.count)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		name     string
+		rules    string
+		phase    string
+		model    string
+		body     string
+		expected string
+		applied  int
+	}{
+		{
+			name:     "set creates missing objects",
+			rules:    `[{"op":"set","path":"$.metadata.user.id","value":"u1"}]`,
+			body:     `{"model":"gpt-4o"}`,
+			expected: `{"model":"gpt-4o","metadata":{"user":{"id":"u1"}}}`,
+			applied:  1,
+		},
+		{
+			name:     "default keeps existing values",
+			rules:    `[{"op":"default","path":"$.temperature","value":0.2},{"op":"default","path":"$.top_p","value":1}]`,
+			body:     `{"temperature":0.7,"top_p":null}`,
+			expected: `{"temperature":0.7,"top_p":1}`,
+			applied:  1,
+		},
+		{
+			name:     "delete with wildcard in an array",
+			rules:    `[{"op":"delete","path":"$.messages[*].name"}]`,
+			body:     `{"messages":[{"role":"user","name":"a","content":"hi"},{"role":"assistant","content":"hello"}]}`,
+			expected: `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`,
+			applied:  1,
+		},
+		{
+			name:     "delete an array element with a negative index",
+			rules:    `[{"op":"delete","path":"$.tools[-1]"}]`,
+			body:     `{"tools":[1,2,3]}`,
+			expected: `{"tools":[1,2]}`,
+			applied:  1,
+		},
+		{
+			name:     "rename a field",
+			rules:    `[{"op":"rename","path":"$.max_tokens","to":"max_completion_tokens"}]`,
+			body:     `{"max_tokens":100}`,
+			expected: `{"max_completion_tokens":100}`,
+			applied:  1,
+		},
+		{
+			name:     "quoted keys with dots",
+			rules:    `[{"op":"set","path":"$.metadata[\"user.id\"]","value":"u1"}]`,
+			body:     `{"metadata":{}}`,
+			expected: `{"metadata":{"user.id":"u1"}}`,
+			applied:  1,
+		},
+		{
+			name:     "index out of range is ignored",
+			rules:    `[{"op":"set","path":"$.tools[5].type","value":"function"}]`,
+			body:     `{"tools":[{"type":"x"}]}`,
+			expected: `{"tools":[{"type":"x"}]}`,
+		},
+		{
+			name:     "set into every array element",
+			rules:    `[{"op":"set","path":"$.tools[*].type","value":"function"}]`,
+			body:     `{"tools":[{"type":"x"},{}]}`,
+			expected: `{"tools":[{"type":"function"},{"type":"function"}]}`,
+			applied:  2,
+		},
+		{
+			name:     "rules run in order",
+			rules:    `[{"op":"rename","path":"$.a","to":"b"},{"op":"set","path":"$.b.c","value":true}]`,
+			body:     `{"a":{}}`,
+			expected: `{"b":{"c":true}}`,
+			applied:  2,
+		},
+		{
+			name:     "models filter skips other models",
+			rules:    `[{"op":"delete","path":"$.temperature","models":["o1*","*-reasoner"]}]`,
+			model:    "gpt-4o",
+			body:     `{"temperature":1}`,
+			expected: `{"temperature":1}`,
+		},
+		{
+			name:     "models filter with wildcards",
+			rules:    `[{"op":"delete","path":"$.temperature","models":["o1*","*-reasoner"]}]`,
+			model:    "deepseek-reasoner",
+			body:     `{"temperature":1}`,
+			expected: `{}`,
+			applied:  1,
+		},
+		{
+			name:     "response rules do not run on requests",
+			rules:    `[{"op":"delete","path":"$.usage","phase":"response"}]`,
+			body:     `{"usage":{}}`,
+			expected: `{"usage":{}}`,
+		},
+		{
+			name:     "response phase",
+			rules:    `[{"op":"delete","path":"$.usage.prompt_tokens_details","phase":"response"}]`,
+			phase:    rewrite.PhaseResponse,
+			body:     `{"usage":{"prompt_tokens":1,"prompt_tokens_details":{}}}`,
+			expected: `{"usage":{"prompt_tokens":1}}`,
+			applied:  1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := rewrite.ParseRules(c.rules)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			phase := c.phase
+			if phase == "" {
+				phase = rewrite.PhaseRequest
+			}
+			body := decode(t, c.body)
+
+			assert.Equal(t, c.applied, rewrite.Apply(rules, phase, c.model, body))
+			assert.Equal(t, decode(t, c.expected), body)
+		})
+	}
+}
+
+func TestApplyClonesValues(t *testing.T) {
+	rules, err := rewrite.ParseRules(`[{"op":"set","path":"$.a","value":{"x":1}},{"op":"set","path":"$.b","value":{"x":1}},{"op":"set","path":"$.a.x","value":2}]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	body := map[string]any{}
+	rewrite.Apply(rules, rewrite.PhaseRequest, "", body)
+	assert.Equal(t, decode(t, `{"a":{"x":2},"b":{"x":1}}`), body)
+
+	// 规则中的值不会被修改，下一次请求仍然使用原值
+	body = map[string]any{}
+	rewrite.Apply(rules[:1], rewrite.PhaseRequest, "", body)
+	assert.Equal(t, decode(t, `{"a":{"x":1}}`), body)
+}
@@ -0,0 +1,59 @@
+package drives
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LocalDrive 本地磁盘存储，只用于网关托管的文件，不参与图床上传
+type LocalDrive struct {
+	BasePath string
+}
+
+func NewLocalDrive(basePath string) *LocalDrive {
+	return &LocalDrive{
+		BasePath: basePath,
+	}
+}
+
+func (l *LocalDrive) Name() string {
+	return "Local"
+}
+
+// getPath 将 key 限制在 BasePath 目录内，防止路径穿越
+func (l *LocalDrive) getPath(key string) string {
+	return filepath.Join(l.BasePath, filepath.Clean("/"+key))
+}
+
+func (l *LocalDrive) Put(key string, data []byte) error {
+	path := l.getPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LocalDrive) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(l.getPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+
+	return data, nil
+}
+
+func (l *LocalDrive) Delete(key string) error {
+	err := os.Remove(l.getPath(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("deleting file: %w", err)
+	}
+
+	return nil
+}
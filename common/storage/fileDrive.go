@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// FileDrive 网关托管文件使用的存储，与图床不同，需要支持读取与删除
+type FileDrive interface {
+	Name() string
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+var ErrNoFileDrive = errors.New("no file storage is configured")
+
+var (
+	fileDrives      = make(map[string]FileDrive)
+	fileDriveOrder  = make([]string, 0)
+	fileDrivesMutex sync.RWMutex
+)
+
+func AddFileDrive(drive FileDrive) {
+	if drive == nil {
+		return
+	}
+
+	fileDrivesMutex.Lock()
+	defer fileDrivesMutex.Unlock()
+
+	driveName := drive.Name()
+	if _, ok := fileDrives[driveName]; ok {
+		return
+	}
+	fileDrives[driveName] = drive
+	fileDriveOrder = append(fileDriveOrder, driveName)
+}
+
+// GetFileDrive 根据名称获取存储，用于读取已保存文件所在的存储
+func GetFileDrive(name string) (FileDrive, error) {
+	fileDrivesMutex.RLock()
+	defer fileDrivesMutex.RUnlock()
+
+	drive, ok := fileDrives[name]
+	if !ok {
+		return nil, errors.New("file storage " + name + " is not available")
+	}
+
+	return drive, nil
+}
+
+// DefaultFileDrive 新文件写入的存储，优先使用 storage.files.drive 指定的存储，否则按注册顺序取第一个
+func DefaultFileDrive() (FileDrive, error) {
+	if name := viper.GetString("storage.files.drive"); name != "" {
+		return GetFileDrive(name)
+	}
+
+	fileDrivesMutex.RLock()
+	defer fileDrivesMutex.RUnlock()
+
+	if len(fileDriveOrder) == 0 {
+		return nil, ErrNoFileDrive
+	}
+
+	return fileDrives[fileDriveOrder[0]], nil
+}
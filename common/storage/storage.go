@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"done-hub/common/config"
+	"done-hub/common/logger"
 	"done-hub/common/storage/drives"
 
 	"github.com/spf13/viper"
@@ -11,6 +13,7 @@ type Storage struct {
 }
 
 func InitStorage() {
+	InitLocalStorage()
 	InitImgurStorage()
 	InitSMStorage()
 	InitALIOSSStorage()
@@ -38,6 +41,7 @@ func InitALIOSSStorage() {
 
 	aliUpload := drives.NewAliOSSUpload(endpoint, accessKeyId, accessKeySecret, bucketName)
 	AddStorageDrive(aliUpload)
+	AddFileDrive(aliUpload)
 }
 
 func InitSMStorage() {
@@ -86,4 +90,21 @@ func InitS3Storage() {
 
 	s3Upload := drives.NewS3Upload(endpoint, accessKeyId, accessKeySecret, bucketName, cdnurl, expirationDays)
 	AddStorageDrive(s3Upload)
+	AddFileDrive(s3Upload)
+}
+
+// InitLocalStorage 本地磁盘只用于保存 Files API 上传的文件
+// 多节点部署时各节点必须挂载同一个共享目录并设置 storage.local.shared，否则从节点不启用本地存储
+func InitLocalStorage() {
+	path := viper.GetString("storage.local.path")
+	if path == "" {
+		return
+	}
+
+	if !config.IsMasterNode && !viper.GetBool("storage.local.shared") {
+		logger.SysError("storage.local is ignored on slave node, set storage.local.shared when the path is shared by all nodes")
+		return
+	}
+
+	AddFileDrive(drives.NewLocalDrive(path))
 }
@@ -71,3 +71,28 @@ func TestImgurUpload(t *testing.T) {
 	fmt.Println(err)
 	assert.Nil(t, err)
 }
+
+func TestLocalDrive(t *testing.T) {
+	basePath := t.TempDir()
+	localDrive := drives.NewLocalDrive(basePath)
+
+	err := localDrive.Put("files/1/file-abc", []byte("hello"))
+	assert.Nil(t, err)
+
+	data, err := localDrive.Get("files/1/file-abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// 路径穿越会被限制在存储目录内
+	err = localDrive.Put("../../outside", []byte("x"))
+	assert.Nil(t, err)
+	data, err = localDrive.Get("outside")
+	assert.Nil(t, err)
+	assert.Equal(t, "x", string(data))
+
+	assert.Nil(t, localDrive.Delete("files/1/file-abc"))
+	assert.Nil(t, localDrive.Delete("files/1/file-abc"))
+
+	_, err = localDrive.Get("files/1/file-abc")
+	assert.NotNil(t, err)
+}
@@ -2,12 +2,14 @@ package controller
 
 import (
 	"done-hub/common"
+	"done-hub/common/rewrite"
 	"done-hub/common/utils"
 	"done-hub/model"
 	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -65,8 +67,40 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := channel.GetRewriteRules(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := channel.GetRateLimits(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := channel.GetSchedules(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validateKeySelection(channel.KeySelection); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = utils.GetTimestamp()
-	keys := strings.Split(channel.Key, "\n")
+	// 多密钥模式下所有密钥保存在同一个渠道中，否则每行一个密钥创建一个渠道
+	keys := []string{channel.Key}
+	if !channel.MultiKey {
+		keys = strings.Split(channel.Key, "\n")
+	}
 
 	baseUrls := []string{}
 	if channel.BaseURL != nil && *channel.BaseURL != "" {
@@ -164,6 +198,34 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := channel.GetRewriteRules(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := channel.GetRateLimits(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := channel.GetSchedules(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validateKeySelection(channel.KeySelection); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	if channel.Models == "" {
 		err = channel.Update(false)
 	} else {
@@ -183,6 +245,245 @@ func UpdateChannel(c *gin.Context) {
 	})
 }
 
+type RewriteDryRunRequest struct {
+	ChannelId int            `json:"channel_id"`
+	Rules     *string        `json:"rules"` // 传入时使用该规则，便于保存前调试
+	Phase     string         `json:"phase"`
+	Model     string         `json:"model"`
+	Payload   map[string]any `json:"payload" binding:"required"`
+}
+
+// GetChannelBalanceWeights 返回分组下渠道当前的负载均衡权重与延迟、成功率统计
+func GetChannelBalanceWeights(c *gin.Context) {
+	group := c.Query("group")
+	if group == "" {
+		common.APIRespondWithError(c, http.StatusOK, errors.New("group is required"))
+		return
+	}
+
+	weights, err := model.ChannelGroup.GetBalanceWeights(group, c.Query("model"))
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"strategy": model.GlobalUserGroupRatio.GetBalanceStrategy(group),
+			"models":   weights,
+		},
+	})
+}
+
+// GetChannelCacheStats 返回各渠道上游提示词缓存的命中率，按是否经粘性路由选中分别统计
+func GetChannelCacheStats(c *gin.Context) {
+	group := c.Query("group")
+
+	data := gin.H{
+		"channels": model.GetPrefixCacheStats(group),
+	}
+	if group != "" {
+		data["prefix_affinity"] = model.GlobalUserGroupRatio.IsPrefixAffinityEnabled(group)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+// GetChannelScheduleCalendar 返回渠道在指定时间是否处于可用时间窗口内，time 为 Unix 时间戳，不传时使用当前时间
+func GetChannelScheduleCalendar(c *gin.Context) {
+	at := time.Now()
+	if value := c.Query("time"); value != "" {
+		timestamp, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			common.APIRespondWithError(c, http.StatusOK, errors.New("time must be a unix timestamp"))
+			return
+		}
+		at = time.Unix(timestamp, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"time":     at.Unix(),
+			"channels": model.ChannelGroup.GetScheduleCalendar(at, c.Query("group"), c.Query("model")),
+		},
+	})
+}
+
+// GetChannelRateLimit 返回渠道 RPM/TPM 额度的当前用量与剩余额度
+func GetChannelRateLimit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	channel, err := model.GetChannelById(id)
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	status, err := channel.GetRateLimitStatus()
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    status,
+	})
+}
+
+func validateKeySelection(keySelection string) error {
+	switch keySelection {
+	case "", model.KeySelectionRoundRobin, model.KeySelectionRandom:
+		return nil
+	}
+	return errors.New("key_selection must be round_robin or random")
+}
+
+// GetChannelKeys 返回多密钥渠道中各个密钥的脱敏信息与统计
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	channel, err := model.GetChannelById(id)
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+	if !channel.MultiKey {
+		common.APIRespondWithError(c, http.StatusOK, errors.New("渠道未开启多密钥模式"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key_selection": channel.KeySelection,
+			"keys":          channel.GetKeyInfos(),
+		},
+	})
+}
+
+type ChannelKeyStatusRequest struct {
+	Index    int  `json:"index"`
+	Disabled bool `json:"disabled"`
+}
+
+// UpdateChannelKeyStatus 手动禁用或启用多密钥渠道中的单个密钥
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	request := ChannelKeyStatusRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	channel, err := model.GetChannelById(id)
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+	if !channel.MultiKey {
+		common.APIRespondWithError(c, http.StatusOK, errors.New("渠道未开启多密钥模式"))
+		return
+	}
+
+	if request.Disabled {
+		err = channel.DisableKey(request.Index, "手动禁用")
+	} else {
+		err = channel.EnableKey(request.Index)
+	}
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// DryRunChannelRewrite 使用渠道或传入的改写规则处理示例请求体/响应体，返回改写后的结果
+func DryRunChannelRewrite(c *gin.Context) {
+	request := RewriteDryRunRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	rulesText := ""
+	if request.Rules != nil {
+		rulesText = *request.Rules
+	} else {
+		if request.ChannelId == 0 {
+			common.APIRespondWithError(c, http.StatusOK, errors.New("channel_id or rules is required"))
+			return
+		}
+		channel, err := model.GetChannelById(request.ChannelId)
+		if err != nil {
+			common.APIRespondWithError(c, http.StatusOK, err)
+			return
+		}
+		if channel.RewriteRules != nil {
+			rulesText = *channel.RewriteRules
+		}
+	}
+
+	rules, err := rewrite.ParseRules(rulesText)
+	if err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
+	phase := request.Phase
+	if phase == "" {
+		phase = rewrite.PhaseRequest
+	}
+	if phase != rewrite.PhaseRequest && phase != rewrite.PhaseResponse {
+		common.APIRespondWithError(c, http.StatusOK, errors.New("phase must be request or response"))
+		return
+	}
+
+	modelName := request.Model
+	if modelName == "" {
+		modelName, _ = request.Payload["model"].(string)
+	}
+
+	applied := rewrite.Apply(rules, phase, modelName, request.Payload)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"phase":   phase,
+			"model":   modelName,
+			"applied": applied,
+			"payload": request.Payload,
+		},
+	})
+}
+
 func BatchUpdateChannelsAzureApi(c *gin.Context) {
 	var params model.BatchChannelsParams
 	err := c.ShouldBindJSON(&params)
@@ -73,6 +73,11 @@ func UpdateChannelsTag(c *gin.Context) {
 		return
 	}
 
+	if _, err := channel.GetRewriteRules(); err != nil {
+		common.APIRespondWithError(c, http.StatusOK, err)
+		return
+	}
+
 	err = model.UpdateChannelsTag(tag, &channel)
 	if err != nil {
 		common.APIRespondWithError(c, http.StatusOK, err)
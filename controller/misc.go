@@ -64,6 +64,7 @@ func GetStatus(c *gin.Context) {
 			"UptimeEnabled":        config.UPTIMEKUMA_ENABLE,
 			"GeminiAPIEnabled":     config.GeminiAPIEnabled,
 			"ClaudeAPIEnabled":     config.ClaudeAPIEnabled,
+			"OllamaAPIEnabled":     config.OllamaAPIEnabled,
 		},
 	})
 }
@@ -6,6 +6,7 @@ import (
 	"done-hub/common/utils"
 	"done-hub/model"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -310,5 +311,10 @@ func validateTokenSetting(setting *model.TokenSetting) error {
 		}
 	}
 
+	// 落败的对冲请求不向用户计费，等待时间不能低于系统设置的下限
+	if setting.Hedge.Enabled && setting.Hedge.Delay > 0 && setting.Hedge.Delay < config.HedgeMinDelay {
+		return fmt.Errorf("hedge delay must be at least %d ms", config.HedgeMinDelay)
+	}
+
 	return nil
 }
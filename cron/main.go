@@ -5,6 +5,7 @@ import (
 	"done-hub/common/logger"
 	"done-hub/common/scheduler"
 	"done-hub/model"
+	"fmt"
 	"github.com/spf13/viper"
 	"time"
 
@@ -12,13 +13,25 @@ import (
 )
 
 func InitCron() {
+	// 渠道时间窗口的状态保存在各个节点的内存中，所有节点都需要刷新
+	err := scheduler.Manager.AddJob(
+		"refresh_channel_schedules",
+		gocron.CronJob("* * * * *", false),
+		gocron.NewTask(func() {
+			model.ChannelGroup.RefreshSchedules()
+		}),
+	)
+	if err != nil {
+		logger.SysError("Cron job error: " + err.Error())
+	}
+
 	if !config.IsMasterNode {
 		logger.SysLog("Cron is disabled on slave node")
 		return
 	}
 
 	// 添加每日统计任务
-	err := scheduler.Manager.AddJob(
+	err = scheduler.Manager.AddJob(
 		"update_daily_statistics",
 		gocron.DailyJob(
 			1,
@@ -59,6 +72,24 @@ func InitCron() {
 		}),
 	)
 
+	// 每天清理超过保留天数的兼容模式 Responses 对象
+	err = scheduler.Manager.AddJob(
+		"clean_stored_responses",
+		gocron.DailyJob(1, gocron.NewAtTimes(gocron.NewAtTime(3, 0, 0))),
+		gocron.NewTask(func() {
+			if config.ResponsesStoreDays <= 0 {
+				return
+			}
+			before := time.Now().AddDate(0, 0, -config.ResponsesStoreDays).Unix()
+			count, err := model.DeleteExpiredStoredResponses(before)
+			if err != nil {
+				logger.SysError("Clean stored responses error: " + err.Error())
+				return
+			}
+			logger.SysLog(fmt.Sprintf("清理过期的 Responses 对象 %d 条", count))
+		}),
+	)
+
 	// 开启自动更新 并且设置了有效自动更新时间 同时自动更新模式不是system 则会从服务器拉取最新价格表
 	autoPriceUpdatesInterval := viper.GetInt("auto_price_updates_interval")
 	autoPriceUpdates := viper.GetBool("auto_price_updates")
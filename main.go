@@ -17,6 +17,7 @@ import (
 	"done-hub/cron"
 	"done-hub/middleware"
 	"done-hub/model"
+	"done-hub/relay/batch"
 	"done-hub/relay/task"
 	"done-hub/router"
 	"done-hub/safty"
@@ -82,6 +83,8 @@ func main() {
 
 	initMemoryCache()
 	initSync()
+	// 通过 Redis 同步各节点的渠道状态
+	model.InitClusterSync()
 
 	common.InitTokenEncoders()
 	requester.InitHttpClient()
@@ -91,6 +94,7 @@ func main() {
 
 	controller.InitMidjourneyTask()
 	task.InitTask()
+	batch.InitBatch()
 	notify.InitNotifier()
 	cron.InitCron()
 	storage.InitStorage()
@@ -180,6 +184,8 @@ func SyncChannelCache(frequency int) {
 		model.PricingInstance.Init()
 		model.ModelOwnedBysInstance.Load()
 		model.GlobalUserGroupRatio.Load()
+		model.GlobalVirtualModels.Load()
+		model.GlobalShadowRules.Load()
 	}
 }
 
@@ -0,0 +1,154 @@
+package remote
+
+import (
+	"context"
+	"done-hub/common/config"
+	"done-hub/model"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ThinkInAIXYZ/go-mcp/client"
+	"github.com/ThinkInAIXYZ/go-mcp/protocol"
+)
+
+// pooledServer 复用同一服务的连接，工具列表在 McpToolsCacheTTL 内直接使用缓存
+type pooledServer struct {
+	sync.Mutex
+	updatedAt int64
+	client    *client.Client
+	tools     []*protocol.Tool
+	expiresAt time.Time
+	usedAt    time.Time
+	removed   bool
+}
+
+var pool = struct {
+	sync.Mutex
+	servers map[int]*pooledServer
+}{servers: make(map[int]*pooledServer)}
+
+// getServer 返回服务的连接与工具列表，服务配置更新后重新连接，缓存过期后通过已有连接刷新工具列表
+func getServer(ctx context.Context, server *model.McpServer) (*client.Client, []*protocol.Tool, error) {
+	pruneIdle()
+
+	entry := lockServer(server.Id)
+	defer entry.Unlock()
+
+	now := time.Now()
+	entry.usedAt = now
+
+	if entry.client != nil && entry.updatedAt != server.UpdatedAt {
+		entry.close()
+	}
+	if entry.client != nil && now.Before(entry.expiresAt) {
+		return entry.client, entry.tools, nil
+	}
+
+	if entry.client != nil {
+		listCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		result, err := entry.client.ListTools(listCtx)
+		cancel()
+		if err == nil {
+			entry.tools = result.Tools
+			entry.expiresAt = now.Add(toolsCacheTTL())
+			return entry.client, entry.tools, nil
+		}
+		entry.close()
+	}
+
+	mcpClient, tools, err := ListTools(ctx, server)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry.updatedAt = server.UpdatedAt
+	entry.client = mcpClient
+	entry.tools = tools
+	entry.expiresAt = now.Add(toolsCacheTTL())
+
+	return entry.client, entry.tools, nil
+}
+
+// lockServer 返回已加锁的缓存项，拿到锁之前已被清理的缓存项会重新创建
+func lockServer(serverId int) *pooledServer {
+	for {
+		pool.Lock()
+		entry, ok := pool.servers[serverId]
+		if !ok {
+			entry = &pooledServer{}
+			pool.servers[serverId] = entry
+		}
+		pool.Unlock()
+
+		entry.Lock()
+		if !entry.removed {
+			return entry
+		}
+		entry.Unlock()
+	}
+}
+
+// Evict 断开服务的连接，服务被修改或删除后调用
+func Evict(serverId int) {
+	pool.Lock()
+	entry, ok := pool.servers[serverId]
+	pool.Unlock()
+
+	if ok {
+		entry.Lock()
+		entry.close()
+		entry.Unlock()
+	}
+}
+
+// evictBroken 调用失败且不是超时或取消导致时，认为连接已断开，下一次对话重新连接
+func evictBroken(serverId int, mcpClient *client.Client, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	pool.Lock()
+	entry, ok := pool.servers[serverId]
+	pool.Unlock()
+	if !ok {
+		return
+	}
+
+	entry.Lock()
+	if entry.client == mcpClient {
+		entry.close()
+	}
+	entry.Unlock()
+}
+
+// pruneIdle 断开长时间没有使用的连接，正在使用中的服务跳过
+func pruneIdle() {
+	idle := max(toolsCacheTTL(), connectTimeout) * 2
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	for id, entry := range pool.servers {
+		if !entry.TryLock() {
+			continue
+		}
+		if time.Since(entry.usedAt) > idle {
+			entry.close()
+			entry.removed = true
+			delete(pool.servers, id)
+		}
+		entry.Unlock()
+	}
+}
+
+func (e *pooledServer) close() {
+	if e.client != nil {
+		e.client.Close()
+	}
+	e.client = nil
+	e.tools = nil
+}
+
+func toolsCacheTTL() time.Duration {
+	return time.Duration(config.McpToolsCacheTTL) * time.Second
+}
@@ -0,0 +1,286 @@
+package remote
+
+import (
+	"context"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThinkInAIXYZ/go-mcp/client"
+	"github.com/ThinkInAIXYZ/go-mcp/protocol"
+	"github.com/ThinkInAIXYZ/go-mcp/transport"
+)
+
+// 连接与初始化 MCP 服务的超时时间
+const connectTimeout = 10 * time.Second
+
+// 暴露给模型的工具名只能包含字母、数字、下划线与短横线，最长 64 个字符
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+const maxToolNameLength = 64
+
+// Tool 远程 MCP 服务提供的工具，Name 为暴露给模型的名称，带有服务 id 前缀避免不同服务的同名工具冲突
+type Tool struct {
+	Name   string
+	Server *model.McpServer
+	Tool   *protocol.Tool
+}
+
+// Session 一次对话中使用的所有 MCP 服务，连接由连接池管理，对话结束后不需要断开
+type Session struct {
+	clients map[int]*client.Client
+	tools   []*Tool
+	toolMap map[string]*Tool
+}
+
+// Connect 并发获取所有服务的连接与工具列表，连接失败的服务会被跳过，不影响对话本身
+func Connect(ctx context.Context, servers []*model.McpServer) *Session {
+	session := &Session{
+		clients: make(map[int]*client.Client),
+		toolMap: make(map[string]*Tool),
+	}
+
+	type connected struct {
+		client *client.Client
+		tools  []*protocol.Tool
+	}
+	results := make([]*connected, len(servers))
+
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *model.McpServer) {
+			defer wg.Done()
+
+			mcpClient, tools, err := getServer(ctx, server)
+			if err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("mcp_server_unavailable server_id=%d name=%s error=\"%s\"", server.Id, server.Name, err.Error()))
+				return
+			}
+			results[i] = &connected{client: mcpClient, tools: tools}
+		}(i, server)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+
+		server := servers[i]
+		session.clients[server.Id] = result.client
+		for _, tool := range result.tools {
+			name := toolName(server.Id, tool.Name)
+			if _, exists := session.toolMap[name]; exists {
+				continue
+			}
+
+			item := &Tool{Name: name, Server: server, Tool: tool}
+			session.tools = append(session.tools, item)
+			session.toolMap[name] = item
+		}
+	}
+
+	return session
+}
+
+// ListTools 连接服务并返回工具列表，返回的 client 由调用方负责关闭
+func ListTools(ctx context.Context, server *model.McpServer) (*client.Client, []*protocol.Tool, error) {
+	mcpClient, err := newClient(server)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	result, err := mcpClient.ListTools(listCtx)
+	if err != nil {
+		mcpClient.Close()
+		return nil, nil, err
+	}
+
+	return mcpClient, result.Tools, nil
+}
+
+// Parameters 以 map 形式返回工具的参数 schema，部分渠道在转换请求时要求参数为 map
+func (t *Tool) Parameters() map[string]any {
+	parameters := map[string]any{"type": "object", "properties": map[string]any{}}
+	body, err := json.Marshal(t.Tool.InputSchema)
+	if err != nil {
+		return parameters
+	}
+	_ = json.Unmarshal(body, &parameters)
+	if properties, ok := parameters["properties"]; !ok || properties == nil {
+		parameters["properties"] = map[string]any{}
+	}
+
+	return parameters
+}
+
+func (s *Session) Tools() []*Tool {
+	return s.tools
+}
+
+func (s *Session) GetTool(name string) *Tool {
+	return s.toolMap[name]
+}
+
+// Call 调用工具并把结果转换为文本，工具返回 isError 时结果文本作为错误返回
+func (s *Session) Call(ctx context.Context, name, arguments string) (string, error) {
+	tool := s.toolMap[name]
+	if tool == nil {
+		return "", fmt.Errorf("tool %s not found", name)
+	}
+
+	mcpClient := s.clients[tool.Server.Id]
+	if mcpClient == nil {
+		return "", fmt.Errorf("mcp server %s is not connected", tool.Server.Name)
+	}
+
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return "", errors.New("tool arguments are not valid JSON")
+	}
+
+	timeout := config.McpToolTimeout
+	if tool.Server.Timeout > 0 {
+		timeout = tool.Server.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	result, err := mcpClient.CallTool(ctx, protocol.NewCallToolRequestWithRawArguments(tool.Tool.Name, json.RawMessage(arguments)))
+	if err != nil {
+		evictBroken(tool.Server.Id, mcpClient, err)
+		return "", err
+	}
+
+	text := contentText(result.Content)
+	if result.IsError {
+		return "", errors.New(text)
+	}
+
+	return text, nil
+}
+
+func newClient(server *model.McpServer) (*client.Client, error) {
+	httpClient := &http.Client{
+		Transport: &headerTransport{
+			headers: server.GetHeaders(),
+			base:    http.DefaultTransport,
+		},
+	}
+
+	var mcpTransport transport.ClientTransport
+	var err error
+	switch server.Type {
+	case model.McpServerTypeStreamable:
+		mcpTransport, err = transport.NewStreamableHTTPClientTransport(server.Url,
+			transport.WithStreamableHTTPClientOptionHTTPClient(httpClient),
+			transport.WithStreamableHTTPClientOptionLogger(clientLogger{}),
+		)
+	default:
+		mcpTransport, err = transport.NewSSEClientTransport(server.Url,
+			transport.WithSSEClientOptionHTTPClient(httpClient),
+			transport.WithSSEClientOptionLogger(clientLogger{}),
+			// 默认会无限重连，这里只尝试一次，连接断开后由下一次对话重新连接
+			transport.WithRetryFunc(func(operation func() error) {
+				_ = operation()
+			}),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mcpClient, err := client.NewClient(mcpTransport,
+		client.WithClientInfo(&protocol.Implementation{
+			Name:    config.SystemName + "-MCP CLIENT",
+			Version: config.Version,
+		}),
+		client.WithInitTimeout(connectTimeout),
+		client.WithLogger(clientLogger{}),
+	)
+	if err != nil {
+		mcpTransport.Close()
+		return nil, err
+	}
+
+	return mcpClient, nil
+}
+
+func toolName(serverId int, name string) string {
+	name = fmt.Sprintf("mcp_%d_%s", serverId, invalidToolNameChars.ReplaceAllString(name, "_"))
+	if len(name) > maxToolNameLength {
+		name = name[:maxToolNameLength]
+	}
+
+	return name
+}
+
+func contentText(contents []protocol.Content) string {
+	parts := make([]string, 0, len(contents))
+	for _, content := range contents {
+		switch item := content.(type) {
+		case *protocol.TextContent:
+			parts = append(parts, item.Text)
+		case *protocol.ImageContent:
+			parts = append(parts, fmt.Sprintf("[image %s]", item.MimeType))
+		case *protocol.AudioContent:
+			parts = append(parts, fmt.Sprintf("[audio %s]", item.MimeType))
+		default:
+			body, err := json.Marshal(content)
+			if err == nil {
+				parts = append(parts, string(body))
+			}
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// headerTransport 为每个请求附带管理员配置的请求头，如鉴权信息
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for key, value := range t.headers {
+			req.Header.Set(key, value)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// clientLogger 只记录 go-mcp 的警告与错误
+type clientLogger struct{}
+
+func (clientLogger) Debugf(string, ...any) {}
+
+func (clientLogger) Infof(string, ...any) {}
+
+func (clientLogger) Warnf(format string, a ...any) {
+	logger.SysLog("mcp client: " + fmt.Sprintf(format, a...))
+}
+
+func (clientLogger) Errorf(format string, a ...any) {
+	logger.SysError("mcp client: " + fmt.Sprintf(format, a...))
+}
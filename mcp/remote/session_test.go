@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ThinkInAIXYZ/go-mcp/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToolName(t *testing.T) {
+	cases := []struct {
+		name     string
+		serverId int
+		tool     string
+		expected string
+	}{
+		{
+			name:     "plain name",
+			serverId: 3,
+			tool:     "get_weather",
+			expected: "mcp_3_get_weather",
+		},
+		{
+			name:     "invalid characters are replaced",
+			serverId: 12,
+			tool:     "files.read/all now",
+			expected: "mcp_12_files_read_all_now",
+		},
+		{
+			name:     "dashes are kept",
+			serverId: 1,
+			tool:     "web-search",
+			expected: "mcp_1_web-search",
+		},
+		{
+			name:     "long names are truncated",
+			serverId: 7,
+			tool:     strings.Repeat("a", 80),
+			expected: "mcp_7_" + strings.Repeat("a", maxToolNameLength-len("mcp_7_")),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			name := toolName(c.serverId, c.tool)
+			assert.Equal(t, c.expected, name)
+			assert.LessOrEqual(t, len(name), maxToolNameLength)
+		})
+	}
+}
+
+func TestContentText(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents []protocol.Content
+		expected string
+	}{
+		{
+			name:     "empty",
+			expected: "",
+		},
+		{
+			name: "text parts are joined by lines",
+			contents: []protocol.Content{
+				&protocol.TextContent{Type: "text", Text: "first"},
+				&protocol.TextContent{Type: "text", Text: "second"},
+			},
+			expected: "first\nsecond",
+		},
+		{
+			name: "image and audio become placeholders",
+			contents: []protocol.Content{
+				&protocol.ImageContent{Type: "image", Data: []byte("png"), MimeType: "image/png"},
+				&protocol.AudioContent{Type: "audio", Data: []byte("wav"), MimeType: "audio/wav"},
+			},
+			expected: "[image image/png]\n[audio audio/wav]",
+		},
+		{
+			name: "other content is written as json",
+			contents: []protocol.Content{
+				protocol.NewEmbeddedResource(&protocol.TextResourceContents{URI: "file:///a.txt", Text: "hello"}, nil),
+			},
+			expected: `{"type":"resource","resource":{"uri":"file:///a.txt","text":"hello"}}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, contentText(c.contents))
+		})
+	}
+}
@@ -34,6 +34,14 @@ func APIEnabled(types string) gin.HandlerFunc {
 				c.Abort()
 				return
 			}
+		case "ollama":
+			if !config.OllamaAPIEnabled {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "Ollama API is not supported",
+				})
+				c.Abort()
+				return
+			}
 		}
 
 		c.Next()
@@ -98,6 +98,8 @@ func Distribute() func(c *gin.Context) {
 		if err := distributor.SetupGroups(); err != nil {
 			return
 		}
+		// 没有记录请求结果的路径（如透传、任务提交）在请求结束时归还熔断试探名额
+		defer model.ChannelGroup.ReleaseCircuitTrial(c)
 		c.Next()
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -44,10 +45,10 @@ type ChannelChoice struct {
 
 type ChannelsChooser struct {
 	sync.RWMutex
-	Channels  map[int]*ChannelChoice
-	Rule      map[string]map[string][][]int // group -> model -> priority -> channelIds
-	Match     []string
-	Cooldowns sync.Map
+	Channels map[int]*ChannelChoice
+	Rule     map[string]map[string][][]int // group -> model -> priority -> channelIds
+	Match    []string
+	Breakers sync.Map // channelId:model -> *circuitBreaker
 
 	ModelGroup map[string]map[string]bool
 }
@@ -79,85 +80,15 @@ func FilterDisabledStream(modelName string) ChannelsFilterFunc {
 }
 
 func init() {
-	// 每5分钟清理一次过期的冷却时间，加快内存回收
+	// 每5分钟清理一次空闲的熔断器，加快内存回收
 	go func() {
 		ticker := time.NewTicker(5 * time.Minute)
 		for range ticker.C {
-			ChannelGroup.CleanupExpiredCooldowns()
+			ChannelGroup.CleanupCircuitBreakers()
 		}
 	}()
 }
 
-func (cc *ChannelsChooser) SetCooldowns(channelId int, modelName string) bool {
-	return cc.SetCooldownsWithDuration(channelId, modelName, int64(config.RetryCooldownSeconds))
-}
-
-// SetCooldownsWithDuration 设置指定渠道和模型的冷却时间（支持自定义冻结时长）
-func (cc *ChannelsChooser) SetCooldownsWithDuration(channelId int, modelName string, durationSeconds int64) bool {
-	if channelId == 0 || modelName == "" || durationSeconds == 0 {
-		return false
-	}
-
-	key := fmt.Sprintf("%d:%s", channelId, modelName)
-	nowTime := time.Now().Unix()
-	newCooldownTime := nowTime + durationSeconds
-
-	// 使用LoadOrStore的原子性，避免竞态条件
-	actualValue, loaded := cc.Cooldowns.LoadOrStore(key, newCooldownTime)
-
-	if loaded {
-		// key已存在，检查是否仍在冷却期内
-		existingCooldownTime := actualValue.(int64)
-		if nowTime < existingCooldownTime {
-			// 仍在冷却期内，无需重新设置
-			return true
-		}
-		// 冷却期已过，尝试更新为新的冷却时间
-		// 如果CompareAndSwap失败，说明其他线程已经更新了，这也是可以接受的
-		cc.Cooldowns.CompareAndSwap(key, existingCooldownTime, newCooldownTime)
-	}
-
-	return true
-}
-
-func (cc *ChannelsChooser) IsInCooldown(channelId int, modelName string) bool {
-	if channelId == 0 || modelName == "" {
-		return false
-	}
-
-	key := fmt.Sprintf("%d:%s", channelId, modelName)
-	nowTime := time.Now().Unix()
-
-	cooldownTime, exists := cc.Cooldowns.Load(key)
-	if !exists {
-		return false
-	}
-
-	// 直接返回冷却状态，不进行任何清理操作
-	return nowTime < cooldownTime.(int64)
-}
-
-func (cc *ChannelsChooser) CleanupExpiredCooldowns() {
-	now := time.Now().Unix()
-	cc.Cooldowns.Range(func(key, value interface{}) bool {
-		if now >= value.(int64) {
-			cc.Cooldowns.Delete(key)
-		}
-		return true
-	})
-}
-
-// ClearChannelCooldowns 清除指定渠道的所有冻结缓存
-func (cc *ChannelsChooser) ClearChannelCooldowns(channelId int) {
-	prefix := fmt.Sprintf("%d:", channelId)
-	cc.Cooldowns.Range(func(key, value interface{}) bool {
-		if strings.HasPrefix(key.(string), prefix) {
-			cc.Cooldowns.Delete(key)
-		}
-		return true
-	})
-}
-
 func (cc *ChannelsChooser) Disable(channelId int) {
 	cc.Lock()
 	defer cc.Unlock()
@@ -178,35 +109,37 @@ func (cc *ChannelsChooser) Enable(channelId int) {
 	cc.Channels[channelId].Disable = false
 }
 
+// ChangeStatus 修改渠道的启用状态，并通知其他节点
 func (cc *ChannelsChooser) ChangeStatus(channelId int, status bool) {
 	if status {
 		cc.Enable(channelId)
 	} else {
 		cc.Disable(channelId)
 	}
+	publishClusterEvent(clusterEvent{Type: clusterEventStatus, ChannelId: channelId, Enabled: status})
 }
 
 // checkStickySession 检查是否有粘性 session 映射，如果有且渠道可用，则返回该渠道
-func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []ChannelsFilterFunc, modelName string, ginContext interface{}) *Channel {
-	if !config.RedisEnabled || ginContext == nil || len(channelIds) == 0 {
+func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []ChannelsFilterFunc, modelName string, ginContext interface{}, prefixAffinity bool) *Channel {
+	if ginContext == nil || len(channelIds) == 0 {
 		return nil
 	}
 
 	// 获取第一个候选渠道以确定渠道类型（同一批候选渠道类型相同）
 	firstChoice, ok := cc.Channels[channelIds[0]]
-	if !ok {
+	if !ok || !stickySessionEnabled(firstChoice.Channel.Type) {
 		return nil
 	}
 
 	// 根据渠道类型生成 session hash（session hash 与具体渠道无关，只与请求内容和渠道类型有关）
-	sessionHash := generateSessionHashForChannel(firstChoice.Channel, ginContext)
+	sessionHash := generateSessionHashForChannel(firstChoice.Channel, modelName, ginContext, prefixAffinity)
 	if sessionHash == "" {
 		return nil
 	}
 
 	// 检查 Redis 中是否有映射（传递渠道类型以使用正确的 key 前缀）
 	channelType := firstChoice.Channel.Type
-	mappedChannelID, err := redis.GetStickySessionMapping(sessionHash, channelType)
+	mappedChannelID, err := getStickySessionMapping(sessionHash, channelType)
 	if err != nil || mappedChannelID <= 0 {
 		return nil
 	}
@@ -215,7 +148,7 @@ func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []Channe
 	mappedChoice, ok := cc.Channels[mappedChannelID]
 	if !ok || mappedChoice.Disable {
 		// 映射的渠道不存在或已禁用，删除映射
-		redis.DeleteStickySessionMapping(sessionHash, channelType)
+		deleteStickySessionMapping(sessionHash, channelType)
 		return nil
 	}
 
@@ -229,14 +162,20 @@ func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []Channe
 	}
 	if !isInCandidates {
 		// 映射的渠道不在候选列表中，删除映射
-		redis.DeleteStickySessionMapping(sessionHash, channelType)
+		deleteStickySessionMapping(sessionHash, channelType)
 		return nil
 	}
 
-	// 检查渠道是否在冷却中
-	if cc.IsInCooldown(mappedChannelID, modelName) {
-		// 渠道在冷却中，删除映射
-		redis.DeleteStickySessionMapping(sessionHash, channelType)
+	// 检查渠道是否在熔断中
+	if cc.IsCircuitOpen(mappedChannelID, modelName) {
+		// 渠道在熔断中，删除映射
+		deleteStickySessionMapping(sessionHash, channelType)
+		return nil
+	}
+
+	// 检查渠道是否在时间窗口外
+	if !mappedChoice.Channel.IsScheduleActive(modelName) {
+		deleteStickySessionMapping(sessionHash, channelType)
 		return nil
 	}
 
@@ -244,7 +183,7 @@ func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []Channe
 	for _, filter := range filters {
 		if filter(mappedChannelID, mappedChoice) {
 			// 渠道被过滤器排除，删除映射
-			redis.DeleteStickySessionMapping(sessionHash, channelType)
+			deleteStickySessionMapping(sessionHash, channelType)
 			return nil
 		}
 	}
@@ -270,19 +209,19 @@ func (cc *ChannelsChooser) checkStickySession(channelIds []int, filters []Channe
 }
 
 // createStickySession 为选定的渠道创建粘性 session 映射
-func (cc *ChannelsChooser) createStickySession(channel *Channel, ginContext interface{}) {
-	if !config.RedisEnabled || ginContext == nil || channel == nil {
+func (cc *ChannelsChooser) createStickySession(channel *Channel, modelName string, ginContext interface{}, prefixAffinity bool) {
+	if ginContext == nil || channel == nil || !stickySessionEnabled(channel.Type) {
 		return
 	}
 
-	sessionHash := generateSessionHashForChannel(channel, ginContext)
+	sessionHash := generateSessionHashForChannel(channel, modelName, ginContext, prefixAffinity)
 	if sessionHash == "" {
 		return
 	}
 
 	ttl := 1 * time.Hour
 	channelType := channel.Type
-	err := redis.SetStickySessionMapping(sessionHash, channel.Id, channelType, ttl)
+	err := setStickySessionMapping(sessionHash, channel.Id, channelType, ttl)
 	if err != nil {
 		// 从 ginContext 获取 context.Context 用于日志
 		if gc, ok := ginContext.(*gin.Context); ok {
@@ -305,7 +244,8 @@ func (cc *ChannelsChooser) createStickySession(channel *Channel, ginContext inte
 }
 
 // generateSessionHashForChannel 根据渠道类型生成 session hash
-func generateSessionHashForChannel(channel *Channel, ginContext interface{}) string {
+// 其他类型的渠道在分组开启前缀亲和时按请求前缀生成，使同一对话命中同一渠道的提示词缓存
+func generateSessionHashForChannel(channel *Channel, modelName string, ginContext interface{}, prefixAffinity bool) string {
 	if channel == nil || ginContext == nil {
 		return ""
 	}
@@ -367,7 +307,21 @@ func generateSessionHashForChannel(channel *Channel, ginContext interface{}) str
 		return session.GenerateCodexSessionHashFromSessionID(sessionID)
 
 	default:
-		return ""
+		if !prefixAffinity {
+			return ""
+		}
+
+		rawBody, exists := c.Get(config.GinRequestBodyKey)
+		if !exists {
+			return ""
+		}
+		bodyBytes, ok := rawBody.([]byte)
+		if !ok {
+			return ""
+		}
+
+		gc, _ := ginContext.(*gin.Context)
+		return getPrefixHash(gc, modelName, bodyBytes)
 	}
 }
 
@@ -567,16 +521,16 @@ func extractMessageContent(msgMap map[string]interface{}) string {
 	return ""
 }
 
-func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFunc, modelName string, ginContext interface{}) *Channel {
+func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFunc, modelName string, ginContext interface{}, adaptive, prefixAffinity bool) *Channel {
 	// 1. 检查粘性 session（优先级最高）
-	stickyChannel := cc.checkStickySession(channelIds, filters, modelName, ginContext)
-	if stickyChannel != nil {
+	stickyChannel := cc.checkStickySession(channelIds, filters, modelName, ginContext, prefixAffinity)
+	if stickyChannel != nil && cc.acquireCircuit(stickyChannel.Id, modelName, ginContext) {
+		setStickySessionHit(ginContext, true)
 		return stickyChannel
 	}
+	setStickySessionHit(ginContext, false)
 
 	// 2. 按权重选择渠道
-	totalWeight := 0
-
 	validChannels := make([]*ChannelChoice, 0, len(channelIds))
 	for _, channelId := range channelIds {
 		choice, ok := cc.Channels[channelId]
@@ -584,7 +538,11 @@ func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFu
 			continue
 		}
 
-		if cc.IsInCooldown(channelId, modelName) {
+		if cc.IsCircuitOpen(channelId, modelName) {
+			continue
+		}
+
+		if !choice.Channel.IsScheduleActive(modelName) {
 			continue
 		}
 
@@ -599,35 +557,55 @@ func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFu
 			continue
 		}
 
-		weight := int(*choice.Channel.Weight)
-		totalWeight += weight
 		validChannels = append(validChannels, choice)
 	}
 
-	if len(validChannels) == 0 {
-		return nil
+	// 半开状态的渠道可能在选择期间被其他请求占满试探名额，此时排除该渠道重新选择
+	for len(validChannels) > 0 {
+		var choice *ChannelChoice
+		if len(validChannels) == 1 {
+			choice = validChannels[0]
+		} else if adaptive {
+			// 3. 自适应策略按延迟与成功率调整后的权重选择
+			choice = chooseAdaptive(validChannels, modelName)
+		} else {
+			choice = chooseByWeight(validChannels)
+		}
+
+		if cc.acquireCircuit(choice.Channel.Id, modelName, ginContext) {
+			// 建立新的粘性 session 映射
+			cc.createStickySession(choice.Channel, modelName, ginContext, prefixAffinity)
+			return choice.Channel
+		}
+
+		validChannels = slices.DeleteFunc(validChannels, func(item *ChannelChoice) bool {
+			return item == choice
+		})
 	}
 
-	if len(validChannels) == 1 {
-		selectedChannel := validChannels[0].Channel
-		// 建立新的粘性 session 映射
-		cc.createStickySession(selectedChannel, ginContext)
-		return selectedChannel
+	return nil
+}
+
+// chooseByWeight 按渠道的静态权重随机选择
+func chooseByWeight(choices []*ChannelChoice) *ChannelChoice {
+	totalWeight := 0
+	for _, choice := range choices {
+		totalWeight += int(*choice.Channel.Weight)
+	}
+
+	if totalWeight <= 0 {
+		return choices[rand.Intn(len(choices))]
 	}
 
 	choiceWeight := rand.Intn(totalWeight)
-	for _, choice := range validChannels {
-		weight := int(*choice.Channel.Weight)
-		choiceWeight -= weight
+	for _, choice := range choices {
+		choiceWeight -= int(*choice.Channel.Weight)
 		if choiceWeight < 0 {
-			selectedChannel := choice.Channel
-			// 建立新的粘性 session 映射
-			cc.createStickySession(selectedChannel, ginContext)
-			return selectedChannel
+			return choice
 		}
 	}
 
-	return nil
+	return choices[len(choices)-1]
 }
 
 // GetMatchedModelName 获取匹配到的实际模型名称
@@ -714,8 +692,9 @@ func (cc *ChannelsChooser) Next(group, modelName string, filters ...ChannelsFilt
 		return nil, errors.New(ErrChannelNotFound)
 	}
 
+	adaptive := GlobalUserGroupRatio.GetBalanceStrategy(group) == BalanceStrategyAdaptive
 	for _, priority := range channelsPriority {
-		channel := cc.balancer(priority, filters, modelName, nil)
+		channel := cc.balancer(priority, filters, modelName, nil, adaptive, false)
 		if channel != nil {
 			return channel, nil
 		}
@@ -743,8 +722,14 @@ func (cc *ChannelsChooser) NextByValidatedModel(group, validatedModelName string
 		return nil, errors.New(ErrNoChannelsAvailable)
 	}
 
+	adaptive := GlobalUserGroupRatio.GetBalanceStrategy(group) == BalanceStrategyAdaptive
+	prefixAffinity := GlobalUserGroupRatio.IsPrefixAffinityEnabled(group)
+	// 记录选择渠道时使用的分组，前缀缓存的统计按该分组记录
+	if c, ok := ginContext.(*gin.Context); ok {
+		c.Set("channel_group", group)
+	}
 	for _, priority := range channelsPriority {
-		channel := cc.balancer(priority, filters, validatedModelName, ginContext)
+		channel := cc.balancer(priority, filters, validatedModelName, ginContext, adaptive, prefixAffinity)
 		if channel != nil {
 			return channel, nil
 		}
@@ -766,6 +751,19 @@ func (cc *ChannelsChooser) GetGroupModels(group string) ([]string, error) {
 		models = append(models, model)
 	}
 
+	// 链中有任一模型在分组内可用时，虚拟模型也出现在模型列表中
+	for name, chain := range GlobalVirtualModels.GetAll() {
+		if _, exists := cc.Rule[group][name]; exists {
+			continue
+		}
+		for _, modelName := range chain {
+			if _, ok := cc.Rule[group][modelName]; ok {
+				models = append(models, name)
+				break
+			}
+		}
+	}
+
 	return models, nil
 }
 
@@ -787,7 +785,7 @@ func (cc *ChannelsChooser) GetChannel(channelId int) *Channel {
 	return nil
 }
 
-// CountAvailableChannels 计算指定分组和模型的可用渠道数量（排除禁用、冷却和过滤的渠道）
+// CountAvailableChannels 计算指定分组和模型的可用渠道数量（排除禁用、熔断和过滤的渠道）
 func (cc *ChannelsChooser) CountAvailableChannels(group, modelName string, filters ...ChannelsFilterFunc) int {
 	cc.RLock()
 	defer cc.RUnlock()
@@ -813,6 +811,25 @@ func (cc *ChannelsChooser) CountAvailableChannels(group, modelName string, filte
 	return totalAvailable
 }
 
+// CandidateChannels 返回分组中该模型的所有启用渠道，调用方据此在不持有锁的情况下查询 Redis 等外部状态
+func (cc *ChannelsChooser) CandidateChannels(group, modelName string) []*Channel {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	var channels []*Channel
+	for _, priority := range cc.Rule[group][modelName] {
+		for _, channelId := range priority {
+			choice, ok := cc.Channels[channelId]
+			if !ok || choice.Disable {
+				continue
+			}
+			channels = append(channels, choice.Channel)
+		}
+	}
+
+	return channels
+}
+
 // countValidChannels 计算指定渠道列表中的可用渠道数量
 // 与balancer方法使用相同的过滤逻辑
 func (cc *ChannelsChooser) countValidChannels(channelIds []int, filters []ChannelsFilterFunc, modelName string) int {
@@ -823,7 +840,11 @@ func (cc *ChannelsChooser) countValidChannels(channelIds []int, filters []Channe
 			continue
 		}
 
-		if cc.IsInCooldown(channelId, modelName) {
+		if cc.IsCircuitOpen(channelId, modelName) {
+			continue
+		}
+
+		if !choice.Channel.IsScheduleActive(modelName) {
 			continue
 		}
 
@@ -863,6 +884,10 @@ func (cc *ChannelsChooser) Load() {
 	// 处理每个channel
 	for _, channel := range channels {
 		channel.SetProxy()
+		channel.loadRateLimits()
+		channel.loadSchedules()
+		channel.loadKeys()
+		channel.loadRewriteRules()
 		if *channel.Weight == 0 {
 			channel.Weight = &config.DefaultChannelWeight
 		}
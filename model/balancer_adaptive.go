@@ -0,0 +1,287 @@
+package model
+
+import (
+	"done-hub/common/config"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BalanceStrategyWeight   = "weight"   // 按渠道的静态权重随机选择
+	BalanceStrategyAdaptive = "adaptive" // 按延迟与成功率持续调整权重
+)
+
+// 样本数不足时不调整权重，新渠道或新模型先按静态权重积累数据
+const adaptiveMinSamples = 5
+
+// 权重调整的最低比例，降级的渠道仍然保留少量流量，用于发现其恢复
+const adaptiveMinFactor = 0.02
+
+// ChannelModelStats 渠道在某个模型上的 EWMA 统计，延迟只由成功的请求更新
+type ChannelModelStats struct {
+	sync.Mutex
+	Latency     float64 // 毫秒
+	TTFT        float64 // 首字时间(毫秒)，非流式请求与 Latency 相同
+	SuccessRate float64
+	Samples     int64
+	UpdatedAt   int64
+}
+
+// 渠道统计，key 为 "channelId:model"
+var channelModelStats sync.Map
+
+func channelStatsKey(channelId int, modelName string) string {
+	return fmt.Sprintf("%d:%s", channelId, modelName)
+}
+
+// RecordChannelResult 记录一次请求的结果，所有分组都会记录，切换到 adaptive 时可以直接使用
+func RecordChannelResult(channelId int, modelName string, latency, ttft time.Duration, success bool) {
+	if channelId == 0 || modelName == "" {
+		return
+	}
+
+	value, _ := channelModelStats.LoadOrStore(channelStatsKey(channelId, modelName), &ChannelModelStats{SuccessRate: 1})
+	stats := value.(*ChannelModelStats)
+
+	alpha := config.AdaptiveBalanceAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+
+	stats.Lock()
+	defer stats.Unlock()
+
+	successValue := 0.0
+	if success {
+		successValue = 1
+	}
+	stats.SuccessRate += alpha * (successValue - stats.SuccessRate)
+
+	if success {
+		latencyMs := float64(latency.Milliseconds())
+		ttftMs := float64(ttft.Milliseconds())
+		if ttftMs <= 0 {
+			ttftMs = latencyMs
+		}
+
+		if stats.Latency == 0 {
+			stats.Latency = latencyMs
+			stats.TTFT = ttftMs
+		} else {
+			stats.Latency += alpha * (latencyMs - stats.Latency)
+			stats.TTFT += alpha * (ttftMs - stats.TTFT)
+		}
+	}
+
+	stats.Samples++
+	stats.UpdatedAt = time.Now().Unix()
+}
+
+// GetChannelModelStats 返回统计的副本，没有数据时返回 nil
+func GetChannelModelStats(channelId int, modelName string) *ChannelModelStats {
+	value, ok := channelModelStats.Load(channelStatsKey(channelId, modelName))
+	if !ok {
+		return nil
+	}
+
+	stats := value.(*ChannelModelStats)
+	stats.Lock()
+	defer stats.Unlock()
+
+	return &ChannelModelStats{
+		Latency:     stats.Latency,
+		TTFT:        stats.TTFT,
+		SuccessRate: stats.SuccessRate,
+		Samples:     stats.Samples,
+		UpdatedAt:   stats.UpdatedAt,
+	}
+}
+
+// ClearChannelModelStats 渠道被修改或删除后清除统计
+func ClearChannelModelStats(channelId int) {
+	prefix := fmt.Sprintf("%d:", channelId)
+	channelModelStats.Range(func(key, _ any) bool {
+		if k, ok := key.(string); ok && strings.HasPrefix(k, prefix) {
+			channelModelStats.Delete(key)
+		}
+		return true
+	})
+}
+
+// adaptiveWeights 有效权重 = 静态权重 × 成功率² × (同一优先级中最低首字时间 / 本渠道首字时间)
+func adaptiveWeights(choices []*ChannelChoice, modelName string) []float64 {
+	statsList := make([]*ChannelModelStats, len(choices))
+	bestTTFT := 0.0
+	for i, choice := range choices {
+		stats := GetChannelModelStats(choice.Channel.Id, modelName)
+		if stats == nil || stats.Samples < adaptiveMinSamples {
+			continue
+		}
+		statsList[i] = stats
+		if stats.TTFT > 0 && (bestTTFT == 0 || stats.TTFT < bestTTFT) {
+			bestTTFT = stats.TTFT
+		}
+	}
+
+	weights := make([]float64, len(choices))
+	for i, choice := range choices {
+		weight := float64(*choice.Channel.Weight)
+		stats := statsList[i]
+		if stats == nil {
+			weights[i] = weight
+			continue
+		}
+
+		factor := stats.SuccessRate * stats.SuccessRate
+		if bestTTFT > 0 && stats.TTFT > 0 {
+			factor *= bestTTFT / stats.TTFT
+		}
+		factor = min(max(factor, adaptiveMinFactor), 1)
+
+		weights[i] = weight * factor
+	}
+
+	return weights
+}
+
+// chooseAdaptive 按有效权重随机选择
+func chooseAdaptive(choices []*ChannelChoice, modelName string) *ChannelChoice {
+	weights := adaptiveWeights(choices, modelName)
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+
+	if totalWeight <= 0 {
+		return choices[rand.Intn(len(choices))]
+	}
+
+	choiceWeight := rand.Float64() * totalWeight
+	for i, weight := range weights {
+		choiceWeight -= weight
+		if choiceWeight < 0 {
+			return choices[i]
+		}
+	}
+
+	return choices[len(choices)-1]
+}
+
+// ChannelBalanceWeight 渠道当前的选择权重，用于管理接口展示
+type ChannelBalanceWeight struct {
+	ChannelId       int     `json:"channel_id"`
+	ChannelName     string  `json:"channel_name"`
+	Priority        int64   `json:"priority"`
+	Weight          uint    `json:"weight"`
+	EffectiveWeight float64 `json:"effective_weight"`
+	Share           float64 `json:"share"` // 在同一优先级的可用渠道中被选中的概率
+	Disabled        bool    `json:"disabled"`
+	CircuitState    string  `json:"circuit_state"`
+	CircuitOpen     bool    `json:"circuit_open"`  // 熔断中或半开状态下试探名额已满，当前不会被选择
+	OutOfWindow     bool    `json:"out_of_window"` // 不在渠道的可用时间窗口内
+	Latency         float64 `json:"latency"`
+	TTFT            float64 `json:"ttft"`
+	SuccessRate     float64 `json:"success_rate"`
+	Samples         int64   `json:"samples"`
+	UpdatedAt       int64   `json:"updated_at"`
+}
+
+// GetBalanceWeights 返回分组下各模型的渠道权重，modelName 为空时返回分组内的所有模型
+func (cc *ChannelsChooser) GetBalanceWeights(group, modelName string) (map[string][]*ChannelBalanceWeight, error) {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	rules, ok := cc.Rule[group]
+	if !ok {
+		return nil, fmt.Errorf(ErrNoAvailableChannelForModel, GlobalUserGroupRatio.GetDisplayName(group), "*")
+	}
+
+	adaptive := GlobalUserGroupRatio.GetBalanceStrategy(group) == BalanceStrategyAdaptive
+	result := make(map[string][]*ChannelBalanceWeight)
+	for name, priorities := range rules {
+		if modelName != "" && name != modelName {
+			continue
+		}
+
+		items := make([]*ChannelBalanceWeight, 0)
+		for _, priority := range priorities {
+			items = append(items, cc.priorityWeights(priority, name, adaptive)...)
+		}
+		result[name] = items
+	}
+
+	if modelName != "" && len(result) == 0 {
+		return nil, errors.New(ErrModelNotFoundInGroup)
+	}
+
+	return result, nil
+}
+
+func (cc *ChannelsChooser) priorityWeights(channelIds []int, modelName string, adaptive bool) []*ChannelBalanceWeight {
+	items := make([]*ChannelBalanceWeight, 0, len(channelIds))
+	available := make([]*ChannelChoice, 0, len(channelIds))
+	availableItems := make([]*ChannelBalanceWeight, 0, len(channelIds))
+
+	for _, channelId := range channelIds {
+		choice, ok := cc.Channels[channelId]
+		if !ok {
+			continue
+		}
+
+		item := &ChannelBalanceWeight{
+			ChannelId:    channelId,
+			ChannelName:  choice.Channel.Name,
+			Priority:     choice.Channel.GetPriority(),
+			Weight:       *choice.Channel.Weight,
+			Disabled:     choice.Disable,
+			CircuitState: cc.GetCircuitState(channelId, modelName),
+			CircuitOpen:  cc.IsCircuitOpen(channelId, modelName),
+			OutOfWindow:  !choice.Channel.IsScheduleActive(modelName),
+		}
+		if stats := GetChannelModelStats(channelId, modelName); stats != nil {
+			item.Latency = stats.Latency
+			item.TTFT = stats.TTFT
+			item.SuccessRate = stats.SuccessRate
+			item.Samples = stats.Samples
+			item.UpdatedAt = stats.UpdatedAt
+		}
+		items = append(items, item)
+
+		if !item.Disabled && !item.CircuitOpen && !item.OutOfWindow {
+			available = append(available, choice)
+			availableItems = append(availableItems, item)
+		}
+	}
+
+	var weights []float64
+	if adaptive {
+		weights = adaptiveWeights(available, modelName)
+	} else {
+		weights = make([]float64, len(available))
+		for i, choice := range available {
+			weights[i] = float64(*choice.Channel.Weight)
+		}
+	}
+
+	totalWeight := 0.0
+	for i, weight := range weights {
+		availableItems[i].EffectiveWeight = weight
+		totalWeight += weight
+	}
+	if totalWeight > 0 {
+		for _, item := range availableItems {
+			item.Share = item.EffectiveWeight / totalWeight
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].ChannelId < items[j].ChannelId
+	})
+
+	return items
+}
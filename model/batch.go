@@ -0,0 +1,205 @@
+package model
+
+import (
+	"done-hub/common/utils"
+	"errors"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+const (
+	BatchItemStatusPending   = "pending"
+	BatchItemStatusCompleted = "completed"
+	BatchItemStatusFailed    = "failed"
+)
+
+type Batch struct {
+	Id               int            `json:"id"`
+	BatchId          string         `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int            `json:"user_id" gorm:"index"`
+	TokenId          int            `json:"token_id" gorm:"index"`
+	Endpoint         string         `json:"endpoint" gorm:"type:varchar(64)"`
+	CompletionWindow string         `json:"completion_window" gorm:"type:varchar(16)"`
+	InputFileId      string         `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string         `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string         `json:"error_file_id" gorm:"type:varchar(64)"`
+	Status           string         `json:"status" gorm:"type:varchar(20);index"`
+	Errors           datatypes.JSON `json:"errors" gorm:"type:json"`
+	Metadata         datatypes.JSON `json:"metadata" gorm:"type:json"`
+	Total            int            `json:"total" gorm:"default:0"`
+	Completed        int            `json:"completed" gorm:"default:0"`
+	Failed           int            `json:"failed" gorm:"default:0"`
+	CreatedAt        int64          `json:"created_at" gorm:"bigint"`
+	InProgressAt     int64          `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64          `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64          `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64          `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64          `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64          `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64          `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64          `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchItem 批量任务中的单行请求，逐行持久化以便重启后继续执行
+type BatchItem struct {
+	Id         int    `json:"id"`
+	BatchId    int    `json:"batch_id" gorm:"index:idx_batch_item_status,priority:1"`
+	Line       int    `json:"line"`
+	CustomId   string `json:"custom_id" gorm:"type:varchar(255)"`
+	Method     string `json:"method" gorm:"type:varchar(10)"`
+	Url        string `json:"url" gorm:"type:varchar(64)"`
+	Body       string `json:"body" gorm:"type:text"`
+	Status     string `json:"status" gorm:"type:varchar(20);index:idx_batch_item_status,priority:2"`
+	StatusCode int    `json:"status_code" gorm:"default:0"`
+	RequestId  string `json:"request_id" gorm:"type:varchar(64)"`
+	Response   string `json:"response" gorm:"type:text"`
+	ErrorCode  string `json:"error_code" gorm:"type:varchar(64)"`
+	ErrorMsg   string `json:"error_msg" gorm:"type:text"`
+	FinishedAt int64  `json:"finished_at" gorm:"bigint"`
+}
+
+var activeBatchStatuses = []string{
+	BatchStatusValidating,
+	BatchStatusInProgress,
+	BatchStatusFinalizing,
+	BatchStatusCancelling,
+}
+
+// CreateBatchWithItems 在同一事务中创建批量任务及其全部请求行
+func CreateBatchWithItems(batch *Batch, items []*BatchItem) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			item.BatchId = batch.Id
+			item.Status = BatchItemStatusPending
+		}
+
+		return BatchInsert(tx, items)
+	})
+}
+
+func GetBatchByBatchId(userId int, batchId string) (*Batch, error) {
+	batch := &Batch{}
+	err := DB.Where("user_id = ? and batch_id = ?", userId, batchId).First(batch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return batch, err
+}
+
+// GetUserBatches 按创建时间倒序获取用户的批量任务，after 为上一页最后一条的 batch_id
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	db := DB.Where("user_id = ?", userId)
+
+	if after != "" {
+		afterBatch, err := GetBatchByBatchId(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		if afterBatch != nil {
+			db = db.Where("id < ?", afterBatch.Id)
+		}
+	}
+
+	err := db.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+func GetActiveBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status in (?)", activeBatchStatuses).Order("id").Find(&batches).Error
+	return batches, err
+}
+
+func GetBatchById(id int) (*Batch, error) {
+	batch := &Batch{}
+	err := DB.First(batch, "id = ?", id).Error
+	return batch, err
+}
+
+func (batch *Batch) Update(fields map[string]any) error {
+	return DB.Model(batch).Updates(fields).Error
+}
+
+// Cancel 仅在任务仍处于进行中时将其标记为取消中，由后台任务完成实际的取消
+func (batch *Batch) Cancel() error {
+	now := utils.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? and status in (?)", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("batch cannot be cancelled in current status")
+	}
+
+	batch.Status = BatchStatusCancelling
+	batch.CancellingAt = now
+	return nil
+}
+
+// RefreshCounts 根据请求行的状态重新统计完成数和失败数
+func (batch *Batch) RefreshCounts() error {
+	var completed, failed int64
+	err := DB.Model(&BatchItem{}).Where("batch_id = ? and status = ?", batch.Id, BatchItemStatusCompleted).Count(&completed).Error
+	if err != nil {
+		return err
+	}
+	err = DB.Model(&BatchItem{}).Where("batch_id = ? and status = ?", batch.Id, BatchItemStatusFailed).Count(&failed).Error
+	if err != nil {
+		return err
+	}
+
+	batch.Completed = int(completed)
+	batch.Failed = int(failed)
+
+	return batch.Update(map[string]any{"completed": batch.Completed, "failed": batch.Failed})
+}
+
+func GetPendingBatchItems(batchId int, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Where("batch_id = ? and status = ?", batchId, BatchItemStatusPending).Order("line").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+// GetBatchItemsByStatus 按行号分页获取指定状态的请求行，用于生成输出文件
+func GetBatchItemsByStatus(batchId int, status string, afterLine int, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Where("batch_id = ? and status = ? and line > ?", batchId, status, afterLine).Order("line").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+// FailPendingBatchItems 将剩余未执行的请求行全部标记为失败
+func FailPendingBatchItems(batchId int, code, message string) error {
+	return DB.Model(&BatchItem{}).
+		Where("batch_id = ? and status = ?", batchId, BatchItemStatusPending).
+		Updates(map[string]any{
+			"status":      BatchItemStatusFailed,
+			"error_code":  code,
+			"error_msg":   message,
+			"finished_at": utils.GetTimestamp(),
+		}).Error
+}
+
+func (item *BatchItem) Finish() error {
+	return DB.Model(item).Select("status", "status_code", "request_id", "response", "error_code", "error_msg", "finished_at").Updates(item).Error
+}
@@ -5,6 +5,7 @@ import (
 	"done-hub/common/cache"
 	"done-hub/common/config"
 	"done-hub/common/logger"
+	"done-hub/common/rewrite"
 	"done-hub/common/utils"
 	"encoding/hex"
 	"fmt"
@@ -36,17 +37,30 @@ type Channel struct {
 	ModelMapping       *string `json:"model_mapping" gorm:"type:text"`
 	ModelHeaders       *string `json:"model_headers" gorm:"type:varchar(1024);default:''"`
 	CustomParameter    *string `json:"custom_parameter" gorm:"type:text"`
+	RewriteRules       *string `json:"rewrite_rules" gorm:"type:text"`
 	Priority           *int64  `json:"priority" gorm:"bigint;default:0"`
 	Proxy              *string `json:"proxy" gorm:"type:varchar(255);default:''"`
 	TestModel          string  `json:"test_model" form:"test_model" gorm:"type:varchar(50);default:''"`
 	OnlyChat           bool    `json:"only_chat" form:"only_chat" gorm:"default:false"`
 	PreCost            int     `json:"pre_cost" form:"pre_cost" gorm:"default:1"`
 	CompatibleResponse bool    `json:"compatible_response" gorm:"default:false"`
+	MaxConcurrency     int     `json:"max_concurrency" gorm:"default:0"` // 同时进行的请求上限，0 表示不限制
+	RateLimits         *string `json:"rate_limits" gorm:"type:text"`     // 上游的 RPM/TPM 额度，JSON 格式
+	Schedules          *string `json:"schedules" gorm:"type:text"`       // 可用的时间窗口，JSON 格式
+	MultiKey           bool    `json:"multi_key" gorm:"default:false"`   // 多密钥模式，Key 中每行一个密钥
+	KeySelection       string  `json:"key_selection" gorm:"type:varchar(32);default:''"`
 
 	DisabledStream *datatypes.JSONSlice[string] `json:"disabled_stream,omitempty" gorm:"type:json"`
+	EmulatedTools  *datatypes.JSONSlice[string] `json:"emulated_tools,omitempty" gorm:"type:json"`
+	DisabledKeys   *datatypes.JSONSlice[string] `json:"disabled_keys,omitempty" gorm:"type:json"` // 多密钥模式下被禁用的密钥摘要
 
 	Plugin    *datatypes.JSONType[PluginType] `json:"plugin" form:"plugin" gorm:"type:json"`
 	DeletedAt gorm.DeletedAt                  `json:"-" gorm:"index"`
+
+	rateLimits   *ChannelRateLimits
+	schedules    *channelSchedules
+	keys         []string
+	rewriteRules []*rewrite.Rule
 }
 
 func (c *Channel) AllowStream(modelName string) bool {
@@ -57,6 +71,15 @@ func (c *Channel) AllowStream(modelName string) bool {
 	return !slices.Contains(*c.DisabledStream, modelName)
 }
 
+// EmulateTools 模型不支持原生工具调用时，通过提示词模拟，* 表示渠道下的所有模型
+func (c *Channel) EmulateTools(modelName string) bool {
+	if c.EmulatedTools == nil {
+		return false
+	}
+
+	return slices.Contains(*c.EmulatedTools, modelName) || slices.Contains(*c.EmulatedTools, "*")
+}
+
 type PluginType map[string]map[string]interface{}
 
 var allowedChannelOrderFields = map[string]bool{
@@ -171,7 +194,7 @@ func GetChannelsByTag(tag string) ([]*Channel, error) {
 func DeleteChannelTag(channelId int) error {
 	result := DB.Model(&Channel{}).Where("id = ?", channelId).Update("tag", "")
 	if result.Error == nil && result.RowsAffected > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 	return result.Error
 }
@@ -179,7 +202,7 @@ func DeleteChannelTag(channelId int) error {
 func BatchDeleteChannel(ids []int) (int64, error) {
 	result := DB.Where("id IN ?", ids).Delete(&Channel{})
 	if result.Error == nil && result.RowsAffected > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 	return result.RowsAffected, result.Error
 }
@@ -190,7 +213,7 @@ func BatchInsertChannels(channels []Channel) error {
 		return err
 	}
 
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 	return nil
 }
 
@@ -206,7 +229,7 @@ func BatchUpdateChannelsAzureApi(params *BatchChannelsParams) (int64, error) {
 	}
 
 	if db.RowsAffected > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 	return db.RowsAffected, nil
 }
@@ -235,7 +258,7 @@ func BatchDelModelChannels(params *BatchChannelsParams) (int64, error) {
 	}
 
 	if count > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 
 	return count, nil
@@ -288,7 +311,7 @@ func BatchAddUserGroupToChannels(params *BatchChannelsParams) (int64, error) {
 	}
 
 	if count > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 
 	return count, nil
@@ -360,7 +383,7 @@ func BatchAddModelToChannels(params *BatchChannelsParams) (int64, error) {
 	}
 
 	if count > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 
 	return count, nil
@@ -407,10 +430,32 @@ func (channel *Channel) GetCustomParameter() string {
 	return *channel.CustomParameter
 }
 
+// GetRewriteRules 解析渠道的改写规则，未配置时返回 nil
+func (channel *Channel) GetRewriteRules() ([]*rewrite.Rule, error) {
+	if channel.RewriteRules == nil {
+		return nil, nil
+	}
+	return rewrite.ParseRules(*channel.RewriteRules)
+}
+
+// loadRewriteRules 加载渠道时解析改写规则，请求时不再重复解析，格式错误时忽略全部规则
+func (channel *Channel) loadRewriteRules() {
+	rules, err := channel.GetRewriteRules()
+	if err != nil {
+		logger.SysError(fmt.Sprintf("channel %d rewrite rules are invalid: %s", channel.Id, err.Error()))
+	}
+	channel.rewriteRules = rules
+}
+
+// LoadedRewriteRules 返回加载渠道时解析好的改写规则
+func (channel *Channel) LoadedRewriteRules() []*rewrite.Rule {
+	return channel.rewriteRules
+}
+
 func (channel *Channel) Insert() error {
 	err := DB.Omit("UsedQuota").Create(channel).Error
 	if err == nil {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 
 	return err
@@ -421,8 +466,13 @@ func (channel *Channel) Update(overwrite bool) error {
 	err := channel.UpdateRaw(overwrite)
 
 	if err == nil {
-		ChannelGroup.Load()
-		ChannelGroup.ClearChannelCooldowns(channel.Id)
+		// 重新加载时会按数据库中的记录恢复密钥的禁用状态
+		ClearChannelKeyStates(channel.Id)
+		ChannelGroup.Reload()
+		ChannelGroup.ResetChannelCircuits(channel.Id)
+		ClearChannelModelStats(channel.Id)
+		ClearPrefixCacheStats(channel.Id)
+		publishChannelReset(channel.Id)
 	}
 
 	return err
@@ -431,10 +481,11 @@ func (channel *Channel) Update(overwrite bool) error {
 func (channel *Channel) UpdateRaw(overwrite bool) error {
 	var err error
 
+	// 密钥的禁用状态只通过密钥管理接口修改
 	if overwrite {
-		err = DB.Model(channel).Select("*").Omit("UsedQuota").Updates(channel).Error
+		err = DB.Model(channel).Select("*").Omit("UsedQuota", "DisabledKeys").Updates(channel).Error
 	} else {
-		err = DB.Model(channel).Omit("UsedQuota").Updates(channel).Error
+		err = DB.Model(channel).Omit("UsedQuota", "DisabledKeys").Updates(channel).Error
 	}
 	if err != nil {
 		return err
@@ -466,7 +517,11 @@ func (channel *Channel) UpdateBalance(balance float64) {
 func (channel *Channel) Delete() error {
 	err := DB.Delete(channel).Error
 	if err == nil {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
+		ClearChannelModelStats(channel.Id)
+		ClearChannelKeyStates(channel.Id)
+		ClearPrefixCacheStats(channel.Id)
+		publishChannelReset(channel.Id)
 	}
 	return err
 }
@@ -498,9 +553,9 @@ func UpdateChannelStatusById(id int, status int) {
 	isEnabled := status == config.ChannelStatusEnabled
 	go ChannelGroup.ChangeStatus(id, isEnabled)
 
-	// 启用渠道时清除冻结缓存
+	// 启用渠道时重置熔断器
 	if isEnabled {
-		ChannelGroup.ClearChannelCooldowns(id)
+		ChannelGroup.ResetChannelCircuits(id)
 	}
 }
 
@@ -541,7 +596,7 @@ func UpdateChannelKey(id int, key string) error {
 	}
 
 	ClearChannelTokenCache(id)
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 
 	return nil
 }
@@ -549,7 +604,7 @@ func UpdateChannelKey(id int, key string) error {
 func DeleteDisabledChannel() (int64, error) {
 	result := DB.Where("status = ? or status = ?", config.ChannelStatusAutoDisabled, config.ChannelStatusManuallyDisabled).Delete(&Channel{})
 	if result.Error == nil && result.RowsAffected > 0 {
-		ChannelGroup.Load()
+		ChannelGroup.Reload()
 	}
 	return result.RowsAffected, result.Error
 }
@@ -0,0 +1,445 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+const (
+	KeySelectionRoundRobin = "round_robin"
+	KeySelectionRandom     = "random"
+)
+
+// 同步给其他节点的密钥状态
+const (
+	channelKeyCooldown = "cooldown"
+	channelKeyDisabled = "disabled"
+	channelKeyEnabled  = "enabled"
+)
+
+// channelKeyState 多密钥渠道中单个密钥的状态，保存在内存中，禁用状态同时写入数据库
+type channelKeyState struct {
+	sync.Mutex
+	disabled      bool
+	disableReason string
+	cooldownCount int // 连续冷却的次数，冷却时长按指数增长
+	cooldownUntil time.Time
+	requests      int64
+	failures      int64
+	lastError     string
+	lastUsedAt    time.Time
+}
+
+// ChannelKeyInfo 密钥的脱敏信息与统计，用于管理接口展示
+type ChannelKeyInfo struct {
+	Index         int    `json:"index"`
+	Key           string `json:"key"`
+	Hash          string `json:"hash"`
+	Disabled      bool   `json:"disabled"`
+	DisableReason string `json:"disable_reason"`
+	CooldownUntil int64  `json:"cooldown_until"`
+	Requests      int64  `json:"requests"`
+	Failures      int64  `json:"failures"`
+	LastError     string `json:"last_error"`
+	LastUsedAt    int64  `json:"last_used_at"`
+}
+
+var (
+	// channelId:hash -> *channelKeyState
+	channelKeyStates sync.Map
+	// channelId -> *atomic.Uint64，轮询选择密钥的游标
+	channelKeyCursors sync.Map
+	// 禁用密钥时需要读取并写回数据库中的列表
+	channelDisabledKeysMu sync.Mutex
+)
+
+// ChannelKeyHash 密钥的摘要，用于在不暴露密钥的情况下标识密钥，编辑密钥列表后顺序变化也不影响禁用状态
+func ChannelKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+func maskChannelKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", 8) + key[len(key)-4:]
+}
+
+func channelKeyStateKey(channelId int, hash string) string {
+	return fmt.Sprintf("%d:%s", channelId, hash)
+}
+
+func getChannelKeyState(channelId int, hash string) *channelKeyState {
+	value, _ := channelKeyStates.LoadOrStore(channelKeyStateKey(channelId, hash), &channelKeyState{})
+	return value.(*channelKeyState)
+}
+
+// available 调用方需要持有锁
+func (s *channelKeyState) available(now time.Time) bool {
+	return !s.disabled && !now.Before(s.cooldownUntil)
+}
+
+// GetKeys 多密钥模式下每行一个密钥，否则整个 Key 作为一个密钥
+func (channel *Channel) GetKeys() []string {
+	if channel.keys != nil {
+		return channel.keys
+	}
+	if !channel.MultiKey {
+		return []string{channel.Key}
+	}
+
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// loadKeys 加载渠道时拆分密钥，并按数据库中的记录恢复密钥的禁用状态
+func (channel *Channel) loadKeys() {
+	if !channel.MultiKey {
+		return
+	}
+
+	channel.keys = channel.GetKeys()
+
+	var disabledKeys []string
+	if channel.DisabledKeys != nil {
+		disabledKeys = *channel.DisabledKeys
+	}
+	for _, key := range channel.keys {
+		hash := ChannelKeyHash(key)
+		state := getChannelKeyState(channel.Id, hash)
+		state.Lock()
+		state.disabled = slices.Contains(disabledKeys, hash)
+		if !state.disabled {
+			state.disableReason = ""
+		}
+		state.Unlock()
+	}
+}
+
+// HasAvailableKey 多密钥渠道是否还有未禁用且不在冷却中的密钥
+func (channel *Channel) HasAvailableKey() bool {
+	if !channel.MultiKey {
+		return true
+	}
+
+	now := time.Now()
+	for _, key := range channel.GetKeys() {
+		state := getChannelKeyState(channel.Id, ChannelKeyHash(key))
+		state.Lock()
+		available := state.available(now)
+		state.Unlock()
+		if available {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasEnabledKey 多密钥渠道是否还有未禁用的密钥，冷却中的密钥也算在内
+func (channel *Channel) HasEnabledKey() bool {
+	if !channel.MultiKey {
+		return true
+	}
+
+	for _, key := range channel.GetKeys() {
+		state := getChannelKeyState(channel.Id, ChannelKeyHash(key))
+		state.Lock()
+		disabled := state.disabled
+		state.Unlock()
+		if !disabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SelectKey 为本次请求选择一个密钥，返回只包含该密钥的渠道副本
+// 没有可用的密钥时优先使用冷却中的密钥，仍然没有时使用第一个密钥
+func (channel *Channel) SelectKey() (int, *Channel) {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || len(keys) == 0 {
+		return 0, channel
+	}
+
+	now := time.Now()
+	available := make([]int, 0, len(keys))
+	cooling := make([]int, 0)
+	for index, key := range keys {
+		state := getChannelKeyState(channel.Id, ChannelKeyHash(key))
+		state.Lock()
+		if state.available(now) {
+			available = append(available, index)
+		} else if !state.disabled {
+			cooling = append(cooling, index)
+		}
+		state.Unlock()
+	}
+	if len(available) == 0 {
+		available = cooling
+	}
+
+	index := 0
+	if len(available) > 0 {
+		if channel.KeySelection == KeySelectionRandom {
+			index = available[rand.Intn(len(available))]
+		} else {
+			value, _ := channelKeyCursors.LoadOrStore(channel.Id, &atomic.Uint64{})
+			cursor := value.(*atomic.Uint64).Add(1) - 1
+			index = available[cursor%uint64(len(available))]
+		}
+	}
+
+	state := getChannelKeyState(channel.Id, ChannelKeyHash(keys[index]))
+	state.Lock()
+	state.requests++
+	state.lastUsedAt = now
+	state.Unlock()
+
+	keyed := *channel
+	keyed.Key = keys[index]
+	return index, &keyed
+}
+
+// RecordKeySuccess 请求成功后重置密钥的连续冷却次数
+func (channel *Channel) RecordKeySuccess(index int) {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || index < 0 || index >= len(keys) {
+		return
+	}
+
+	state := getChannelKeyState(channel.Id, ChannelKeyHash(keys[index]))
+	state.Lock()
+	state.cooldownCount = 0
+	state.Unlock()
+}
+
+// RecordKeyFailure 记录密钥的失败次数与最后一次错误
+func (channel *Channel) RecordKeyFailure(index int, message string) {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || index < 0 || index >= len(keys) {
+		return
+	}
+
+	state := getChannelKeyState(channel.Id, ChannelKeyHash(keys[index]))
+	state.Lock()
+	state.failures++
+	state.lastError = message
+	state.Unlock()
+}
+
+// CooldownKey 密钥被上游限流时暂停使用，durationSeconds 大于 0 时使用指定的时长，否则按连续冷却次数退避
+func (channel *Channel) CooldownKey(index int, durationSeconds int64) {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || index < 0 || index >= len(keys) {
+		return
+	}
+
+	hash := ChannelKeyHash(keys[index])
+	state := getChannelKeyState(channel.Id, hash)
+	state.Lock()
+	state.cooldownCount++
+	duration := circuitOpenDuration(state.cooldownCount)
+	if durationSeconds > 0 {
+		duration = time.Duration(durationSeconds) * time.Second
+	}
+	state.cooldownUntil = time.Now().Add(duration)
+	cooldownUntil := state.cooldownUntil
+	state.Unlock()
+
+	publishChannelKeyState(channel.Id, hash, channelKeyCooldown, cooldownUntil, "")
+}
+
+// DisableKey 禁用鉴权失败或额度耗尽的密钥，禁用状态写入数据库，重启后仍然生效
+func (channel *Channel) DisableKey(index int, reason string) error {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || index < 0 || index >= len(keys) {
+		return errors.New("密钥不存在")
+	}
+
+	hash := ChannelKeyHash(keys[index])
+	if err := setChannelKeyDisabled(channel.Id, hash, true); err != nil {
+		return err
+	}
+
+	state := getChannelKeyState(channel.Id, hash)
+	state.Lock()
+	state.disabled = true
+	state.disableReason = reason
+	state.Unlock()
+
+	publishChannelKeyState(channel.Id, hash, channelKeyDisabled, time.Time{}, reason)
+	return nil
+}
+
+// EnableKey 重新启用密钥，同时清除冷却状态
+func (channel *Channel) EnableKey(index int) error {
+	keys := channel.GetKeys()
+	if !channel.MultiKey || index < 0 || index >= len(keys) {
+		return errors.New("密钥不存在")
+	}
+
+	hash := ChannelKeyHash(keys[index])
+	if err := setChannelKeyDisabled(channel.Id, hash, false); err != nil {
+		return err
+	}
+
+	state := getChannelKeyState(channel.Id, hash)
+	state.Lock()
+	state.disabled = false
+	state.disableReason = ""
+	state.cooldownCount = 0
+	state.cooldownUntil = time.Time{}
+	state.Unlock()
+
+	publishChannelKeyState(channel.Id, hash, channelKeyEnabled, time.Time{}, "")
+	return nil
+}
+
+// applyChannelKeyState 应用其他节点发布的密钥状态，禁用状态已由发布的节点写入数据库
+func applyChannelKeyState(channelId int, hash, status string, until int64, reason string) {
+	state := getChannelKeyState(channelId, hash)
+	state.Lock()
+	defer state.Unlock()
+
+	switch status {
+	case channelKeyCooldown:
+		if cooldownUntil := time.UnixMilli(until); cooldownUntil.After(state.cooldownUntil) {
+			state.cooldownUntil = cooldownUntil
+		}
+	case channelKeyDisabled:
+		state.disabled = true
+		state.disableReason = reason
+	case channelKeyEnabled:
+		state.disabled = false
+		state.disableReason = ""
+		state.cooldownCount = 0
+		state.cooldownUntil = time.Time{}
+	}
+}
+
+func setChannelKeyDisabled(channelId int, hash string, disabled bool) error {
+	channelDisabledKeysMu.Lock()
+	defer channelDisabledKeysMu.Unlock()
+
+	channel := &Channel{}
+	if err := DB.Select("id", "disabled_keys").First(channel, "id = ?", channelId).Error; err != nil {
+		return err
+	}
+
+	var disabledKeys []string
+	if channel.DisabledKeys != nil {
+		disabledKeys = *channel.DisabledKeys
+	}
+
+	index := slices.Index(disabledKeys, hash)
+	if disabled == (index >= 0) {
+		return nil
+	}
+	if disabled {
+		disabledKeys = append(disabledKeys, hash)
+	} else {
+		disabledKeys = slices.Delete(disabledKeys, index, index+1)
+	}
+
+	return DB.Model(&Channel{}).Where("id = ?", channelId).Update("disabled_keys", datatypes.NewJSONSlice(disabledKeys)).Error
+}
+
+// GetKeyInfos 返回密钥的脱敏信息与统计
+func (channel *Channel) GetKeyInfos() []*ChannelKeyInfo {
+	now := time.Now()
+	var disabledKeys []string
+	if channel.DisabledKeys != nil {
+		disabledKeys = *channel.DisabledKeys
+	}
+
+	infos := make([]*ChannelKeyInfo, 0)
+	for index, key := range channel.GetKeys() {
+		hash := ChannelKeyHash(key)
+		info := &ChannelKeyInfo{
+			Index:    index,
+			Key:      maskChannelKey(key),
+			Hash:     hash,
+			Disabled: slices.Contains(disabledKeys, hash),
+		}
+
+		if value, ok := channelKeyStates.Load(channelKeyStateKey(channel.Id, hash)); ok {
+			state := value.(*channelKeyState)
+			state.Lock()
+			info.DisableReason = state.disableReason
+			if now.Before(state.cooldownUntil) {
+				info.CooldownUntil = state.cooldownUntil.Unix()
+			}
+			info.Requests = state.requests
+			info.Failures = state.failures
+			info.LastError = state.lastError
+			if !state.lastUsedAt.IsZero() {
+				info.LastUsedAt = state.lastUsedAt.Unix()
+			}
+			state.Unlock()
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// publishChannelKeySnapshot 向其他节点发送本节点冷却中的密钥，禁用的密钥保存在数据库中，不需要发送
+func publishChannelKeySnapshot() {
+	now := time.Now()
+	channelKeyStates.Range(func(key, value any) bool {
+		state := value.(*channelKeyState)
+		state.Lock()
+		cooldownUntil := state.cooldownUntil
+		state.Unlock()
+		if !now.Before(cooldownUntil) {
+			return true
+		}
+
+		channelIdText, hash, _ := strings.Cut(key.(string), ":")
+		if channelId, err := strconv.Atoi(channelIdText); err == nil {
+			publishChannelKeyState(channelId, hash, channelKeyCooldown, cooldownUntil, "")
+		}
+		return true
+	})
+}
+
+// ClearChannelKeyStates 清除渠道所有密钥的内存状态，渠道修改或删除后调用
+func ClearChannelKeyStates(channelId int) {
+	prefix := fmt.Sprintf("%d:", channelId)
+	channelKeyStates.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			channelKeyStates.Delete(key)
+		}
+		return true
+	})
+	channelKeyCursors.Delete(channelId)
+}
+
+// FilterUnavailableKeys 跳过所有密钥都已禁用或冷却中的多密钥渠道
+func FilterUnavailableKeys() ChannelsFilterFunc {
+	return func(_ int, choice *ChannelChoice) bool {
+		return !choice.Channel.HasAvailableKey()
+	}
+}
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"done-hub/common/limit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChannelRateLimit 上游每分钟的请求数与 tokens 额度，0 表示不限制
+type ChannelRateLimit struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+// ChannelRateLimits 渠道整体的额度，以及按模型单独设置的额度，两者同时生效
+// 模型名称支持以 * 结尾的前缀匹配
+type ChannelRateLimits struct {
+	ChannelRateLimit
+	Models map[string]ChannelRateLimit `json:"models,omitempty"`
+}
+
+// ChannelRateLimitStatus 某项额度的当前用量，用于管理接口展示
+type ChannelRateLimitStatus struct {
+	Model        string `json:"model"` // 为空表示渠道整体的额度
+	RPM          int    `json:"rpm"`
+	RPMUsed      int    `json:"rpm_used"`
+	RPMRemaining int    `json:"rpm_remaining"`
+	TPM          int    `json:"tpm"`
+	TPMUsed      int    `json:"tpm_used"`
+	TPMRemaining int    `json:"tpm_remaining"`
+}
+
+// channelBudget 一次请求需要检查的一项额度
+type channelBudget struct {
+	key   string
+	limit int
+	cost  int
+}
+
+func ParseChannelRateLimits(text string) (*ChannelRateLimits, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	rateLimits := &ChannelRateLimits{}
+	if err := json.Unmarshal([]byte(text), rateLimits); err != nil {
+		return nil, fmt.Errorf("rate_limits is not valid JSON: %s", err.Error())
+	}
+
+	if rateLimits.RPM < 0 || rateLimits.TPM < 0 {
+		return nil, errors.New("rate_limits rpm and tpm must not be negative")
+	}
+	for modelName, rateLimit := range rateLimits.Models {
+		if modelName == "" {
+			return nil, errors.New("rate_limits model name is empty")
+		}
+		if rateLimit.RPM < 0 || rateLimit.TPM < 0 {
+			return nil, fmt.Errorf("rate_limits rpm and tpm of model %s must not be negative", modelName)
+		}
+	}
+
+	return rateLimits, nil
+}
+
+// GetRateLimits 解析渠道的额度设置，未配置时返回 nil
+func (channel *Channel) GetRateLimits() (*ChannelRateLimits, error) {
+	if channel.RateLimits == nil {
+		return nil, nil
+	}
+
+	return ParseChannelRateLimits(*channel.RateLimits)
+}
+
+// loadRateLimits 加载渠道时解析额度设置，选择渠道时不再重复解析
+func (channel *Channel) loadRateLimits() {
+	channel.rateLimits, _ = channel.GetRateLimits()
+}
+
+// modelRateLimit 返回模型单独设置的额度，精确匹配优先
+func (rl *ChannelRateLimits) modelRateLimit(modelName string) (string, ChannelRateLimit, bool) {
+	if rateLimit, ok := rl.Models[modelName]; ok {
+		return modelName, rateLimit, true
+	}
+
+	matched := ""
+	for pattern := range rl.Models {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(modelName, strings.TrimSuffix(pattern, "*")) && len(pattern) > len(matched) {
+			matched = pattern
+		}
+	}
+	if matched == "" {
+		return "", ChannelRateLimit{}, false
+	}
+
+	return matched, rl.Models[matched], true
+}
+
+func channelBudgetKey(channelId int, modelName, kind string) string {
+	if modelName == "" {
+		return fmt.Sprintf("channel:%d:%s", channelId, kind)
+	}
+	return fmt.Sprintf("channel:%d:model:%s:%s", channelId, modelName, kind)
+}
+
+// budgets 一次请求需要检查的额度，按模型的通配设置共享同一份额度
+func (channel *Channel) budgets(modelName string, tokens int) []channelBudget {
+	rl := channel.rateLimits
+	if rl == nil {
+		return nil
+	}
+
+	var budgets []channelBudget
+	add := func(scope string, rateLimit ChannelRateLimit) {
+		if rateLimit.RPM > 0 {
+			budgets = append(budgets, channelBudget{key: channelBudgetKey(channel.Id, scope, "rpm"), limit: rateLimit.RPM, cost: 1})
+		}
+		if rateLimit.TPM > 0 {
+			// 预估超过整个额度的请求最多占满额度，否则永远无法选中该渠道，只能排队到超时
+			budgets = append(budgets, channelBudget{key: channelBudgetKey(channel.Id, scope, "tpm"), limit: rateLimit.TPM, cost: min(max(tokens, 1), rateLimit.TPM)})
+		}
+	}
+
+	add("", rl.ChannelRateLimit)
+	if scope, rateLimit, ok := rl.modelRateLimit(modelName); ok {
+		add(scope, rateLimit)
+	}
+
+	return budgets
+}
+
+// HasRateLimitHeadroom 渠道剩余的额度是否足够本次请求，tokens 为预估的 tokens 数量
+func (channel *Channel) HasRateLimitHeadroom(modelName string, tokens int) bool {
+	for _, budget := range channel.budgets(modelName, tokens) {
+		if limit.NewBudgetLimiter(budget.limit, time.Minute).Used(budget.key)+budget.cost > budget.limit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReserveRateLimit 预留本次请求的额度，任意一项不足时归还已预留的额度并返回 false
+func (channel *Channel) ReserveRateLimit(modelName string, tokens int) bool {
+	budgets := channel.budgets(modelName, tokens)
+	for i, budget := range budgets {
+		if limit.NewBudgetLimiter(budget.limit, time.Minute).Reserve(budget.key, budget.cost) {
+			continue
+		}
+
+		for _, reserved := range budgets[:i] {
+			limit.NewBudgetLimiter(reserved.limit, time.Minute).Adjust(reserved.key, -reserved.cost)
+		}
+		return false
+	}
+
+	return true
+}
+
+// AdjustRateLimitTokens 请求结束后按实际的 tokens 修正预留的用量
+func (channel *Channel) AdjustRateLimitTokens(modelName string, reservedTokens, actualTokens int) {
+	for _, budget := range channel.budgets(modelName, reservedTokens) {
+		if !strings.HasSuffix(budget.key, ":tpm") {
+			continue
+		}
+		limit.NewBudgetLimiter(budget.limit, time.Minute).Adjust(budget.key, actualTokens-budget.cost)
+	}
+}
+
+// GetRateLimitStatus 返回渠道各项额度的当前用量
+func (channel *Channel) GetRateLimitStatus() ([]*ChannelRateLimitStatus, error) {
+	rl, err := channel.GetRateLimits()
+	if err != nil || rl == nil {
+		return nil, err
+	}
+
+	status := func(scope string, rateLimit ChannelRateLimit) *ChannelRateLimitStatus {
+		item := &ChannelRateLimitStatus{Model: scope, RPM: rateLimit.RPM, TPM: rateLimit.TPM}
+		if rateLimit.RPM > 0 {
+			item.RPMUsed = limit.NewBudgetLimiter(rateLimit.RPM, time.Minute).Used(channelBudgetKey(channel.Id, scope, "rpm"))
+			item.RPMRemaining = max(rateLimit.RPM-item.RPMUsed, 0)
+		}
+		if rateLimit.TPM > 0 {
+			item.TPMUsed = limit.NewBudgetLimiter(rateLimit.TPM, time.Minute).Used(channelBudgetKey(channel.Id, scope, "tpm"))
+			item.TPMRemaining = max(rateLimit.TPM-item.TPMUsed, 0)
+		}
+		return item
+	}
+
+	result := []*ChannelRateLimitStatus{status("", rl.ChannelRateLimit)}
+	scopes := make([]string, 0, len(rl.Models))
+	for scope := range rl.Models {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		result = append(result, status(scope, rl.Models[scope]))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,325 @@
+package model
+
+import (
+	"done-hub/common/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ChannelScheduleWindows 渠道的可用时间窗口，每条规则是标准的 5 段 cron 表达式（分 时 日 月 周），表达式匹配的每一分钟都在窗口内
+// Active 为空表示全天可用；Inactive 为维护窗口，优先于 Active
+type ChannelScheduleWindows struct {
+	Active   []string `json:"active,omitempty"`
+	Inactive []string `json:"inactive,omitempty"`
+}
+
+// ChannelSchedules 渠道整体的时间窗口，以及按模型单独设置的时间窗口，两者同时生效
+// 模型名称支持以 * 结尾的前缀匹配
+type ChannelSchedules struct {
+	Timezone string `json:"timezone,omitempty"` // IANA 时区，例如 Asia/Shanghai，为空时使用服务器时区
+	ChannelScheduleWindows
+	Models map[string]ChannelScheduleWindows `json:"models,omitempty"`
+}
+
+// ChannelScheduleStatus 渠道在指定时间的可用状态，用于管理接口展示
+type ChannelScheduleStatus struct {
+	ChannelId   int                    `json:"channel_id"`
+	ChannelName string                 `json:"channel_name"`
+	Timezone    string                 `json:"timezone"`
+	Scheduled   bool                   `json:"scheduled"` // 是否设置了时间窗口
+	Active      bool                   `json:"active"`
+	Models      []ChannelScheduleModel `json:"models"`
+	Schedules   *ChannelSchedules      `json:"schedules,omitempty"`
+}
+
+type ChannelScheduleModel struct {
+	Model  string `json:"model"`
+	Active bool   `json:"active"`
+}
+
+// scheduleWindows 解析后的时间窗口，state 保存定时任务最近一次计算的结果，选择渠道时直接读取
+type scheduleWindows struct {
+	active   []cron.Schedule
+	inactive []cron.Schedule
+	state    atomic.Bool
+}
+
+type channelSchedules struct {
+	timezone string
+	channel  *scheduleWindows
+	models   map[string]*scheduleWindows
+}
+
+var scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+func ParseChannelSchedules(text string) (*ChannelSchedules, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	schedules := &ChannelSchedules{}
+	if err := json.Unmarshal([]byte(text), schedules); err != nil {
+		return nil, fmt.Errorf("schedules is not valid JSON: %s", err.Error())
+	}
+
+	if _, err := schedules.compile(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (s *ChannelSchedules) compile() (*channelSchedules, error) {
+	location := time.Local
+	if s.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, fmt.Errorf("schedules timezone %s is invalid: %s", s.Timezone, err.Error())
+		}
+	}
+
+	compiled := &channelSchedules{
+		timezone: location.String(),
+		models:   make(map[string]*scheduleWindows, len(s.Models)),
+	}
+
+	var err error
+	if compiled.channel, err = s.ChannelScheduleWindows.compile(location); err != nil {
+		return nil, err
+	}
+	for modelName, windows := range s.Models {
+		if modelName == "" {
+			return nil, errors.New("schedules model name is empty")
+		}
+		if compiled.models[modelName], err = windows.compile(location); err != nil {
+			return nil, fmt.Errorf("schedules of model %s: %s", modelName, err.Error())
+		}
+	}
+
+	return compiled, nil
+}
+
+func (w ChannelScheduleWindows) compile(location *time.Location) (*scheduleWindows, error) {
+	parse := func(specs []string) ([]cron.Schedule, error) {
+		schedules := make([]cron.Schedule, 0, len(specs))
+		for _, spec := range specs {
+			schedule, err := scheduleParser.Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("schedules cron expression %q is invalid: %s", spec, err.Error())
+			}
+			schedule.(*cron.SpecSchedule).Location = location
+			schedules = append(schedules, schedule)
+		}
+		return schedules, nil
+	}
+
+	windows := &scheduleWindows{}
+	var err error
+	if windows.active, err = parse(w.Active); err != nil {
+		return nil, err
+	}
+	if windows.inactive, err = parse(w.Inactive); err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+// matchSchedule cron 表达式是否匹配 t 所在的这一分钟
+func matchSchedule(schedule cron.Schedule, t time.Time) bool {
+	minute := t.Truncate(time.Minute)
+	return schedule.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+// activeAt 不在维护窗口内，并且在活动窗口内或没有设置活动窗口
+func (w *scheduleWindows) activeAt(t time.Time) bool {
+	for _, schedule := range w.inactive {
+		if matchSchedule(schedule, t) {
+			return false
+		}
+	}
+	if len(w.active) == 0 {
+		return true
+	}
+	for _, schedule := range w.active {
+		if matchSchedule(schedule, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelWindows 返回模型单独设置的时间窗口，精确匹配优先
+func (s *channelSchedules) modelWindows(modelName string) (string, *scheduleWindows) {
+	if windows, ok := s.models[modelName]; ok {
+		return modelName, windows
+	}
+
+	matched := ""
+	for pattern := range s.models {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(modelName, strings.TrimSuffix(pattern, "*")) && len(pattern) > len(matched) {
+			matched = pattern
+		}
+	}
+	if matched == "" {
+		return "", nil
+	}
+
+	return matched, s.models[matched]
+}
+
+// GetSchedules 解析渠道的时间窗口设置，未配置时返回 nil
+func (channel *Channel) GetSchedules() (*ChannelSchedules, error) {
+	if channel.Schedules == nil {
+		return nil, nil
+	}
+
+	return ParseChannelSchedules(*channel.Schedules)
+}
+
+// loadSchedules 加载渠道时解析时间窗口并计算当前的状态，之后由定时任务每分钟刷新
+func (channel *Channel) loadSchedules() {
+	channel.schedules = nil
+
+	schedules, err := channel.GetSchedules()
+	if err != nil || schedules == nil {
+		return
+	}
+
+	compiled, err := schedules.compile()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	compiled.channel.state.Store(compiled.channel.activeAt(now))
+	for _, windows := range compiled.models {
+		windows.state.Store(windows.activeAt(now))
+	}
+	channel.schedules = compiled
+}
+
+// IsScheduleActive 渠道当前是否在时间窗口内，模型单独设置的时间窗口与渠道整体的同时生效
+func (channel *Channel) IsScheduleActive(modelName string) bool {
+	schedules := channel.schedules
+	if schedules == nil {
+		return true
+	}
+
+	if !schedules.channel.state.Load() {
+		return false
+	}
+	if _, windows := schedules.modelWindows(modelName); windows != nil && !windows.state.Load() {
+		return false
+	}
+
+	return true
+}
+
+// IsScheduleActiveAt 按时间窗口计算渠道在指定时间是否可用，modelName 为空时只计算渠道整体的时间窗口
+func (channel *Channel) IsScheduleActiveAt(modelName string, t time.Time) bool {
+	schedules := channel.schedules
+	if schedules == nil {
+		return true
+	}
+
+	if !schedules.channel.activeAt(t) {
+		return false
+	}
+	// 模型名称为空时不能参与前缀匹配，否则会命中 * 的时间窗口
+	if modelName == "" {
+		return true
+	}
+	if _, windows := schedules.modelWindows(modelName); windows != nil && !windows.activeAt(t) {
+		return false
+	}
+
+	return true
+}
+
+// RefreshSchedules 按当前时间刷新所有渠道的时间窗口状态，状态变化时记录日志
+func (cc *ChannelsChooser) RefreshSchedules() {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	now := time.Now()
+	for channelId, choice := range cc.Channels {
+		schedules := choice.Channel.schedules
+		if schedules == nil {
+			continue
+		}
+
+		if active := schedules.channel.activeAt(now); schedules.channel.state.Swap(active) != active {
+			logger.SysLog(fmt.Sprintf("channel_schedule channel_id=%d channel_name=%s active=%t", channelId, choice.Channel.Name, active))
+		}
+		for modelName, windows := range schedules.models {
+			if active := windows.activeAt(now); windows.state.Swap(active) != active {
+				logger.SysLog(fmt.Sprintf("channel_schedule channel_id=%d channel_name=%s model=%s active=%t", channelId, choice.Channel.Name, modelName, active))
+			}
+		}
+	}
+}
+
+// GetScheduleCalendar 返回已加载的渠道在指定时间的可用状态，group 与 modelName 为空时不过滤
+func (cc *ChannelsChooser) GetScheduleCalendar(t time.Time, group, modelName string) []*ChannelScheduleStatus {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	result := make([]*ChannelScheduleStatus, 0)
+	for channelId, choice := range cc.Channels {
+		channel := choice.Channel
+		if group != "" && !containsTrimmed(channel.Group, group) {
+			continue
+		}
+		if modelName != "" && !containsTrimmed(channel.Models, modelName) {
+			continue
+		}
+
+		status := &ChannelScheduleStatus{
+			ChannelId:   channelId,
+			ChannelName: channel.Name,
+			Active:      channel.IsScheduleActiveAt(modelName, t),
+			Models:      make([]ChannelScheduleModel, 0),
+		}
+
+		if schedules := channel.schedules; schedules != nil {
+			status.Scheduled = true
+			status.Timezone = schedules.timezone
+			status.Schedules, _ = channel.GetSchedules()
+			for pattern, windows := range schedules.models {
+				status.Models = append(status.Models, ChannelScheduleModel{
+					Model:  pattern,
+					Active: schedules.channel.activeAt(t) && windows.activeAt(t),
+				})
+			}
+			sort.Slice(status.Models, func(i, j int) bool {
+				return status.Models[i].Model < status.Models[j].Model
+			})
+		}
+
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ChannelId < result[j].ChannelId
+	})
+
+	return result
+}
+
+func containsTrimmed(list, item string) bool {
+	for _, value := range strings.Split(list, ",") {
+		if strings.TrimSpace(value) == item {
+			return true
+		}
+	}
+	return false
+}
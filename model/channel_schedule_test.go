@@ -0,0 +1,126 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func compileTestSchedules(t *testing.T, text string) *channelSchedules {
+	t.Helper()
+
+	schedules, err := ParseChannelSchedules(text)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	compiled, err := schedules.compile()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return compiled
+}
+
+func TestMatchScheduleTimezone(t *testing.T) {
+	compiled := compileTestSchedules(t, `{"timezone":"Asia/Shanghai","active":["0 9 * * *"]}`)
+	schedule := compiled.channel.active[0]
+
+	cases := []struct {
+		name     string
+		at       time.Time
+		expected bool
+	}{
+		{name: "nine in shanghai", at: time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC), expected: true},
+		{name: "seconds within the minute", at: time.Date(2026, 3, 2, 1, 0, 59, 0, time.UTC), expected: true},
+		{name: "nine in utc", at: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), expected: false},
+		{name: "next minute", at: time.Date(2026, 3, 2, 1, 1, 0, 0, time.UTC), expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, matchSchedule(schedule, tc.at))
+		})
+	}
+}
+
+func TestScheduleWindowsActiveAt(t *testing.T) {
+	// 2026-03-02 是周一
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 2, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name     string
+		text     string
+		at       time.Time
+		expected bool
+	}{
+		{name: "inside active window", text: `{"timezone":"UTC","active":["* 9-17 * * 1-5"],"inactive":["* 12 * * *"]}`, at: monday(10, 30), expected: true},
+		{name: "inactive takes precedence", text: `{"timezone":"UTC","active":["* 9-17 * * 1-5"],"inactive":["* 12 * * *"]}`, at: monday(12, 30), expected: false},
+		{name: "outside active window", text: `{"timezone":"UTC","active":["* 9-17 * * 1-5"]}`, at: time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC), expected: false},
+		{name: "only inactive windows", text: `{"timezone":"UTC","inactive":["* 2-3 * * *"]}`, at: monday(10, 0), expected: true},
+		{name: "only inactive windows matched", text: `{"timezone":"UTC","inactive":["* 2-3 * * *"]}`, at: monday(2, 15), expected: false},
+		{name: "window in channel timezone", text: `{"timezone":"Asia/Shanghai","active":["* 9-17 * * *"]}`, at: monday(2, 0), expected: true},
+		{name: "utc hour outside channel timezone", text: `{"timezone":"Asia/Shanghai","active":["* 9-17 * * *"]}`, at: monday(10, 0), expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiled := compileTestSchedules(t, tc.text)
+			assert.Equal(t, tc.expected, compiled.channel.activeAt(tc.at))
+		})
+	}
+}
+
+func TestScheduleModelWindows(t *testing.T) {
+	compiled := compileTestSchedules(t, `{"models":{"gpt-4o":{},"gpt-*":{},"gpt-4*":{},"*":{}}}`)
+	withoutWildcard := compileTestSchedules(t, `{"models":{"gpt-4o":{},"gpt-*":{}}}`)
+
+	cases := []struct {
+		name      string
+		schedules *channelSchedules
+		model     string
+		expected  string
+	}{
+		{name: "exact match first", schedules: compiled, model: "gpt-4o", expected: "gpt-4o"},
+		{name: "longest prefix", schedules: compiled, model: "gpt-4o-mini", expected: "gpt-4*"},
+		{name: "shorter prefix", schedules: compiled, model: "gpt-3.5-turbo", expected: "gpt-*"},
+		{name: "wildcard matches all", schedules: compiled, model: "claude-3-5-sonnet", expected: "*"},
+		{name: "no match", schedules: withoutWildcard, model: "claude-3-5-sonnet", expected: ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pattern, windows := tc.schedules.modelWindows(tc.model)
+			assert.Equal(t, tc.expected, pattern)
+			assert.Equal(t, tc.expected != "", windows != nil)
+		})
+	}
+}
+
+func TestGetScheduleCalendarWithoutModel(t *testing.T) {
+	text := `{"timezone":"UTC","active":["* 9-17 * * *"],"models":{"*":{"inactive":["* * * * *"]}}}`
+	channel := &Channel{Id: 1, Name: "scheduled", Group: "default", Models: "gpt-4o", Schedules: &text}
+	channel.loadSchedules()
+	cc := &ChannelsChooser{Channels: map[int]*ChannelChoice{1: {Channel: channel}}}
+
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	// 不指定模型时只按渠道整体的时间窗口计算，不会命中 * 的时间窗口
+	calendar := cc.GetScheduleCalendar(at, "", "")
+	if assert.Len(t, calendar, 1) {
+		assert.True(t, calendar[0].Active)
+		assert.True(t, calendar[0].Scheduled)
+		assert.Equal(t, []ChannelScheduleModel{{Model: "*", Active: false}}, calendar[0].Models)
+	}
+
+	calendar = cc.GetScheduleCalendar(at, "", "gpt-4o")
+	if assert.Len(t, calendar, 1) {
+		assert.False(t, calendar[0].Active)
+	}
+
+	calendar = cc.GetScheduleCalendar(time.Date(2026, 3, 2, 20, 0, 0, 0, time.UTC), "default", "")
+	if assert.Len(t, calendar, 1) {
+		assert.False(t, calendar[0].Active)
+	}
+}
@@ -152,12 +152,14 @@ func UpdateChannelsTag(tag string, channel *Channel) error {
 			ModelMapping:       channel.ModelMapping,
 			ModelHeaders:       channel.ModelHeaders,
 			CustomParameter:    channel.CustomParameter,
+			RewriteRules:       channel.RewriteRules,
 			Proxy:              channel.Proxy,
 			TestModel:          channel.TestModel,
 			OnlyChat:           channel.OnlyChat,
 			Plugin:             channel.Plugin,
 			PreCost:            channel.PreCost,
 			DisabledStream:     channel.DisabledStream,
+			EmulatedTools:      channel.EmulatedTools,
 			CompatibleResponse: channel.CompatibleResponse,
 		}).Error
 
@@ -168,7 +170,7 @@ func UpdateChannelsTag(tag string, channel *Channel) error {
 
 	tx.Commit()
 
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 
 	return err
 }
@@ -191,7 +193,7 @@ func DeleteChannelsTag(tag string, delDisabled bool) error {
 	}
 
 	tx.Commit()
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 
 	return err
 }
@@ -206,7 +208,7 @@ func ChangeChannelsTagStatus(tag string, status int) error {
 		return err
 	}
 
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 
 	return nil
 }
@@ -217,6 +219,6 @@ func UpdateChannelsTagPriority(tag string, value int) error {
 		return err
 	}
 
-	ChannelGroup.Load()
+	ChannelGroup.Reload()
 	return nil
 }
@@ -0,0 +1,408 @@
+package model
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/notify"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CircuitStateClosed   = "closed"    // 正常放行
+	CircuitStateOpen     = "open"      // 熔断中，不再选择该渠道
+	CircuitStateHalfOpen = "half_open" // 熔断到期，只放行少量试探请求
+)
+
+// 试探请求超过该时间仍没有结果时不再占用名额，避免请求未走到上报结果的流程时一直停留在半开状态
+const circuitTrialTimeout = 60 * time.Second
+
+// circuitBreaker 渠道在某个模型上的熔断器
+type circuitBreaker struct {
+	sync.Mutex
+	state               string
+	consecutiveFailures int
+	windowStart         time.Time // 错误率统计窗口的开始时间
+	windowRequests      int
+	windowFailures      int
+	openCount           int // 连续熔断的次数，熔断时长按指数增长
+	openUntil           time.Time
+	trials              int // 半开状态下正在进行的试探请求
+	trialSuccesses      int
+	trialDeadline       time.Time
+}
+
+func circuitKey(channelId int, modelName string) string {
+	return fmt.Sprintf("%d:%s", channelId, modelName)
+}
+
+func (cc *ChannelsChooser) getCircuitBreaker(key string, create bool) *circuitBreaker {
+	if !create {
+		value, ok := cc.Breakers.Load(key)
+		if !ok {
+			return nil
+		}
+		return value.(*circuitBreaker)
+	}
+
+	value, _ := cc.Breakers.LoadOrStore(key, &circuitBreaker{state: CircuitStateClosed, windowStart: time.Now()})
+	return value.(*circuitBreaker)
+}
+
+// circuitOpenDuration 第 n 次连续熔断的时长为 RetryCooldownSeconds * 2^(n-1)，不超过 CircuitBreakerMaxOpenSeconds
+func circuitOpenDuration(openCount int) time.Duration {
+	base := max(config.RetryCooldownSeconds, 1)
+	seconds := base
+	for i := 1; i < openCount && seconds < config.CircuitBreakerMaxOpenSeconds; i++ {
+		seconds *= 2
+	}
+	if config.CircuitBreakerMaxOpenSeconds > 0 {
+		seconds = min(seconds, max(config.CircuitBreakerMaxOpenSeconds, base))
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// OpenCircuit 立即熔断指定渠道和模型，durationSeconds 大于 0 时使用指定的时长（如上游返回的限流重置时间），否则按连续熔断次数退避
+func (cc *ChannelsChooser) OpenCircuit(channelId int, modelName string, durationSeconds int64, reason string) bool {
+	if channelId == 0 || modelName == "" {
+		return false
+	}
+
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, modelName), true)
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	now := time.Now()
+	if breaker.state == CircuitStateOpen && now.Before(breaker.openUntil) {
+		// 仍在熔断中，无需重新设置
+		return true
+	}
+
+	breaker.open(channelId, modelName, now, durationSeconds, reason)
+	return true
+}
+
+// open 调用方需要持有锁
+func (b *circuitBreaker) open(channelId int, modelName string, now time.Time, durationSeconds int64, reason string) {
+	from := b.state
+	b.openCount++
+
+	duration := circuitOpenDuration(b.openCount)
+	if durationSeconds > 0 {
+		duration = time.Duration(durationSeconds) * time.Second
+	}
+
+	b.state = CircuitStateOpen
+	b.openUntil = now.Add(duration)
+	b.consecutiveFailures = 0
+	b.windowStart = now
+	b.windowRequests = 0
+	b.windowFailures = 0
+	b.trials = 0
+	b.trialSuccesses = 0
+
+	logCircuitTransition(channelId, modelName, from, CircuitStateOpen,
+		fmt.Sprintf("reason=\"%s\" duration=%ds open_count=%d", reason, int64(duration.Seconds()), b.openCount))
+	publishCircuitState(channelId, modelName, CircuitStateOpen, b.openUntil, b.openCount)
+}
+
+// close 调用方需要持有锁
+func (b *circuitBreaker) close(now time.Time) {
+	b.state = CircuitStateClosed
+	b.openCount = 0
+	b.consecutiveFailures = 0
+	b.windowStart = now
+	b.windowRequests = 0
+	b.windowFailures = 0
+	b.trials = 0
+	b.trialSuccesses = 0
+}
+
+// IsCircuitOpen 渠道在该模型上是否不可选择：熔断中，或者半开状态下试探名额已满
+func (cc *ChannelsChooser) IsCircuitOpen(channelId int, modelName string) bool {
+	if channelId == 0 || modelName == "" {
+		return false
+	}
+
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, modelName), false)
+	if breaker == nil {
+		return false
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	now := time.Now()
+	switch breaker.state {
+	case CircuitStateOpen:
+		return now.Before(breaker.openUntil)
+	case CircuitStateHalfOpen:
+		return breaker.trials >= max(config.CircuitBreakerHalfOpenRequests, 1) && now.Before(breaker.trialDeadline)
+	}
+
+	return false
+}
+
+// acquireCircuit 选中渠道后占用试探名额，熔断到期时转为半开状态
+// 占用了试探名额且 ginContext 为 gin.Context 时记录在上下文中，请求没有发出时可以通过 ReleaseCircuitTrial 归还
+func (cc *ChannelsChooser) acquireCircuit(channelId int, modelName string, ginContext interface{}) bool {
+	if channelId == 0 || modelName == "" {
+		return true
+	}
+
+	// 重新选择渠道时，上一次选择占用但没有记录结果的试探名额先归还
+	if c, ok := ginContext.(*gin.Context); ok {
+		cc.ReleaseCircuitTrial(c)
+	}
+
+	key := circuitKey(channelId, modelName)
+	breaker := cc.getCircuitBreaker(key, false)
+	if breaker == nil {
+		return true
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	now := time.Now()
+	limit := max(config.CircuitBreakerHalfOpenRequests, 1)
+	switch breaker.state {
+	case CircuitStateOpen:
+		if now.Before(breaker.openUntil) {
+			return false
+		}
+		breaker.state = CircuitStateHalfOpen
+		breaker.trials = 0
+		breaker.trialSuccesses = 0
+		logCircuitTransition(channelId, modelName, CircuitStateOpen, CircuitStateHalfOpen, fmt.Sprintf("trials=%d", limit))
+	case CircuitStateHalfOpen:
+		if !now.Before(breaker.trialDeadline) {
+			breaker.trials = 0
+		}
+	default:
+		return true
+	}
+
+	if breaker.trials >= limit {
+		return false
+	}
+
+	breaker.trials++
+	breaker.trialDeadline = now.Add(circuitTrialTimeout)
+	if c, ok := ginContext.(*gin.Context); ok {
+		c.Set("circuit_trial", key)
+	}
+
+	return true
+}
+
+// ReleaseCircuitTrial 归还上下文中占用的试探名额，选中渠道后没有实际发出请求时调用
+func (cc *ChannelsChooser) ReleaseCircuitTrial(c *gin.Context) {
+	key := c.GetString("circuit_trial")
+	if key == "" {
+		return
+	}
+	c.Set("circuit_trial", "")
+
+	breaker := cc.getCircuitBreaker(key, false)
+	if breaker == nil {
+		return
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if breaker.state == CircuitStateHalfOpen && breaker.trials > 0 {
+		breaker.trials--
+	}
+}
+
+// RecordCircuitResult 记录请求结果
+// 关闭状态下连续失败次数或窗口内的错误率达到阈值时熔断；半开状态下试探请求全部成功时恢复，任意一次失败则再次熔断
+func (cc *ChannelsChooser) RecordCircuitResult(channelId int, modelName string, success bool) {
+	if channelId == 0 || modelName == "" {
+		return
+	}
+
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, modelName), true)
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	now := time.Now()
+	switch breaker.state {
+	case CircuitStateOpen:
+		// 熔断前发出的请求，结果不再影响状态
+		return
+	case CircuitStateHalfOpen:
+		if breaker.trials > 0 {
+			breaker.trials--
+		}
+		if !success {
+			breaker.open(channelId, modelName, now, 0, "trial request failed")
+			return
+		}
+
+		breaker.trialSuccesses++
+		if breaker.trialSuccesses >= max(config.CircuitBreakerHalfOpenRequests, 1) {
+			trialSuccesses := breaker.trialSuccesses
+			breaker.close(now)
+			logCircuitTransition(channelId, modelName, CircuitStateHalfOpen, CircuitStateClosed,
+				fmt.Sprintf("trial_successes=%d", trialSuccesses))
+			publishCircuitState(channelId, modelName, CircuitStateClosed, time.Time{}, 0)
+		}
+		return
+	}
+
+	window := time.Duration(max(config.CircuitBreakerWindowSeconds, 1)) * time.Second
+	if now.Sub(breaker.windowStart) > window {
+		breaker.windowStart = now
+		breaker.windowRequests = 0
+		breaker.windowFailures = 0
+	}
+	breaker.windowRequests++
+
+	if success {
+		breaker.consecutiveFailures = 0
+		return
+	}
+
+	breaker.consecutiveFailures++
+	breaker.windowFailures++
+
+	if config.CircuitBreakerFailureThreshold > 0 && breaker.consecutiveFailures >= config.CircuitBreakerFailureThreshold {
+		breaker.open(channelId, modelName, now, 0, fmt.Sprintf("%d consecutive failures", breaker.consecutiveFailures))
+		return
+	}
+
+	if config.CircuitBreakerErrorRate > 0 && breaker.windowRequests >= max(config.CircuitBreakerMinRequests, 1) {
+		errorRate := breaker.windowFailures * 100 / breaker.windowRequests
+		if errorRate >= config.CircuitBreakerErrorRate {
+			breaker.open(channelId, modelName, now, 0, fmt.Sprintf("error rate %d%% in %d requests", errorRate, breaker.windowRequests))
+		}
+	}
+}
+
+// GetCircuitState 返回熔断器当前的状态，熔断已到期但还没有请求时仍返回 open
+func (cc *ChannelsChooser) GetCircuitState(channelId int, modelName string) string {
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, modelName), false)
+	if breaker == nil {
+		return CircuitStateClosed
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	return breaker.state
+}
+
+// CleanupCircuitBreakers 清理统计窗口已过期且没有失败的熔断器，加快内存回收
+func (cc *ChannelsChooser) CleanupCircuitBreakers() {
+	window := time.Duration(max(config.CircuitBreakerWindowSeconds, 1)) * time.Second
+	now := time.Now()
+	cc.Breakers.Range(func(key, value interface{}) bool {
+		breaker := value.(*circuitBreaker)
+		breaker.Lock()
+		idle := breaker.state == CircuitStateClosed && breaker.consecutiveFailures == 0 && now.Sub(breaker.windowStart) > window
+		breaker.Unlock()
+
+		if idle {
+			cc.Breakers.Delete(key)
+		}
+		return true
+	})
+}
+
+// ResetChannelCircuits 重置指定渠道在所有模型上的熔断器，并通知其他节点
+func (cc *ChannelsChooser) ResetChannelCircuits(channelId int) {
+	cc.resetChannelCircuits(channelId)
+	publishClusterEvent(clusterEvent{Type: clusterEventCircuitReset, ChannelId: channelId})
+}
+
+func (cc *ChannelsChooser) resetChannelCircuits(channelId int) {
+	prefix := fmt.Sprintf("%d:", channelId)
+	cc.Breakers.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			cc.Breakers.Delete(key)
+		}
+		return true
+	})
+}
+
+// applyCircuitState 应用其他节点发布的熔断或恢复，不再重复记录日志和发送通知
+func (cc *ChannelsChooser) applyCircuitState(channelId int, modelName, state string, until int64, openCount int) {
+	if channelId == 0 || modelName == "" {
+		return
+	}
+
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, modelName), true)
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	now := time.Now()
+	switch state {
+	case CircuitStateOpen:
+		openUntil := time.UnixMilli(until)
+		if breaker.state == CircuitStateOpen && !openUntil.After(breaker.openUntil) {
+			return
+		}
+		breaker.close(now)
+		breaker.state = CircuitStateOpen
+		breaker.openUntil = openUntil
+		breaker.openCount = max(openCount, 1)
+	case CircuitStateClosed:
+		breaker.close(now)
+	}
+}
+
+// publishCircuitSnapshot 向其他节点发送本节点熔断中的渠道，用于其他节点订阅后同步状态
+func (cc *ChannelsChooser) publishCircuitSnapshot() {
+	now := time.Now()
+	cc.Breakers.Range(func(key, value any) bool {
+		breaker := value.(*circuitBreaker)
+		breaker.Lock()
+		open := breaker.state == CircuitStateOpen && now.Before(breaker.openUntil)
+		openUntil, openCount := breaker.openUntil, breaker.openCount
+		breaker.Unlock()
+		if !open {
+			return true
+		}
+
+		channelIdText, modelName, _ := strings.Cut(key.(string), ":")
+		if channelId, err := strconv.Atoi(channelIdText); err == nil {
+			publishCircuitState(channelId, modelName, CircuitStateOpen, openUntil, openCount)
+		}
+		return true
+	})
+}
+
+func logCircuitTransition(channelId int, modelName, from, to, detail string) {
+	logger.SysLog(fmt.Sprintf("circuit_breaker channel_id=%d model=\"%s\" from=%s to=%s %s", channelId, modelName, from, to, detail))
+
+	// 半开只是中间状态，只通知熔断与恢复
+	if !config.CircuitBreakerNotifyEnabled || to == CircuitStateHalfOpen {
+		return
+	}
+
+	// 选择渠道时持有 ChannelGroup 的读锁，在新的 goroutine 中读取渠道名称
+	go func() {
+		channelName := fmt.Sprintf("#%d", channelId)
+		if channel := ChannelGroup.GetChannel(channelId); channel != nil {
+			channelName = fmt.Sprintf("%s(#%d)", channel.Name, channelId)
+		}
+
+		var subject string
+		if to == CircuitStateOpen {
+			subject = fmt.Sprintf("渠道 %s 的模型 %s 已熔断", channelName, modelName)
+		} else {
+			subject = fmt.Sprintf("渠道 %s 的模型 %s 已恢复", channelName, modelName)
+		}
+
+		notify.Send(subject, fmt.Sprintf("%s: %s -> %s, %s", subject, from, to, detail))
+	}()
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const testCircuitModel = "gpt-4o"
+
+// setCircuitConfig 修改熔断配置，测试结束后恢复
+func setCircuitConfig(t *testing.T, threshold, errorRate, minRequests, halfOpen int) {
+	t.Helper()
+	if logger.Logger == nil {
+		logger.Logger = zap.NewNop()
+	}
+
+	saved := []int{config.RetryCooldownSeconds, config.CircuitBreakerFailureThreshold, config.CircuitBreakerErrorRate,
+		config.CircuitBreakerMinRequests, config.CircuitBreakerWindowSeconds, config.CircuitBreakerMaxOpenSeconds, config.CircuitBreakerHalfOpenRequests}
+	notify := config.CircuitBreakerNotifyEnabled
+	t.Cleanup(func() {
+		config.RetryCooldownSeconds, config.CircuitBreakerFailureThreshold, config.CircuitBreakerErrorRate = saved[0], saved[1], saved[2]
+		config.CircuitBreakerMinRequests, config.CircuitBreakerWindowSeconds, config.CircuitBreakerMaxOpenSeconds = saved[3], saved[4], saved[5]
+		config.CircuitBreakerHalfOpenRequests = saved[6]
+		config.CircuitBreakerNotifyEnabled = notify
+	})
+
+	config.RetryCooldownSeconds = 5
+	config.CircuitBreakerFailureThreshold = threshold
+	config.CircuitBreakerErrorRate = errorRate
+	config.CircuitBreakerMinRequests = minRequests
+	config.CircuitBreakerWindowSeconds = 60
+	config.CircuitBreakerMaxOpenSeconds = 600
+	config.CircuitBreakerHalfOpenRequests = halfOpen
+	config.CircuitBreakerNotifyEnabled = false
+}
+
+func newTestCircuitContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return c
+}
+
+// expireCircuit 让熔断立即到期
+func expireCircuit(cc *ChannelsChooser, channelId int) *circuitBreaker {
+	breaker := cc.getCircuitBreaker(circuitKey(channelId, testCircuitModel), false)
+	breaker.openUntil = time.Now().Add(-time.Second)
+	return breaker
+}
+
+func TestCircuitOpenDuration(t *testing.T) {
+	cases := []struct {
+		name      string
+		base      int
+		maxOpen   int
+		openCount int
+		expected  time.Duration
+	}{
+		{name: "first open uses base", base: 5, maxOpen: 600, openCount: 1, expected: 5 * time.Second},
+		{name: "doubles each time", base: 5, maxOpen: 600, openCount: 3, expected: 20 * time.Second},
+		{name: "capped at max", base: 5, maxOpen: 600, openCount: 10, expected: 600 * time.Second},
+		{name: "max below base keeps base", base: 30, maxOpen: 10, openCount: 2, expected: 30 * time.Second},
+		{name: "zero base uses one second", base: 0, maxOpen: 600, openCount: 2, expected: 2 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setCircuitConfig(t, 5, 50, 20, 3)
+			config.RetryCooldownSeconds = tc.base
+			config.CircuitBreakerMaxOpenSeconds = tc.maxOpen
+
+			assert.Equal(t, tc.expected, circuitOpenDuration(tc.openCount))
+		})
+	}
+}
+
+func TestCircuitRecordResult(t *testing.T) {
+	cases := []struct {
+		name        string
+		threshold   int
+		errorRate   int
+		minRequests int
+		results     []bool
+		expired     bool // 记录最后一个结果前统计窗口已过期
+		expected    string
+	}{
+		{name: "consecutive failures open", threshold: 3, results: []bool{false, false, false}, expected: CircuitStateOpen},
+		{name: "success resets consecutive failures", threshold: 3, results: []bool{false, false, true, false, false}, expected: CircuitStateClosed},
+		{name: "error rate opens after min requests", errorRate: 50, minRequests: 4, results: []bool{true, false, true, false}, expected: CircuitStateOpen},
+		{name: "error rate waits for min requests", errorRate: 50, minRequests: 4, results: []bool{false, false, false}, expected: CircuitStateClosed},
+		{name: "error rate below threshold", errorRate: 50, minRequests: 4, results: []bool{true, true, true, false}, expected: CircuitStateClosed},
+		{name: "expired window restarts counting", errorRate: 50, minRequests: 4, results: []bool{false, false, false, false}, expired: true, expected: CircuitStateClosed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setCircuitConfig(t, tc.threshold, tc.errorRate, tc.minRequests, 3)
+			cc := &ChannelsChooser{}
+
+			for i, success := range tc.results {
+				if tc.expired && i == len(tc.results)-1 {
+					breaker := cc.getCircuitBreaker(circuitKey(1, testCircuitModel), false)
+					breaker.windowStart = time.Now().Add(-2 * time.Minute)
+				}
+				cc.RecordCircuitResult(1, testCircuitModel, success)
+			}
+
+			assert.Equal(t, tc.expected, cc.GetCircuitState(1, testCircuitModel))
+			assert.Equal(t, tc.expected == CircuitStateOpen, cc.IsCircuitOpen(1, testCircuitModel))
+		})
+	}
+}
+
+func TestCircuitHalfOpenTransitions(t *testing.T) {
+	cases := []struct {
+		name          string
+		trialResults  []bool
+		expected      string
+		expectedCount int // 结束时连续熔断的次数
+		expectedOpen  time.Duration
+	}{
+		{name: "all trials succeed closes", trialResults: []bool{true, true}, expected: CircuitStateClosed, expectedCount: 0},
+		{name: "partial success stays half open", trialResults: []bool{true}, expected: CircuitStateHalfOpen, expectedCount: 1},
+		{name: "failed trial reopens with longer duration", trialResults: []bool{true, false}, expected: CircuitStateOpen, expectedCount: 2, expectedOpen: 10 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setCircuitConfig(t, 1, 0, 1, 2)
+			cc := &ChannelsChooser{}
+
+			cc.RecordCircuitResult(1, testCircuitModel, false)
+			assert.Equal(t, CircuitStateOpen, cc.GetCircuitState(1, testCircuitModel))
+			assert.False(t, cc.acquireCircuit(1, testCircuitModel, nil), "open circuit should reject")
+
+			breaker := expireCircuit(cc, 1)
+			// 到期后仍显示 open，直到有请求转为半开
+			assert.Equal(t, CircuitStateOpen, cc.GetCircuitState(1, testCircuitModel))
+			assert.False(t, cc.IsCircuitOpen(1, testCircuitModel))
+
+			for range tc.trialResults {
+				assert.True(t, cc.acquireCircuit(1, testCircuitModel, nil))
+			}
+			assert.Equal(t, CircuitStateHalfOpen, cc.GetCircuitState(1, testCircuitModel))
+
+			for _, success := range tc.trialResults {
+				cc.RecordCircuitResult(1, testCircuitModel, success)
+			}
+
+			assert.Equal(t, tc.expected, cc.GetCircuitState(1, testCircuitModel))
+			assert.Equal(t, tc.expectedCount, breaker.openCount)
+			if tc.expected == CircuitStateOpen {
+				assert.WithinDuration(t, time.Now().Add(tc.expectedOpen), breaker.openUntil, time.Second)
+			}
+		})
+	}
+}
+
+func TestCircuitTrialAccounting(t *testing.T) {
+	setCircuitConfig(t, 1, 0, 1, 2)
+	cc := &ChannelsChooser{}
+
+	cc.RecordCircuitResult(1, testCircuitModel, false)
+	breaker := expireCircuit(cc, 1)
+
+	first, second, third := newTestCircuitContext(), newTestCircuitContext(), newTestCircuitContext()
+	assert.True(t, cc.acquireCircuit(1, testCircuitModel, first))
+	assert.True(t, cc.acquireCircuit(1, testCircuitModel, second))
+	assert.Equal(t, circuitKey(1, testCircuitModel), first.GetString("circuit_trial"))
+	assert.Equal(t, 2, breaker.trials)
+
+	// 试探名额已满
+	assert.True(t, cc.IsCircuitOpen(1, testCircuitModel))
+	assert.False(t, cc.acquireCircuit(1, testCircuitModel, third))
+	assert.Empty(t, third.GetString("circuit_trial"))
+
+	// 同一个请求重新选择时先归还上一次的名额
+	assert.True(t, cc.acquireCircuit(1, testCircuitModel, first))
+	assert.Equal(t, 2, breaker.trials)
+
+	// 没有发出请求时归还名额，重复归还不会多减
+	cc.ReleaseCircuitTrial(first)
+	cc.ReleaseCircuitTrial(first)
+	assert.Equal(t, 1, breaker.trials)
+	assert.Empty(t, first.GetString("circuit_trial"))
+	assert.False(t, cc.IsCircuitOpen(1, testCircuitModel))
+
+	// 记录结果同样归还名额
+	cc.RecordCircuitResult(1, testCircuitModel, true)
+	assert.Equal(t, 0, breaker.trials)
+	assert.Equal(t, 1, breaker.trialSuccesses)
+	assert.Equal(t, CircuitStateHalfOpen, breaker.state)
+}
+
+func TestCircuitTrialTimeout(t *testing.T) {
+	setCircuitConfig(t, 1, 0, 1, 1)
+	cc := &ChannelsChooser{}
+
+	cc.RecordCircuitResult(1, testCircuitModel, false)
+	breaker := expireCircuit(cc, 1)
+
+	assert.True(t, cc.acquireCircuit(1, testCircuitModel, nil))
+	assert.True(t, cc.IsCircuitOpen(1, testCircuitModel))
+	assert.False(t, cc.acquireCircuit(1, testCircuitModel, nil))
+
+	// 试探请求一直没有结果，超时后名额被回收
+	breaker.trialDeadline = time.Now().Add(-time.Second)
+	assert.False(t, cc.IsCircuitOpen(1, testCircuitModel))
+	assert.True(t, cc.acquireCircuit(1, testCircuitModel, nil))
+	assert.Equal(t, 1, breaker.trials)
+	assert.WithinDuration(t, time.Now().Add(circuitTrialTimeout), breaker.trialDeadline, time.Second)
+}
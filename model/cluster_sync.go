@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/redis"
+	"done-hub/common/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// 多个节点之间通过 Redis 发布订阅同步渠道状态，未启用 Redis 时只使用本节点的状态
+const clusterEventChannel = "done-hub:cluster_events"
+
+const (
+	clusterEventReload       = "channels_reload" // 渠道配置已修改，重新从数据库加载
+	clusterEventStatus       = "channel_status"  // 渠道启用或禁用
+	clusterEventCircuit      = "circuit"         // 熔断器熔断或恢复
+	clusterEventCircuitReset = "circuit_reset"   // 重置渠道在所有模型上的熔断器
+	clusterEventKey          = "channel_key"     // 多密钥渠道中的密钥冷却、禁用或启用
+	clusterEventChannelReset = "channel_reset"   // 渠道修改或删除，清除密钥状态与统计
+	clusterEventSnapshot     = "snapshot"        // 节点订阅或重新连接后，请求其他节点发送当前的熔断与密钥冷却状态
+)
+
+type clusterEvent struct {
+	Node      string `json:"node"`
+	Type      string `json:"type"`
+	ChannelId int    `json:"channel_id,omitempty"`
+	Model     string `json:"model,omitempty"`
+	KeyHash   string `json:"key_hash,omitempty"`
+	Enabled   bool   `json:"enabled,omitempty"`
+	State     string `json:"state,omitempty"`
+	Until     int64  `json:"until,omitempty"` // 毫秒时间戳
+	OpenCount int    `json:"open_count,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+var (
+	// 当前节点的标识，忽略自己发出的事件
+	clusterNodeId = utils.GetUUID()
+	// 短时间内收到多个重新加载的事件时只加载一次
+	clusterReloadSignal = make(chan struct{}, 1)
+)
+
+// InitClusterSync 订阅其他节点发布的渠道状态变更，需要在 Redis 初始化之后调用
+func InitClusterSync() {
+	if !config.RedisEnabled {
+		return
+	}
+
+	go func() {
+		for range clusterReloadSignal {
+			ChannelGroup.Load()
+		}
+	}()
+
+	go func() {
+		ctx := context.Background()
+		pubsub := redis.RedisSubscribe(ctx, clusterEventChannel)
+		defer pubsub.Close()
+
+		for {
+			message, err := pubsub.Receive(ctx)
+			if err != nil {
+				if errors.Is(err, goredis.ErrClosed) {
+					return
+				}
+				// 连接断开后下一次读取时重新连接并重新订阅
+				time.Sleep(time.Second)
+				continue
+			}
+
+			switch message := message.(type) {
+			case *goredis.Subscription:
+				if message.Kind == "subscribe" {
+					syncClusterSnapshot()
+				}
+			case *goredis.Message:
+				handleClusterEvent(message.Payload)
+			}
+		}
+	}()
+
+	logger.SysLog(fmt.Sprintf("cluster state sync enabled, node: %s", clusterNodeId))
+}
+
+// syncClusterSnapshot 订阅成功后同步断开期间错过的状态
+// 渠道的启用状态与禁用的密钥保存在数据库中，重新加载即可，熔断与密钥冷却只在内存中，由其他节点重新发送
+func syncClusterSnapshot() {
+	signalClusterReload()
+	publishClusterEvent(clusterEvent{Type: clusterEventSnapshot})
+}
+
+func signalClusterReload() {
+	select {
+	case clusterReloadSignal <- struct{}{}:
+	default:
+	}
+}
+
+func publishClusterEvent(event clusterEvent) {
+	if !config.RedisEnabled {
+		return
+	}
+
+	event.Node = clusterNodeId
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	// 调用方可能持有熔断器或渠道的锁，在新的 goroutine 中发布
+	go func() {
+		if err := redis.RedisPublish(clusterEventChannel, string(payload)); err != nil {
+			logger.SysError("failed to publish cluster event: " + err.Error())
+		}
+	}()
+}
+
+func handleClusterEvent(payload string) {
+	var event clusterEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		logger.SysError("failed to parse cluster event: " + err.Error())
+		return
+	}
+	if event.Node == clusterNodeId {
+		return
+	}
+
+	switch event.Type {
+	case clusterEventReload:
+		signalClusterReload()
+	case clusterEventStatus:
+		if event.Enabled {
+			ChannelGroup.Enable(event.ChannelId)
+		} else {
+			ChannelGroup.Disable(event.ChannelId)
+		}
+	case clusterEventCircuit:
+		ChannelGroup.applyCircuitState(event.ChannelId, event.Model, event.State, event.Until, event.OpenCount)
+	case clusterEventCircuitReset:
+		ChannelGroup.resetChannelCircuits(event.ChannelId)
+	case clusterEventKey:
+		applyChannelKeyState(event.ChannelId, event.KeyHash, event.State, event.Until, event.Reason)
+	case clusterEventChannelReset:
+		// 与重新加载的事件到达顺序不确定，清除后再加载一次，按数据库中的记录恢复密钥的禁用状态
+		ClearChannelKeyStates(event.ChannelId)
+		ClearChannelModelStats(event.ChannelId)
+		ClearPrefixCacheStats(event.ChannelId)
+		signalClusterReload()
+	case clusterEventSnapshot:
+		ChannelGroup.publishCircuitSnapshot()
+		publishChannelKeySnapshot()
+	}
+}
+
+// Reload 修改渠道配置后重新加载，并通知其他节点重新加载
+func (cc *ChannelsChooser) Reload() {
+	cc.Load()
+	publishClusterEvent(clusterEvent{Type: clusterEventReload})
+}
+
+// publishChannelReset 渠道修改或删除后通知其他节点清除该渠道的密钥状态与统计
+func publishChannelReset(channelId int) {
+	publishClusterEvent(clusterEvent{Type: clusterEventChannelReset, ChannelId: channelId})
+}
+
+func publishCircuitState(channelId int, modelName, state string, until time.Time, openCount int) {
+	event := clusterEvent{
+		Type:      clusterEventCircuit,
+		ChannelId: channelId,
+		Model:     modelName,
+		State:     state,
+		OpenCount: openCount,
+	}
+	if !until.IsZero() {
+		event.Until = until.UnixMilli()
+	}
+	publishClusterEvent(event)
+}
+
+func publishChannelKeyState(channelId int, hash, state string, until time.Time, reason string) {
+	event := clusterEvent{
+		Type:      clusterEventKey,
+		ChannelId: channelId,
+		KeyHash:   hash,
+		State:     state,
+		Reason:    reason,
+	}
+	if !until.IsZero() {
+		event.Until = until.UnixMilli()
+	}
+	publishClusterEvent(event)
+}
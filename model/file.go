@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// File 网关托管的文件，内容保存在 storage 中，Drive 记录文件所在的存储
+type File struct {
+	Id         int    `json:"id"`
+	FileId     string `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	Bytes      int64  `json:"bytes" gorm:"bigint"`
+	Filename   string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose    string `json:"purpose" gorm:"type:varchar(32);index"`
+	Drive      string `json:"drive" gorm:"type:varchar(32)"`
+	StorageKey string `json:"storage_key" gorm:"type:varchar(255)"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
+
+func GetFileByFileId(userId int, fileId string) (*File, error) {
+	file := &File{}
+	err := DB.Where("user_id = ? and file_id = ?", userId, fileId).First(file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return file, err
+}
+
+// GetUserFiles 获取用户的文件列表，after 为上一页最后一条的 file_id，order 为 asc 或 desc
+func GetUserFiles(userId int, purpose, after, order string, limit int) ([]*File, error) {
+	var files []*File
+	db := DB.Where("user_id = ?", userId)
+
+	if purpose != "" {
+		db = db.Where("purpose = ?", purpose)
+	}
+
+	if order != "asc" {
+		order = "desc"
+	}
+
+	if after != "" {
+		afterFile, err := GetFileByFileId(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		if afterFile != nil {
+			if order == "asc" {
+				db = db.Where("id > ?", afterFile.Id)
+			} else {
+				db = db.Where("id < ?", afterFile.Id)
+			}
+		}
+	}
+
+	err := db.Order("id " + order).Limit(limit).Find(&files).Error
+	return files, err
+}
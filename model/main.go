@@ -27,6 +27,8 @@ func SetupDB() {
 	}
 	ChannelGroup.Load()
 	GlobalUserGroupRatio.Load()
+	GlobalVirtualModels.Load()
+	GlobalShadowRules.Load()
 	config.RootUserEmail = GetRootUserEmail()
 	NewModelOwnedBys()
 
@@ -199,6 +201,31 @@ func InitDB() (err error) {
 			return err
 		}
 
+		err = db.AutoMigrate(&Batch{}, &BatchItem{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&File{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&StoredResponse{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&McpServer{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&VirtualModel{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ShadowRule{}, &ShadowComparison{})
+		if err != nil {
+			return err
+		}
+
 		if config.UserInvoiceMonth {
 			err = db.AutoMigrate(&StatisticsMonthGeneratedHistory{})
 			if err != nil {
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"done-hub/common/utils"
+	"errors"
+)
+
+const (
+	McpServerTypeSSE        = "sse"
+	McpServerTypeStreamable = "streamable_http"
+)
+
+// McpServer 管理员登记的远程 MCP 服务，挂载到令牌或分组后由网关在对话中代为调用其工具
+type McpServer struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(100)"`
+	Description string `json:"description" gorm:"type:text"`
+	Type        string `json:"type" gorm:"type:varchar(32);default:'sse'"`
+	Url         string `json:"url" gorm:"type:text"`
+	Headers     string `json:"headers" gorm:"type:text"` // 请求 MCP 服务时附带的请求头，JSON 对象
+	Timeout     int    `json:"timeout" gorm:"default:0"` // 单次工具调用的超时时间(秒)，0 表示使用系统默认值
+	Enable      *bool  `json:"enable" gorm:"default:true"`
+	CreatedAt   int64  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   int64  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func (s *McpServer) TableName() string {
+	return "mcp_servers"
+}
+
+func (s *McpServer) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if s.Url == "" {
+		return errors.New("url is required")
+	}
+	if s.Type != McpServerTypeSSE && s.Type != McpServerTypeStreamable {
+		return errors.New("type must be sse or streamable_http")
+	}
+	if s.Headers != "" {
+		if _, err := utils.UnmarshalString[map[string]string](s.Headers); err != nil {
+			return errors.New("headers must be a JSON object of strings")
+		}
+	}
+
+	return nil
+}
+
+func (s *McpServer) GetHeaders() map[string]string {
+	if s.Headers == "" {
+		return nil
+	}
+
+	headers, _ := utils.UnmarshalString[map[string]string](s.Headers)
+	return headers
+}
+
+func (s *McpServer) IsEnabled() bool {
+	return s.Enable == nil || *s.Enable
+}
+
+func CreateMcpServer(server *McpServer) error {
+	return DB.Create(server).Error
+}
+
+func UpdateMcpServer(server *McpServer) error {
+	return DB.Omit("id", "created_at").Save(server).Error
+}
+
+func GetMcpServer(id int) (*McpServer, error) {
+	server := &McpServer{}
+	err := DB.Where("id = ?", id).First(server).Error
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func GetAllMcpServers() ([]*McpServer, error) {
+	var servers []*McpServer
+	err := DB.Order("id desc").Find(&servers).Error
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// GetEnabledMcpServersByIds 只返回启用中的服务，已删除或禁用的 id 会被忽略
+func GetEnabledMcpServersByIds(ids []int) ([]*McpServer, error) {
+	var servers []*McpServer
+	if len(ids) == 0 {
+		return servers, nil
+	}
+
+	err := DB.Where("id IN ? AND enable = ?", ids, true).Order("id").Find(&servers).Error
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func DeleteMcpServer(id int) error {
+	return DB.Delete(&McpServer{}, id).Error
+}
@@ -1,9 +1,18 @@
 package model
 
 import (
+	"done-hub/common/cache"
+	"done-hub/common/config"
 	"done-hub/common/utils"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
 )
 
+var ModelContextLengthCacheKey = "model_context_length:%s"
+
 type ModelInfo struct {
 	Id               int    `json:"id" gorm:"index"`
 	Model            string `json:"model" gorm:"type:varchar(100);index"`
@@ -99,6 +108,33 @@ func GetModelInfoByModel(model string) (*ModelInfo, error) {
 	return modelInfo, nil
 }
 
+// GetModelContextLength 获取模型的上下文长度，没有登记或未设置时返回 0
+func GetModelContextLength(modelName string) (int, error) {
+	modelInfo, err := GetModelInfoByModel(modelName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return modelInfo.ContextLength, nil
+}
+
+func CacheGetModelContextLength(modelName string) (int, error) {
+	if !config.RedisEnabled {
+		return GetModelContextLength(modelName)
+	}
+
+	return cache.GetOrSetCache(
+		fmt.Sprintf(ModelContextLengthCacheKey, modelName),
+		time.Minute,
+		func() (int, error) {
+			return GetModelContextLength(modelName)
+		},
+		cache.CacheTimeout)
+}
+
 func GetAllModelInfo() ([]*ModelInfo, error) {
 	var modelInfos []*ModelInfo
 	err := DB.Order("id desc").Find(&modelInfos).Error
@@ -101,6 +101,13 @@ func InitOptionMap() {
 	config.GlobalOption.RegisterFloat("QuotaPerUnit", &config.QuotaPerUnit)
 	config.GlobalOption.RegisterInt("RetryTimes", &config.RetryTimes)
 	config.GlobalOption.RegisterInt("RetryCooldownSeconds", &config.RetryCooldownSeconds)
+	config.GlobalOption.RegisterInt("CircuitBreakerFailureThreshold", &config.CircuitBreakerFailureThreshold)
+	config.GlobalOption.RegisterInt("CircuitBreakerErrorRate", &config.CircuitBreakerErrorRate)
+	config.GlobalOption.RegisterInt("CircuitBreakerMinRequests", &config.CircuitBreakerMinRequests)
+	config.GlobalOption.RegisterInt("CircuitBreakerWindowSeconds", &config.CircuitBreakerWindowSeconds)
+	config.GlobalOption.RegisterInt("CircuitBreakerMaxOpenSeconds", &config.CircuitBreakerMaxOpenSeconds)
+	config.GlobalOption.RegisterInt("CircuitBreakerHalfOpenRequests", &config.CircuitBreakerHalfOpenRequests)
+	config.GlobalOption.RegisterBool("CircuitBreakerNotifyEnabled", &config.CircuitBreakerNotifyEnabled)
 
 	config.GlobalOption.RegisterBool("MjNotifyEnabled", &config.MjNotifyEnabled)
 	config.GlobalOption.RegisterBool("BuiltinChatEnabled", &config.BuiltinChatEnabled)
@@ -123,6 +130,7 @@ func InitOptionMap() {
 
 	config.GlobalOption.RegisterBool("GeminiAPIEnabled", &config.GeminiAPIEnabled)
 	config.GlobalOption.RegisterBool("ClaudeAPIEnabled", &config.ClaudeAPIEnabled)
+	config.GlobalOption.RegisterBool("OllamaAPIEnabled", &config.OllamaAPIEnabled)
 
 	config.GlobalOption.RegisterCustom("DisableChannelKeywords", func() string {
 		return common.DisableChannelKeywordsInstance.GetKeywords()
@@ -148,6 +156,54 @@ func InitOptionMap() {
 	// 注册模型名称大小写不敏感配置项
 	config.GlobalOption.RegisterBool("ModelNameCaseInsensitiveEnabled", &config.ModelNameCaseInsensitiveEnabled)
 
+	// 批量任务(Batch API)执行速率
+	config.GlobalOption.RegisterInt("BatchConcurrency", &config.BatchConcurrency)
+	config.GlobalOption.RegisterInt("BatchRPM", &config.BatchRPM)
+	config.GlobalOption.RegisterInt("BatchMaxRequests", &config.BatchMaxRequests)
+
+	// 网关托管文件(Files API)大小限制
+	config.GlobalOption.RegisterInt("FileMaxSize", &config.FileMaxSize)
+
+	// 兼容模式下保存的 Responses 对象保留天数
+	config.GlobalOption.RegisterInt("ResponsesStoreDays", &config.ResponsesStoreDays)
+
+	// 响应缓存
+	config.GlobalOption.RegisterInt("ResponseCacheTTL", &config.ResponseCacheTTL)
+	config.GlobalOption.RegisterInt("ResponseCacheMaxSize", &config.ResponseCacheMaxSize)
+
+	// 对冲请求默认等待时间
+	config.GlobalOption.RegisterInt("HedgeDelay", &config.HedgeDelay)
+	config.GlobalOption.RegisterInt("HedgeMinDelay", &config.HedgeMinDelay)
+
+	// 流式输出中途断开时的续写次数
+	config.GlobalOption.RegisterInt("StreamFailoverTimes", &config.StreamFailoverTimes)
+
+	// 结构化输出校验与修复
+	config.GlobalOption.RegisterBool("StructuredOutputValidation", &config.StructuredOutputValidation)
+	config.GlobalOption.RegisterInt("StructuredOutputRepairTimes", &config.StructuredOutputRepairTimes)
+
+	// 网关代为执行的 MCP 工具
+	config.GlobalOption.RegisterInt("McpMaxIterations", &config.McpMaxIterations)
+	config.GlobalOption.RegisterInt("McpToolTimeout", &config.McpToolTimeout)
+	config.GlobalOption.RegisterInt("McpToolsCacheTTL", &config.McpToolsCacheTTL)
+
+	// 对话超出上下文长度时的裁剪
+	config.GlobalOption.RegisterInt("ContextFitKeepLast", &config.ContextFitKeepLast)
+	config.GlobalOption.RegisterString("ContextFitSummaryModel", &config.ContextFitSummaryModel)
+	config.GlobalOption.RegisterInt("ContextFitSummaryMaxTokens", &config.ContextFitSummaryMaxTokens)
+
+	// 影子流量
+	config.GlobalOption.RegisterInt("ShadowTimeout", &config.ShadowTimeout)
+	config.GlobalOption.RegisterInt("ShadowMaxConcurrent", &config.ShadowMaxConcurrent)
+	config.GlobalOption.RegisterInt("ShadowMaxResponseSize", &config.ShadowMaxResponseSize)
+
+	// 渠道并发已满时的排队
+	config.GlobalOption.RegisterInt("ChannelQueueTimeout", &config.ChannelQueueTimeout)
+	config.GlobalOption.RegisterInt("ChannelQueueMaxSize", &config.ChannelQueueMaxSize)
+
+	// 自适应负载均衡
+	config.GlobalOption.RegisterFloat("AdaptiveBalanceAlpha", &config.AdaptiveBalanceAlpha)
+
 	loadOptionsFromDatabase()
 }
 
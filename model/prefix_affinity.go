@@ -0,0 +1,292 @@
+package model
+
+import (
+	"crypto/sha256"
+	"done-hub/common/config"
+	"done-hub/common/redis"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// generatePrefixHash 按请求中不随对话轮次变化的前缀生成 hash，同一对话的请求会命中同一个渠道，复用上游的提示词缓存
+// 客户端指定了 prompt_cache_key 时直接使用；否则使用系统提示词、工具定义和第一条消息
+func generatePrefixHash(modelName string, body []byte) string {
+	var request map[string]any
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+
+	parts := []any{modelName}
+	if cacheKey, ok := request["prompt_cache_key"].(string); ok && cacheKey != "" {
+		parts = append(parts, cacheKey)
+		return hashPrefixParts(parts)
+	}
+
+	// OpenAI / Claude / Responses API / Gemini 的系统提示词与工具定义
+	prefixFields := []string{"system", "instructions", "systemInstruction", "system_instruction", "tools"}
+	for _, field := range prefixFields {
+		if value, ok := request[field]; ok && value != nil {
+			parts = append(parts, field, value)
+		}
+	}
+
+	// 第一条非系统消息，与前面的系统提示词一起构成对话的固定前缀
+	foundMessage := false
+	for _, field := range []string{"messages", "contents", "input"} {
+		value, ok := request[field]
+		if !ok {
+			continue
+		}
+
+		messages, ok := value.([]any)
+		if !ok {
+			// Responses API 的 input 可以是字符串，此时整个请求只有一轮
+			parts = append(parts, field, value)
+			foundMessage = true
+			break
+		}
+
+		for _, message := range messages {
+			if item, ok := message.(map[string]any); ok && (item["role"] == "system" || item["role"] == "developer") {
+				parts = append(parts, message)
+				continue
+			}
+			parts = append(parts, field, message)
+			foundMessage = true
+			break
+		}
+		break
+	}
+
+	if !foundMessage {
+		return ""
+	}
+
+	return hashPrefixParts(parts)
+}
+
+// prefixHash 缓存在上下文中的前缀 hash，检查与建立粘性 session 时不再重复解析请求体
+type prefixHash struct {
+	modelName string
+	hash      string
+}
+
+// getPrefixHash 同一请求只解析一次请求体，hash 与模型有关，模型变化时重新计算
+func getPrefixHash(c *gin.Context, modelName string, body []byte) string {
+	if c == nil {
+		return generatePrefixHash(modelName, body)
+	}
+
+	if value, ok := c.Get("prefix_hash"); ok {
+		if cached, ok := value.(*prefixHash); ok && cached.modelName == modelName {
+			return cached.hash
+		}
+	}
+
+	hash := generatePrefixHash(modelName, body)
+	c.Set("prefix_hash", &prefixHash{modelName: modelName, hash: hash})
+	return hash
+}
+
+func hashPrefixParts(parts []any) string {
+	// map 序列化时按 key 排序，结果稳定
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// 未启用 Redis 时前缀亲和的映射保存在本节点内存中，只对单节点部署有效，多节点部署需要启用 Redis 共享映射
+// 其他类型渠道的粘性 session 仍然只在启用 Redis 时生效
+type localStickySession struct {
+	channelId int
+	expiresAt time.Time
+}
+
+var (
+	// sessionHash -> *localStickySession
+	localStickySessions sync.Map
+	// 上一次清理过期映射的时间，Unix 秒
+	localStickySessionsPrunedAt atomic.Int64
+)
+
+// stickySessionEnabled 该类型的渠道是否可以使用粘性 session
+func stickySessionEnabled(channelType int) bool {
+	return config.RedisEnabled || isPrefixAffinityChannelType(channelType)
+}
+
+func isPrefixAffinityChannelType(channelType int) bool {
+	return redis.GetStickySessionKeyPrefix(channelType) == redis.StickySessionKeyPrefixAffinity
+}
+
+func getStickySessionMapping(sessionHash string, channelType int) (int, error) {
+	if config.RedisEnabled {
+		return redis.GetStickySessionMapping(sessionHash, channelType)
+	}
+
+	value, ok := localStickySessions.Load(sessionHash)
+	if !ok {
+		return 0, nil
+	}
+	mapping := value.(*localStickySession)
+	if time.Now().After(mapping.expiresAt) {
+		localStickySessions.Delete(sessionHash)
+		return 0, nil
+	}
+
+	return mapping.channelId, nil
+}
+
+func setStickySessionMapping(sessionHash string, channelId int, channelType int, ttl time.Duration) error {
+	if config.RedisEnabled {
+		return redis.SetStickySessionMapping(sessionHash, channelId, channelType, ttl)
+	}
+
+	now := time.Now()
+	localStickySessions.Store(sessionHash, &localStickySession{channelId: channelId, expiresAt: now.Add(ttl)})
+
+	// 每分钟最多清理一次已过期但没有再被读取的映射
+	prunedAt := localStickySessionsPrunedAt.Load()
+	if now.Unix()-prunedAt >= 60 && localStickySessionsPrunedAt.CompareAndSwap(prunedAt, now.Unix()) {
+		localStickySessions.Range(func(key, value any) bool {
+			if now.After(value.(*localStickySession).expiresAt) {
+				localStickySessions.Delete(key)
+			}
+			return true
+		})
+	}
+
+	return nil
+}
+
+func deleteStickySessionMapping(sessionHash string, channelType int) {
+	if config.RedisEnabled {
+		redis.DeleteStickySessionMapping(sessionHash, channelType)
+		return
+	}
+
+	localStickySessions.Delete(sessionHash)
+}
+
+// setStickySessionHit 记录本次选择的渠道是否来自粘性 session，用于区分统计缓存命中率
+func setStickySessionHit(ginContext interface{}, hit bool) {
+	if c, ok := ginContext.(*gin.Context); ok {
+		c.Set("sticky_session_hit", hit)
+	}
+}
+
+// PrefixCacheStats 渠道在分组下的提示词缓存命中统计，按是否通过粘性路由选中分别统计
+type PrefixCacheStats struct {
+	Group              string  `json:"group"`
+	ChannelId          int     `json:"channel_id"`
+	ChannelName        string  `json:"channel_name"`
+	StickyRequests     int64   `json:"sticky_requests"`
+	StickyPromptTokens int64   `json:"sticky_prompt_tokens"`
+	StickyCachedTokens int64   `json:"sticky_cached_tokens"`
+	StickyHitRatio     float64 `json:"sticky_hit_ratio"`
+	OtherRequests      int64   `json:"other_requests"`
+	OtherPromptTokens  int64   `json:"other_prompt_tokens"`
+	OtherCachedTokens  int64   `json:"other_cached_tokens"`
+	OtherHitRatio      float64 `json:"other_hit_ratio"`
+	// 粘性路由的请求比按非粘性请求的命中率估算多命中的缓存 tokens
+	SavedCachedTokens int64 `json:"saved_cached_tokens"`
+	UpdatedAt         int64 `json:"updated_at"`
+}
+
+type prefixCacheCounter struct {
+	sync.Mutex
+	stats PrefixCacheStats
+}
+
+// group:channelId -> *prefixCacheCounter
+var prefixCacheCounters sync.Map
+
+// RecordPrefixCacheUsage 记录请求的输入 tokens 与其中命中上游缓存的 tokens
+func RecordPrefixCacheUsage(group string, channelId int, sticky bool, promptTokens, cachedTokens int) {
+	if channelId == 0 || promptTokens <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s:%d", group, channelId)
+	value, _ := prefixCacheCounters.LoadOrStore(key, &prefixCacheCounter{
+		stats: PrefixCacheStats{Group: group, ChannelId: channelId},
+	})
+	counter := value.(*prefixCacheCounter)
+
+	counter.Lock()
+	defer counter.Unlock()
+
+	// 部分上游的输入 tokens 不包含缓存读取的部分
+	promptTokens = max(promptTokens, cachedTokens)
+	if sticky {
+		counter.stats.StickyRequests++
+		counter.stats.StickyPromptTokens += int64(promptTokens)
+		counter.stats.StickyCachedTokens += int64(cachedTokens)
+	} else {
+		counter.stats.OtherRequests++
+		counter.stats.OtherPromptTokens += int64(promptTokens)
+		counter.stats.OtherCachedTokens += int64(cachedTokens)
+	}
+	counter.stats.UpdatedAt = time.Now().Unix()
+}
+
+// GetPrefixCacheStats 返回各渠道的缓存命中统计，group 为空时返回所有分组
+func GetPrefixCacheStats(group string) []*PrefixCacheStats {
+	result := make([]*PrefixCacheStats, 0)
+	prefixCacheCounters.Range(func(_, value interface{}) bool {
+		counter := value.(*prefixCacheCounter)
+		counter.Lock()
+		stats := counter.stats
+		counter.Unlock()
+
+		if group != "" && stats.Group != group {
+			return true
+		}
+
+		if stats.StickyPromptTokens > 0 {
+			stats.StickyHitRatio = float64(stats.StickyCachedTokens) / float64(stats.StickyPromptTokens)
+		}
+		if stats.OtherPromptTokens > 0 {
+			stats.OtherHitRatio = float64(stats.OtherCachedTokens) / float64(stats.OtherPromptTokens)
+		}
+		if saved := stats.StickyCachedTokens - int64(float64(stats.StickyPromptTokens)*stats.OtherHitRatio); saved > 0 {
+			stats.SavedCachedTokens = saved
+		}
+		if channel := ChannelGroup.GetChannel(stats.ChannelId); channel != nil {
+			stats.ChannelName = channel.Name
+		}
+
+		result = append(result, &stats)
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Group != result[j].Group {
+			return result[i].Group < result[j].Group
+		}
+		return result[i].ChannelId < result[j].ChannelId
+	})
+
+	return result
+}
+
+// ClearPrefixCacheStats 清除渠道的缓存命中统计，渠道修改或删除后调用
+func ClearPrefixCacheStats(channelId int) {
+	suffix := fmt.Sprintf(":%d", channelId)
+	prefixCacheCounters.Range(func(key, _ interface{}) bool {
+		if k := key.(string); len(k) > len(suffix) && k[len(k)-len(suffix):] == suffix {
+			prefixCacheCounters.Delete(key)
+		}
+		return true
+	})
+}
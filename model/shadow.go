@@ -0,0 +1,200 @@
+package model
+
+import (
+	"errors"
+	"sync"
+)
+
+// ShadowRule 把分组下某个模型的一部分真实流量异步复制到影子渠道，用于上线新渠道或新模型前的对比评估
+type ShadowRule struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(100)"`
+	Group       string `json:"group" gorm:"type:varchar(50)"`         // 用户分组，* 表示所有分组
+	Model       string `json:"model" gorm:"type:varchar(100)"`        // 用户请求的模型，* 表示所有模型
+	ChannelId   int    `json:"channel_id"`                            // 影子渠道
+	ShadowModel string `json:"shadow_model" gorm:"type:varchar(100)"` // 影子渠道使用的模型，为空时与用户请求的模型相同
+	Percent     int    `json:"percent" gorm:"default:0"`              // 复制的流量比例，0-100
+	Enable      *bool  `json:"enable" gorm:"default:true"`
+	CreatedAt   int64  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   int64  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func (r *ShadowRule) TableName() string {
+	return "shadow_rules"
+}
+
+func (r *ShadowRule) Validate() error {
+	if r.Group == "" {
+		return errors.New("group is required")
+	}
+	if r.Model == "" {
+		return errors.New("model is required")
+	}
+	if r.ChannelId <= 0 {
+		return errors.New("channel_id is required")
+	}
+	if r.Percent < 0 || r.Percent > 100 {
+		return errors.New("percent must be between 0 and 100")
+	}
+
+	return nil
+}
+
+func (r *ShadowRule) IsEnabled() bool {
+	return r.Enable == nil || *r.Enable
+}
+
+// Match 判断规则是否适用于实际使用的分组与用户请求的模型
+func (r *ShadowRule) Match(group, modelName string) bool {
+	return (r.Group == "*" || r.Group == group) && (r.Model == "*" || r.Model == modelName)
+}
+
+// ShadowComparison 一次影子请求与对应的主请求的对比记录
+type ShadowComparison struct {
+	Id        int    `json:"id"`
+	RuleId    int    `json:"rule_id" gorm:"index"`
+	RequestId string `json:"request_id" gorm:"type:varchar(64)"`
+	UserId    int    `json:"user_id"`
+	Group     string `json:"group" gorm:"type:varchar(50)"`
+	Model     string `json:"model" gorm:"type:varchar(100)"`
+	IsStream  bool   `json:"is_stream"`
+
+	PrimaryChannelId        int    `json:"primary_channel_id"`
+	PrimaryModel            string `json:"primary_model" gorm:"type:varchar(100)"`
+	PrimaryLatency          int64  `json:"primary_latency"` // 毫秒
+	PrimaryPromptTokens     int    `json:"primary_prompt_tokens"`
+	PrimaryCompletionTokens int    `json:"primary_completion_tokens"`
+	PrimaryResponse         string `json:"primary_response" gorm:"type:text"`
+
+	ShadowChannelId        int    `json:"shadow_channel_id" gorm:"index"`
+	ShadowModel            string `json:"shadow_model" gorm:"type:varchar(100)"`
+	ShadowLatency          int64  `json:"shadow_latency"` // 毫秒
+	ShadowPromptTokens     int    `json:"shadow_prompt_tokens"`
+	ShadowCompletionTokens int    `json:"shadow_completion_tokens"`
+	ShadowStatusCode       int    `json:"shadow_status_code"`
+	ShadowError            string `json:"shadow_error" gorm:"type:text"`
+	ShadowResponse         string `json:"shadow_response" gorm:"type:text"`
+
+	CreatedAt int64 `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+func (s *ShadowComparison) TableName() string {
+	return "shadow_comparisons"
+}
+
+type SearchShadowComparisonParams struct {
+	RuleId          int    `form:"rule_id"`
+	ShadowChannelId int    `form:"shadow_channel_id"`
+	Model           string `form:"model"`
+	PaginationParams
+}
+
+var allowedShadowComparisonOrderFields = map[string]bool{
+	"id":              true,
+	"created_at":      true,
+	"primary_latency": true,
+	"shadow_latency":  true,
+}
+
+func GetShadowComparisonsList(params *SearchShadowComparisonParams) (*DataResult[ShadowComparison], error) {
+	var comparisons []*ShadowComparison
+	db := DB
+
+	if params.RuleId > 0 {
+		db = db.Where("rule_id = ?", params.RuleId)
+	}
+	if params.ShadowChannelId > 0 {
+		db = db.Where("shadow_channel_id = ?", params.ShadowChannelId)
+	}
+	if params.Model != "" {
+		db = db.Where("model = ?", params.Model)
+	}
+	if params.Order == "" {
+		params.Order = "-id"
+	}
+
+	return PaginateAndOrder(db, &params.PaginationParams, &comparisons, allowedShadowComparisonOrderFields)
+}
+
+func CreateShadowComparison(comparison *ShadowComparison) error {
+	return DB.Create(comparison).Error
+}
+
+func CreateShadowRule(rule *ShadowRule) error {
+	err := DB.Create(rule).Error
+	if err == nil {
+		GlobalShadowRules.Load()
+	}
+	return err
+}
+
+func UpdateShadowRule(rule *ShadowRule) error {
+	err := DB.Omit("id", "created_at").Save(rule).Error
+	if err == nil {
+		GlobalShadowRules.Load()
+	}
+	return err
+}
+
+func GetShadowRule(id int) (*ShadowRule, error) {
+	rule := &ShadowRule{}
+	err := DB.Where("id = ?", id).First(rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func GetAllShadowRules() ([]*ShadowRule, error) {
+	var rules []*ShadowRule
+	err := DB.Order("id desc").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DeleteShadowRule 同时删除规则产生的对比记录
+func DeleteShadowRule(id int) error {
+	err := DB.Delete(&ShadowRule{}, id).Error
+	if err != nil {
+		return err
+	}
+	GlobalShadowRules.Load()
+
+	return DB.Where("rule_id = ?", id).Delete(&ShadowComparison{}).Error
+}
+
+// ShadowRules 启用中的影子流量规则
+type ShadowRules struct {
+	sync.RWMutex
+	Rules []*ShadowRule
+}
+
+var GlobalShadowRules = ShadowRules{}
+
+func (sr *ShadowRules) Load() {
+	var rules []*ShadowRule
+	if err := DB.Where("enable = ? AND percent > ?", true, 0).Order("id").Find(&rules).Error; err != nil {
+		return
+	}
+
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.Rules = rules
+}
+
+// Find 返回第一条匹配的规则
+func (sr *ShadowRules) Find(group, modelName string) *ShadowRule {
+	sr.RLock()
+	defer sr.RUnlock()
+
+	for _, rule := range sr.Rules {
+		if rule.Match(group, modelName) {
+			return rule
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// StoredResponse 兼容模式下保存的 Responses 对象，用于 previous_response_id 续接对话
+// Input 为本轮请求的输入项，Response 为完整的响应对象，通过 PreviousResponseId 串联成对话链
+type StoredResponse struct {
+	Id                 int    `json:"id"`
+	ResponseId         string `json:"response_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId             int    `json:"user_id" gorm:"index"`
+	TokenId            int    `json:"token_id" gorm:"index"`
+	PreviousResponseId string `json:"previous_response_id" gorm:"type:varchar(64)"`
+	Model              string `json:"model" gorm:"type:varchar(255)"`
+	Input              string `json:"input" gorm:"type:text"`
+	Response           string `json:"response" gorm:"type:text"`
+	CreatedAt          int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (response *StoredResponse) Insert() error {
+	return DB.Create(response).Error
+}
+
+func (response *StoredResponse) Delete() error {
+	return DB.Delete(response).Error
+}
+
+func GetStoredResponse(tokenId int, responseId string) (*StoredResponse, error) {
+	response := &StoredResponse{}
+	err := DB.Where("token_id = ? and response_id = ?", tokenId, responseId).First(response).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return response, err
+}
+
+// GetStoredResponseChain 沿 previous_response_id 向前获取整条对话链，按时间正序返回
+// 链中间的响应被删除时在该处截断
+func GetStoredResponseChain(tokenId int, responseId string, maxDepth int) ([]*StoredResponse, error) {
+	chain := make([]*StoredResponse, 0)
+	for responseId != "" && len(chain) < maxDepth {
+		response, err := GetStoredResponse(tokenId, responseId)
+		if err != nil {
+			return nil, err
+		}
+		if response == nil {
+			break
+		}
+
+		chain = append(chain, response)
+		responseId = response.PreviousResponseId
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain, nil
+}
+
+// DeleteExpiredStoredResponses 删除 before 之前保存的响应
+func DeleteExpiredStoredResponses(before int64) (int64, error) {
+	result := DB.Where("created_at < ?", before).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
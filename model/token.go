@@ -64,8 +64,11 @@ func (token *Token) AfterCreate(tx *gorm.DB) (err error) {
 }
 
 type TokenSetting struct {
-	Heartbeat HeartbeatSetting `json:"heartbeat,omitempty"`
-	Limits    LimitsConfig     `json:"limits,omitempty"`
+	Heartbeat     HeartbeatSetting     `json:"heartbeat,omitempty"`
+	Limits        LimitsConfig         `json:"limits,omitempty"`
+	ResponseCache ResponseCacheSetting `json:"response_cache,omitempty"`
+	Hedge         HedgeSetting         `json:"hedge,omitempty"`
+	ContextFit    ContextFitSetting    `json:"context_fit,omitempty"`
 }
 
 type HeartbeatSetting struct {
@@ -73,6 +76,30 @@ type HeartbeatSetting struct {
 	TimeoutSeconds int  `json:"timeout_seconds"`
 }
 
+// ResponseCacheSetting 响应缓存，TTL 为 0 时使用系统默认的缓存时间
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	TTL     int  `json:"ttl"`
+}
+
+// HedgeSetting 对冲请求，Delay 为 0 时使用系统默认的等待时间(毫秒)
+type HedgeSetting struct {
+	Enabled bool `json:"enabled"`
+	Delay   int  `json:"delay"`
+}
+
+const (
+	ContextFitDropOldest = "drop_oldest" // 从最早的对话轮次开始丢弃
+	ContextFitKeepLast   = "keep_last"   // 只保留系统提示词与最近的若干轮
+	ContextFitSummarize  = "summarize"   // 保留最近的若干轮，之前的内容由模型总结
+)
+
+// ContextFitSetting 对话超出模型上下文长度时的处理方式，Strategy 为空表示不处理
+type ContextFitSetting struct {
+	Strategy string `json:"strategy"`
+	KeepLast int    `json:"keep_last"`
+}
+
 type LimitsConfig struct {
 	LimitModelSetting LimitModelSetting `json:"limit_model_setting,omitempty"`
 	LimitsIPSetting   LimitsIPSetting   `json:"limits_ip_setting,omitempty"`
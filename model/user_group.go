@@ -6,6 +6,8 @@ import (
 	"done-hub/common/logger"
 	"fmt"
 	"sync"
+
+	"gorm.io/datatypes"
 )
 
 type UserGroup struct {
@@ -19,6 +21,17 @@ type UserGroup struct {
 	Min       int     `json:"min" form:"min" gorm:"default:0"`                 // 晋级条件最小值
 	Max       int     `json:"max" form:"max" gorm:"default:0"`                 // 晋级条件最大值
 	Enable    *bool   `json:"enable" form:"enable" gorm:"default:true"`        // 是否启用
+
+	BatchRatio   float64 `json:"batch_ratio" gorm:"type:decimal(10,2); default:1"` // 批量任务(Batch API)折扣倍率，在分组倍率基础上相乘
+	CacheEnabled bool    `json:"cache_enabled" gorm:"default:false"`               // 是否为该分组开启响应缓存
+	CacheRatio   float64 `json:"cache_ratio" gorm:"type:decimal(10,2); default:1"` // 命中响应缓存时的计费倍率，在分组倍率基础上相乘
+	HedgeEnabled bool    `json:"hedge_enabled" gorm:"default:false"`               // 是否为该分组开启对冲请求
+	HedgeDelay   int     `json:"hedge_delay" gorm:"default:0"`                     // 对冲请求等待时间(毫秒)，0 表示使用系统默认值
+
+	BalanceStrategy string `json:"balance_strategy" gorm:"type:varchar(20);default:''"` // 渠道负载均衡策略，weight(默认) 或 adaptive
+	PrefixAffinity  bool   `json:"prefix_affinity" gorm:"default:false"`                // 是否按请求前缀将同一对话固定到同一渠道，提高上游提示词缓存的命中率，未启用 Redis 时映射只保存在本节点
+
+	McpServers datatypes.JSONSlice[int] `json:"mcp_servers" gorm:"type:json"` // 分组挂载的 MCP 服务
 }
 
 type SearchUserGroupParams struct {
@@ -79,7 +92,7 @@ func (c *UserGroup) Create() error {
 }
 
 func (c *UserGroup) Update() error {
-	err := DB.Select("name", "ratio", "public", "api_rate", "promotion", "min", "max").Updates(c).Error
+	err := DB.Select("name", "ratio", "public", "api_rate", "promotion", "min", "max", "batch_ratio", "cache_enabled", "cache_ratio", "hedge_enabled", "hedge_delay", "balance_strategy", "prefix_affinity", "mcp_servers").Updates(c).Error
 	if err == nil {
 		GlobalUserGroupRatio.Load()
 	}
@@ -179,6 +192,58 @@ func (cgrm *UserGroupRatio) GetAPIRate(symbol string) int {
 	return userGroup.APIRate
 }
 
+// GetBatchRatio 获取分组的批量任务折扣倍率，未设置时不打折
+func (cgrm *UserGroupRatio) GetBatchRatio(symbol string) float64 {
+	userGroup := cgrm.GetBySymbol(symbol)
+	if userGroup == nil || userGroup.BatchRatio <= 0 {
+		return 1
+	}
+
+	return userGroup.BatchRatio
+}
+
+// IsCacheEnabled 分组是否开启了响应缓存
+func (cgrm *UserGroupRatio) IsCacheEnabled(symbol string) bool {
+	userGroup := cgrm.GetBySymbol(symbol)
+	return userGroup != nil && userGroup.CacheEnabled
+}
+
+// GetCacheRatio 获取分组命中响应缓存时的计费倍率，未设置时按原价计费
+func (cgrm *UserGroupRatio) GetCacheRatio(symbol string) float64 {
+	userGroup := cgrm.GetBySymbol(symbol)
+	if userGroup == nil || userGroup.CacheRatio <= 0 {
+		return 1
+	}
+
+	return userGroup.CacheRatio
+}
+
+// GetBalanceStrategy 获取分组的渠道负载均衡策略，未设置时按静态权重选择
+func (cgrm *UserGroupRatio) GetBalanceStrategy(symbol string) string {
+	userGroup := cgrm.GetBySymbol(symbol)
+	if userGroup == nil || userGroup.BalanceStrategy == "" {
+		return BalanceStrategyWeight
+	}
+
+	return userGroup.BalanceStrategy
+}
+
+// IsPrefixAffinityEnabled 分组是否开启前缀亲和的粘性路由
+func (cgrm *UserGroupRatio) IsPrefixAffinityEnabled(symbol string) bool {
+	userGroup := cgrm.GetBySymbol(symbol)
+	return userGroup != nil && userGroup.PrefixAffinity
+}
+
+// GetMcpServers 获取分组挂载的 MCP 服务 id
+func (cgrm *UserGroupRatio) GetMcpServers(symbol string) []int {
+	userGroup := cgrm.GetBySymbol(symbol)
+	if userGroup == nil {
+		return nil
+	}
+
+	return userGroup.McpServers
+}
+
 // GetDisplayName 获取分组的展示名称，如果找不到则返回 symbol 本身
 func (cgrm *UserGroupRatio) GetDisplayName(symbol string) string {
 	userGroup := cgrm.GetBySymbol(symbol)
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"done-hub/common/config"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/datatypes"
+)
+
+// VirtualModel 管理员定义的虚拟模型，请求时按顺序尝试 Models 中的真实模型，前一个不可用或出错时使用下一个
+type VirtualModel struct {
+	Id          int                         `json:"id"`
+	Name        string                      `json:"name" gorm:"type:varchar(100);uniqueIndex"`
+	Description string                      `json:"description" gorm:"type:text"`
+	Models      datatypes.JSONSlice[string] `json:"models" gorm:"type:json"`
+	Enable      *bool                       `json:"enable" gorm:"default:true"`
+	CreatedAt   int64                       `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   int64                       `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func (v *VirtualModel) TableName() string {
+	return "virtual_models"
+}
+
+func (v *VirtualModel) Validate() error {
+	if v.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(v.Models) == 0 {
+		return errors.New("models is required")
+	}
+
+	// 与渠道的模型同名时请求无法区分使用哪一个，未启用的渠道也会检查
+	channelModel, err := findChannelModel(v.Name)
+	if err != nil {
+		return err
+	}
+	if channelModel != "" {
+		return fmt.Errorf("name %s conflicts with channel model %s", v.Name, channelModel)
+	}
+
+	seen := make(map[string]bool, len(v.Models))
+	for _, modelName := range v.Models {
+		if modelName == "" {
+			return errors.New("model name is empty")
+		}
+		if modelName == v.Name {
+			return errors.New("virtual model cannot contain itself")
+		}
+		if seen[modelName] {
+			return fmt.Errorf("model %s is duplicated", modelName)
+		}
+		seen[modelName] = true
+
+		// 不支持嵌套，避免出现循环
+		if existing := GlobalVirtualModels.GetModels(modelName); existing != nil {
+			return fmt.Errorf("model %s is a virtual model", modelName)
+		}
+	}
+
+	return nil
+}
+
+// findChannelModel 返回与名称相同或通配符匹配该名称的渠道模型，没有时返回空字符串
+func findChannelModel(name string) (string, error) {
+	var modelsList []string
+	if err := DB.Model(&Channel{}).Pluck("models", &modelsList).Error; err != nil {
+		return "", err
+	}
+
+	for _, models := range modelsList {
+		for _, modelName := range strings.Split(models, ",") {
+			modelName = strings.TrimSpace(modelName)
+			if modelName == "" {
+				continue
+			}
+
+			pattern, target := modelName, name
+			if config.ModelNameCaseInsensitiveEnabled {
+				pattern, target = strings.ToLower(pattern), strings.ToLower(target)
+			}
+			if pattern == target || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(target, strings.TrimRight(pattern, "*"))) {
+				return modelName, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (v *VirtualModel) IsEnabled() bool {
+	return v.Enable == nil || *v.Enable
+}
+
+func CreateVirtualModel(virtualModel *VirtualModel) error {
+	err := DB.Create(virtualModel).Error
+	if err == nil {
+		GlobalVirtualModels.Load()
+	}
+	return err
+}
+
+func UpdateVirtualModel(virtualModel *VirtualModel) error {
+	err := DB.Omit("id", "created_at").Save(virtualModel).Error
+	if err == nil {
+		GlobalVirtualModels.Load()
+	}
+	return err
+}
+
+func GetVirtualModel(id int) (*VirtualModel, error) {
+	virtualModel := &VirtualModel{}
+	err := DB.Where("id = ?", id).First(virtualModel).Error
+	if err != nil {
+		return nil, err
+	}
+	return virtualModel, nil
+}
+
+func GetAllVirtualModels() ([]*VirtualModel, error) {
+	var virtualModels []*VirtualModel
+	err := DB.Order("id desc").Find(&virtualModels).Error
+	if err != nil {
+		return nil, err
+	}
+	return virtualModels, nil
+}
+
+func DeleteVirtualModel(id int) error {
+	err := DB.Delete(&VirtualModel{}, id).Error
+	if err == nil {
+		GlobalVirtualModels.Load()
+	}
+	return err
+}
+
+// VirtualModels 启用中的虚拟模型，名称到真实模型链的映射
+type VirtualModels struct {
+	sync.RWMutex
+	Models map[string][]string
+}
+
+var GlobalVirtualModels = VirtualModels{}
+
+func (vm *VirtualModels) Load() {
+	var virtualModels []*VirtualModel
+	if err := DB.Where("enable = ?", true).Find(&virtualModels).Error; err != nil {
+		return
+	}
+
+	newModels := make(map[string][]string, len(virtualModels))
+	for _, virtualModel := range virtualModels {
+		if len(virtualModel.Models) > 0 {
+			newModels[virtualModel.Name] = virtualModel.Models
+		}
+	}
+
+	vm.Lock()
+	defer vm.Unlock()
+
+	vm.Models = newModels
+}
+
+// GetModels 返回虚拟模型的真实模型链，不是虚拟模型时返回 nil
+func (vm *VirtualModels) GetModels(name string) []string {
+	vm.RLock()
+	defer vm.RUnlock()
+
+	models, ok := vm.Models[name]
+	if !ok {
+		return nil
+	}
+
+	return append([]string(nil), models...)
+}
+
+func (vm *VirtualModels) GetAll() map[string][]string {
+	vm.RLock()
+	defer vm.RUnlock()
+
+	return vm.Models
+}
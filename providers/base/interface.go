@@ -139,6 +139,12 @@ type RealtimeInterface interface {
 	CreateChatRealtime(modelName string) (*websocket.Conn, requester.MessageHandler, *types.OpenAIErrorWithStatusCode)
 }
 
+// RealtimeBridgeInterface 上游不是 OpenAI Realtime 协议时，通过 WSBridge 转换事件
+type RealtimeBridgeInterface interface {
+	ProviderInterface
+	CreateRealtimeBridge(modelName string) (*websocket.Conn, requester.WSBridge, *types.OpenAIErrorWithStatusCode)
+}
+
 type ResponsesInterface interface {
 	ProviderInterface
 	CreateResponses(request *types.OpenAIResponsesRequest) (*types.OpenAIResponsesResponses, *types.OpenAIErrorWithStatusCode)
@@ -2,10 +2,13 @@ package claude
 
 import (
 	"bytes"
+	"done-hub/common"
+	"done-hub/common/config"
 	"done-hub/common/requester"
 	"done-hub/types"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 )
 
@@ -70,6 +73,51 @@ func (p *ClaudeProvider) CreateClaudeChatStream(request *ClaudeRequest) (request
 	return stream, nil
 }
 
+func (p *ClaudeProvider) CountClaudeTokens(request *ClaudeRequest) (*ClaudeCountTokensResponse, *types.OpenAIErrorWithStatusCode) {
+	url, errWithCode := p.GetSupportedAPIUri(config.RelayModeChatCompletions)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL := p.GetFullRequestURL(url + "/count_tokens")
+	if fullRequestURL == "" {
+		return nil, common.ErrorWrapperLocal(nil, "invalid_claude_config", http.StatusInternalServerError)
+	}
+
+	countRequest := &ClaudeCountTokensRequest{
+		Model:      request.Model,
+		System:     request.System,
+		Messages:   request.Messages,
+		Tools:      request.Tools,
+		ToolChoice: request.ToolChoice,
+		Thinking:   request.Thinking,
+		McpServers: request.McpServers,
+	}
+
+	headers := p.GetRequestHeaders()
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(countRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	countResponse := &ClaudeCountTokensResponse{}
+	_, errWithCode = p.Requester.SendRequest(req, countResponse, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	aiError := errorHandle(countResponse.Error)
+	if aiError != nil {
+		return nil, &types.OpenAIErrorWithStatusCode{
+			OpenAIError: *aiError,
+			StatusCode:  http.StatusBadRequest,
+		}
+	}
+
+	return countResponse, nil
+}
+
 func (h *ClaudeRelayStreamHandler) HandlerStream(rawLine *[]byte, dataChan chan string, errChan chan error) {
 	rawStr := string(*rawLine)
 	// 如果rawLine 前缀不为data:，则直接返回
@@ -222,6 +222,23 @@ func (u *Usage) UnmarshalJSON(data []byte) error {
 type ServerToolUse struct {
 	WebSearchRequests int `json:"web_search_requests,omitempty"`
 }
+
+// ClaudeCountTokensRequest count_tokens 接口只接受以下字段
+type ClaudeCountTokensRequest struct {
+	Model      string      `json:"model"`
+	System     any         `json:"system,omitempty"`
+	Messages   []Message   `json:"messages"`
+	Tools      []Tools     `json:"tools,omitempty"`
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+	Thinking   *Thinking   `json:"thinking,omitempty"`
+	McpServers any         `json:"mcp_servers,omitempty"`
+}
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int          `json:"input_tokens"`
+	Error       *ClaudeError `json:"error,omitempty"`
+}
+
 type ClaudeResponse struct {
 	Id           string       `json:"id"`
 	Type         string       `json:"type"`
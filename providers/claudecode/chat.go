@@ -88,6 +88,75 @@ func (p *ClaudeCodeProvider) getChatRequest(claudeRequest *claude.ClaudeRequest)
 	}
 
 	// 获取请求头
+	headers, errWithCode := p.getClaudeCodeHeaders()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if claudeRequest.Stream {
+		headers["Accept"] = "text/event-stream"
+	}
+
+	// 使用BaseProvider的统一方法创建请求，支持额外参数处理
+	req, errWithCode := p.NewRequestWithCustomParams(http.MethodPost, fullRequestURL, claudeRequest, headers, claudeRequest.Model)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	return req, nil
+}
+
+// CountClaudeTokens 使用 OAuth 凭证调用上游 count_tokens 接口
+func (p *ClaudeCodeProvider) CountClaudeTokens(request *claude.ClaudeRequest) (*claude.ClaudeCountTokensResponse, *types.OpenAIErrorWithStatusCode) {
+	url, errWithCode := p.GetSupportedAPIUri(config.RelayModeChatCompletions)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL := p.GetFullRequestURL(url + "/count_tokens")
+	if fullRequestURL == "" {
+		return nil, common.ErrorWrapperLocal(nil, "invalid_claudecode_config", http.StatusInternalServerError)
+	}
+
+	headers, errWithCode := p.getClaudeCodeHeaders()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	countRequest := &claude.ClaudeCountTokensRequest{
+		Model:      request.Model,
+		System:     request.System,
+		Messages:   request.Messages,
+		Tools:      request.Tools,
+		ToolChoice: request.ToolChoice,
+		Thinking:   request.Thinking,
+		McpServers: request.McpServers,
+	}
+
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(countRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	countResponse := &claude.ClaudeCountTokensResponse{}
+	_, errWithCode = p.Requester.SendRequest(req, countResponse, false)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if aiError := errorHandle(countResponse.Error); aiError != nil {
+		return nil, &types.OpenAIErrorWithStatusCode{
+			OpenAIError: *aiError,
+			StatusCode:  http.StatusBadRequest,
+		}
+	}
+
+	return countResponse, nil
+}
+
+// getClaudeCodeHeaders 获取带 OAuth 凭证和 ClaudeCode 默认值的请求头
+func (p *ClaudeCodeProvider) getClaudeCodeHeaders() (map[string]string, *types.OpenAIErrorWithStatusCode) {
 	headers := p.GetRequestHeaders()
 
 	// 检查 token 是否获取成功
@@ -104,17 +173,7 @@ func (p *ClaudeCodeProvider) getChatRequest(claudeRequest *claude.ClaudeRequest)
 	// 应用 ClaudeCode 默认请求头
 	p.applyDefaultHeaders(headers)
 
-	if claudeRequest.Stream {
-		headers["Accept"] = "text/event-stream"
-	}
-
-	// 使用BaseProvider的统一方法创建请求，支持额外参数处理
-	req, errWithCode := p.NewRequestWithCustomParams(http.MethodPost, fullRequestURL, claudeRequest, headers, claudeRequest.Model)
-	if errWithCode != nil {
-		return nil, errWithCode
-	}
-
-	return req, nil
+	return headers, nil
 }
 
 // applyDefaultHeaders 应用 ClaudeCode 默认请求头
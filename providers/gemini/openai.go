@@ -0,0 +1,592 @@
+package gemini
+
+import (
+	"done-hub/common/utils"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// ToChatCompletionRequest 将 Gemini 原生请求转换为 OpenAI 聊天请求，供非 Gemini 渠道使用
+func (r *GeminiChatRequest) ToChatCompletionRequest() *types.ChatCompletionRequest {
+	request := &types.ChatCompletionRequest{
+		Model:    r.Model,
+		Stream:   r.Stream,
+		Messages: make([]types.ChatCompletionMessage, 0, len(r.Contents)+1),
+	}
+
+	if systemText := geminiSystemInstructionText(r.SystemInstruction); systemText != "" {
+		request.Messages = append(request.Messages, types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleSystem,
+			Content: systemText,
+		})
+	}
+
+	// Gemini 的 functionCall 通常没有 id，按函数名排队生成 id，供后续的 functionResponse 匹配
+	pendingCallIds := make(map[string][]string)
+
+	for _, content := range r.Contents {
+		if content.Role == "model" {
+			if message, ok := convertGeminiModelContent(content, pendingCallIds); ok {
+				request.Messages = append(request.Messages, message)
+			}
+			continue
+		}
+
+		request.Messages = append(request.Messages, convertGeminiUserContent(content, pendingCallIds)...)
+	}
+
+	r.applyGenerationConfig(request)
+	r.applyTools(request)
+
+	return request
+}
+
+func (r *GeminiChatRequest) applyGenerationConfig(request *types.ChatCompletionRequest) {
+	config := r.GenerationConfig
+
+	request.Temperature = config.Temperature
+	request.TopP = config.TopP
+	request.TopK = config.TopK
+	request.MaxTokens = config.MaxOutputTokens
+
+	if config.CandidateCount > 1 {
+		n := config.CandidateCount
+		request.N = &n
+	}
+
+	if len(config.StopSequences) > 0 {
+		request.Stop = config.StopSequences
+	}
+
+	if config.ResponseMimeType == "application/json" {
+		if config.ResponseSchema != nil {
+			request.ResponseFormat = &types.ChatCompletionResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &types.FormatJsonSchema{
+					Name:   "response",
+					Schema: normalizeGeminiSchema(config.ResponseSchema),
+				},
+			}
+		} else {
+			request.ResponseFormat = &types.ChatCompletionResponseFormat{
+				Type: "json_object",
+			}
+		}
+	}
+
+	if config.ThinkingConfig != nil {
+		request.IncludeThoughts = config.ThinkingConfig.IncludeThoughts
+		if config.ThinkingConfig.ThinkingLevel != "" {
+			effort := strings.ToLower(config.ThinkingConfig.ThinkingLevel)
+			request.ReasoningEffort = &effort
+		} else if config.ThinkingConfig.ThinkingBudget != nil && *config.ThinkingConfig.ThinkingBudget > 0 {
+			request.Reasoning = &types.ChatReasoning{
+				MaxTokens: *config.ThinkingConfig.ThinkingBudget,
+			}
+		}
+	}
+}
+
+func (r *GeminiChatRequest) applyTools(request *types.ChatCompletionRequest) {
+	for _, tool := range r.Tools {
+		// googleSearch / codeExecution 等内置工具无法在其他渠道上执行，直接忽略
+		for _, function := range tool.FunctionDeclarations {
+			function.Parameters = normalizeGeminiSchema(function.Parameters)
+			request.Tools = append(request.Tools, &types.ChatCompletionTool{
+				Type:     "function",
+				Function: function,
+			})
+		}
+	}
+
+	if len(request.Tools) == 0 || r.ToolConfig == nil || r.ToolConfig.FunctionCallingConfig == nil {
+		return
+	}
+
+	callingConfig := r.ToolConfig.FunctionCallingConfig
+	switch strings.ToUpper(callingConfig.Mode) {
+	case "NONE":
+		request.ToolChoice = types.ToolChoiceTypeNone
+	case "ANY":
+		request.ToolChoice = types.ToolChoiceTypeRequired
+		if names, ok := callingConfig.AllowedFunctionNames.([]any); ok && len(names) == 1 {
+			if name, ok := names[0].(string); ok {
+				request.ToolChoice = map[string]any{
+					"type": types.ToolChoiceTypeFunction,
+					"function": map[string]any{
+						"name": name,
+					},
+				}
+			}
+		}
+	case "AUTO":
+		request.ToolChoice = types.ToolChoiceTypeAuto
+	}
+}
+
+func geminiSystemInstructionText(systemInstruction any) string {
+	switch system := systemInstruction.(type) {
+	case nil:
+		return ""
+	case string:
+		return system
+	}
+
+	systemBytes, err := json.Marshal(systemInstruction)
+	if err != nil {
+		return ""
+	}
+
+	var content GeminiChatContent
+	if err := json.Unmarshal(systemBytes, &content); err != nil {
+		return ""
+	}
+
+	texts := make([]string, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+func convertGeminiModelContent(content GeminiChatContent, pendingCallIds map[string][]string) (types.ChatCompletionMessage, bool) {
+	message := types.ChatCompletionMessage{
+		Role: types.ChatMessageRoleAssistant,
+	}
+
+	var text, reasoning strings.Builder
+	for _, part := range content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			callId := part.FunctionCall.Id
+			if callId == "" {
+				callId = "call_" + utils.GetRandomString(24)
+			}
+			pendingCallIds[part.FunctionCall.Name] = append(pendingCallIds[part.FunctionCall.Name], callId)
+
+			args, _ := json.Marshal(part.FunctionCall.Args)
+			if part.FunctionCall.Args == nil {
+				args = []byte("{}")
+			}
+			message.ToolCalls = append(message.ToolCalls, &types.ChatCompletionToolCalls{
+				Id:    callId,
+				Type:  types.ChatMessageRoleFunction,
+				Index: len(message.ToolCalls),
+				Function: &types.ChatCompletionToolCallsFunction{
+					Name:      part.FunctionCall.Name,
+					Arguments: string(args),
+				},
+			})
+		case part.Thought:
+			reasoning.WriteString(part.Text)
+		case part.Text != "":
+			text.WriteString(part.Text)
+		case part.ExecutableCode != nil:
+			text.WriteString(fmt.Sprintf("\n```%s\n%s\n```\n", strings.ToLower(part.ExecutableCode.Language), part.ExecutableCode.Code))
+		case part.CodeExecutionResult != nil:
+			text.WriteString(fmt.Sprintf("\n```output\n%s\n```\n", part.CodeExecutionResult.Output))
+		}
+	}
+
+	if text.Len() > 0 {
+		message.Content = text.String()
+	}
+	message.ReasoningContent = reasoning.String()
+
+	if message.Content == nil && len(message.ToolCalls) == 0 {
+		return message, false
+	}
+
+	return message, true
+}
+
+func convertGeminiUserContent(content GeminiChatContent, pendingCallIds map[string][]string) []types.ChatCompletionMessage {
+	messages := make([]types.ChatCompletionMessage, 0, 1)
+	parts := make([]types.ChatMessagePart, 0, len(content.Parts))
+
+	for _, part := range content.Parts {
+		switch {
+		case part.FunctionResponse != nil:
+			messages = append(messages, convertGeminiFunctionResponse(part.FunctionResponse, pendingCallIds))
+		case part.InlineData != nil:
+			parts = append(parts, convertGeminiInlineData(part.InlineData))
+		case part.FileData != nil:
+			parts = append(parts, convertGeminiFileData(part.FileData))
+		case part.Text != "":
+			parts = append(parts, types.ChatMessagePart{
+				Type: types.ContentTypeText,
+				Text: part.Text,
+			})
+		}
+	}
+
+	if len(parts) == 0 {
+		return messages
+	}
+
+	message := types.ChatCompletionMessage{
+		Role: types.ChatMessageRoleUser,
+	}
+	if len(parts) == 1 && parts[0].Type == types.ContentTypeText {
+		message.Content = parts[0].Text
+	} else {
+		message.Content = parts
+	}
+
+	return append(messages, message)
+}
+
+func convertGeminiFunctionResponse(response *GeminiFunctionResponse, pendingCallIds map[string][]string) types.ChatCompletionMessage {
+	var callId string
+	if len(response.ID) > 0 {
+		json.Unmarshal(response.ID, &callId)
+	}
+
+	if queue := pendingCallIds[response.Name]; len(queue) > 0 {
+		if callId == "" {
+			callId = queue[0]
+		}
+		pendingCallIds[response.Name] = queue[1:]
+	}
+
+	if callId == "" {
+		callId = "call_" + utils.GetRandomString(24)
+	}
+
+	var result string
+	switch value := response.Response.(type) {
+	case string:
+		result = value
+	default:
+		resultBytes, _ := json.Marshal(value)
+		result = string(resultBytes)
+	}
+
+	return types.ChatCompletionMessage{
+		Role:       types.ChatMessageRoleTool,
+		Content:    result,
+		ToolCallID: callId,
+		Name:       &response.Name,
+	}
+}
+
+func convertGeminiInlineData(data *GeminiInlineData) types.ChatMessagePart {
+	mimeType := data.MimeType
+	switch {
+	case strings.HasPrefix(mimeType, "audio/"):
+		return types.ChatMessagePart{
+			Type: "input_audio",
+			InputAudio: &types.InputAudio{
+				Data:   data.Data,
+				Format: geminiAudioFormat(mimeType),
+			},
+		}
+	case strings.HasPrefix(mimeType, "image/") || mimeType == "":
+		if mimeType == "" {
+			mimeType = "image/png"
+		}
+		return types.ChatMessagePart{
+			Type: types.ContentTypeImageURL,
+			ImageURL: &types.ChatMessageImageURL{
+				URL: fmt.Sprintf("data:%s;base64,%s", mimeType, data.Data),
+			},
+		}
+	default:
+		return types.ChatMessagePart{
+			Type: "file",
+			File: &types.ChatMessageFile{
+				Filename: "file" + extensionFromMimeType(mimeType),
+				FileData: fmt.Sprintf("data:%s;base64,%s", mimeType, data.Data),
+			},
+		}
+	}
+}
+
+func convertGeminiFileData(data *GeminiFileData) types.ChatMessagePart {
+	if data.MimeType == "" || strings.HasPrefix(data.MimeType, "image/") {
+		return types.ChatMessagePart{
+			Type: types.ContentTypeImageURL,
+			ImageURL: &types.ChatMessageImageURL{
+				URL: data.FileUri,
+			},
+		}
+	}
+
+	return types.ChatMessagePart{
+		Type: "file",
+		File: &types.ChatMessageFile{
+			Filename: "file" + extensionFromMimeType(data.MimeType),
+			FileData: data.FileUri,
+		},
+	}
+}
+
+func geminiAudioFormat(mimeType string) string {
+	format := strings.TrimPrefix(mimeType, "audio/")
+	switch format {
+	case "mpeg", "mp3":
+		return "mp3"
+	case "x-wav", "wave", "wav":
+		return "wav"
+	default:
+		return format
+	}
+}
+
+func extensionFromMimeType(mimeType string) string {
+	extensions, err := mime.ExtensionsByType(mimeType)
+	if err != nil || len(extensions) == 0 {
+		return ""
+	}
+
+	return extensions[0]
+}
+
+// normalizeGeminiSchema 将 Gemini 的大写类型（OBJECT、STRING 等）转换为 JSON Schema 的小写类型
+func normalizeGeminiSchema(schema any) any {
+	switch value := schema.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(value))
+		for key, item := range value {
+			if key == "type" {
+				if typeName, ok := item.(string); ok {
+					normalized[key] = strings.ToLower(typeName)
+					continue
+				}
+			}
+			normalized[key] = normalizeGeminiSchema(item)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(value))
+		for i, item := range value {
+			normalized[i] = normalizeGeminiSchema(item)
+		}
+		return normalized
+	default:
+		return schema
+	}
+}
+
+// ConvertOpenAIFinishReason 将 OpenAI 的结束原因转换为 Gemini 格式
+func ConvertOpenAIFinishReason(reason string) string {
+	switch reason {
+	case types.FinishReasonLength:
+		return "MAX_TOKENS"
+	case types.FinishReasonContentFilter:
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// ConvertOpenAIUsageToGemini 将 OpenAI 用量转换为 Gemini 的 usageMetadata
+func ConvertOpenAIUsageToGemini(usage *types.Usage) *GeminiUsageMetadata {
+	if usage == nil {
+		return nil
+	}
+
+	reasoningTokens := usage.CompletionTokensDetails.ReasoningTokens
+	totalTokens := usage.TotalTokens
+	if totalTokens == 0 {
+		totalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	return &GeminiUsageMetadata{
+		PromptTokenCount:        usage.PromptTokens,
+		CandidatesTokenCount:    usage.CompletionTokens - reasoningTokens,
+		TotalTokenCount:         totalTokens,
+		CachedContentTokenCount: usage.PromptTokensDetails.CachedTokens,
+		ThoughtsTokenCount:      reasoningTokens,
+	}
+}
+
+// ConvertChatOpenaiToGemini 将 OpenAI 聊天响应转换为 Gemini 响应
+func ConvertChatOpenaiToGemini(response *types.ChatCompletionResponse, modelVersion string) *GeminiChatResponse {
+	geminiResponse := &GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(response.Choices)),
+		UsageMetadata: ConvertOpenAIUsageToGemini(response.Usage),
+		ModelVersion:  modelVersion,
+		ResponseId:    response.ID,
+	}
+
+	for _, choice := range response.Choices {
+		parts := make([]GeminiPart, 0, 2)
+		if choice.Message.ReasoningContent != "" {
+			parts = append(parts, GeminiPart{Text: choice.Message.ReasoningContent, Thought: true})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+
+		choice.Message.FuncToToolCalls()
+		parts = append(parts, toolCallsToGeminiParts(choice.Message.ToolCalls)...)
+
+		finishReason := ConvertOpenAIFinishReason(choice.FinishReason)
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: &finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+
+	return geminiResponse
+}
+
+func toolCallsToGeminiParts(toolCalls []*types.ChatCompletionToolCalls) []GeminiPart {
+	parts := make([]GeminiPart, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		if toolCall == nil || toolCall.Function == nil {
+			continue
+		}
+
+		args := make(map[string]interface{})
+		if toolCall.Function.Arguments != "" {
+			json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
+		}
+
+		parts = append(parts, GeminiPart{
+			FunctionCall: &GeminiFunctionCall{
+				Name: toolCall.Function.Name,
+				Args: args,
+				Id:   toolCall.Id,
+			},
+		})
+	}
+
+	return parts
+}
+
+// OpenAIStreamToGemini 将 OpenAI 流式块逐个转换为 Gemini 流式响应
+// 工具调用的参数在 OpenAI 中是分片下发的，需要累积后在结束时一次性输出
+type OpenAIStreamToGemini struct {
+	ModelVersion string
+	ResponseId   string
+
+	toolCalls    map[int]*types.ChatCompletionToolCalls
+	finishReason string
+}
+
+func NewOpenAIStreamToGemini(modelVersion string) *OpenAIStreamToGemini {
+	return &OpenAIStreamToGemini{
+		ModelVersion: modelVersion,
+		toolCalls:    make(map[int]*types.ChatCompletionToolCalls),
+	}
+}
+
+// Convert 转换单个 OpenAI 流式块，没有可输出内容时返回 nil
+func (s *OpenAIStreamToGemini) Convert(chunk *types.ChatCompletionStreamResponse) *GeminiChatResponse {
+	if s.ResponseId == "" {
+		s.ResponseId = chunk.ID
+	}
+
+	parts := make([]GeminiPart, 0, 2)
+	for _, choice := range chunk.Choices {
+		reasoning := choice.Delta.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Delta.Reasoning
+		}
+		if reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		if choice.Delta.Content != "" {
+			parts = append(parts, GeminiPart{Text: choice.Delta.Content})
+		}
+
+		if choice.Delta.FunctionCall != nil {
+			s.appendToolCall(&types.ChatCompletionToolCalls{Function: choice.Delta.FunctionCall})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			s.appendToolCall(toolCall)
+		}
+
+		if reason, ok := choice.FinishReason.(string); ok && reason != "" && reason != types.FinishReasonNull {
+			s.finishReason = reason
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	return s.newResponse(parts, nil)
+}
+
+// Finish 生成最后一个响应块，包含累积的工具调用、结束原因和用量
+func (s *OpenAIStreamToGemini) Finish(usage *types.Usage) *GeminiChatResponse {
+	indexes := make([]int, 0, len(s.toolCalls))
+	for index := range s.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	toolCalls := make([]*types.ChatCompletionToolCalls, 0, len(indexes))
+	for _, index := range indexes {
+		toolCalls = append(toolCalls, s.toolCalls[index])
+	}
+
+	parts := toolCallsToGeminiParts(toolCalls)
+	if len(parts) == 0 {
+		parts = append(parts, GeminiPart{Text: ""})
+	}
+
+	finishReason := ConvertOpenAIFinishReason(s.finishReason)
+	response := s.newResponse(parts, &finishReason)
+	response.UsageMetadata = ConvertOpenAIUsageToGemini(usage)
+
+	return response
+}
+
+func (s *OpenAIStreamToGemini) appendToolCall(toolCall *types.ChatCompletionToolCalls) {
+	if toolCall == nil || toolCall.Function == nil {
+		return
+	}
+
+	existing, ok := s.toolCalls[toolCall.Index]
+	if !ok {
+		s.toolCalls[toolCall.Index] = &types.ChatCompletionToolCalls{
+			Id:    toolCall.Id,
+			Type:  toolCall.Type,
+			Index: toolCall.Index,
+			Function: &types.ChatCompletionToolCallsFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			},
+		}
+		return
+	}
+
+	if toolCall.Id != "" {
+		existing.Id = toolCall.Id
+	}
+	if toolCall.Function.Name != "" {
+		existing.Function.Name = toolCall.Function.Name
+	}
+	existing.Function.Arguments += toolCall.Function.Arguments
+}
+
+func (s *OpenAIStreamToGemini) newResponse(parts []GeminiPart, finishReason *string) *GeminiChatResponse {
+	return &GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{
+			{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+				FinishReason: finishReason,
+			},
+		},
+		ModelVersion: s.ModelVersion,
+		ResponseId:   s.ResponseId,
+	}
+}
@@ -0,0 +1,772 @@
+package gemini
+
+import (
+	"done-hub/common"
+	"done-hub/common/logger"
+	"done-hub/common/requester"
+	"done-hub/common/utils"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// OpenAI Realtime 的 pcm16 为 24kHz，Gemini Live 输出同样是 24kHz
+const geminiLiveAudioMimeType = "audio/pcm;rate=24000"
+
+// OpenAI 的音色映射到 Gemini 的预置音色，传入 Gemini 音色名时直接使用
+var geminiLiveVoices = map[string]string{
+	"alloy":   "Zephyr",
+	"ash":     "Charon",
+	"ballad":  "Orus",
+	"coral":   "Kore",
+	"echo":    "Puck",
+	"sage":    "Aoede",
+	"shimmer": "Leda",
+	"verse":   "Fenrir",
+}
+
+// CreateRealtimeBridge 连接 Gemini Live，并将 OpenAI Realtime 事件与 BidiGenerateContent 消息互相转换
+func (p *GeminiProvider) CreateRealtimeBridge(modelName string) (*websocket.Conn, requester.WSBridge, *types.OpenAIErrorWithStatusCode) {
+	version := "v1beta"
+	if p.Channel.Other != "" {
+		version = p.Channel.Other
+	}
+
+	baseURL := strings.TrimSuffix(p.GetBaseURL(), "/")
+	baseURL = strings.Replace(baseURL, "https://", "wss://", 1)
+	baseURL = strings.Replace(baseURL, "http://", "ws://", 1)
+	fullRequestURL := fmt.Sprintf("%s/ws/google.ai.generativelanguage.%s.GenerativeService.BidiGenerateContent", baseURL, version)
+
+	httpHeaders := make(http.Header)
+	httpHeaders.Set("x-goog-api-key", p.Channel.Key)
+
+	wsRequester := requester.NewWSRequester(*p.Channel.Proxy)
+	wsConn, err := wsRequester.NewRequest(fullRequestURL, httpHeaders)
+	if err != nil {
+		return nil, nil, common.ErrorWrapper(err, "ws_request_failed", http.StatusInternalServerError)
+	}
+
+	return wsConn, newGeminiRealtimeBridge(modelName), nil
+}
+
+type geminiRealtimeBridge struct {
+	lock      sync.Mutex
+	modelName string
+	session   types.RealtimeSession
+
+	// setup 在收到第一个 session.update 时发送，setupComplete 之前的消息先缓存
+	setupSent bool
+	setupDone bool
+	pending   []*GeminiLiveClientMessage
+
+	manualTurn      bool
+	activityStarted bool
+	pendingTurns    bool
+	audioOutput     bool
+	dropTurn        bool
+
+	calls           map[string]string
+	response        *geminiRealtimeResponse
+	inputItemId     string
+	inputTranscript strings.Builder
+	lastUsage       *types.UsageEvent
+}
+
+type geminiRealtimeResponse struct {
+	id         string
+	itemId     string
+	started    bool
+	hasAudio   bool
+	transcript strings.Builder
+	text       strings.Builder
+	output     []types.RealtimeItem
+}
+
+func newGeminiRealtimeBridge(modelName string) *geminiRealtimeBridge {
+	return &geminiRealtimeBridge{
+		modelName: modelName,
+		session: types.RealtimeSession{
+			ID:                      "sess_" + utils.GetUUID(),
+			Object:                  "realtime.session",
+			Model:                   modelName,
+			Modalities:              []string{"text", "audio"},
+			Voice:                   "Puck",
+			InputAudioFormat:        "pcm16",
+			OutputAudioFormat:       "pcm16",
+			TurnDetection:           json.RawMessage(`{"type":"server_vad"}`),
+			ToolChoice:              "auto",
+			MaxResponseOutputTokens: "inf",
+		},
+		calls: make(map[string]string),
+	}
+}
+
+func (b *geminiRealtimeBridge) Open(sender *requester.WSSender) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return sender.SendToUser(b.newEvent(types.EventTypeSessionCreated, func(event *types.RealtimeServerEvent) {
+		event.Session = &b.session
+	}))
+}
+
+func (b *geminiRealtimeBridge) HandleMessage(sender *requester.WSSender, source requester.MessageSource, messageType int, message []byte) (*types.UsageEvent, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if source == requester.UserMessage {
+		return nil, b.handleUserMessage(sender, message)
+	}
+
+	return b.handleSupplierMessage(sender, message)
+}
+
+func (b *geminiRealtimeBridge) newEvent(eventType string, fill func(event *types.RealtimeServerEvent)) *types.RealtimeServerEvent {
+	event := &types.RealtimeServerEvent{
+		EventId: "event_" + utils.GetRandomString(16),
+		Type:    eventType,
+	}
+	if fill != nil {
+		fill(event)
+	}
+
+	return event
+}
+
+func (b *geminiRealtimeBridge) handleUserMessage(sender *requester.WSSender, message []byte) error {
+	// 无效的客户端事件只回复错误事件，不关闭会话
+	var event types.RealtimeClientEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return sender.SendToUser(types.NewErrorEvent("", "invalid_request_error", "invalid_event", err.Error()))
+	}
+
+	switch event.Type {
+	case types.EventTypeSessionUpdate:
+		return b.updateSession(sender, &event)
+	case types.EventTypeInputAudioBufferAppend:
+		if b.manualTurn && !b.activityStarted {
+			b.activityStarted = true
+			if err := b.sendToGemini(sender, &GeminiLiveClientMessage{RealtimeInput: &GeminiLiveRealtimeInput{ActivityStart: &struct{}{}}}); err != nil {
+				return err
+			}
+		}
+
+		return b.sendToGemini(sender, &GeminiLiveClientMessage{
+			RealtimeInput: &GeminiLiveRealtimeInput{
+				Audio: &GeminiInlineData{MimeType: geminiLiveAudioMimeType, Data: event.Audio},
+			},
+		})
+	case types.EventTypeInputAudioBufferCommit:
+		// 自动语音检测时由 Gemini 判断说话结束，手动模式需要显式结束本轮输入
+		if b.manualTurn && b.activityStarted {
+			b.activityStarted = false
+			if err := b.sendToGemini(sender, &GeminiLiveClientMessage{RealtimeInput: &GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}}); err != nil {
+				return err
+			}
+		}
+
+		b.inputItemId = "item_" + utils.GetRandomString(16)
+		return sender.SendToUser(b.newEvent(types.EventTypeInputAudioBufferCommitted, func(e *types.RealtimeServerEvent) {
+			e.ItemId = b.inputItemId
+		}))
+	case types.EventTypeInputAudioBufferClear:
+		// 已发送的音频无法撤回，只回复确认事件
+		return sender.SendToUser(b.newEvent(types.EventTypeInputAudioBufferCleared, nil))
+	case types.EventTypeConversationItemCreate:
+		return b.createItem(sender, &event)
+	case types.EventTypeResponseCreate:
+		// Gemini 在用户输入结束或收到工具结果后会自动回复，只有文本输入需要显式结束本轮
+		if b.pendingTurns {
+			b.pendingTurns = false
+			return b.sendToGemini(sender, &GeminiLiveClientMessage{ClientContent: &GeminiLiveClientContent{TurnComplete: true}})
+		}
+		return b.ensureSetup(sender)
+	case types.EventTypeResponseCancel:
+		// Gemini 不支持取消回复，丢弃本轮剩余的输出
+		if b.response != nil {
+			b.dropTurn = true
+			return b.finishResponse(sender, "cancelled")
+		}
+	default:
+		return sender.SendToUser(types.NewErrorEvent(event.EventId, "invalid_request_error", "invalid_event", fmt.Sprintf("event type %s is not supported", event.Type)))
+	}
+
+	return nil
+}
+
+func (b *geminiRealtimeBridge) updateSession(sender *requester.WSSender, event *types.RealtimeClientEvent) error {
+	if b.setupSent {
+		logger.SysLog(fmt.Sprintf("[Gemini] realtime session %s can not be updated after setup", b.session.ID))
+		return sender.SendToUser(types.NewErrorEvent(event.EventId, "invalid_request_error", "session_update_not_supported", "Gemini Live does not support updating the session after the first session.update"))
+	}
+
+	if update := event.Session; update != nil {
+		if update.Modalities != nil {
+			b.session.Modalities = update.Modalities
+		}
+		if update.Instructions != "" {
+			b.session.Instructions = update.Instructions
+		}
+		if update.Voice != "" {
+			b.session.Voice = update.Voice
+		}
+		if update.InputAudioTranscription != nil {
+			b.session.InputAudioTranscription = update.InputAudioTranscription
+		}
+		if update.TurnDetection != nil {
+			b.session.TurnDetection = update.TurnDetection
+		}
+		if update.Tools != nil {
+			b.session.Tools = update.Tools
+		}
+		if update.ToolChoice != nil {
+			b.session.ToolChoice = update.ToolChoice
+		}
+		if update.Temperature != nil {
+			b.session.Temperature = update.Temperature
+		}
+		if update.MaxResponseOutputTokens != nil {
+			b.session.MaxResponseOutputTokens = update.MaxResponseOutputTokens
+		}
+	}
+
+	// session.updated 在 setupComplete 之后发送
+	return b.ensureSetup(sender)
+}
+
+func (b *geminiRealtimeBridge) createItem(sender *requester.WSSender, event *types.RealtimeClientEvent) error {
+	item := event.Item
+	if item == nil {
+		return sender.SendToUser(types.NewErrorEvent(event.EventId, "invalid_request_error", "invalid_item", "item is required"))
+	}
+
+	if item.ID == "" {
+		item.ID = "item_" + utils.GetRandomString(16)
+	}
+	item.Object = "realtime.item"
+	item.Status = "completed"
+
+	switch item.Type {
+	case "message":
+		content := GeminiChatContent{Role: "user"}
+		if item.Role == "assistant" {
+			content.Role = "model"
+		}
+
+		for _, part := range item.Content {
+			switch part.Type {
+			case "input_text", "text":
+				content.Parts = append(content.Parts, GeminiPart{Text: part.Text})
+			case "input_audio":
+				content.Parts = append(content.Parts, GeminiPart{InlineData: &GeminiInlineData{MimeType: geminiLiveAudioMimeType, Data: part.Audio}})
+			}
+		}
+
+		if len(content.Parts) > 0 {
+			b.pendingTurns = true
+			err := b.sendToGemini(sender, &GeminiLiveClientMessage{
+				ClientContent: &GeminiLiveClientContent{Turns: []GeminiChatContent{content}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case "function_call_output":
+		var output any = map[string]any{"output": item.Output}
+		err := b.sendToGemini(sender, &GeminiLiveClientMessage{
+			ToolResponse: &GeminiLiveToolResponse{
+				FunctionResponses: []GeminiLiveFunctionResponse{{
+					Id:       item.CallID,
+					Name:     b.calls[item.CallID],
+					Response: output,
+				}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return sender.SendToUser(types.NewErrorEvent(event.EventId, "invalid_request_error", "invalid_item", fmt.Sprintf("item type %s is not supported", item.Type)))
+	}
+
+	return sender.SendToUser(b.newEvent(types.EventTypeConversationItemCreated, func(e *types.RealtimeServerEvent) {
+		e.Item = item
+	}))
+}
+
+func (b *geminiRealtimeBridge) ensureSetup(sender *requester.WSSender) error {
+	if b.setupSent {
+		return nil
+	}
+
+	b.setupSent = true
+	return sender.SendToSupplier(&GeminiLiveClientMessage{Setup: b.buildSetup()})
+}
+
+func (b *geminiRealtimeBridge) sendToGemini(sender *requester.WSSender, message *GeminiLiveClientMessage) error {
+	if err := b.ensureSetup(sender); err != nil {
+		return err
+	}
+
+	if !b.setupDone {
+		b.pending = append(b.pending, message)
+		return nil
+	}
+
+	return sender.SendToSupplier(message)
+}
+
+func (b *geminiRealtimeBridge) buildSetup() *GeminiLiveSetup {
+	session := &b.session
+	setup := &GeminiLiveSetup{
+		Model:            "models/" + b.modelName,
+		GenerationConfig: &GeminiLiveGenerationConfig{Temperature: session.Temperature},
+	}
+
+	if maxTokens, ok := session.MaxResponseOutputTokens.(float64); ok {
+		setup.GenerationConfig.MaxOutputTokens = int(maxTokens)
+	}
+
+	// Gemini Live 只能选择一种输出模态，需要音频时通过输出转写提供文字
+	b.audioOutput = utils.Contains("audio", session.Modalities)
+	if b.audioOutput {
+		voice := session.Voice
+		if mapped, ok := geminiLiveVoices[voice]; ok {
+			voice = mapped
+		}
+
+		setup.GenerationConfig.ResponseModalities = []string{"AUDIO"}
+		setup.GenerationConfig.SpeechConfig = &GeminiLiveSpeechConfig{
+			VoiceConfig: GeminiLiveVoiceConfig{PrebuiltVoiceConfig: GeminiLivePrebuiltVoice{VoiceName: voice}},
+		}
+		setup.OutputAudioTranscription = &struct{}{}
+	} else {
+		setup.GenerationConfig.ResponseModalities = []string{"TEXT"}
+	}
+
+	if session.Instructions != "" {
+		setup.SystemInstruction = &GeminiChatContent{Parts: []GeminiPart{{Text: session.Instructions}}}
+	}
+
+	if len(session.InputAudioTranscription) > 0 && string(session.InputAudioTranscription) != "null" {
+		setup.InputAudioTranscription = &struct{}{}
+	}
+
+	if string(session.TurnDetection) == "null" {
+		b.manualTurn = true
+		setup.RealtimeInputConfig = &GeminiLiveRealtimeInputConfig{
+			AutomaticActivityDetection: &GeminiLiveActivityDetection{Disabled: true},
+		}
+	}
+
+	var functions []types.ChatCompletionFunction
+	for _, tool := range session.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		functions = append(functions, types.ChatCompletionFunction{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	if len(functions) > 0 {
+		setup.Tools = []GeminiChatTools{{FunctionDeclarations: functions}}
+	}
+
+	return setup
+}
+
+func (b *geminiRealtimeBridge) handleSupplierMessage(sender *requester.WSSender, message []byte) (*types.UsageEvent, error) {
+	// Gemini Live 的 JSON 消息可能以二进制帧发送
+	var serverMessage GeminiLiveServerMessage
+	if err := json.Unmarshal(message, &serverMessage); err != nil {
+		return nil, types.NewErrorEvent("", "json_unmarshal_failed", "invalid_event", err.Error())
+	}
+
+	if serverMessage.Error != nil {
+		logger.SysError(fmt.Sprintf("[Gemini] realtime error: %s", serverMessage.Error.Message))
+		return nil, types.NewErrorEvent("", "server_error", serverMessage.Error.Status, serverMessage.Error.Message)
+	}
+
+	if serverMessage.SetupComplete != nil {
+		if err := b.completeSetup(sender); err != nil {
+			return nil, err
+		}
+	}
+
+	var usage *types.UsageEvent
+	if serverMessage.UsageMetadata != nil {
+		usage = serverMessage.UsageMetadata.ToUsageEvent()
+		b.lastUsage = usage
+	}
+
+	if serverMessage.ToolCall != nil {
+		if err := b.handleToolCall(sender, serverMessage.ToolCall); err != nil {
+			return nil, err
+		}
+	}
+
+	if serverMessage.ServerContent != nil {
+		if err := b.handleServerContent(sender, serverMessage.ServerContent); err != nil {
+			return nil, err
+		}
+	}
+
+	if serverMessage.GoAway != nil {
+		logger.SysLog(fmt.Sprintf("[Gemini] realtime session %s will be closed in %s", b.session.ID, serverMessage.GoAway.TimeLeft))
+	}
+
+	return usage, nil
+}
+
+func (b *geminiRealtimeBridge) completeSetup(sender *requester.WSSender) error {
+	b.setupDone = true
+
+	err := sender.SendToUser(b.newEvent(types.EventTypeSessionUpdated, func(e *types.RealtimeServerEvent) {
+		e.Session = &b.session
+	}))
+	if err != nil {
+		return err
+	}
+
+	for _, message := range b.pending {
+		if err := sender.SendToSupplier(message); err != nil {
+			return err
+		}
+	}
+	b.pending = nil
+
+	return nil
+}
+
+func (b *geminiRealtimeBridge) handleServerContent(sender *requester.WSSender, content *GeminiLiveServerContent) error {
+	if content.InputTranscription != nil && content.InputTranscription.Text != "" {
+		if b.inputItemId == "" {
+			b.inputItemId = "item_" + utils.GetRandomString(16)
+		}
+		b.inputTranscript.WriteString(content.InputTranscription.Text)
+
+		err := sender.SendToUser(b.newEvent(types.EventTypeInputAudioTranscriptionDelta, func(e *types.RealtimeServerEvent) {
+			e.ItemId = b.inputItemId
+			e.ContentIndex = utils.GetPointer(0)
+			e.Delta = content.InputTranscription.Text
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if content.Interrupted {
+		// 用户打断时 Gemini 会丢弃未发送的输出
+		if err := sender.SendToUser(b.newEvent(types.EventTypeInputAudioBufferSpeechStarted, nil)); err != nil {
+			return err
+		}
+		if err := b.finishResponse(sender, "cancelled"); err != nil {
+			return err
+		}
+	}
+
+	if !b.dropTurn {
+		if content.ModelTurn != nil {
+			for _, part := range content.ModelTurn.Parts {
+				if err := b.handleModelPart(sender, &part); err != nil {
+					return err
+				}
+			}
+		}
+
+		if content.OutputTranscription != nil && content.OutputTranscription.Text != "" {
+			if err := b.startMessage(sender); err != nil {
+				return err
+			}
+			b.response.transcript.WriteString(content.OutputTranscription.Text)
+			if err := sender.SendToUser(b.newContentEvent(types.EventTypeResponseAudioTranscriptDelta, content.OutputTranscription.Text)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if content.TurnComplete {
+		b.dropTurn = false
+		if err := b.finishInputTranscription(sender); err != nil {
+			return err
+		}
+		return b.finishResponse(sender, "completed")
+	}
+
+	return nil
+}
+
+func (b *geminiRealtimeBridge) handleModelPart(sender *requester.WSSender, part *GeminiPart) error {
+	if part.Thought {
+		return nil
+	}
+
+	if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+		if err := b.startMessage(sender); err != nil {
+			return err
+		}
+		b.response.hasAudio = true
+		return sender.SendToUser(b.newContentEvent(types.EventTypeResponseAudioDelta, part.InlineData.Data))
+	}
+
+	if part.Text != "" {
+		if err := b.startMessage(sender); err != nil {
+			return err
+		}
+		b.response.text.WriteString(part.Text)
+		return sender.SendToUser(b.newContentEvent(types.EventTypeResponseTextDelta, part.Text))
+	}
+
+	return nil
+}
+
+func (b *geminiRealtimeBridge) handleToolCall(sender *requester.WSSender, toolCall *GeminiLiveToolCall) error {
+	if err := b.startResponse(sender); err != nil {
+		return err
+	}
+
+	for _, call := range toolCall.FunctionCalls {
+		if call.Id == "" {
+			call.Id = "call_" + utils.GetRandomString(16)
+		}
+		b.calls[call.Id] = call.Name
+
+		arguments := "{}"
+		if call.Args != nil {
+			if args, err := json.Marshal(call.Args); err == nil {
+				arguments = string(args)
+			}
+		}
+
+		item := types.RealtimeItem{
+			ID:     "item_" + utils.GetRandomString(16),
+			Object: "realtime.item",
+			Type:   "function_call",
+			Status: "in_progress",
+			CallID: call.Id,
+			Name:   call.Name,
+		}
+		outputIndex := len(b.response.output)
+
+		err := sender.SendToUser(b.newEvent(types.EventTypeResponseOutputItemAdded, func(e *types.RealtimeServerEvent) {
+			e.ResponseId = b.response.id
+			e.OutputIndex = utils.GetPointer(outputIndex)
+			e.Item = utils.GetPointer(item)
+		}))
+		if err != nil {
+			return err
+		}
+
+		err = sender.SendToUser(b.newEvent(types.EventTypeResponseFunctionCallArgsDone, func(e *types.RealtimeServerEvent) {
+			e.ResponseId = b.response.id
+			e.ItemId = item.ID
+			e.OutputIndex = utils.GetPointer(outputIndex)
+			e.CallId = call.Id
+			e.Name = call.Name
+			e.Arguments = arguments
+		}))
+		if err != nil {
+			return err
+		}
+
+		item.Status = "completed"
+		item.Arguments = arguments
+		b.response.output = append(b.response.output, item)
+
+		err = sender.SendToUser(b.newEvent(types.EventTypeResponseOutputItemDone, func(e *types.RealtimeServerEvent) {
+			e.ResponseId = b.response.id
+			e.OutputIndex = utils.GetPointer(outputIndex)
+			e.Item = utils.GetPointer(item)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Gemini 在收到工具结果前不会继续输出，本次回复到此结束
+	return b.finishResponse(sender, "completed")
+}
+
+func (b *geminiRealtimeBridge) startResponse(sender *requester.WSSender) error {
+	if b.response != nil {
+		return nil
+	}
+
+	b.response = &geminiRealtimeResponse{id: "resp_" + utils.GetRandomString(16)}
+	return sender.SendToUser(b.newEvent(types.EventTypeResponseCreated, func(e *types.RealtimeServerEvent) {
+		e.Response = &types.ResponseEvent{ID: b.response.id, Object: "realtime.response", Status: "in_progress"}
+	}))
+}
+
+// startMessage 在第一段模型输出前发送 output_item.added 和 content_part.added
+func (b *geminiRealtimeBridge) startMessage(sender *requester.WSSender) error {
+	if err := b.startResponse(sender); err != nil {
+		return err
+	}
+
+	if b.response.started {
+		return nil
+	}
+	b.response.started = true
+	b.response.itemId = "item_" + utils.GetRandomString(16)
+
+	err := sender.SendToUser(b.newEvent(types.EventTypeResponseOutputItemAdded, func(e *types.RealtimeServerEvent) {
+		e.ResponseId = b.response.id
+		e.OutputIndex = utils.GetPointer(len(b.response.output))
+		e.Item = &types.RealtimeItem{ID: b.response.itemId, Object: "realtime.item", Type: "message", Status: "in_progress", Role: "assistant"}
+	}))
+	if err != nil {
+		return err
+	}
+
+	return sender.SendToUser(b.newContentEvent(types.EventTypeResponseContentPartAdded, ""))
+}
+
+func (b *geminiRealtimeBridge) newContentEvent(eventType, delta string) *types.RealtimeServerEvent {
+	return b.newEvent(eventType, func(e *types.RealtimeServerEvent) {
+		e.ResponseId = b.response.id
+		e.ItemId = b.response.itemId
+		e.OutputIndex = utils.GetPointer(len(b.response.output))
+		e.ContentIndex = utils.GetPointer(0)
+		e.Delta = delta
+		if eventType == types.EventTypeResponseContentPartAdded {
+			e.Part = b.contentPart()
+		}
+	})
+}
+
+func (b *geminiRealtimeBridge) contentPart() *types.RealtimeContent {
+	if b.audioOutput {
+		return &types.RealtimeContent{Type: "audio", Transcript: b.response.transcript.String()}
+	}
+
+	return &types.RealtimeContent{Type: "text", Text: b.response.text.String()}
+}
+
+func (b *geminiRealtimeBridge) finishResponse(sender *requester.WSSender, status string) error {
+	if b.response == nil {
+		return nil
+	}
+
+	if b.response.started {
+		part := b.contentPart()
+		var doneEvents []*types.RealtimeServerEvent
+
+		if b.response.hasAudio {
+			doneEvents = append(doneEvents, b.newContentEvent(types.EventTypeResponseAudioDone, ""))
+		}
+		if b.audioOutput {
+			event := b.newContentEvent(types.EventTypeResponseAudioTranscriptDone, "")
+			event.Transcript = utils.GetPointer(part.Transcript)
+			doneEvents = append(doneEvents, event)
+		} else {
+			event := b.newContentEvent(types.EventTypeResponseTextDone, "")
+			event.Text = utils.GetPointer(part.Text)
+			doneEvents = append(doneEvents, event)
+		}
+
+		partDone := b.newContentEvent(types.EventTypeResponseContentPartDone, "")
+		partDone.Part = part
+		doneEvents = append(doneEvents, partDone)
+
+		item := types.RealtimeItem{
+			ID:      b.response.itemId,
+			Object:  "realtime.item",
+			Type:    "message",
+			Status:  "completed",
+			Role:    "assistant",
+			Content: []types.RealtimeContent{*part},
+		}
+		if status != "completed" {
+			item.Status = "incomplete"
+		}
+		outputIndex := len(b.response.output)
+		doneEvents = append(doneEvents, b.newEvent(types.EventTypeResponseOutputItemDone, func(e *types.RealtimeServerEvent) {
+			e.ResponseId = b.response.id
+			e.OutputIndex = utils.GetPointer(outputIndex)
+			e.Item = utils.GetPointer(item)
+		}))
+		b.response.output = append(b.response.output, item)
+
+		for _, event := range doneEvents {
+			if err := sender.SendToUser(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	response := &types.ResponseEvent{
+		ID:     b.response.id,
+		Object: "realtime.response",
+		Status: status,
+		Output: b.response.output,
+		Usage:  b.lastUsage,
+	}
+	b.response = nil
+	b.lastUsage = nil
+
+	return sender.SendToUser(b.newEvent(types.EventTypeResponseDone, func(e *types.RealtimeServerEvent) {
+		e.Response = response
+	}))
+}
+
+func (b *geminiRealtimeBridge) finishInputTranscription(sender *requester.WSSender) error {
+	if b.inputTranscript.Len() == 0 {
+		return nil
+	}
+
+	transcript := b.inputTranscript.String()
+	itemId := b.inputItemId
+	b.inputTranscript.Reset()
+	b.inputItemId = ""
+
+	return sender.SendToUser(b.newEvent(types.EventTypeInputAudioTranscriptionCompleted, func(e *types.RealtimeServerEvent) {
+		e.ItemId = itemId
+		e.ContentIndex = utils.GetPointer(0)
+		e.Transcript = utils.GetPointer(transcript)
+	}))
+}
+
+// ToUsageEvent Gemini Live 每轮回复结束时返回本轮的用量
+func (u *GeminiLiveUsageMetadata) ToUsageEvent() *types.UsageEvent {
+	usage := &types.UsageEvent{
+		InputTokens:  u.PromptTokenCount,
+		OutputTokens: u.ResponseTokenCount + u.ThoughtsTokenCount,
+		TotalTokens:  u.TotalTokenCount,
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+
+	usage.InputTokenDetails.CachedTokens = u.CachedContentTokenCount
+	usage.OutputTokenDetails.ReasoningTokens = u.ThoughtsTokenCount
+
+	for _, detail := range u.PromptTokensDetails {
+		switch detail.Modality {
+		case "AUDIO":
+			usage.InputTokenDetails.AudioTokens += detail.TokenCount
+		case "TEXT":
+			usage.InputTokenDetails.TextTokens += detail.TokenCount
+		case "IMAGE", "VIDEO":
+			usage.InputTokenDetails.ImageTokens += detail.TokenCount
+		}
+	}
+
+	for _, detail := range u.ResponseTokensDetails {
+		switch detail.Modality {
+		case "AUDIO":
+			usage.OutputTokenDetails.AudioTokens += detail.TokenCount
+		case "TEXT":
+			usage.OutputTokenDetails.TextTokens += detail.TokenCount
+		}
+	}
+
+	return usage
+}
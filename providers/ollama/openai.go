@@ -0,0 +1,398 @@
+package ollama
+
+import (
+	"done-hub/common/utils"
+	"done-hub/types"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IsStream Ollama 的 stream 缺省为 true
+func (r *ApiChatRequest) IsStream() bool {
+	return r.Stream == nil || *r.Stream
+}
+
+func (r *ApiGenerateRequest) IsStream() bool {
+	return r.Stream == nil || *r.Stream
+}
+
+// ToChatCompletionRequest 将 Ollama /api/chat 请求转换为 OpenAI 聊天请求
+func (r *ApiChatRequest) ToChatCompletionRequest() *types.ChatCompletionRequest {
+	request := &types.ChatCompletionRequest{
+		Model:    r.Model,
+		Stream:   r.IsStream(),
+		Messages: make([]types.ChatCompletionMessage, 0, len(r.Messages)),
+		Tools:    r.Tools,
+	}
+
+	// Ollama 的工具调用没有 id，按函数名排队生成 id，供后续的 tool 消息匹配
+	pendingCallIds := make(map[string][]string)
+	for _, message := range r.Messages {
+		request.Messages = append(request.Messages, convertOllamaMessage(message, pendingCallIds))
+	}
+
+	r.Options.applyTo(request)
+	request.ResponseFormat = convertOllamaFormat(r.Format)
+	applyOllamaThink(request, r.Think)
+
+	return request
+}
+
+// ToChatCompletionRequest 将 Ollama /api/generate 请求转换为单轮 OpenAI 聊天请求
+func (r *ApiGenerateRequest) ToChatCompletionRequest() *types.ChatCompletionRequest {
+	request := &types.ChatCompletionRequest{
+		Model:    r.Model,
+		Stream:   r.IsStream(),
+		Messages: make([]types.ChatCompletionMessage, 0, 2),
+	}
+
+	if r.System != "" {
+		request.Messages = append(request.Messages, types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleSystem,
+			Content: r.System,
+		})
+	}
+
+	request.Messages = append(request.Messages, convertOllamaMessage(Message{
+		Role:    types.ChatMessageRoleUser,
+		Content: r.Prompt,
+		Images:  r.Images,
+	}, nil))
+
+	r.Options.applyTo(request)
+	request.ResponseFormat = convertOllamaFormat(r.Format)
+	applyOllamaThink(request, r.Think)
+
+	return request
+}
+
+func (o *Option) applyTo(request *types.ChatCompletionRequest) {
+	request.Temperature = o.Temperature
+	request.TopP = o.TopP
+	request.Seed = o.Seed
+	request.PresencePenalty = o.PresencePenalty
+	request.FrequencyPenalty = o.FrequencyPenalty
+
+	if o.TopK != nil {
+		topK := float64(*o.TopK)
+		request.TopK = &topK
+	}
+
+	// num_predict 为 -1 表示不限制
+	if o.NumPredict != nil && *o.NumPredict > 0 {
+		request.MaxTokens = *o.NumPredict
+	}
+
+	if len(o.Stop) > 0 {
+		request.Stop = o.Stop
+	}
+}
+
+// convertOllamaFormat format 可以是 "json" 或 JSON Schema
+func convertOllamaFormat(format json.RawMessage) *types.ChatCompletionResponseFormat {
+	if len(format) == 0 || string(format) == "null" || string(format) == `""` {
+		return nil
+	}
+
+	var formatType string
+	if err := json.Unmarshal(format, &formatType); err == nil {
+		if formatType != "json" {
+			return nil
+		}
+		return &types.ChatCompletionResponseFormat{Type: "json_object"}
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(format, &schema); err != nil {
+		return nil
+	}
+
+	return &types.ChatCompletionResponseFormat{
+		Type: "json_schema",
+		JsonSchema: &types.FormatJsonSchema{
+			Name:   "response",
+			Schema: schema,
+		},
+	}
+}
+
+// applyOllamaThink think 可以是布尔值，也可以是 "low"、"medium"、"high"
+func applyOllamaThink(request *types.ChatCompletionRequest, think any) {
+	switch value := think.(type) {
+	case bool:
+		request.EnableThinking = &value
+	case string:
+		if value != "" {
+			effort := strings.ToLower(value)
+			request.ReasoningEffort = &effort
+		}
+	}
+}
+
+func convertOllamaMessage(message Message, pendingCallIds map[string][]string) types.ChatCompletionMessage {
+	chatMessage := types.ChatCompletionMessage{
+		Role:             message.Role,
+		ReasoningContent: message.Thinking,
+	}
+
+	switch message.Role {
+	case types.ChatMessageRoleAssistant:
+		for _, toolCall := range message.ToolCalls {
+			callId := "call_" + utils.GetRandomString(24)
+			pendingCallIds[toolCall.Function.Name] = append(pendingCallIds[toolCall.Function.Name], callId)
+
+			args, _ := json.Marshal(toolCall.Function.Arguments)
+			if toolCall.Function.Arguments == nil {
+				args = []byte("{}")
+			}
+			chatMessage.ToolCalls = append(chatMessage.ToolCalls, &types.ChatCompletionToolCalls{
+				Id:    callId,
+				Type:  types.ChatMessageRoleFunction,
+				Index: len(chatMessage.ToolCalls),
+				Function: &types.ChatCompletionToolCallsFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: string(args),
+				},
+			})
+		}
+	case types.ChatMessageRoleTool:
+		if ids := pendingCallIds[message.ToolName]; len(ids) > 0 {
+			chatMessage.ToolCallID = ids[0]
+			pendingCallIds[message.ToolName] = ids[1:]
+		}
+		if message.ToolName != "" {
+			chatMessage.Name = utils.GetPointer(message.ToolName)
+		}
+	}
+
+	if len(message.Images) == 0 {
+		if message.Content != "" || len(chatMessage.ToolCalls) == 0 {
+			chatMessage.Content = message.Content
+		}
+		return chatMessage
+	}
+
+	parts := make([]types.ChatMessagePart, 0, len(message.Images)+1)
+	if message.Content != "" {
+		parts = append(parts, types.ChatMessagePart{
+			Type: types.ContentTypeText,
+			Text: message.Content,
+		})
+	}
+	for _, image := range message.Images {
+		parts = append(parts, types.ChatMessagePart{
+			Type: types.ContentTypeImageURL,
+			ImageURL: &types.ChatMessageImageURL{
+				URL: fmt.Sprintf("data:%s;base64,%s", ollamaImageMimeType(image), image),
+			},
+		})
+	}
+	chatMessage.Content = parts
+
+	return chatMessage
+}
+
+// ollamaImageMimeType Ollama 的图片是不带前缀的 base64，根据内容判断类型
+func ollamaImageMimeType(image string) string {
+	header := image
+	if len(header) > 64 {
+		header = header[:64]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(header[:len(header)/4*4])
+	if err != nil {
+		return "image/png"
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "image/png"
+	}
+
+	return mimeType
+}
+
+// ConvertOpenAIFinishReason 将 OpenAI 的结束原因转换为 Ollama 的 done_reason
+func ConvertOpenAIFinishReason(reason string) string {
+	if reason == types.FinishReasonLength {
+		return "length"
+	}
+
+	return "stop"
+}
+
+// ConvertChatOpenaiToOllama 将 OpenAI 聊天响应转换为 Ollama /api/chat 响应
+func ConvertChatOpenaiToOllama(response *types.ChatCompletionResponse, model string) *ChatResponse {
+	ollamaResponse := &ChatResponse{
+		Model:     model,
+		CreatedAt: time.Now().UTC(),
+		Message:   Message{Role: types.ChatMessageRoleAssistant},
+		Done:      true,
+	}
+
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		ollamaResponse.Message.Content = choice.Message.StringContent()
+		ollamaResponse.Message.Thinking = choice.Message.ReasoningContent
+		if ollamaResponse.Message.Thinking == "" {
+			ollamaResponse.Message.Thinking = choice.Message.Reasoning
+		}
+		ollamaResponse.Message.ToolCalls = toolCallsToOllama(choice.Message.ToolCalls)
+
+		ollamaResponse.DoneReason = ConvertOpenAIFinishReason(choice.FinishReason)
+	}
+
+	if response.Usage != nil {
+		ollamaResponse.PromptEvalCount = response.Usage.PromptTokens
+		ollamaResponse.EvalCount = response.Usage.CompletionTokens
+	}
+
+	return ollamaResponse
+}
+
+// ToGenerateResponse 将 /api/chat 的响应转换为 /api/generate 的响应
+func (r *ChatResponse) ToGenerateResponse() *GenerateResponse {
+	return &GenerateResponse{
+		Model:           r.Model,
+		CreatedAt:       r.CreatedAt,
+		Response:        r.Message.Content,
+		Thinking:        r.Message.Thinking,
+		Done:            r.Done,
+		DoneReason:      r.DoneReason,
+		TotalDuration:   r.TotalDuration,
+		EvalCount:       r.EvalCount,
+		PromptEvalCount: r.PromptEvalCount,
+	}
+}
+
+func toolCallsToOllama(toolCalls []*types.ChatCompletionToolCalls) []ToolCall {
+	ollamaToolCalls := make([]ToolCall, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		if toolCall == nil || toolCall.Function == nil {
+			continue
+		}
+
+		args := make(map[string]any)
+		if toolCall.Function.Arguments != "" {
+			json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
+		}
+
+		ollamaToolCalls = append(ollamaToolCalls, ToolCall{
+			Function: ToolCallFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: args,
+			},
+		})
+	}
+
+	return ollamaToolCalls
+}
+
+// OpenAIStreamToOllama 将 OpenAI 流式块逐个转换为 Ollama 的 NDJSON 响应
+// 工具调用的参数在 OpenAI 中是分片下发的，需要累积后在结束时一次性输出
+type OpenAIStreamToOllama struct {
+	Model string
+
+	toolCalls    map[int]*types.ChatCompletionToolCalls
+	finishReason string
+}
+
+func NewOpenAIStreamToOllama(model string) *OpenAIStreamToOllama {
+	return &OpenAIStreamToOllama{
+		Model:     model,
+		toolCalls: make(map[int]*types.ChatCompletionToolCalls),
+	}
+}
+
+// Convert 转换单个 OpenAI 流式块，没有可输出内容时返回 nil
+func (s *OpenAIStreamToOllama) Convert(chunk *types.ChatCompletionStreamResponse) *ChatResponse {
+	var content, thinking strings.Builder
+	for _, choice := range chunk.Choices {
+		reasoning := choice.Delta.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Delta.Reasoning
+		}
+		thinking.WriteString(reasoning)
+		content.WriteString(choice.Delta.Content)
+
+		for _, toolCall := range choice.Delta.ToolCalls {
+			s.appendToolCall(toolCall)
+		}
+
+		if reason, ok := choice.FinishReason.(string); ok && reason != "" && reason != types.FinishReasonNull {
+			s.finishReason = reason
+		}
+	}
+
+	if content.Len() == 0 && thinking.Len() == 0 {
+		return nil
+	}
+
+	response := s.newResponse(false)
+	response.Message.Content = content.String()
+	response.Message.Thinking = thinking.String()
+
+	return response
+}
+
+// Finish 生成最后一个响应块，包含累积的工具调用、结束原因和用量
+func (s *OpenAIStreamToOllama) Finish(usage *types.Usage) *ChatResponse {
+	indexes := make([]int, 0, len(s.toolCalls))
+	for index := range s.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	toolCalls := make([]*types.ChatCompletionToolCalls, 0, len(indexes))
+	for _, index := range indexes {
+		toolCalls = append(toolCalls, s.toolCalls[index])
+	}
+
+	response := s.newResponse(true)
+	response.Message.ToolCalls = toolCallsToOllama(toolCalls)
+	response.DoneReason = ConvertOpenAIFinishReason(s.finishReason)
+	if usage != nil {
+		response.PromptEvalCount = usage.PromptTokens
+		response.EvalCount = usage.CompletionTokens
+	}
+
+	return response
+}
+
+func (s *OpenAIStreamToOllama) appendToolCall(toolCall *types.ChatCompletionToolCalls) {
+	if toolCall == nil || toolCall.Function == nil {
+		return
+	}
+
+	existing, ok := s.toolCalls[toolCall.Index]
+	if !ok {
+		s.toolCalls[toolCall.Index] = &types.ChatCompletionToolCalls{
+			Id:    toolCall.Id,
+			Index: toolCall.Index,
+			Function: &types.ChatCompletionToolCallsFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			},
+		}
+		return
+	}
+
+	if toolCall.Function.Name != "" {
+		existing.Function.Name = toolCall.Function.Name
+	}
+	existing.Function.Arguments += toolCall.Function.Arguments
+}
+
+func (s *OpenAIStreamToOllama) newResponse(done bool) *ChatResponse {
+	return &ChatResponse{
+		Model:     s.Model,
+		CreatedAt: time.Now().UTC(),
+		Message:   Message{Role: types.ChatMessageRoleAssistant},
+		Done:      done,
+	}
+}
@@ -1,6 +1,10 @@
 package ollama
 
-import "time"
+import (
+	"done-hub/types"
+	"encoding/json"
+	"time"
+)
 
 type OllamaError struct {
 	Error string `json:"error,omitempty"`
@@ -15,10 +19,14 @@ type ChatRequest struct {
 }
 
 type Option struct {
-	Temperature *float64 `json:"temperature,omitempty"`
-	Seed        *int     `json:"seed,omitempty"`
-	TopP        *float64 `json:"top_p,omitempty"`
-	TopK        *int     `json:"top_k,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
 }
 
 type ChatResponse struct {
@@ -27,19 +35,34 @@ type ChatResponse struct {
 	CreatedAt       time.Time `json:"created_at"`
 	Message         Message   `json:"message,omitempty"`
 	Done            bool      `json:"done"`
+	DoneReason      string    `json:"done_reason,omitempty"`
+	TotalDuration   int64     `json:"total_duration,omitempty"`
 	EvalCount       int       `json:"eval_count,omitempty"`
 	PromptEvalCount int       `json:"prompt_eval_count,omitempty"`
 }
 
 type Message struct {
-	Role    string   `json:"role,omitempty"`
-	Content string   `json:"content,omitempty"`
-	Images  []string `json:"images,omitempty"`
+	Role      string     `json:"role,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
 }
 
 type EmbeddingRequest struct {
-	Model string `json:"model"`
-	Input any    `json:"input"`
+	Model  string `json:"model"`
+	Input  any    `json:"input"`
+	Prompt string `json:"prompt,omitempty"` // 旧版 /api/embeddings 接口使用
 }
 
 type EmbeddingResponse struct {
@@ -71,3 +94,45 @@ type ModelDetails struct {
 	ParameterSize     string    `json:"parameter_size"`
 	QuantizationLevel string    `json:"quantization_level"`
 }
+
+// ApiChatRequest 入站 /api/chat 请求，stream 缺省时为 true
+type ApiChatRequest struct {
+	Model    string                      `json:"model"`
+	Messages []Message                   `json:"messages"`
+	Tools    []*types.ChatCompletionTool `json:"tools,omitempty"`
+	Format   json.RawMessage             `json:"format,omitempty"`
+	Options  Option                      `json:"options,omitempty"`
+	Stream   *bool                       `json:"stream,omitempty"`
+	Think    any                         `json:"think,omitempty"`
+}
+
+// ApiGenerateRequest 入站 /api/generate 请求
+type ApiGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	Suffix  string          `json:"suffix,omitempty"`
+	System  string          `json:"system,omitempty"`
+	Images  []string        `json:"images,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options Option          `json:"options,omitempty"`
+	Stream  *bool           `json:"stream,omitempty"`
+	Raw     bool            `json:"raw,omitempty"`
+	Think   any             `json:"think,omitempty"`
+}
+
+type GenerateResponse struct {
+	OllamaError
+	Model           string    `json:"model"`
+	CreatedAt       time.Time `json:"created_at"`
+	Response        string    `json:"response"`
+	Thinking        string    `json:"thinking,omitempty"`
+	Done            bool      `json:"done"`
+	DoneReason      string    `json:"done_reason,omitempty"`
+	TotalDuration   int64     `json:"total_duration,omitempty"`
+	EvalCount       int       `json:"eval_count,omitempty"`
+	PromptEvalCount int       `json:"prompt_eval_count,omitempty"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
@@ -108,6 +108,18 @@ func init() {
 
 // 获取供应商
 func GetProvider(channel *model.Channel, c *gin.Context) base.ProviderInterface {
+	// 多密钥渠道每次创建供应商时选择一个密钥，上下文中记录密钥的序号
+	// 预选渠道只读取渠道配置，不会发出请求，不选择密钥
+	if channel.MultiKey && (c == nil || !c.GetBool("skip_key_selection")) {
+		keyIndex, keyed := channel.SelectKey()
+		channel = keyed
+		if c != nil {
+			c.Set("channel_key_index", keyIndex)
+		}
+	} else if c != nil {
+		c.Set("channel_key_index", nil)
+	}
+
 	factory, ok := providerFactories[channel.Type]
 	var provider base.ProviderInterface
 	if !ok {
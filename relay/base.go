@@ -95,6 +95,10 @@ func (r *relayBase) getModelName() string {
 	billingOriginalModel := r.c.GetBool("billing_original_model")
 
 	if billingOriginalModel {
+		// 虚拟模型按实际使用的真实模型计费
+		if getVirtualModelRoute(r.c) != nil {
+			return r.c.GetString("original_model")
+		}
 		return r.originalModel
 	}
 	return r.modelName
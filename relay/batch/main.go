@@ -0,0 +1,437 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/storage"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/relay/files"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	batchCompletionWindow = "24h"
+	batchMaxLineSize      = 10 * 1024 * 1024
+	batchOutputPageSize   = 500
+)
+
+// 支持批量执行的接口，每一行都会交给对应的 Relay 处理
+var allowedBatchEndpoints = []string{
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/embeddings",
+	"/v1/responses",
+	"/v1/moderations",
+}
+
+// CreateBatch 创建批量任务
+// 与 OpenAI 一致通过 JSON 传入 input_file_id，也可以直接以 multipart 表单的 file 字段上传输入文件
+func CreateBatch(c *gin.Context) {
+	var request types.BatchRequest
+	var input []byte
+
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		request.Endpoint = c.PostForm("endpoint")
+		request.CompletionWindow = c.PostForm("completion_window")
+		if metadataStr := c.PostForm("metadata"); metadataStr != "" {
+			if err := json.Unmarshal([]byte(metadataStr), &request.Metadata); err != nil {
+				common.AbortWithMessage(c, http.StatusBadRequest, "metadata must be a JSON object with string values")
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&request); err != nil {
+		common.AbortWithMessage(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if !utils.Contains(request.Endpoint, allowedBatchEndpoints) {
+		common.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("endpoint must be one of %s", strings.Join(allowedBatchEndpoints, ", ")))
+		return
+	}
+
+	if request.CompletionWindow == "" {
+		request.CompletionWindow = batchCompletionWindow
+	}
+	if request.CompletionWindow != batchCompletionWindow {
+		common.AbortWithMessage(c, http.StatusBadRequest, "completion_window must be 24h")
+		return
+	}
+
+	userId := c.GetInt("id")
+	if request.InputFileID != "" {
+		file, data, err := files.ReadFileContent(userId, request.InputFileID)
+		if err != nil {
+			common.AbortWithMessage(c, http.StatusInternalServerError, "failed to read input file: "+err.Error())
+			return
+		}
+		if file == nil {
+			common.AbortWithMessage(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", request.InputFileID))
+			return
+		}
+		if file.Purpose != files.PurposeBatch {
+			common.AbortWithMessage(c, http.StatusBadRequest, "input file must be uploaded with purpose 'batch'")
+			return
+		}
+		input = data
+	} else {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			common.AbortWithMessage(c, http.StatusBadRequest, "input_file_id is required, or upload the JSONL input file as multipart field `file`")
+			return
+		}
+
+		reader, err := fileHeader.Open()
+		if err != nil {
+			common.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer reader.Close()
+
+		if input, err = io.ReadAll(reader); err != nil {
+			common.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// 配置了文件存储时保存输入文件，使其可以通过 Files API 获取
+		if file, err := files.SaveFile(userId, fileHeader.Filename, files.PurposeBatch, input); err == nil {
+			request.InputFileID = file.FileId
+		} else if !errors.Is(err, storage.ErrNoFileDrive) {
+			logger.LogError(c.Request.Context(), "batch_input_save_failed error=\""+err.Error()+"\"")
+		}
+	}
+
+	items, err := parseBatchInput(bytes.NewReader(input), request.Endpoint)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := utils.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          "batch_" + utils.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		CompletionWindow: request.CompletionWindow,
+		InputFileId:      request.InputFileID,
+		Status:           model.BatchStatusValidating,
+		Total:            len(items),
+		CreatedAt:        now,
+		ExpiresAt:        now + int64((24 * time.Hour).Seconds()),
+	}
+
+	if request.Metadata != nil {
+		batch.Metadata, _ = json.Marshal(request.Metadata)
+	}
+
+	if err := model.CreateBatchWithItems(batch, items); err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, "failed to create batch: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	limit := utils.String2Int(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	// 多取一条用于判断是否还有下一页
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := &types.BatchList{
+		Object: "list",
+		Data:   make([]*types.Batch, 0, len(batches)),
+	}
+
+	if len(batches) > limit {
+		response.HasMore = true
+		batches = batches[:limit]
+	}
+
+	for _, batch := range batches {
+		response.Data = append(response.Data, toOpenAIBatch(batch))
+	}
+
+	if len(response.Data) > 0 {
+		response.FirstID = &response.Data[0].ID
+		response.LastID = &response.Data[len(response.Data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func CancelBatch(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+
+	if err := batch.Cancel(); err != nil {
+		common.AbortWithMessage(c, http.StatusConflict, fmt.Sprintf("batch %s with status %s cannot be cancelled", batch.BatchId, batch.Status))
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// GetBatchOutput 下载执行成功的结果(JSONL)
+func GetBatchOutput(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+
+	downloadBatchItems(c, batch, model.BatchItemStatusCompleted)
+}
+
+// GetBatchErrors 下载执行失败的结果(JSONL)
+func GetBatchErrors(c *gin.Context) {
+	batch := getUserBatch(c)
+	if batch == nil {
+		return
+	}
+
+	downloadBatchItems(c, batch, model.BatchItemStatusFailed)
+}
+
+func getUserBatch(c *gin.Context) *model.Batch {
+	batchId := c.Param("id")
+	batch, err := model.GetBatchByBatchId(c.GetInt("id"), batchId)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	if batch == nil {
+		common.AbortWithMessage(c, http.StatusNotFound, fmt.Sprintf("No batch found with id '%s'", batchId))
+		return nil
+	}
+
+	return batch
+}
+
+func downloadBatchItems(c *gin.Context, batch *model.Batch, status string) {
+	c.Header("Content-Type", "application/jsonl")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s.jsonl", batch.BatchId, status))
+	c.Status(http.StatusOK)
+
+	writeBatchItems(c.Writer, batch, status)
+}
+
+// writeBatchItems 按行号顺序写出指定状态的结果行(JSONL)，返回写出的行数
+func writeBatchItems(w io.Writer, batch *model.Batch, status string) (int, error) {
+	count := 0
+	afterLine := 0
+	for {
+		items, err := model.GetBatchItemsByStatus(batch.Id, status, afterLine, batchOutputPageSize)
+		if err != nil {
+			return count, err
+		}
+
+		for _, item := range items {
+			data, err := json.Marshal(toOutputLine(item))
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return count, err
+			}
+			afterLine = item.Line
+			count++
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		if len(items) < batchOutputPageSize {
+			return count, nil
+		}
+	}
+}
+
+// parseBatchInput 校验输入文件并拆分为请求行
+func parseBatchInput(reader io.Reader, endpoint string) ([]*model.BatchItem, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), batchMaxLineSize)
+
+	items := make([]*model.BatchItem, 0)
+	customIds := make(map[string]bool)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+
+		var line types.BatchInputLine
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON", lineNum)
+		}
+
+		if line.CustomID == "" {
+			return nil, fmt.Errorf("line %d: custom_id is required", lineNum)
+		}
+		if customIds[line.CustomID] {
+			return nil, fmt.Errorf("line %d: duplicate custom_id '%s'", lineNum, line.CustomID)
+		}
+		customIds[line.CustomID] = true
+
+		if line.Method != http.MethodPost {
+			return nil, fmt.Errorf("line %d: method must be POST", lineNum)
+		}
+		if line.URL != endpoint {
+			return nil, fmt.Errorf("line %d: url '%s' does not match the batch endpoint '%s'", lineNum, line.URL, endpoint)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(line.Body, &body); err != nil || body == nil {
+			return nil, fmt.Errorf("line %d: body must be a JSON object", lineNum)
+		}
+		if modelName, _ := body["model"].(string); modelName == "" {
+			return nil, fmt.Errorf("line %d: body.model is required", lineNum)
+		}
+		if stream, _ := body["stream"].(bool); stream {
+			return nil, fmt.Errorf("line %d: stream is not supported in batch requests", lineNum)
+		}
+
+		items = append(items, &model.BatchItem{
+			Line:     lineNum,
+			CustomId: line.CustomID,
+			Method:   line.Method,
+			Url:      line.URL,
+			Body:     string(line.Body),
+		})
+
+		if len(items) > config.BatchMaxRequests {
+			return nil, fmt.Errorf("a batch can contain at most %d requests", config.BatchMaxRequests)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d: exceeds the maximum line size", lineNum+1)
+		}
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("input file contains no requests")
+	}
+
+	return items, nil
+}
+
+func toOpenAIBatch(batch *model.Batch) *types.Batch {
+	response := &types.Batch{
+		ID:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileID:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileID:     optionalString(batch.OutputFileId),
+		ErrorFileID:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTimestamp(batch.InProgressAt),
+		ExpiresAt:        optionalTimestamp(batch.ExpiresAt),
+		FinalizingAt:     optionalTimestamp(batch.FinalizingAt),
+		CompletedAt:      optionalTimestamp(batch.CompletedAt),
+		FailedAt:         optionalTimestamp(batch.FailedAt),
+		ExpiredAt:        optionalTimestamp(batch.ExpiredAt),
+		CancellingAt:     optionalTimestamp(batch.CancellingAt),
+		CancelledAt:      optionalTimestamp(batch.CancelledAt),
+		RequestCounts: types.BatchRequestCounts{
+			Total:     batch.Total,
+			Completed: batch.Completed,
+			Failed:    batch.Failed,
+		},
+	}
+
+	if len(batch.Errors) > 0 {
+		var batchErrors types.BatchErrors
+		if json.Unmarshal(batch.Errors, &batchErrors) == nil {
+			response.Errors = &batchErrors
+		}
+	}
+
+	if len(batch.Metadata) > 0 {
+		_ = json.Unmarshal(batch.Metadata, &response.Metadata)
+	}
+
+	return response
+}
+
+func toOutputLine(item *model.BatchItem) *types.BatchOutputLine {
+	line := &types.BatchOutputLine{
+		ID:       fmt.Sprintf("batch_req_%d", item.Id),
+		CustomID: item.CustomId,
+	}
+
+	// 没有状态码说明请求未被执行（取消、过期或令牌失效）
+	if item.StatusCode == 0 {
+		line.Error = &types.BatchOutputError{
+			Code:    item.ErrorCode,
+			Message: item.ErrorMsg,
+		}
+		return line
+	}
+
+	body := json.RawMessage(item.Response)
+	if !json.Valid(body) {
+		body, _ = json.Marshal(item.Response)
+	}
+
+	line.Response = &types.BatchOutputResponse{
+		StatusCode: item.StatusCode,
+		RequestID:  item.RequestId,
+		Body:       body,
+	}
+
+	return line
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func optionalTimestamp(value int64) *int64 {
+	if value == 0 {
+		return nil
+	}
+	return &value
+}
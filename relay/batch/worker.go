@@ -0,0 +1,330 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/storage"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/relay"
+	"done-hub/relay/files"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const batchPollInterval = 5 * time.Second
+
+// 上一次派发请求的时间，只在 worker 协程中读写
+var lastDispatchTime time.Time
+
+// InitBatch 启动批量任务执行器，只在主节点运行，未完成的任务在重启后会继续执行
+func InitBatch() {
+	if !config.IsMasterNode {
+		logger.SysLog("Batch worker is disabled on slave node")
+		return
+	}
+
+	common.SafeGoroutine(func() {
+		batchWorker()
+	})
+}
+
+func batchWorker() {
+	for {
+		if !processActiveBatches() {
+			time.Sleep(batchPollInterval)
+		}
+	}
+}
+
+// processActiveBatches 每个进行中的任务执行一轮，保证多个任务之间公平调度，返回本轮是否执行过请求
+func processActiveBatches() bool {
+	batches, err := model.GetActiveBatches()
+	if err != nil {
+		logger.SysError("failed to get active batches: " + err.Error())
+		return false
+	}
+
+	busy := false
+	for _, batch := range batches {
+		if processBatch(batch) {
+			busy = true
+		}
+	}
+
+	return busy
+}
+
+func processBatch(batch *model.Batch) bool {
+	now := utils.GetTimestamp()
+
+	switch batch.Status {
+	case model.BatchStatusCancelling:
+		finishBatch(batch, model.BatchStatusCancelled, "batch_cancelled", "This request was not executed because the batch was cancelled.")
+		return false
+	case model.BatchStatusFinalizing:
+		finishBatch(batch, model.BatchStatusCompleted, "", "")
+		return false
+	case model.BatchStatusValidating:
+		batch.Status = model.BatchStatusInProgress
+		batch.InProgressAt = now
+		if err := batch.Update(map[string]any{"status": batch.Status, "in_progress_at": now}); err != nil {
+			logger.SysError(fmt.Sprintf("batch_update_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+			return false
+		}
+	}
+
+	if batch.ExpiresAt > 0 && now > batch.ExpiresAt {
+		finishBatch(batch, model.BatchStatusExpired, "batch_expired", "This request could not be executed before the completion window expired.")
+		return false
+	}
+
+	token, err := getBatchToken(batch)
+	if err != nil {
+		setBatchError(batch, "token_invalid", err.Error())
+		finishBatch(batch, model.BatchStatusFailed, "token_invalid", err.Error())
+		return false
+	}
+
+	items, err := model.GetPendingBatchItems(batch.Id, utils.Max(config.BatchConcurrency, 1))
+	if err != nil {
+		logger.SysError(fmt.Sprintf("batch_items_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+		return false
+	}
+
+	if len(items) == 0 {
+		batch.Update(map[string]any{"status": model.BatchStatusFinalizing, "finalizing_at": utils.GetTimestamp()})
+		finishBatch(batch, model.BatchStatusCompleted, "", "")
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for _, item := range items {
+		waitBatchRateLimit()
+		wg.Add(1)
+		go func(item *model.BatchItem) {
+			defer wg.Done()
+			runBatchItem(batch, token, item)
+		}(item)
+	}
+	wg.Wait()
+
+	if err := batch.RefreshCounts(); err != nil {
+		logger.SysError(fmt.Sprintf("batch_update_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+	}
+
+	return true
+}
+
+// finishBatch 结束任务，code 不为空时剩余未执行的请求行会以该错误标记为失败
+func finishBatch(batch *model.Batch, status, code, message string) {
+	if code != "" {
+		if err := model.FailPendingBatchItems(batch.Id, code, message); err != nil {
+			logger.SysError(fmt.Sprintf("batch_update_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+			return
+		}
+	}
+
+	if err := batch.RefreshCounts(); err != nil {
+		logger.SysError(fmt.Sprintf("batch_update_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+		return
+	}
+
+	now := utils.GetTimestamp()
+	fields := map[string]any{"status": status}
+	saveBatchResultFiles(batch, fields)
+
+	switch status {
+	case model.BatchStatusCompleted:
+		fields["completed_at"] = now
+	case model.BatchStatusCancelled:
+		fields["cancelled_at"] = now
+	case model.BatchStatusExpired:
+		fields["expired_at"] = now
+	case model.BatchStatusFailed:
+		fields["failed_at"] = now
+	}
+
+	if err := batch.Update(fields); err != nil {
+		logger.SysError(fmt.Sprintf("batch_update_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+		return
+	}
+
+	logger.SysLog(fmt.Sprintf("batch_finished batch_id=%s status=%s total=%d completed=%d failed=%d",
+		batch.BatchId, status, batch.Total, batch.Completed, batch.Failed))
+}
+
+// saveBatchResultFiles 配置了文件存储时将结果和错误保存为文件，可以通过 Files API 下载
+func saveBatchResultFiles(batch *model.Batch, fields map[string]any) {
+	if batch.Completed > 0 && batch.OutputFileId == "" {
+		if fileId := saveBatchItemsFile(batch, model.BatchItemStatusCompleted); fileId != "" {
+			batch.OutputFileId = fileId
+			fields["output_file_id"] = fileId
+		}
+	}
+
+	if batch.Failed > 0 && batch.ErrorFileId == "" {
+		if fileId := saveBatchItemsFile(batch, model.BatchItemStatusFailed); fileId != "" {
+			batch.ErrorFileId = fileId
+			fields["error_file_id"] = fileId
+		}
+	}
+}
+
+func saveBatchItemsFile(batch *model.Batch, status string) string {
+	var buffer bytes.Buffer
+	if _, err := writeBatchItems(&buffer, batch, status); err != nil {
+		logger.SysError(fmt.Sprintf("batch_file_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+		return ""
+	}
+
+	file, err := files.SaveFile(batch.UserId, fmt.Sprintf("%s_%s.jsonl", batch.BatchId, status), files.PurposeBatchOutput, buffer.Bytes())
+	if err != nil {
+		if !errors.Is(err, storage.ErrNoFileDrive) {
+			logger.SysError(fmt.Sprintf("batch_file_failed batch_id=%s error=\"%s\"", batch.BatchId, err.Error()))
+		}
+		return ""
+	}
+
+	return file.FileId
+}
+
+func setBatchError(batch *model.Batch, code, message string) {
+	batchErrors := types.BatchErrors{
+		Object: "list",
+		Data: []types.BatchErrorData{{
+			Code:    code,
+			Message: message,
+		}},
+	}
+
+	data, err := json.Marshal(batchErrors)
+	if err != nil {
+		return
+	}
+
+	batch.Update(map[string]any{"errors": data})
+}
+
+// getBatchToken 每轮重新校验令牌，令牌被禁用、过期或额度用尽时终止任务
+func getBatchToken(batch *model.Batch) (*model.Token, error) {
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		return nil, model.ErrTokenNotFound
+	}
+
+	if token.UserId != batch.UserId {
+		return nil, model.ErrTokenInvalid
+	}
+
+	return model.ValidateUserToken(token.Key)
+}
+
+func waitBatchRateLimit() {
+	if config.BatchRPM <= 0 {
+		return
+	}
+
+	interval := time.Minute / time.Duration(config.BatchRPM)
+	if wait := interval - time.Since(lastDispatchTime); wait > 0 {
+		time.Sleep(wait)
+	}
+	lastDispatchTime = time.Now()
+}
+
+// runBatchItem 构造与正常请求一致的上下文，交给 Relay 完成选渠道、重试与计费
+func runBatchItem(batch *model.Batch, token *model.Token, item *model.BatchItem) {
+	requestId := utils.GetTimeString() + utils.GetRandomString(8)
+	item.RequestId = requestId
+
+	defer func() {
+		if err := recover(); err != nil {
+			logger.SysError(fmt.Sprintf("batch_item_panic batch_id=%s line=%d error=\"%v\"", batch.BatchId, item.Line, err))
+			item.Status = model.BatchItemStatusFailed
+			item.StatusCode = 0
+			item.ErrorCode = "server_error"
+			item.ErrorMsg = "internal error while executing the request"
+			item.FinishedAt = utils.GetTimestamp()
+			item.Finish()
+		}
+	}()
+
+	c, w, err := newBatchContext(batch, token, item, requestId)
+	if err != nil {
+		item.Status = model.BatchItemStatusFailed
+		item.ErrorCode = "invalid_request"
+		item.ErrorMsg = err.Error()
+	} else {
+		relay.Relay(c)
+
+		item.StatusCode = w.Code
+		item.Response = w.Body.String()
+		if w.Code >= http.StatusOK && w.Code < http.StatusMultipleChoices && item.Response != "" {
+			item.Status = model.BatchItemStatusCompleted
+		} else {
+			item.Status = model.BatchItemStatusFailed
+		}
+	}
+
+	item.FinishedAt = utils.GetTimestamp()
+	if err := item.Finish(); err != nil {
+		logger.SysError(fmt.Sprintf("batch_item_update_failed batch_id=%s line=%d error=\"%s\"", batch.BatchId, item.Line, err.Error()))
+	}
+}
+
+func newBatchContext(batch *model.Batch, token *model.Token, item *model.BatchItem, requestId string) (*gin.Context, *httptest.ResponseRecorder, error) {
+	req, err := http.NewRequest(item.Method, item.Url, strings.NewReader(item.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx := context.WithValue(context.Background(), logger.RequestIdKey, requestId)
+	ctx = context.WithValue(ctx, "id", token.UserId)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req.WithContext(ctx)
+
+	c.Set(logger.RequestIdKey, requestId)
+	c.Set("requestStartTime", time.Now())
+	c.Set("id", token.UserId)
+	c.Set("token_id", token.Id)
+	c.Set("token_name", token.Name)
+	c.Set("token_group", token.Group)
+	c.Set("token_backup_group", token.BackupGroup)
+	c.Set("token_setting", utils.GetPointer(token.Setting.Data()))
+	c.Set("batch_id", batch.BatchId)
+
+	// 与 Distribute 中间件一致，先按第一优先级分组设置倍率，实际分组由 Relay 决定
+	userGroup, _ := model.CacheGetUserGroup(token.UserId)
+	c.Set("group", userGroup)
+
+	initialGroup := token.Group
+	if initialGroup == "" {
+		initialGroup = token.BackupGroup
+	}
+	if initialGroup == "" {
+		initialGroup = userGroup
+	}
+
+	groupRatio := model.GlobalUserGroupRatio.GetBySymbol(initialGroup)
+	if groupRatio == nil {
+		return nil, nil, fmt.Errorf("分组 %s 不存在", initialGroup)
+	}
+	c.Set("group_ratio", groupRatio.Ratio)
+
+	return c, w, nil
+}
@@ -0,0 +1,59 @@
+package relay
+
+import (
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/controller"
+	"done-hub/model"
+	"done-hub/types"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordChannelKeyResult 记录多密钥渠道中本次使用的密钥的结果，密钥被限流时暂停使用，鉴权失败或额度耗尽时禁用
+// 返回 true 表示错误只与该密钥有关，不计入渠道的熔断统计
+func recordChannelKeyResult(c *gin.Context, channel *model.Channel, apiErr *types.OpenAIErrorWithStatusCode) bool {
+	c.Set("channel_key_evicted", false)
+
+	keyIndex, ok := utils.GetGinValue[int](c, "channel_key_index")
+	if !ok || !channel.MultiKey {
+		return false
+	}
+
+	if apiErr == nil {
+		channel.RecordKeySuccess(keyIndex)
+		return false
+	}
+	if apiErr.LocalError {
+		return false
+	}
+
+	message := utils.TruncateBase64InMessage(apiErr.OpenAIError.Message)
+	channel.RecordKeyFailure(keyIndex, message)
+
+	switch {
+	case controller.ShouldDisableChannel(channel.Type, apiErr):
+		if err := channel.DisableKey(keyIndex, message); err != nil {
+			logger.LogError(c.Request.Context(), fmt.Sprintf("channel_key_disable_failed channel_id=%d key_index=%d error=\"%s\"", channel.Id, keyIndex, err.Error()))
+			return false
+		}
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_key_disabled channel_id=%d key_index=%d status_code=%d error=\"%s\"",
+			channel.Id, keyIndex, apiErr.StatusCode, message))
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		var durationSeconds int64
+		if apiErr.RateLimitResetAt > 0 {
+			durationSeconds = max(apiErr.RateLimitResetAt-time.Now().Unix(), 0)
+		}
+		channel.CooldownKey(keyIndex, durationSeconds)
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_key_cooldown channel_id=%d key_index=%d reset_duration=%ds",
+			channel.Id, keyIndex, durationSeconds))
+	default:
+		return false
+	}
+
+	c.Set("channel_key_evicted", true)
+	return true
+}
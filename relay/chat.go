@@ -20,7 +20,11 @@ import (
 
 type relayChat struct {
 	relayBase
-	chatRequest types.ChatCompletionRequest
+	chatRequest  types.ChatCompletionRequest
+	failover     *chatStreamFailover
+	mcpServerIds []int
+	mcp          *mcpToolLoop
+	contextFit   *contextFitResult
 }
 
 func NewRelayChat(c *gin.Context) *relayChat {
@@ -42,9 +46,17 @@ func (r *relayChat) setRequest() error {
 		return errors.New("max_tokens is invalid")
 	}
 
-	if r.chatRequest.Tools != nil {
+	// 客户端要求不调用工具时不挂载 MCP 服务
+	if toolType, _ := r.chatRequest.ParseToolChoice(); toolType != types.ToolChoiceTypeNone {
+		r.mcpServerIds = getMcpServerIds(r.c)
+	}
+
+	if r.chatRequest.Tools != nil || len(r.mcpServerIds) > 0 {
 		r.c.Set("skip_only_chat", true)
 	}
+	if len(r.mcpServerIds) > 0 {
+		r.c.Set("mcp_round", 1)
+	}
 
 	if !r.chatRequest.Stream {
 		r.chatRequest.StreamOptions = nil
@@ -56,9 +68,10 @@ func (r *relayChat) setRequest() error {
 
 	if otherArg == "search" {
 		handleSearch(r.c, &r.chatRequest)
-		return nil
 	}
 
+	r.fitContextWindow()
+
 	return nil
 }
 
@@ -88,6 +101,14 @@ var need2Response = map[string]bool{
 }
 
 func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	// 总结会产生单独计费的请求，等主请求通过额度检查后再进行
+	if r.summarizeContext() {
+		if usage := r.provider.GetUsage(); usage != nil {
+			usage.PromptTokens, _ = r.getPromptTokens()
+		}
+	}
+	r.setContextFitHeaders()
+
 	if need2Response[r.modelName] {
 		resProvider, ok := r.provider.(providersBase.ResponsesInterface)
 		if ok {
@@ -117,12 +138,27 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 		}
 	}
 
+	r.mcp = nil
+	if loop := r.newMcpToolLoop(chatProvider); loop != nil {
+		defer loop.finish()
+	}
+
+	chatRequest := r.toolEmulationRequest()
+	emulateTools := chatRequest != &r.chatRequest
+
 	if r.chatRequest.Stream {
 		var response requester.StreamReaderInterface[string]
-		response, err = chatProvider.CreateChatCompletionStream(&r.chatRequest)
+		response, err = chatProvider.CreateChatCompletionStream(chatRequest)
 		if err != nil {
 			return
 		}
+		if emulateTools {
+			response = newToolEmulationStream(response, &r.chatRequest)
+		}
+		if r.mcp != nil {
+			response = newMcpToolStream(r.mcp, response)
+		}
+		response = newChatStreamFailover(r, response)
 
 		if r.heartbeat != nil {
 			r.heartbeat.Stop()
@@ -137,11 +173,33 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 		r.SetFirstResponseTime(firstResponseTime)
 	} else {
 		var response *types.ChatCompletionResponse
-		response, err = chatProvider.CreateChatCompletion(&r.chatRequest)
+		response, err = chatProvider.CreateChatCompletion(chatRequest)
 		if err != nil {
 			return
 		}
 
+		if emulateTools {
+			applyEmulatedToolCalls(response, &r.chatRequest)
+		}
+
+		if r.mcp != nil {
+			response, err = r.mcp.complete(response)
+			if err != nil {
+				return
+			}
+		}
+
+		response, err = r.enforceStructuredOutput(chatProvider, response)
+		if err != nil {
+			done = true
+			return
+		}
+
+		// 执行过 MCP 工具时返回所有轮次的合计用量
+		if r.mcp != nil && len(r.mcp.rounds) > 0 {
+			response.Usage = r.mcp.totalUsage()
+		}
+
 		if r.heartbeat != nil {
 			r.heartbeat.Stop()
 		}
@@ -167,6 +225,13 @@ func (r *relayChat) getUsageResponse() string {
 			Choices: []types.ChatCompletionStreamChoice{},
 			Usage:   r.provider.GetUsage(),
 		}
+		// 发生过中途续写时返回所有片段的合计用量
+		if r.failover != nil && len(r.failover.segments) > 0 {
+			usageResponse.Usage = r.failover.totalUsage()
+		}
+		if r.mcp != nil && len(r.mcp.rounds) > 0 {
+			usageResponse.Usage = r.mcp.totalUsage()
+		}
 
 		responseBody, err := json.Marshal(usageResponse)
 		if err != nil {
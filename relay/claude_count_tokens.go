@@ -0,0 +1,75 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/model"
+	"done-hub/providers/claude"
+	"done-hub/types"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountClaudeTokens 处理 /claude/v1/messages/count_tokens 请求
+// 原生 Claude 与 ClaudeCode 渠道转发到上游计算，转换为 OpenAI/Gemini 等格式的渠道使用本地计算，该接口不计费
+func CountClaudeTokens(c *gin.Context) {
+	request := &claude.ClaudeRequest{}
+	if err := common.UnmarshalBodyReusable(c, request); err != nil {
+		abortWithClaudeErr(c, common.ErrorWrapperLocal(err, "invalid_claude_request", http.StatusBadRequest))
+		return
+	}
+
+	if request.Model == "" {
+		abortWithClaudeErr(c, common.StringErrorWrapperLocal("model is required", "invalid_claude_request", http.StatusBadRequest))
+		return
+	}
+
+	c.Set("allow_channel_type", AllowChannelType)
+	provider, modelName, fail := GetProvider(c, request.Model)
+	if fail != nil {
+		abortWithClaudeErr(c, common.StringErrorWrapperLocal(fail.Error(), "channel_error", http.StatusServiceUnavailable))
+		return
+	}
+
+	channel := provider.GetChannel()
+	if countProvider, ok := provider.(claude.ClaudeCountTokensInterface); ok && (channel.Type == config.ChannelTypeAnthropic || channel.Type == config.ChannelTypeClaudeCode) {
+		countRequest := *request
+		countRequest.Model = modelName
+
+		startTime := time.Now()
+		response, errWithCode := countProvider.CountClaudeTokens(&countRequest)
+		if errWithCode == nil {
+			recordChannelAttempt(c, channel, startTime, time.Time{}, nil)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		// 上游不支持该接口（如部分中转站）时降级为本地计算，其余错误与普通转发一样计入渠道并返回给客户端
+		if errWithCode.StatusCode != http.StatusNotFound && errWithCode.StatusCode != http.StatusMethodNotAllowed {
+			recordChannelAttempt(c, channel, startTime, time.Time{}, errWithCode)
+			go processChannelRelayError(c.Request.Context(), channel.Id, channel.Name, errWithCode, channel.Type)
+			abortWithClaudeErr(c, errWithCode)
+			return
+		}
+
+		model.ChannelGroup.ReleaseCircuitTrial(c)
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("count_tokens_fallback model=%s channel_id=%d status_code=%d error=\"%s\"",
+			modelName, channel.Id, errWithCode.StatusCode, errWithCode.Message))
+	}
+
+	inputTokens, _ := CountTokenMessages(request, config.PreCostDefault)
+	c.JSON(http.StatusOK, &claude.ClaudeCountTokensResponse{
+		InputTokens: inputTokens,
+	})
+}
+
+func abortWithClaudeErr(c *gin.Context, err *types.OpenAIErrorWithStatusCode) {
+	newErr := FilterOpenAIErr(c, err)
+	claudeErr := claude.OpenaiErrToClaudeErr(&newErr)
+	c.JSON(newErr.StatusCode, claudeErr.ClaudeError)
+	c.Abort()
+}
@@ -60,6 +60,10 @@ func Path2Relay(c *gin.Context, path string) RelayBaseInterface {
 		}
 	} else if strings.HasPrefix(path, "/v1/responses") {
 		relay = NewRelayResponses(c)
+	} else if strings.HasPrefix(path, "/ollama/api/embed") {
+		relay = NewRelayOllamaEmbed(c)
+	} else if strings.HasPrefix(path, "/ollama") {
+		relay = NewRelayOllamaOnly(c)
 	}
 
 	return relay
@@ -140,6 +144,14 @@ func GetProvider(c *gin.Context, modelName string) (provider providersBase.Provi
 		return nil, "", err
 	}
 
+	if chain := model.GlobalVirtualModels.GetModels(modelName); chain != nil {
+		return getVirtualModelProvider(c, modelName, chain)
+	}
+
+	return getProviderByModel(c, modelName)
+}
+
+func getProviderByModel(c *gin.Context, modelName string) (provider providersBase.ProviderInterface, newModelName string, fail error) {
 	// 获取分组信息
 	tokenGroup := c.GetString("token_group")
 	backupGroup := c.GetString("token_backup_group")
@@ -202,6 +214,7 @@ func GetProvider(c *gin.Context, modelName string) (provider providersBase.Provi
 	c.Set("is_backupGroup", isBackupGroup)
 	c.Set("channel_id", channel.Id)
 	c.Set("channel_type", channel.Type)
+	c.Set("matched_model", actualModelName) // 分组中匹配到的模型名称，用于记录渠道的负载均衡统计
 
 	// 重新设置分组倍率
 	groupRatio := model.GlobalUserGroupRatio.GetBySymbol(usedGroup)
@@ -279,9 +292,22 @@ func buildChannelFilters(c *gin.Context, modelName string) []model.ChannelsFilte
 		filters = append(filters, model.FilterDisabledStream(modelName))
 	}
 
+	candidates := channelCandidates(c, modelName)
+	filters = append(filters, model.FilterUnavailableKeys(), filterSaturatedChannels(c, candidates), filterRateLimitedChannels(c, candidates, modelName))
+
 	return filters
 }
 
+// channelCandidates 本次请求可能选中的渠道，用于在加锁选择渠道之前查询并发与额度
+func channelCandidates(c *gin.Context, modelName string) []*model.Channel {
+	group := c.GetString("token_group")
+	if group == "" {
+		group = c.GetString("group")
+	}
+
+	return model.ChannelGroup.CandidateChannels(group, modelName)
+}
+
 func fetchChannelByModel(c *gin.Context, modelName string) (*model.Channel, error) {
 	group := c.GetString("token_group")
 	filters := buildChannelFilters(c, modelName)
@@ -314,6 +340,7 @@ func responseJsonClient(c *gin.Context, data interface{}) *types.OpenAIErrorWith
 
 	// Encode 会在末尾添加换行符，需要去掉
 	responseBody := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	responseBody = rewriteResponseBody(c, responseBody)
 
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(http.StatusOK)
@@ -576,6 +603,10 @@ func shouldRetryBadRequest(channelType int, apiErr *types.OpenAIErrorWithStatusC
 
 func processChannelRelayError(ctx context.Context, channelId int, channelName string, err *types.OpenAIErrorWithStatusCode, channelType int) {
 	if controller.ShouldDisableChannel(channelType, err) {
+		// 多密钥渠道只禁用出错的密钥，所有密钥都不可用时才禁用渠道
+		if channel := model.ChannelGroup.GetChannel(channelId); channel != nil && channel.MultiKey && channel.HasEnabledKey() {
+			return
+		}
 		logger.LogError(ctx, fmt.Sprintf("channel_disabled channel_id=%d channel_name=\"%s\" channel_type=%d status_code=%d error=\"%s\" auto_disabled=true",
 			channelId, channelName, channelType, err.StatusCode, err.Message))
 		controller.DisableChannel(channelId, channelName, err.Message, true)
@@ -0,0 +1,151 @@
+package relay
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/limit"
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 所有渠道的并发都已满时，轮询是否有渠道释放了名额的间隔
+const channelQueuePollInterval = 200 * time.Millisecond
+
+// 本节点正在排队等待渠道的请求数量
+var channelQueueLength atomic.Int64
+
+var (
+	errChannelBusy         = errors.New("渠道并发已满，请稍后再试")
+	errChannelQueueFull    = errors.New("渠道并发已满，排队请求过多，请稍后再试")
+	errChannelQueueTimeout = errors.New("渠道并发已满，排队等待超时，请稍后再试")
+)
+
+// isChannelQueueError 渠道并发已满导致的选择失败，返回 429
+func isChannelQueueError(err error) bool {
+	return errors.Is(err, errChannelBusy) || errors.Is(err, errChannelQueueFull) || errors.Is(err, errChannelQueueTimeout)
+}
+
+func channelConcurrencyKey(channelId int) string {
+	return fmt.Sprintf("channel:%d", channelId)
+}
+
+// filterSaturatedChannels 跳过并发已满的渠道，并在上下文中记录，选择失败时据此判断是否需要排队
+// 并发数在构建过滤器时按候选渠道查询，渠道组加锁期间不访问 Redis
+func filterSaturatedChannels(c *gin.Context, candidates []*model.Channel) model.ChannelsFilterFunc {
+	saturated := make(map[int]bool, len(candidates))
+	for _, channel := range candidates {
+		saturated[channel.Id] = isChannelSaturated(channel)
+	}
+
+	return func(channelId int, choice *model.ChannelChoice) bool {
+		full, ok := saturated[channelId]
+		if !ok {
+			// 查询之后才加入的渠道
+			full = isChannelSaturated(choice.Channel)
+		}
+		if !full {
+			return false
+		}
+
+		c.Set("channel_saturated", true)
+		return true
+	}
+}
+
+func isChannelSaturated(channel *model.Channel) bool {
+	return channel.MaxConcurrency > 0 && limit.GetSemaphoreCount(channelConcurrencyKey(channel.Id)) >= channel.MaxConcurrency
+}
+
+// acquireChannelConcurrency 占用渠道的并发名额，名额在请求结束或切换渠道时通过 releaseChannelConcurrency 归还
+func acquireChannelConcurrency(c *gin.Context, channel *model.Channel) bool {
+	if channel.MaxConcurrency <= 0 {
+		return true
+	}
+
+	lease := limit.TryAcquireSemaphore(channelConcurrencyKey(channel.Id), channel.MaxConcurrency)
+	if lease == nil {
+		return false
+	}
+
+	c.Set("channel_concurrency_lease", lease)
+	return true
+}
+
+func releaseChannelConcurrency(c *gin.Context) {
+	lease, ok := utils.GetGinValue[*limit.SemaphoreLease](c, "channel_concurrency_lease")
+	if !ok || lease == nil {
+		return
+	}
+
+	c.Set("channel_concurrency_lease", nil)
+	lease.Release()
+}
+
+// channelSelector 选择渠道需要的方法，realtime 等没有实现 RelayBaseInterface 的请求同样适用
+type channelSelector interface {
+	setProvider(modelName string) error
+	getProvider() providersBase.ProviderInterface
+	getOriginalModel() string
+	getContext() *gin.Context
+}
+
+// setProviderWithConcurrency 选择渠道并占用并发名额，会先归还上一个渠道的名额
+// 所有可用渠道的并发都已满，或者选中的渠道无法占用名额时，在有界队列中等待其他请求释放名额，超时后返回错误
+func setProviderWithConcurrency(relay channelSelector) error {
+	c := relay.getContext()
+	releaseChannelConcurrency(c)
+
+	var deadline time.Time
+	for {
+		c.Set("channel_saturated", false)
+		err := relay.setProvider(relay.getOriginalModel())
+		if err == nil {
+			channel := relay.getProvider().GetChannel()
+			if acquireChannelConcurrency(c, channel) {
+				if reserveChannelRateLimit(c, channel) {
+					return nil
+				}
+				releaseChannelConcurrency(c)
+			}
+			// 选中后名额或额度被其他请求占满，与所有渠道都已满时一样排队等待
+			// 指定渠道时不经过过滤器，立即重新选择仍会选中同一个渠道
+			model.ChannelGroup.ReleaseCircuitTrial(c)
+			c.Set("channel_saturated", true)
+			err = errChannelBusy
+		}
+
+		if !c.GetBool("channel_saturated") || config.ChannelQueueTimeout <= 0 {
+			return err
+		}
+
+		if deadline.IsZero() {
+			if channelQueueLength.Add(1) > int64(config.ChannelQueueMaxSize) {
+				channelQueueLength.Add(-1)
+				logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_queue_full model=%s queue_length=%d", relay.getOriginalModel(), config.ChannelQueueMaxSize))
+				return errChannelQueueFull
+			}
+			defer channelQueueLength.Add(-1)
+
+			deadline = time.Now().Add(time.Duration(config.ChannelQueueTimeout) * time.Second)
+			logger.LogInfo(c.Request.Context(), fmt.Sprintf("channel_queue_wait model=%s timeout=%ds", relay.getOriginalModel(), config.ChannelQueueTimeout))
+		}
+
+		if time.Now().After(deadline) {
+			logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_queue_timeout model=%s timeout=%ds", relay.getOriginalModel(), config.ChannelQueueTimeout))
+			return errChannelQueueTimeout
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return c.Request.Context().Err()
+		case <-time.After(channelQueuePollInterval):
+		}
+	}
+}
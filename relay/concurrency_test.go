@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/limit"
+	"done-hub/common/logger"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// pinnedChannelSelector 与指定渠道一样，每次都选中同一个渠道
+type pinnedChannelSelector struct {
+	c        *gin.Context
+	provider providersBase.ProviderInterface
+	calls    int
+}
+
+func (s *pinnedChannelSelector) setProvider(modelName string) error {
+	s.calls++
+	return nil
+}
+
+func (s *pinnedChannelSelector) getProvider() providersBase.ProviderInterface {
+	return s.provider
+}
+
+func (s *pinnedChannelSelector) getOriginalModel() string {
+	return "gpt-4o"
+}
+
+func (s *pinnedChannelSelector) getContext() *gin.Context {
+	return s.c
+}
+
+// pinnedProvider 只需要提供渠道
+type pinnedProvider struct {
+	providersBase.ProviderInterface
+	channel *model.Channel
+}
+
+func (p *pinnedProvider) GetChannel() *model.Channel {
+	return p.channel
+}
+
+func newPinnedChannelSelector(channelId int) *pinnedChannelSelector {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	return &pinnedChannelSelector{
+		c:        c,
+		provider: &pinnedProvider{channel: &model.Channel{Id: channelId, MaxConcurrency: 1}},
+	}
+}
+
+func TestSetProviderWithConcurrencySaturatedChannel(t *testing.T) {
+	if logger.Logger == nil {
+		logger.Logger = zap.NewNop()
+	}
+	queueTimeout := config.ChannelQueueTimeout
+	defer func() { config.ChannelQueueTimeout = queueTimeout }()
+
+	cases := []struct {
+		name         string
+		queueTimeout int
+		releaseAfter time.Duration // 大于 0 时占用的名额在该时间后归还
+		err          error
+		maxCalls     int
+	}{
+		{name: "queue disabled fails fast", queueTimeout: 0, err: errChannelBusy, maxCalls: 1},
+		{name: "queue times out without spinning", queueTimeout: 1, err: errChannelQueueTimeout, maxCalls: 7},
+		{name: "released slot is acquired", queueTimeout: 1, releaseAfter: 300 * time.Millisecond, maxCalls: 4},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config.ChannelQueueTimeout = tc.queueTimeout
+			selector := newPinnedChannelSelector(900001 + i)
+
+			lease := limit.TryAcquireSemaphore(channelConcurrencyKey(selector.provider.GetChannel().Id), 1)
+			if tc.releaseAfter > 0 {
+				time.AfterFunc(tc.releaseAfter, lease.Release)
+			} else {
+				defer lease.Release()
+			}
+
+			err := setProviderWithConcurrency(selector)
+			assert.ErrorIs(t, err, tc.err)
+			assert.LessOrEqual(t, selector.calls, tc.maxCalls)
+			assert.Equal(t, tc.err != nil, isChannelQueueError(err))
+
+			releaseChannelConcurrency(selector.c)
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextSummaryPrompt = "Summarize the following earlier part of a conversation so it can replace the original messages. Keep facts, decisions, names, numbers, code identifiers and open questions. Reply with the summary only."
+
+const contextSummaryPrefix = "Summary of the earlier conversation, which was shortened to fit the context window:\n"
+
+// CountTokenMessages 对每组消息都会额外计入回复前缀的长度
+const replyPrimingTokens = 3
+
+// contextFitResult 裁剪后的消息与裁剪情况，保存在上下文中，对冲请求重新解析请求时直接复用
+type contextFitResult struct {
+	Strategy        string
+	OriginalTokens  int
+	Tokens          int
+	DroppedMessages int
+	Summarized      bool
+	Messages        []types.ChatCompletionMessage
+
+	// summarize 策略在预扣额度之后才总结被裁剪的消息，重试与对冲请求共用同一次总结
+	budget      int
+	system      []types.ChatCompletionMessage
+	kept        [][]types.ChatCompletionMessage
+	dropped     []types.ChatCompletionMessage
+	summaryOnce sync.Once
+}
+
+// fitContextWindow 对话超出模型登记的上下文长度时，按令牌设置的策略裁剪历史消息
+func (r *relayChat) fitContextWindow() {
+	if cached, ok := r.c.Get("context_fit"); ok {
+		if result, ok := cached.(*contextFitResult); ok {
+			r.chatRequest.Messages = result.Messages
+			r.contextFit = result
+		}
+		return
+	}
+
+	setting := getContextFitSetting(r)
+	if setting == nil || len(r.chatRequest.Messages) == 0 {
+		return
+	}
+
+	ctx := r.c.Request.Context()
+	contextLength, err := model.CacheGetModelContextLength(r.originalModel)
+	if err != nil {
+		logger.LogError(ctx, "context_fit_failed error=\""+err.Error()+"\"")
+		return
+	}
+	if contextLength <= 0 {
+		return
+	}
+
+	budget := contextLength - r.reservedContextTokens()
+	if budget <= 0 {
+		return
+	}
+
+	originalTokens := r.countMessageTokens(r.chatRequest.Messages)
+	if originalTokens <= budget {
+		return
+	}
+
+	result := r.trimMessages(setting, budget)
+	if result == nil {
+		logger.LogWarn(ctx, fmt.Sprintf("context_fit_failed model=%s strategy=%s context_length=%d tokens=%d reason=\"latest turn does not fit\"",
+			r.originalModel, setting.Strategy, contextLength, originalTokens))
+		return
+	}
+	result.OriginalTokens = originalTokens
+	result.Tokens = r.countMessageTokens(result.Messages)
+
+	logger.LogInfo(ctx, fmt.Sprintf("context_fit model=%s strategy=%s context_length=%d original_tokens=%d tokens=%d dropped_messages=%d summarized=%t",
+		r.originalModel, result.Strategy, contextLength, originalTokens, result.Tokens, result.DroppedMessages, result.Summarized))
+
+	r.chatRequest.Messages = result.Messages
+	r.contextFit = result
+	r.c.Set("context_fit", result)
+}
+
+func getContextFitSetting(r *relayChat) *model.ContextFitSetting {
+	tokenSetting, ok := r.c.Get("token_setting")
+	if !ok {
+		return nil
+	}
+
+	setting, ok := tokenSetting.(*model.TokenSetting)
+	if !ok || setting == nil {
+		return nil
+	}
+
+	switch setting.ContextFit.Strategy {
+	case model.ContextFitDropOldest, model.ContextFitKeepLast, model.ContextFitSummarize:
+		return &setting.ContextFit
+	default:
+		return nil
+	}
+}
+
+// reservedContextTokens 为输出与工具定义预留的长度
+func (r *relayChat) reservedContextTokens() int {
+	reserved := r.chatRequest.MaxTokens
+	if r.chatRequest.MaxCompletionTokens > reserved {
+		reserved = r.chatRequest.MaxCompletionTokens
+	}
+
+	if len(r.chatRequest.Tools) > 0 {
+		if body, err := json.Marshal(r.chatRequest.Tools); err == nil {
+			reserved += common.CountTokenText(string(body), r.originalModel)
+		}
+	}
+
+	return reserved
+}
+
+func (r *relayChat) countMessageTokens(messages []types.ChatCompletionMessage) int {
+	return common.CountTokenMessages(messages, r.originalModel, config.PreCostDefault)
+}
+
+// trimMessages 最近一轮对话仍然放不下时返回 nil
+func (r *relayChat) trimMessages(setting *model.ContextFitSetting, budget int) *contextFitResult {
+	system, turns := splitConversationTurns(r.chatRequest.Messages)
+	if len(turns) == 0 {
+		return nil
+	}
+
+	keepLast := setting.KeepLast
+	if keepLast <= 0 {
+		keepLast = config.ContextFitKeepLast
+	}
+
+	strategy := setting.Strategy
+	if strategy == model.ContextFitSummarize && config.ContextFitSummaryModel == "" {
+		strategy = model.ContextFitKeepLast
+	}
+
+	kept := turns
+	if strategy != model.ContextFitDropOldest && keepLast > 0 && len(kept) > keepLast {
+		kept = kept[len(kept)-keepLast:]
+	}
+
+	// 总结需要占用一部分长度
+	trimBudget := budget
+	if strategy == model.ContextFitSummarize {
+		trimBudget -= config.ContextFitSummaryMaxTokens
+	}
+
+	// 每一轮只计算一次长度，丢弃时直接减去
+	tokens := r.countMessageTokens(system)
+	turnTokens := make([]int, len(kept))
+	for i, turn := range kept {
+		turnTokens[i] = r.countMessageTokens(turn) - replyPrimingTokens
+		tokens += turnTokens[i]
+	}
+	for len(kept) > 1 && tokens > trimBudget {
+		tokens -= turnTokens[0]
+		kept, turnTokens = kept[1:], turnTokens[1:]
+	}
+
+	dropped := turns[:len(turns)-len(kept)]
+	result := &contextFitResult{
+		Strategy:        strategy,
+		DroppedMessages: len(joinConversation(nil, nil, dropped)),
+		Messages:        joinConversation(system, nil, kept),
+		budget:          budget,
+	}
+
+	if r.countMessageTokens(result.Messages) > budget {
+		return nil
+	}
+
+	if strategy == model.ContextFitSummarize && len(dropped) > 0 {
+		result.system = system
+		result.kept = kept
+		result.dropped = joinConversation(nil, nil, dropped)
+	}
+
+	return result
+}
+
+// summarizeContext 在预扣额度之后调用，总结失败时保留直接裁剪的结果，返回是否使用了总结
+func (r *relayChat) summarizeContext() bool {
+	result := r.contextFit
+	if result == nil || len(result.dropped) == 0 {
+		return false
+	}
+
+	result.summaryOnce.Do(func() {
+		summary, err := r.summarizeMessages(result.dropped)
+		if err != nil {
+			logger.LogWarn(r.c.Request.Context(), fmt.Sprintf("context_summary_failed model=%s summary_model=%s error=\"%s\"",
+				r.originalModel, config.ContextFitSummaryModel, err.Error()))
+			return
+		}
+
+		summaryMessage := &types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleSystem,
+			Content: contextSummaryPrefix + summary,
+		}
+		messages := joinConversation(result.system, summaryMessage, result.kept)
+		tokens := r.countMessageTokens(messages)
+		if tokens > result.budget {
+			return
+		}
+
+		result.Messages = messages
+		result.Tokens = tokens
+		result.Summarized = true
+	})
+
+	r.chatRequest.Messages = result.Messages
+	return result.Summarized
+}
+
+// splitConversationTurns 开头的系统提示词单独保留，其余消息以 user 消息为起点划分轮次
+// 工具调用与工具结果总是在同一轮中，裁剪时不会被拆开
+func splitConversationTurns(messages []types.ChatCompletionMessage) (system []types.ChatCompletionMessage, turns [][]types.ChatCompletionMessage) {
+	index := 0
+	for index < len(messages) && messages[index].IsSystemRole() {
+		index++
+	}
+	system = messages[:index]
+
+	for _, message := range messages[index:] {
+		if message.Role == types.ChatMessageRoleUser || len(turns) == 0 {
+			turns = append(turns, []types.ChatCompletionMessage{message})
+			continue
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], message)
+	}
+
+	return system, turns
+}
+
+func joinConversation(system []types.ChatCompletionMessage, summary *types.ChatCompletionMessage, turns [][]types.ChatCompletionMessage) []types.ChatCompletionMessage {
+	messages := make([]types.ChatCompletionMessage, 0, len(system)+len(turns)*2+1)
+	messages = append(messages, system...)
+	if summary != nil {
+		messages = append(messages, *summary)
+	}
+	for _, turn := range turns {
+		messages = append(messages, turn...)
+	}
+
+	return messages
+}
+
+// summarizeMessages 使用配置的模型总结被裁剪的消息
+// 总结请求与普通请求一样选择渠道、占用并发名额并预扣额度，单独计费，响应不返回给客户端
+func (r *relayChat) summarizeMessages(messages []types.ChatCompletionMessage) (string, error) {
+	c := newContextSummaryContext(r.c)
+	defer releaseChannelConcurrency(c)
+
+	summary := &relayContextSummary{
+		relayBase: relayBase{c: c},
+		request: types.ChatCompletionRequest{
+			Messages: []types.ChatCompletionMessage{
+				{Role: types.ChatMessageRoleSystem, Content: contextSummaryPrompt},
+				{Role: types.ChatMessageRoleUser, Content: formatTranscript(messages)},
+			},
+			MaxTokens: config.ContextFitSummaryMaxTokens,
+		},
+	}
+	summary.setOriginalModel(config.ContextFitSummaryModel)
+
+	if err := setProviderWithConcurrency(summary); err != nil {
+		return "", err
+	}
+
+	if errWithCode, _ := RelayHandler(summary); errWithCode != nil {
+		return "", errors.New(errWithCode.Message)
+	}
+
+	return summary.summary, nil
+}
+
+// newContextSummaryContext 总结请求使用复制的上下文，不占用主请求的渠道、名额与重试状态
+func newContextSummaryContext(c *gin.Context) *gin.Context {
+	summaryContext := c.Copy()
+	for _, key := range []string{
+		"channel_concurrency_lease", "channel_rate_limit", "rate_limit_tokens", "circuit_trial",
+		"skip_channel_ids", "attempt_count", "channel_key_index",
+		"virtual_model_route", "virtual_model", "virtual_model_chain",
+		"mcp_round", "mcp_tools", "stream_segment",
+	} {
+		summaryContext.Set(key, nil)
+	}
+	summaryContext.Set("skip_only_chat", false)
+	summaryContext.Set("is_stream", false)
+	summaryContext.Set("context_summary", true)
+
+	return summaryContext
+}
+
+// relayContextSummary 总结请求，只在 summarizeMessages 中通过 RelayHandler 发送
+type relayContextSummary struct {
+	relayBase
+	request types.ChatCompletionRequest
+	summary string
+}
+
+func (r *relayContextSummary) setRequest() error {
+	return nil
+}
+
+func (r *relayContextSummary) getRequest() any {
+	return &r.request
+}
+
+func (r *relayContextSummary) getPromptTokens() (int, error) {
+	return common.CountTokenMessages(r.request.Messages, r.modelName, r.provider.GetChannel().PreCost), nil
+}
+
+func (r *relayContextSummary) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	chatProvider, ok := r.provider.(providersBase.ChatInterface)
+	if !ok {
+		err = common.StringErrorWrapperLocal("channel not implemented", "channel_error", http.StatusServiceUnavailable)
+		done = true
+		return
+	}
+
+	r.request.Model = r.modelName
+	response, err := chatProvider.CreateChatCompletion(&r.request)
+	if err != nil {
+		return
+	}
+	if len(response.Choices) > 0 {
+		r.summary = strings.TrimSpace(response.Choices[0].Message.StringContent())
+	}
+	if r.summary == "" {
+		err = common.StringErrorWrapperLocal("summary is empty", "channel_error", http.StatusBadGateway)
+		done = true
+		return
+	}
+
+	if usage := r.provider.GetUsage(); usage != nil && usage.CompletionTokens == 0 {
+		usage.CompletionTokens = common.CountTokenText(r.summary, r.modelName)
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	return
+}
+
+// formatTranscript 把消息转换为纯文本，工具调用与结果也以文本形式保留
+func formatTranscript(messages []types.ChatCompletionMessage) string {
+	var builder strings.Builder
+	for _, message := range messages {
+		builder.WriteString(message.Role)
+		builder.WriteString(": ")
+		builder.WriteString(message.StringContent())
+		for _, toolCall := range message.ToolCalls {
+			if toolCall.Function != nil {
+				builder.WriteString(fmt.Sprintf("\n[tool call %s %s]", toolCall.Function.Name, toolCall.Function.Arguments))
+			}
+		}
+		builder.WriteString("\n\n")
+	}
+
+	return builder.String()
+}
+
+// setContextFitHeaders 在响应头中返回裁剪情况
+func (r *relayChat) setContextFitHeaders() {
+	if r.contextFit == nil {
+		return
+	}
+
+	r.c.Header("X-Context-Fit-Strategy", r.contextFit.Strategy)
+	r.c.Header("X-Context-Fit-Original-Tokens", strconv.Itoa(r.contextFit.OriginalTokens))
+	r.c.Header("X-Context-Fit-Tokens", strconv.Itoa(r.contextFit.Tokens))
+	r.c.Header("X-Context-Fit-Dropped-Messages", strconv.Itoa(r.contextFit.DroppedMessages))
+	if r.contextFit.Summarized {
+		r.c.Header("X-Context-Fit-Summarized", "true")
+	}
+}
@@ -0,0 +1,262 @@
+package files
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/storage"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/types"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PurposeBatch       = "batch"
+	PurposeBatchOutput = "batch_output"
+)
+
+// 允许用户上传的文件用途，batch_output 只由网关生成
+var allowedUploadPurposes = []string{
+	"assistants",
+	PurposeBatch,
+	"fine-tune",
+	"vision",
+	"user_data",
+	"evals",
+}
+
+// UploadFile 上传文件，文件内容保存到配置的存储中，文件 ID 与渠道无关
+func UploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if !utils.Contains(purpose, allowedUploadPurposes) {
+		common.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("purpose must be one of %s", strings.Join(allowedUploadPurposes, ", ")))
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusBadRequest, "file is required")
+		return
+	}
+
+	maxSize := int64(config.FileMaxSize) * 1024 * 1024
+	if maxSize > 0 && fileHeader.Size > maxSize {
+		common.AbortWithMessage(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file size exceeds the limit of %d MB", config.FileMaxSize))
+		return
+	}
+
+	reader, err := fileHeader.Open()
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := SaveFile(c.GetInt("id"), fileHeader.Filename, purpose, data)
+	if err != nil {
+		abortWithStorageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ToOpenAIFile(file))
+}
+
+func ListFiles(c *gin.Context) {
+	limit := utils.String2Int(c.DefaultQuery("limit", "10000"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+
+	// 多取一条用于判断是否还有下一页
+	userFiles, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), c.DefaultQuery("order", "desc"), limit+1)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := &types.FileList{
+		Object: "list",
+		Data:   make([]*types.File, 0, len(userFiles)),
+	}
+
+	if len(userFiles) > limit {
+		response.HasMore = true
+		userFiles = userFiles[:limit]
+	}
+
+	for _, file := range userFiles {
+		response.Data = append(response.Data, ToOpenAIFile(file))
+	}
+
+	if len(response.Data) > 0 {
+		response.FirstID = &response.Data[0].ID
+		response.LastID = &response.Data[len(response.Data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func RetrieveFile(c *gin.Context) {
+	file := getUserFile(c)
+	if file == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, ToOpenAIFile(file))
+}
+
+func DeleteFile(c *gin.Context) {
+	file := getUserFile(c)
+	if file == nil {
+		return
+	}
+
+	// 存储删除失败不影响元数据删除，避免文件无法被用户清理
+	if drive, err := storage.GetFileDrive(file.Drive); err == nil {
+		if err := drive.Delete(file.StorageKey); err != nil {
+			logger.LogError(c.Request.Context(), fmt.Sprintf("file_delete_failed file_id=%s drive=%s error=\"%s\"", file.FileId, file.Drive, err.Error()))
+		}
+	}
+
+	if err := file.Delete(); err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, &types.FileDeleteResponse{
+		ID:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// GetFileContent 下载文件内容
+func GetFileContent(c *gin.Context) {
+	file := getUserFile(c)
+	if file == nil {
+		return
+	}
+
+	data, err := readFile(file)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("file_read_failed file_id=%s drive=%s error=\"%s\"", file.FileId, file.Drive, err.Error()))
+		common.AbortWithMessage(c, http.StatusInternalServerError, "failed to read file content")
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(file.Filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// SaveFile 保存文件内容并记录元数据，供其他功能（如批量任务）生成文件使用
+func SaveFile(userId int, filename, purpose string, data []byte) (*model.File, error) {
+	drive, err := storage.DefaultFileDrive()
+	if err != nil {
+		return nil, err
+	}
+
+	fileId := "file-" + utils.GetUUID()
+	file := &model.File{
+		FileId:     fileId,
+		UserId:     userId,
+		Bytes:      int64(len(data)),
+		Filename:   filepath.Base(filename),
+		Purpose:    purpose,
+		Drive:      drive.Name(),
+		StorageKey: fmt.Sprintf("files/%d/%s", userId, fileId),
+		CreatedAt:  utils.GetTimestamp(),
+	}
+
+	if err := drive.Put(file.StorageKey, data); err != nil {
+		return nil, err
+	}
+
+	if err := file.Insert(); err != nil {
+		drive.Delete(file.StorageKey)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// ReadFileContent 读取用户文件内容，文件不存在时返回 nil
+func ReadFileContent(userId int, fileId string) (*model.File, []byte, error) {
+	file, err := model.GetFileByFileId(userId, fileId)
+	if err != nil || file == nil {
+		return nil, nil, err
+	}
+
+	data, err := readFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, data, nil
+}
+
+func readFile(file *model.File) ([]byte, error) {
+	drive, err := storage.GetFileDrive(file.Drive)
+	if err != nil {
+		return nil, err
+	}
+
+	return drive.Get(file.StorageKey)
+}
+
+func getUserFile(c *gin.Context) *model.File {
+	fileId := c.Param("id")
+	file, err := model.GetFileByFileId(c.GetInt("id"), fileId)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	if file == nil {
+		common.AbortWithMessage(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", fileId))
+		return nil
+	}
+
+	return file
+}
+
+func abortWithStorageError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrNoFileDrive) {
+		common.AbortWithMessage(c, http.StatusServiceUnavailable, "file storage is not configured")
+		return
+	}
+
+	logger.LogError(c.Request.Context(), "file_save_failed error=\""+err.Error()+"\"")
+	common.AbortWithMessage(c, http.StatusInternalServerError, "failed to save file")
+}
+
+func ToOpenAIFile(file *model.File) *types.File {
+	return &types.File{
+		ID:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
@@ -3,14 +3,19 @@ package relay
 import (
 	"done-hub/common"
 	"done-hub/common/config"
+	"done-hub/common/logger"
 	"done-hub/common/requester"
+	providersBase "done-hub/providers/base"
 	"done-hub/providers/gemini"
 	"done-hub/safty"
 	"done-hub/types"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,7 +28,6 @@ type relayGeminiOnly struct {
 }
 
 func NewRelayGeminiOnly(c *gin.Context) *relayGeminiOnly {
-	c.Set("allow_channel_type", AllowGeminiChannelType)
 	relay := &relayGeminiOnly{
 		relayBase: relayBase{
 			allowHeartbeat: true,
@@ -87,11 +91,6 @@ func (r *relayGeminiOnly) getPromptTokens() (int, error) {
 }
 
 func (r *relayGeminiOnly) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
-	chatProvider, ok := r.provider.(gemini.GeminiChatInterface)
-	if !ok {
-		return nil, false
-	}
-
 	// 内容审查
 	if config.EnableSafe {
 		for _, message := range r.geminiRequest.Contents {
@@ -108,6 +107,12 @@ func (r *relayGeminiOnly) send() (err *types.OpenAIErrorWithStatusCode, done boo
 
 	r.geminiRequest.Model = r.modelName
 
+	chatProvider, ok := r.provider.(gemini.GeminiChatInterface)
+	if !ok {
+		// 非 Gemini 渠道，使用 Gemini->OpenAI->Gemini 的转换逻辑
+		return r.sendOpenAIWithGeminiFormat()
+	}
+
 	if r.geminiRequest.Stream {
 		var response requester.StreamReaderInterface[string]
 		response, err = chatProvider.CreateGeminiChatStream(r.geminiRequest)
@@ -145,6 +150,150 @@ func (r *relayGeminiOnly) send() (err *types.OpenAIErrorWithStatusCode, done boo
 	return
 }
 
+// sendOpenAIWithGeminiFormat 处理非 Gemini 渠道的 Gemini 格式请求
+// 实现 Gemini格式 -> OpenAI格式 -> 上游接口 -> OpenAI响应 -> Gemini格式 的转换
+func (r *relayGeminiOnly) sendOpenAIWithGeminiFormat() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	chatProvider, ok := r.provider.(providersBase.ChatInterface)
+	if !ok {
+		err = common.StringErrorWrapperLocal("channel not implemented", "channel_error", http.StatusServiceUnavailable)
+		done = true
+		return
+	}
+
+	switch r.geminiRequest.Action {
+	case "countTokens":
+		return r.countTokensLocally()
+	case "generateContent", "streamGenerateContent":
+	default:
+		err = common.StringErrorWrapperLocal(fmt.Sprintf("action %s is not supported by current channel", r.geminiRequest.Action), "channel_error", http.StatusBadRequest)
+		done = true
+		return
+	}
+
+	openaiRequest := r.geminiRequest.ToChatCompletionRequest()
+
+	if r.geminiRequest.Stream {
+		var stream requester.StreamReaderInterface[string]
+		stream, err = chatProvider.CreateChatCompletionStream(openaiRequest)
+		if err != nil {
+			return
+		}
+
+		if r.heartbeat != nil {
+			r.heartbeat.Stop()
+		}
+
+		var firstResponseTime time.Time
+		firstResponseTime, err = r.convertOpenAIStreamToGemini(stream)
+		r.SetFirstResponseTime(firstResponseTime)
+	} else {
+		var response *types.ChatCompletionResponse
+		response, err = chatProvider.CreateChatCompletion(openaiRequest)
+		if err != nil {
+			return
+		}
+
+		if r.heartbeat != nil {
+			r.heartbeat.Stop()
+		}
+
+		err = responseJsonClient(r.c, gemini.ConvertChatOpenaiToGemini(response, r.getOriginalModel()))
+	}
+
+	if err != nil {
+		done = true
+	}
+
+	return
+}
+
+// countTokensLocally 非 Gemini 渠道没有 countTokens 接口，使用本地计算结果，不产生费用
+func (r *relayGeminiOnly) countTokensLocally() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	totalTokens, _ := CountGeminiTokenMessages(r.geminiRequest, config.PreCostDefault)
+	*r.provider.GetUsage() = types.Usage{}
+
+	err = responseJsonClient(r.c, map[string]int{
+		"totalTokens": totalTokens,
+	})
+	if err != nil {
+		done = true
+	}
+
+	return
+}
+
+// convertOpenAIStreamToGemini 将 OpenAI 流式响应转换为 Gemini SSE 流式响应
+func (r *relayGeminiOnly) convertOpenAIStreamToGemini(stream requester.StreamReaderInterface[string]) (firstResponseTime time.Time, errWithOP *types.OpenAIErrorWithStatusCode) {
+	requester.SetEventStreamHeaders(r.c)
+	dataChan, errChan := stream.Recv()
+	defer stream.Close()
+
+	converter := gemini.NewOpenAIStreamToGemini(r.getOriginalModel())
+	ctx := r.c.Request.Context()
+	clientDisconnected := false
+
+	writeResponse := func(response *gemini.GeminiChatResponse) {
+		if response == nil || clientDisconnected {
+			return
+		}
+
+		responseBody, err := json.Marshal(response)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			clientDisconnected = true
+		default:
+			r.c.Writer.Write([]byte("data: " + string(responseBody) + "\n\n"))
+			r.c.Writer.Flush()
+		}
+	}
+
+	for {
+		select {
+		case rawLine, ok := <-dataChan:
+			if !ok {
+				return
+			}
+
+			if firstResponseTime.IsZero() {
+				firstResponseTime = time.Now()
+			}
+
+			data := strings.TrimSpace(strings.TrimPrefix(rawLine, "data: "))
+			if data == "" || data == "[DONE]" {
+				continue
+			}
+
+			var chunk types.ChatCompletionStreamResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				continue
+			}
+
+			// 客户端断开后继续消费数据以确保计费准确，但不写入
+			writeResponse(converter.Convert(&chunk))
+
+		case err := <-errChan:
+			if !errors.Is(err, io.EOF) {
+				errWithOP = common.StringErrorWrapper(err.Error(), "stream_error", 900)
+				logger.LogError(ctx, "Stream err:"+err.Error())
+				if !clientDisconnected {
+					r.HandleStreamError(errWithOP)
+				}
+				return
+			}
+
+			writeResponse(converter.Finish(r.provider.GetUsage()))
+			return
+
+		case <-ctx.Done():
+			clientDisconnected = true
+		}
+	}
+}
+
 func (r *relayGeminiOnly) GetError(err *types.OpenAIErrorWithStatusCode) (int, any) {
 	newErr := FilterOpenAIErr(r.c, err)
 
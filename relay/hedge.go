@@ -0,0 +1,433 @@
+package relay
+
+import (
+	"context"
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/relay/relay_util"
+	"done-hub/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// hedger 首个渠道在等待时间内没有响应时，向另一个渠道发起同样的请求，先响应的一方写给客户端，另一方被取消
+type hedger struct {
+	c       *gin.Context
+	relay   RelayBaseInterface
+	delay   time.Duration
+	race    *hedgeRace
+	primary *hedgeAttempt
+}
+
+type hedgeAttempt struct {
+	relay    RelayBaseInterface
+	writer   *hedgeWriter
+	number   int
+	err      *types.OpenAIErrorWithStatusCode
+	done     bool
+	finished chan struct{}
+}
+
+// hedgeRace 记录最先写出响应的请求，只有胜者的内容会写给客户端
+type hedgeRace struct {
+	sync.Mutex
+	writer  gin.ResponseWriter
+	writers []*hedgeWriter
+	winner  *hedgeWriter
+	decided chan struct{}
+}
+
+// hedgeWriter 胜负未分时响应头和状态码先保存在本地，第一次写入时决出胜者
+type hedgeWriter struct {
+	gin.ResponseWriter
+	race      *hedgeRace
+	header    http.Header
+	status    int
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// newHedger 令牌或分组开启了对冲请求时返回 hedger，否则返回 nil
+// 心跳会提前写入内容，开启心跳的请求不做对冲
+func newHedger(relay RelayBaseInterface, heartbeat *relay_util.Heartbeat) *hedger {
+	if heartbeat != nil || !isHedgeable(relay) {
+		return nil
+	}
+
+	c := relay.getContext()
+	if c.GetInt("specific_channel_id") > 0 && !c.GetBool("specific_channel_id_ignore") {
+		return nil
+	}
+
+	// 虚拟模型的降级进度保存在上下文中，两个请求同时推进会互相干扰
+	if getVirtualModelRoute(c) != nil {
+		return nil
+	}
+
+	delay := getHedgeDelay(c)
+	if delay <= 0 {
+		return nil
+	}
+
+	return &hedger{
+		c:     c,
+		relay: relay,
+		delay: delay,
+		race: &hedgeRace{
+			writer:  c.Writer,
+			decided: make(chan struct{}),
+		},
+	}
+}
+
+// getHedgeDelay 令牌设置优先，其次是分组设置，返回 0 表示不对冲
+func getHedgeDelay(c *gin.Context) time.Duration {
+	delay := config.HedgeDelay
+	enabled := false
+
+	if tokenSetting, ok := c.Get("token_setting"); ok {
+		if setting, ok := tokenSetting.(*model.TokenSetting); ok && setting != nil && setting.Hedge.Enabled {
+			enabled = true
+			if setting.Hedge.Delay > 0 {
+				delay = max(setting.Hedge.Delay, config.HedgeMinDelay)
+			}
+		}
+	}
+
+	if !enabled {
+		groupName := c.GetString("token_group")
+		if groupName == "" {
+			groupName = c.GetString("group")
+		}
+		if userGroup := model.GlobalUserGroupRatio.GetBySymbol(groupName); userGroup != nil && userGroup.HedgeEnabled {
+			enabled = true
+			if userGroup.HedgeDelay > 0 {
+				delay = userGroup.HedgeDelay
+			}
+		}
+	}
+
+	if !enabled || delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(delay) * time.Millisecond
+}
+
+// isHedgeable 只对冲文本生成类请求，图片、音频等请求重复执行的代价较高
+// 挂载了 MCP 服务的对话会由网关执行工具，重复执行可能产生副作用，也不做对冲
+func isHedgeable(relay RelayBaseInterface) bool {
+	switch r := relay.(type) {
+	case *relayChat:
+		return len(r.mcpServerIds) == 0
+	case *relayCompletions, *relayClaudeOnly, *relayGeminiOnly, *relayOllamaOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// isHedgeLoser 对冲请求中没有抢先写出响应的一方，不写入也不计费
+func isHedgeLoser(c *gin.Context) bool {
+	writer, ok := c.Writer.(*hedgeWriter)
+	return ok && !writer.isWinner()
+}
+
+// isHedgeCancelled 对冲请求因另一方胜出而被取消，此时的错误与渠道质量无关
+func isHedgeCancelled(c *gin.Context) bool {
+	writer, ok := c.Writer.(*hedgeWriter)
+	if !ok {
+		return false
+	}
+
+	writer.race.Lock()
+	defer writer.race.Unlock()
+
+	return writer.cancelled
+}
+
+// run 执行请求并返回最终采用的 relay，对冲失败时返回首个请求的结果交给重试流程处理
+func (h *hedger) run() (RelayBaseInterface, *types.OpenAIErrorWithStatusCode, bool) {
+	ctx := h.c.Request.Context()
+	modelName := h.c.GetString("new_model")
+
+	defer func() {
+		h.c.Writer = h.race.writer
+	}()
+
+	h.primary = h.start(h.relay, 1)
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	select {
+	case <-h.primary.finished:
+		return h.relay, h.primary.err, h.primary.done
+	case <-h.race.decided:
+		<-h.primary.finished
+		return h.relay, h.primary.err, h.primary.done
+	case <-timer.C:
+	}
+
+	hedgeRelay := h.newHedgeRelay()
+	if hedgeRelay == nil {
+		<-h.primary.finished
+		return h.relay, h.primary.err, h.primary.done
+	}
+
+	primaryChannel := h.relay.getProvider().GetChannel()
+	hedgeChannel := hedgeRelay.getProvider().GetChannel()
+	h.c.Set("attempt_count", 2)
+	logger.LogInfo(ctx, fmt.Sprintf("hedge_start model=%s channel_id=%d hedge_channel_id=%d delay=%dms",
+		modelName, primaryChannel.Id, hedgeChannel.Id, h.delay.Milliseconds()))
+
+	secondary := h.start(hedgeRelay, 2)
+	<-h.primary.finished
+	<-secondary.finished
+	releaseChannelConcurrency(hedgeRelay.getContext())
+
+	winner, loser := h.primary, secondary
+	if secondary.writer.isWinner() {
+		winner, loser = secondary, h.primary
+	}
+
+	if !winner.writer.isWinner() {
+		// 两个渠道都失败，第二个渠道的错误在这里处理，首个渠道的错误交给重试流程
+		if secondary.err != nil {
+			logger.LogError(ctx, fmt.Sprintf("hedge_failed model=%s channel_id=%d attempt=%d status_code=%d error=\"%s\"",
+				modelName, hedgeChannel.Id, secondary.number, secondary.err.StatusCode, utils.TruncateBase64InMessage(secondary.err.OpenAIError.Message)))
+			go processChannelRelayError(ctx, hedgeChannel.Id, hedgeChannel.Name, secondary.err, hedgeChannel.Type)
+			shouldCooldowns(h.c, hedgeChannel, secondary.err)
+		}
+		return h.relay, h.primary.err, h.primary.done
+	}
+
+	loserChannel := loser.relay.getProvider().GetChannel()
+	winnerChannel := winner.relay.getProvider().GetChannel()
+	logger.LogWarn(ctx, fmt.Sprintf("hedge_lost model=%s channel_id=%d attempt=%d winner_channel_id=%d winner_attempt=%d cancelled=%t",
+		modelName, loserChannel.Id, loser.number, winnerChannel.Id, winner.number, loser.writer.cancelled))
+
+	// 在胜者写出响应之前就已经失败的请求，按正常的渠道错误处理
+	if !loser.writer.cancelled && loser.err != nil {
+		go processChannelRelayError(ctx, loserChannel.Id, loserChannel.Name, loser.err, loserChannel.Type)
+	}
+
+	if winner.err != nil {
+		// 已经向客户端输出了内容，不能再重试
+		return winner.relay, winner.err, true
+	}
+
+	return winner.relay, nil, false
+}
+
+// start 在独立的协程中执行请求，上游请求使用可以取消的 context，客户端断开时两个请求都会被取消
+func (h *hedger) start(relay RelayBaseInterface, number int) *hedgeAttempt {
+	c := relay.getContext()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	if requester := relay.getProvider().GetRequester(); requester != nil {
+		requester.Context = ctx
+	}
+
+	attempt := &hedgeAttempt{
+		relay:    relay,
+		writer:   h.race.newWriter(cancel),
+		number:   number,
+		finished: make(chan struct{}),
+	}
+	c.Writer = attempt.writer
+
+	common.SafeGoroutine(func() {
+		defer close(attempt.finished)
+		defer cancel()
+		// panic 时保留默认错误
+		attempt.err = common.StringErrorWrapperLocal("hedged request aborted", "system_error", http.StatusInternalServerError)
+		attempt.done = true
+		attempt.err, attempt.done = RelayHandler(relay)
+	})
+
+	return attempt
+}
+
+// newHedgeRelay 在复制的上下文中重新解析请求，并选择首个渠道以外的渠道
+func (h *hedger) newHedgeRelay() RelayBaseInterface {
+	ctx := h.c.Request.Context()
+	primaryChannel := h.relay.getProvider().GetChannel()
+
+	hedgeContext := h.c.Copy()
+	// 复制的上下文中保存的是首个渠道的并发名额，对冲请求需要单独占用
+	hedgeContext.Set("channel_concurrency_lease", nil)
+	hedgeContext.Set("channel_rate_limit", nil)
+	hedgeContext.Set("circuit_trial", "")
+	hedgeContext.Set("attempt_count", 2)
+	skipChannelIds, _ := utils.GetGinValue[[]int](h.c, "skip_channel_ids")
+	skipChannelIds = append(append([]int{}, skipChannelIds...), primaryChannel.Id)
+	hedgeContext.Set("skip_channel_ids", skipChannelIds)
+
+	relay := Path2Relay(hedgeContext, hedgeContext.Request.URL.Path)
+	if relay == nil {
+		return nil
+	}
+
+	if err := relay.setRequest(); err != nil {
+		logger.LogError(ctx, "hedge_request_failed error=\""+err.Error()+"\"")
+		return nil
+	}
+
+	if err := relay.setProvider(relay.getOriginalModel()); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("hedge_skip model=%s channel_id=%d reason=\"%s\"",
+			h.c.GetString("new_model"), primaryChannel.Id, err.Error()))
+		return nil
+	}
+
+	if relay.getProvider().GetChannel().Id == primaryChannel.Id {
+		model.ChannelGroup.ReleaseCircuitTrial(hedgeContext)
+		return nil
+	}
+
+	// 对冲请求不排队，渠道并发或额度已满时不发起
+	hedgeChannel := relay.getProvider().GetChannel()
+	if !acquireChannelConcurrency(hedgeContext, hedgeChannel) {
+		model.ChannelGroup.ReleaseCircuitTrial(hedgeContext)
+		return nil
+	}
+	if !reserveChannelRateLimit(hedgeContext, hedgeChannel) {
+		releaseChannelConcurrency(hedgeContext)
+		model.ChannelGroup.ReleaseCircuitTrial(hedgeContext)
+		return nil
+	}
+	if err := applyChannelRewriteRules(relay); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("hedge_skip model=%s channel_id=%d reason=\"%s\"",
+			h.c.GetString("new_model"), hedgeChannel.Id, err.Error()))
+		return nil
+	}
+
+	return relay
+}
+
+func (r *hedgeRace) newWriter(cancel context.CancelFunc) *hedgeWriter {
+	r.Lock()
+	defer r.Unlock()
+
+	writer := &hedgeWriter{
+		ResponseWriter: r.writer,
+		race:           r,
+		header:         make(http.Header),
+		status:         http.StatusOK,
+		cancel:         cancel,
+	}
+	r.writers = append(r.writers, writer)
+
+	return writer
+}
+
+// claim 第一次写入的请求成为胜者，其余请求被取消
+func (r *hedgeRace) claim(writer *hedgeWriter) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.winner != nil {
+		return r.winner == writer
+	}
+
+	r.winner = writer
+	for key, values := range writer.header {
+		r.writer.Header()[key] = values
+	}
+	r.writer.WriteHeader(writer.status)
+
+	for _, other := range r.writers {
+		if other != writer {
+			other.cancelled = true
+			other.cancel()
+		}
+	}
+	close(r.decided)
+
+	return true
+}
+
+func (w *hedgeWriter) isWinner() bool {
+	w.race.Lock()
+	defer w.race.Unlock()
+
+	return w.race.winner == w
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.isWinner() {
+		return w.ResponseWriter.Header()
+	}
+
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.isWinner() {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.isWinner() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.race.claim(w) {
+		return 0, errHedgeLost
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.race.claim(w) {
+		return 0, errHedgeLost
+	}
+
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.isWinner() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.isWinner() {
+		return w.ResponseWriter.Status()
+	}
+
+	return w.status
+}
+
+func (w *hedgeWriter) Written() bool {
+	if w.isWinner() {
+		return w.ResponseWriter.Written()
+	}
+
+	return false
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.isWinner() {
+		return w.ResponseWriter.Size()
+	}
+
+	return -1
+}
@@ -26,7 +26,10 @@ func Relay(c *gin.Context) {
 		c.Set(config.GinRequestBodyKey, nil)
 		c.Set(config.GinProcessedBodyKey, nil)
 		c.Set(config.GinProcessedBodyIsVertexAI, nil)
+		c.Set("rewrite_original_body", nil)
 	}()
+	// 请求结束后归还渠道的并发名额
+	defer releaseChannelConcurrency(c)
 
 	relay := Path2Relay(c, c.Request.URL.Path)
 	if relay == nil {
@@ -44,20 +47,50 @@ func Relay(c *gin.Context) {
 	}
 
 	c.Set("is_stream", relay.IsStream())
-	if err := relay.setProvider(relay.getOriginalModel()); err != nil {
-		openaiErr := common.StringErrorWrapperLocal(err.Error(), "one_hub_error", http.StatusServiceUnavailable)
+	cacher := newResponseCacher(relay)
+	hit, cacheErr := cacher.replay(relay)
+	if cacheErr != nil {
+		relay.HandleJsonError(cacheErr)
+		return
+	}
+	if hit {
+		return
+	}
+
+	if err := setProviderWithConcurrency(relay); err != nil {
+		statusCode := http.StatusServiceUnavailable
+		if isChannelQueueError(err) {
+			statusCode = http.StatusTooManyRequests
+		}
+		openaiErr := common.StringErrorWrapperLocal(err.Error(), "one_hub_error", statusCode)
+		relay.HandleJsonError(openaiErr)
+		return
+	}
+	if err := applyChannelRewriteRules(relay); err != nil {
+		openaiErr := common.StringErrorWrapperLocal(err.Error(), "one_hub_error", http.StatusInternalServerError)
 		relay.HandleJsonError(openaiErr)
 		return
 	}
 
+	cacher.capture(c)
+	shadow := newShadowMirror(relay)
+
 	heartbeat := relay.SetHeartbeat(relay.IsStream())
 	if heartbeat != nil {
 		defer heartbeat.Close()
 	}
 
-	apiErr, done := RelayHandler(relay)
+	var apiErr *types.OpenAIErrorWithStatusCode
+	var done bool
+	if hedge := newHedger(relay, heartbeat); hedge != nil {
+		relay, apiErr, done = hedge.run()
+	} else {
+		apiErr, done = RelayHandler(relay)
+	}
 	if apiErr == nil {
-		metrics.RecordProvider(c, 200)
+		metrics.RecordProvider(relay.getContext(), 200)
+		cacher.save(relay)
+		shadow.run(relay)
 		return
 	}
 
@@ -72,6 +105,11 @@ func Relay(c *gin.Context) {
 	}
 	modelName := c.GetString("new_model")
 	totalChannelsAtStart := model.ChannelGroup.CountAvailableChannels(groupName, modelName)
+	// 虚拟模型会沿着模型链继续重试
+	if totalChannels, virtualRetryTimes, ok := virtualModelRetryBudget(c, groupName); ok {
+		totalChannelsAtStart = totalChannels
+		retryTimes = virtualRetryTimes
+	}
 
 	if done || !shouldRetry(c, apiErr, channel.Type) {
 		logger.LogError(c.Request.Context(), fmt.Sprintf("retry_skip model=%s channel_id=%d status_code=%d done=%t should_retry=%t total_channels=%d error=\"%s\"",
@@ -90,7 +128,10 @@ func Relay(c *gin.Context) {
 
 	c.Set("total_channels_at_start", totalChannelsAtStart)
 	c.Set("actual_retry_times", actualRetryTimes)
-	c.Set("attempt_count", 1) // 初始化尝试计数
+	// 初始化尝试计数，对冲请求已经计入了第二次尝试
+	if c.GetInt("attempt_count") == 0 {
+		c.Set("attempt_count", 1)
+	}
 
 	// 记录初始失败 - 使用统一的结构化日志格式
 	logger.LogError(c.Request.Context(), fmt.Sprintf("retry_start model=%s channel_id=%d total_channels=%d config_max_retries=%d actual_max_retries=%d status_code=%d error=\"%s\"",
@@ -101,7 +142,7 @@ func Relay(c *gin.Context) {
 	}
 
 	for i := actualRetryTimes; i > 0; i-- {
-		// 冻结通道并记录是否应用了冷却
+		// 熔断通道并记录是否已熔断
 		cooldownApplied := shouldCooldowns(c, channel, apiErr)
 
 		if time.Since(startTime) > timeout {
@@ -111,11 +152,16 @@ func Relay(c *gin.Context) {
 			break
 		}
 
-		if err := relay.setProvider(relay.getOriginalModel()); err != nil {
+		if err := setProviderWithConcurrency(relay); err != nil {
 			logger.LogError(c.Request.Context(), fmt.Sprintf("retry_provider_error model=%s channel_id=%d error=\"%s\"",
 				modelName, channel.Id, err.Error()))
 			break
 		}
+		if err := applyChannelRewriteRules(relay); err != nil {
+			logger.LogError(c.Request.Context(), fmt.Sprintf("retry_rewrite_error model=%s channel_id=%d error=\"%s\"",
+				modelName, relay.getProvider().GetChannel().Id, err.Error()))
+			break
+		}
 
 		channel = relay.getProvider().GetChannel()
 
@@ -142,6 +188,8 @@ func Relay(c *gin.Context) {
 			logger.LogInfo(c.Request.Context(), fmt.Sprintf("retry_success model=%s channel_id=%d attempt=%d/%d total_channels=%d",
 				modelName, channel.Id, attemptCount, actualRetryTimes, c.GetInt("total_channels_at_start")))
 			metrics.RecordProvider(c, 200)
+			cacher.save(relay)
+			shadow.run(relay)
 			return
 		}
 
@@ -205,13 +253,31 @@ func RelayHandler(relay RelayBaseInterface) (err *types.OpenAIErrorWithStatusCod
 		return
 	}
 
+	sendStartTime := time.Now()
 	err, done = relay.send()
+	hedgeLost := isHedgeLoser(relay.getContext())
+	if hedgeLost && isHedgeCancelled(relay.getContext()) {
+		// 被胜出的一方取消，不计入渠道的成功率
+		model.ChannelGroup.ReleaseCircuitTrial(relay.getContext())
+	} else if !streamFailoverRecorded(relay) {
+		recordChannelResult(relay, sendStartTime, err)
+	}
+
 	// 最后处理流式中断时计算tokens
 	if usage.CompletionTokens == 0 && usage.TextBuilder.Len() > 0 {
 		usage.CompletionTokens = common.CountTokenText(usage.TextBuilder.String(), relay.getModelName())
 		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 	}
 
+	settleChannelRateLimit(relay.getContext(), usage.TotalTokens)
+
+	// 对冲请求中落败的一方没有向客户端输出内容，不计费，只记录日志
+	if hedgeLost {
+		quota.SetFirstResponseTime(relay.GetFirstResponseTime())
+		quota.ConsumeHedgeLoser(relay.getContext(), usage, relay.IsStream())
+		return
+	}
+
 	// 即使出错，只要有实际输出就记录计费，避免上游已计费但本地未记录
 	if err != nil {
 		if usage.CompletionTokens > 0 {
@@ -223,6 +289,8 @@ func RelayHandler(relay RelayBaseInterface) (err *types.OpenAIErrorWithStatusCod
 		return
 	}
 
+	recordPrefixCacheUsage(relay.getContext(), usage)
+
 	quota.SetFirstResponseTime(relay.GetFirstResponseTime())
 
 	quota.Consume(relay.getContext(), usage, relay.IsStream())
@@ -230,37 +298,74 @@ func RelayHandler(relay RelayBaseInterface) (err *types.OpenAIErrorWithStatusCod
 	return
 }
 
+// recordChannelResult 记录渠道的延迟与成功率，供自适应负载均衡与熔断器使用
+// 本地错误和客户端请求错误与渠道质量无关，不记录
+func recordChannelResult(relay RelayBaseInterface, startTime time.Time, apiErr *types.OpenAIErrorWithStatusCode) {
+	recordChannelAttempt(relay.getContext(), relay.getProvider().GetChannel(), startTime, relay.GetFirstResponseTime(), apiErr)
+}
+
+// recordChannelAttempt 同 recordChannelResult，供没有实现 RelayBaseInterface 的请求（如 realtime）使用
+func recordChannelAttempt(c *gin.Context, channel *model.Channel, startTime, firstResponseTime time.Time, apiErr *types.OpenAIErrorWithStatusCode) {
+	success := apiErr == nil
+	// 多密钥渠道中被限流或禁用的只是单个密钥
+	if recordChannelKeyResult(c, channel, apiErr) {
+		model.ChannelGroup.ReleaseCircuitTrial(c)
+		return
+	}
+	if apiErr != nil {
+		if apiErr.LocalError {
+			model.ChannelGroup.ReleaseCircuitTrial(c)
+			return
+		}
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusUnauthorized, http.StatusForbidden:
+		default:
+			if apiErr.StatusCode < http.StatusInternalServerError {
+				model.ChannelGroup.ReleaseCircuitTrial(c)
+				return
+			}
+		}
+	}
+
+	latency := time.Since(startTime)
+	ttft := latency
+	if !firstResponseTime.IsZero() && firstResponseTime.After(startTime) {
+		ttft = firstResponseTime.Sub(startTime)
+	}
+
+	channelId := c.GetInt("channel_id")
+	modelName := c.GetString("matched_model")
+	model.RecordChannelResult(channelId, modelName, latency, ttft, success)
+
+	// 结果会归还试探名额
+	c.Set("circuit_trial", "")
+	model.ChannelGroup.RecordCircuitResult(channelId, modelName, success)
+}
+
 func shouldCooldowns(c *gin.Context, channel *model.Channel, apiErr *types.OpenAIErrorWithStatusCode) bool {
-	modelName := c.GetString("new_model")
+	modelName := c.GetString("matched_model")
+	if modelName == "" {
+		modelName = c.GetString("new_model")
+	}
 	channelId := channel.Id
 	cooldownApplied := false
 
-	// 如果是频率限制，冻结通道
+	// 多密钥渠道已暂停或禁用出错的密钥，还有可用的密钥时继续使用该渠道重试
+	if c.GetBool("channel_key_evicted") && channel.HasAvailableKey() {
+		c.Set("channel_key_evicted", false)
+		return true
+	}
+
+	// 如果是频率限制，立即熔断渠道，其他错误由熔断器按失败次数与错误率判断
 	if apiErr.StatusCode == http.StatusTooManyRequests {
-		// 检查是否有响应头中的冻结时间（如 ClaudeCode 的 anthropic-ratelimit-unified-reset）
+		// 优先使用响应头中的重置时间（如 ClaudeCode 的 anthropic-ratelimit-unified-reset），否则按连续熔断次数退避
+		var durationSeconds int64
 		if apiErr.RateLimitResetAt > 0 {
-			// 使用响应头中的冻结时间
-			nowTime := time.Now().Unix()
-			durationSeconds := apiErr.RateLimitResetAt - nowTime
-			if durationSeconds > 0 {
-				model.ChannelGroup.SetCooldownsWithDuration(channelId, modelName, durationSeconds)
-				cooldownApplied = true
-				logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_cooldown channel_id=%d model=\"%s\" duration=%ds reason=\"rate_limit\" reset_at=%s",
-					channelId, modelName, durationSeconds, time.Unix(apiErr.RateLimitResetAt, 0).Format(time.RFC3339)))
-			} else {
-				// 冻结时间已过，使用默认冻结时间
-				model.ChannelGroup.SetCooldowns(channelId, modelName)
-				cooldownApplied = true
-				logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_cooldown channel_id=%d model=\"%s\" duration=%ds reason=\"rate_limit\"",
-					channelId, modelName, config.RetryCooldownSeconds))
-			}
-		} else {
-			// 没有响应头中的冻结时间，使用配置中的默认冻结时间
-			model.ChannelGroup.SetCooldowns(channelId, modelName)
-			cooldownApplied = true
-			logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_cooldown channel_id=%d model=\"%s\" duration=%ds reason=\"rate_limit\"",
-				channelId, modelName, config.RetryCooldownSeconds))
+			durationSeconds = max(apiErr.RateLimitResetAt-time.Now().Unix(), 0)
 		}
+		cooldownApplied = model.ChannelGroup.OpenCircuit(channelId, modelName, durationSeconds, "rate_limit")
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("channel_circuit_open channel_id=%d model=\"%s\" reason=\"rate_limit\" reset_duration=%ds",
+			channelId, modelName, durationSeconds))
 	}
 
 	skipChannelIds, ok := utils.GetGinValue[[]int](c, "skip_channel_ids")
@@ -321,8 +426,17 @@ func applyPreMappingBeforeRequest(c *gin.Context) {
 		c.Set("original_model", nil)
 		c.Set("new_model", nil)
 		c.Set("billing_original_model", nil)
+		c.Set("virtual_model_route", nil)
+		c.Set("virtual_model", nil)
+		c.Set("virtual_model_chain", nil)
+		c.Set("channel_key_index", nil)
+		c.Set("skip_key_selection", false)
+		// 预选渠道不会发出请求，归还占用的熔断试探名额
+		model.ChannelGroup.ReleaseCircuitTrial(c)
 	}()
 
+	// 密钥在最终选中渠道时才选择，避免预选占用密钥的轮询顺序和请求计数
+	c.Set("skip_key_selection", true)
 	provider, _, err := GetProvider(c, requestBody.Model)
 	if err != nil {
 		return
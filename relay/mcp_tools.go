@@ -0,0 +1,486 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/requester"
+	"done-hub/common/utils"
+	"done-hub/mcp/remote"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"done-hub/relay/relay_util"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getMcpServerIds MCP 服务只能由管理员挂载到分组，服务的请求头中可能带有鉴权信息
+func getMcpServerIds(c *gin.Context) []int {
+	groupName := c.GetString("token_group")
+	if groupName == "" {
+		groupName = c.GetString("group")
+	}
+
+	return model.GlobalUserGroupRatio.GetMcpServers(groupName)
+}
+
+// mcpToolLoop 把挂载的 MCP 工具声明给模型，模型调用这些工具时由网关执行并把结果交回模型，直到得到最终回答
+// 第一轮的用量由 RelayHandler 结算，之后的每一轮在结束后单独结算，日志中记录轮次
+type mcpToolLoop struct {
+	relay        *relayChat
+	chatProvider providersBase.ChatInterface
+	session      *remote.Session
+	firstUsage   *types.Usage
+	rounds       []*mcpRound
+	executed     int
+
+	// 执行工具时对话追加在请求上，结束后恢复，重试时不会带上失败尝试的工具调用记录
+	messages   []types.ChatCompletionMessage
+	tools      []*types.ChatCompletionTool
+	toolChoice any
+}
+
+type mcpRound struct {
+	number   int
+	usage    *types.Usage
+	tools    []string
+	consumed bool
+}
+
+// newMcpToolLoop 连接挂载的服务并把工具追加到请求中，没有可用工具时返回 nil
+func (r *relayChat) newMcpToolLoop(chatProvider providersBase.ChatInterface) *mcpToolLoop {
+	if len(r.mcpServerIds) == 0 || config.McpMaxIterations <= 0 {
+		return nil
+	}
+
+	ctx := r.c.Request.Context()
+	servers, err := model.GetEnabledMcpServersByIds(r.mcpServerIds)
+	if err != nil {
+		logger.LogError(ctx, "mcp_servers_load_failed error=\""+err.Error()+"\"")
+		return nil
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	session := remote.Connect(ctx, servers)
+	if len(session.Tools()) == 0 {
+		return nil
+	}
+
+	loop := &mcpToolLoop{
+		relay:        r,
+		chatProvider: chatProvider,
+		session:      session,
+		firstUsage:   r.provider.GetUsage(),
+		messages:     r.chatRequest.Messages,
+		tools:        r.chatRequest.Tools,
+		toolChoice:   r.chatRequest.ToolChoice,
+	}
+	r.mcp = loop
+
+	// 客户端自带的同名工具优先
+	existing := make(map[string]bool, len(r.chatRequest.Tools))
+	for _, tool := range r.chatRequest.Tools {
+		existing[tool.Function.Name] = true
+	}
+	for _, tool := range session.Tools() {
+		if existing[tool.Name] {
+			continue
+		}
+
+		description := tool.Tool.Description
+		if tool.Server.Description != "" {
+			description = fmt.Sprintf("[%s: %s] %s", tool.Server.Name, tool.Server.Description, description)
+		}
+		r.chatRequest.Tools = append(r.chatRequest.Tools, &types.ChatCompletionTool{
+			Type: types.ChatMessageRoleFunction,
+			Function: types.ChatCompletionFunction{
+				Name:        tool.Name,
+				Description: description,
+				Parameters:  tool.Parameters(),
+			},
+		})
+	}
+
+	return loop
+}
+
+// isMcpToolCalls 只有全部是 MCP 工具时才由网关执行，混有客户端工具时原样返回给客户端
+func (l *mcpToolLoop) isMcpToolCalls(toolCalls []*types.ChatCompletionToolCalls) bool {
+	if len(toolCalls) == 0 {
+		return false
+	}
+
+	for _, toolCall := range toolCalls {
+		if toolCall.Function == nil || l.session.GetTool(toolCall.Function.Name) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *mcpToolLoop) canContinue() bool {
+	return l.relay.c.Request.Context().Err() == nil
+}
+
+// execute 执行工具调用，并把模型的调用与工具结果追加到对话中
+func (l *mcpToolLoop) execute(content any, toolCalls []*types.ChatCompletionToolCalls) []string {
+	r := l.relay
+	ctx := r.c.Request.Context()
+	channel := r.provider.GetChannel()
+	l.executed++
+
+	messages := make([]types.ChatCompletionMessage, 0, len(r.chatRequest.Messages)+len(toolCalls)+1)
+	messages = append(messages, r.chatRequest.Messages...)
+	messages = append(messages, types.ChatCompletionMessage{
+		Role:      types.ChatMessageRoleAssistant,
+		Content:   content,
+		ToolCalls: toolCalls,
+	})
+
+	names := make([]string, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		if toolCall.Id == "" {
+			toolCall.Id = "call_" + utils.GetUUID()
+		}
+		if toolCall.Type == "" {
+			toolCall.Type = types.ChatMessageRoleFunction
+		}
+
+		tool := l.session.GetTool(toolCall.Function.Name)
+		names = append(names, tool.Name)
+
+		startTime := time.Now()
+		result, err := l.session.Call(ctx, tool.Name, toolCall.Function.Arguments)
+		duration := time.Since(startTime).Milliseconds()
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("mcp_tool_call model=%s channel_id=%d round=%d server_id=%d tool=%s duration=%dms error=\"%s\"",
+				r.modelName, channel.Id, l.executed, tool.Server.Id, tool.Tool.Name, duration, err.Error()))
+			result = "Error: " + err.Error()
+		} else {
+			logger.LogInfo(ctx, fmt.Sprintf("mcp_tool_call model=%s channel_id=%d round=%d server_id=%d tool=%s duration=%dms result_length=%d",
+				r.modelName, channel.Id, l.executed, tool.Server.Id, tool.Tool.Name, duration, len(result)))
+		}
+
+		messages = append(messages, types.ChatCompletionMessage{
+			Role:       types.ChatMessageRoleTool,
+			ToolCallID: toolCall.Id,
+			Content:    result,
+		})
+	}
+	r.chatRequest.Messages = messages
+
+	// 强制调用工具只对第一轮生效，达到轮数上限后要求模型直接回答
+	if toolType, _ := r.chatRequest.ParseToolChoice(); toolType == types.ToolChoiceTypeRequired || toolType == types.ToolChoiceTypeFunction {
+		r.chatRequest.ToolChoice = types.ToolChoiceTypeAuto
+	}
+	if l.executed >= config.McpMaxIterations {
+		r.chatRequest.ToolChoice = types.ToolChoiceTypeNone
+	}
+
+	return names
+}
+
+// nextRound 结算上一轮并为下一轮准备新的用量
+func (l *mcpToolLoop) nextRound(tools []string) {
+	r := l.relay
+	l.consumeRounds()
+
+	usage := &types.Usage{
+		PromptTokens: common.CountTokenMessages(r.chatRequest.Messages, r.modelName, r.provider.GetChannel().PreCost),
+	}
+	r.provider.SetUsage(usage)
+	l.rounds = append(l.rounds, &mcpRound{number: len(l.rounds) + 2, usage: usage, tools: tools})
+}
+
+// consumeRounds 结算还没有结算的轮次，第一轮由 RelayHandler 结算
+func (l *mcpToolLoop) consumeRounds() {
+	r := l.relay
+	for _, round := range l.pendingRounds() {
+		r.c.Set("mcp_round", round.number)
+		r.c.Set("mcp_tools", round.tools)
+		quota := relay_util.NewQuota(r.c, r.getModelName(), round.usage.PromptTokens)
+		quota.Consume(r.c, round.usage, r.IsStream())
+	}
+
+	// 重试时新的第一轮仍然记录为第一轮
+	r.c.Set("mcp_round", 1)
+	r.c.Set("mcp_tools", []string{})
+}
+
+// pendingRounds 取出还没有结算的轮次并标记为已结算
+// 每一轮都把完整的对话发给了上游，没有输出的轮次同样按提示词计费
+func (l *mcpToolLoop) pendingRounds() []*mcpRound {
+	var pending []*mcpRound
+	for _, round := range l.rounds {
+		if round.consumed {
+			continue
+		}
+		round.consumed = true
+		l.settleUsage(round.usage)
+		pending = append(pending, round)
+	}
+
+	return pending
+}
+
+// settleUsage 上游没有返回输出用量时按输出文本计算
+func (l *mcpToolLoop) settleUsage(usage *types.Usage) {
+	if usage.CompletionTokens == 0 && usage.TextBuilder.Len() > 0 {
+		usage.CompletionTokens = common.CountTokenText(usage.TextBuilder.String(), l.relay.getModelName())
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+}
+
+func (l *mcpToolLoop) finish() {
+	l.consumeRounds()
+
+	r := l.relay
+	r.chatRequest.Messages = l.messages
+	r.chatRequest.Tools = l.tools
+	r.chatRequest.ToolChoice = l.toolChoice
+}
+
+// totalUsage 返回给客户端的用量包含所有轮次
+func (l *mcpToolLoop) totalUsage() *types.Usage {
+	usage := &types.Usage{}
+	for _, item := range append([]*types.Usage{l.firstUsage}, l.roundUsages()...) {
+		completionTokens := item.CompletionTokens
+		if completionTokens == 0 && item.TextBuilder.Len() > 0 {
+			completionTokens = common.CountTokenText(item.TextBuilder.String(), l.relay.getModelName())
+		}
+		usage.PromptTokens += item.PromptTokens
+		usage.CompletionTokens += completionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return usage
+}
+
+func (l *mcpToolLoop) roundUsages() []*types.Usage {
+	usages := make([]*types.Usage, 0, len(l.rounds))
+	for _, round := range l.rounds {
+		usages = append(usages, round.usage)
+	}
+	return usages
+}
+
+// complete 非流式请求，循环执行工具直到模型不再调用 MCP 工具
+func (l *mcpToolLoop) complete(response *types.ChatCompletionResponse) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	for len(response.Choices) == 1 && l.isMcpToolCalls(response.Choices[0].Message.ToolCalls) && l.canContinue() {
+		message := response.Choices[0].Message
+		tools := l.execute(message.Content, message.ToolCalls)
+		l.nextRound(tools)
+
+		request := l.relay.toolEmulationRequest()
+		var errWithCode *types.OpenAIErrorWithStatusCode
+		response, errWithCode = l.chatProvider.CreateChatCompletion(request)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+		if request != &l.relay.chatRequest {
+			applyEmulatedToolCalls(response, &l.relay.chatRequest)
+		}
+	}
+
+	return response, nil
+}
+
+func (l *mcpToolLoop) createStream() (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	request := l.relay.toolEmulationRequest()
+	stream, errWithCode := l.chatProvider.CreateChatCompletionStream(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	if request != &l.relay.chatRequest {
+		stream = newToolEmulationStream(stream, &l.relay.chatRequest)
+	}
+
+	return stream, nil
+}
+
+// mcpToolStream 流式请求中文本内容直接转发，工具调用与结束标记先缓存
+// 一轮结束时如果全部是 MCP 工具调用，丢弃缓存并在执行工具后发起下一轮，否则把缓存的内容补发给客户端
+type mcpToolStream struct {
+	loop *mcpToolLoop
+
+	sync.Mutex
+	stream requester.StreamReaderInterface[string]
+
+	id      string
+	created any
+	model   string
+
+	dataChan chan string
+	errChan  chan error
+}
+
+func newMcpToolStream(loop *mcpToolLoop, stream requester.StreamReaderInterface[string]) requester.StreamReaderInterface[string] {
+	return &mcpToolStream{
+		loop:     loop,
+		stream:   stream,
+		dataChan: make(chan string),
+		errChan:  make(chan error),
+	}
+}
+
+func (s *mcpToolStream) Recv() (<-chan string, <-chan error) {
+	common.SafeGoroutine(s.forward)
+
+	return s.dataChan, s.errChan
+}
+
+func (s *mcpToolStream) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stream.Close()
+}
+
+func (s *mcpToolStream) forward() {
+	for {
+		s.Lock()
+		stream := s.stream
+		s.Unlock()
+
+		dataChan, errChan := stream.Recv()
+		round := &mcpStreamRound{toolCalls: make(map[int]*types.ChatCompletionToolCalls)}
+		err := s.pipe(round, dataChan, errChan)
+
+		toolCalls := round.sortedToolCalls()
+		if !errors.Is(err, io.EOF) || !s.loop.isMcpToolCalls(toolCalls) || !s.loop.canContinue() {
+			for _, data := range round.held {
+				s.dataChan <- data
+			}
+			s.errChan <- err
+			return
+		}
+
+		var content any
+		if round.content.Len() > 0 {
+			content = round.content.String()
+		}
+		tools := s.loop.execute(content, toolCalls)
+		s.loop.nextRound(tools)
+
+		next, errWithCode := s.loop.createStream()
+		if errWithCode != nil {
+			s.errChan <- errors.New(errWithCode.Message)
+			return
+		}
+
+		s.Lock()
+		s.stream.Close()
+		s.stream = next
+		s.Unlock()
+	}
+}
+
+type mcpStreamRound struct {
+	content   strings.Builder
+	toolCalls map[int]*types.ChatCompletionToolCalls
+	held      []string
+}
+
+func (s *mcpToolStream) pipe(round *mcpStreamRound, dataChan <-chan string, errChan <-chan error) error {
+	for {
+		select {
+		case data, ok := <-dataChan:
+			if !ok {
+				return io.EOF
+			}
+			if data, hold := s.record(round, data); hold {
+				round.held = append(round.held, data)
+			} else {
+				s.dataChan <- data
+			}
+		case err, ok := <-errChan:
+			if !ok {
+				return io.EOF
+			}
+			return err
+		}
+	}
+}
+
+// record 累积工具调用，后续轮次改写 id、model 等字段，让客户端看到的是同一个响应
+func (s *mcpToolStream) record(round *mcpStreamRound, data string) (string, bool) {
+	var chunk types.ChatCompletionStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return data, false
+	}
+
+	continued := len(s.loop.rounds) > 0
+	if s.id == "" {
+		s.id, s.created, s.model = chunk.ID, chunk.Created, chunk.Model
+	}
+
+	hold := len(chunk.Choices) == 0
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		round.content.WriteString(choice.Delta.Content)
+		if choice.FinishReason != nil {
+			hold = true
+		}
+		if continued {
+			choice.Delta.Role = ""
+		}
+
+		for _, toolCall := range choice.Delta.ToolCalls {
+			hold = true
+			current, ok := round.toolCalls[toolCall.Index]
+			if !ok {
+				current = &types.ChatCompletionToolCalls{
+					Index:    toolCall.Index,
+					Type:     types.ChatMessageRoleFunction,
+					Function: &types.ChatCompletionToolCallsFunction{},
+				}
+				round.toolCalls[toolCall.Index] = current
+			}
+			if toolCall.Id != "" {
+				current.Id = toolCall.Id
+			}
+			if toolCall.Function != nil {
+				if toolCall.Function.Name != "" {
+					current.Function.Name = toolCall.Function.Name
+				}
+				current.Function.Arguments += toolCall.Function.Arguments
+			}
+		}
+	}
+
+	if !continued {
+		return data, hold
+	}
+
+	chunk.ID, chunk.Created, chunk.Model = s.id, s.created, s.model
+	responseBody, err := json.Marshal(chunk)
+	if err != nil {
+		return data, hold
+	}
+
+	return string(responseBody), hold
+}
+
+func (r *mcpStreamRound) sortedToolCalls() []*types.ChatCompletionToolCalls {
+	toolCalls := make([]*types.ChatCompletionToolCalls, 0, len(r.toolCalls))
+	for _, toolCall := range r.toolCalls {
+		toolCalls = append(toolCalls, toolCall)
+	}
+	sort.Slice(toolCalls, func(i, j int) bool {
+		return toolCalls[i].Index < toolCalls[j].Index
+	})
+
+	return toolCalls
+}
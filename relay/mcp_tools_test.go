@@ -0,0 +1,94 @@
+package relay
+
+import (
+	"done-hub/types"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMcpToolLoop(request types.ChatCompletionRequest) *mcpToolLoop {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	r := &relayChat{
+		relayBase:   relayBase{c: c, modelName: "gpt-4o"},
+		chatRequest: request,
+	}
+
+	loop := &mcpToolLoop{
+		relay:      r,
+		firstUsage: &types.Usage{PromptTokens: 100, CompletionTokens: 20},
+		messages:   r.chatRequest.Messages,
+		tools:      r.chatRequest.Tools,
+		toolChoice: r.chatRequest.ToolChoice,
+	}
+	r.mcp = loop
+
+	return loop
+}
+
+func TestMcpPendingRounds(t *testing.T) {
+	loop := newTestMcpToolLoop(types.ChatCompletionRequest{})
+	loop.rounds = []*mcpRound{
+		{number: 2, usage: &types.Usage{PromptTokens: 150, CompletionTokens: 30}, tools: []string{"mcp_1_search"}},
+		// 上游没有输出的轮次仍然按提示词计费
+		{number: 3, usage: &types.Usage{PromptTokens: 200}, tools: []string{"mcp_1_fetch"}},
+	}
+
+	pending := loop.pendingRounds()
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, 2, pending[0].number)
+		assert.Equal(t, 180, pending[0].usage.TotalTokens)
+		assert.Equal(t, 3, pending[1].number)
+		assert.Equal(t, 200, pending[1].usage.PromptTokens)
+		assert.Equal(t, 200, pending[1].usage.TotalTokens)
+	}
+
+	// 已结算的轮次不会重复计费
+	assert.Empty(t, loop.pendingRounds())
+
+	loop.rounds = append(loop.rounds, &mcpRound{number: 4, usage: &types.Usage{PromptTokens: 250, CompletionTokens: 5}})
+	pending = loop.pendingRounds()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 4, pending[0].number)
+	}
+}
+
+func TestMcpTotalUsage(t *testing.T) {
+	loop := newTestMcpToolLoop(types.ChatCompletionRequest{})
+	loop.rounds = []*mcpRound{
+		{number: 2, usage: &types.Usage{PromptTokens: 150, CompletionTokens: 30}},
+		{number: 3, usage: &types.Usage{PromptTokens: 200}},
+	}
+
+	usage := loop.totalUsage()
+	assert.Equal(t, 450, usage.PromptTokens)
+	assert.Equal(t, 50, usage.CompletionTokens)
+	assert.Equal(t, 500, usage.TotalTokens)
+}
+
+func TestMcpFinishRestoresRequest(t *testing.T) {
+	messages := []types.ChatCompletionMessage{{Role: types.ChatMessageRoleUser, Content: "weather in Paris?"}}
+	tools := []*types.ChatCompletionTool{{Type: "function", Function: types.ChatCompletionFunction{Name: "client_tool"}}}
+	loop := newTestMcpToolLoop(types.ChatCompletionRequest{
+		Messages:   messages,
+		Tools:      tools,
+		ToolChoice: types.ToolChoiceTypeRequired,
+	})
+
+	r := loop.relay
+	r.chatRequest.Tools = append(r.chatRequest.Tools, &types.ChatCompletionTool{Type: "function", Function: types.ChatCompletionFunction{Name: "mcp_1_search"}})
+	r.chatRequest.Messages = append(r.chatRequest.Messages,
+		types.ChatCompletionMessage{Role: types.ChatMessageRoleAssistant, ToolCalls: []*types.ChatCompletionToolCalls{{Id: "call_1"}}},
+		types.ChatCompletionMessage{Role: types.ChatMessageRoleTool, ToolCallID: "call_1", Content: "sunny"},
+	)
+	r.chatRequest.ToolChoice = types.ToolChoiceTypeNone
+
+	loop.finish()
+
+	assert.Equal(t, messages, r.chatRequest.Messages)
+	assert.Equal(t, tools, r.chatRequest.Tools)
+	assert.Equal(t, types.ToolChoiceTypeRequired, r.chatRequest.ToolChoice)
+	assert.Equal(t, 1, r.c.GetInt("mcp_round"))
+}
@@ -1,17 +1,21 @@
 package relay
 
 import (
+	"crypto/sha256"
 	"done-hub/common"
 	"done-hub/common/config"
 	"done-hub/common/utils"
 	"done-hub/model"
 	"done-hub/providers/claude"
 	"done-hub/providers/gemini"
+	"done-hub/providers/ollama"
 	"done-hub/types"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -157,6 +161,51 @@ func ListGeminiModelsByToken(c *gin.Context) {
 	})
 }
 
+// ListOllamaModelsByToken 以 Ollama /api/tags 的格式返回令牌可用的模型
+func ListOllamaModelsByToken(c *gin.Context) {
+	groupName := c.GetString("token_group")
+	if groupName == "" {
+		groupName = c.GetString("group")
+	}
+
+	if groupName == "" {
+		c.JSON(http.StatusServiceUnavailable, ollama.OllamaError{Error: "分组不存在"})
+		return
+	}
+
+	models, err := model.ChannelGroup.GetGroupModels(groupName)
+	if err != nil {
+		c.JSON(200, ollama.ModelListResponse{
+			Models: []ollama.ModelInfo{},
+		})
+		return
+	}
+	sort.Strings(models)
+
+	// 根据令牌的模型限制过滤模型列表
+	models = filterModelsByTokenLimit(c, models)
+
+	modifiedAt := time.Now().UTC()
+	ollamaModels := make([]ollama.ModelInfo, 0, len(models))
+	for _, modelName := range models {
+		digest := sha256.Sum256([]byte(modelName))
+		ollamaModels = append(ollamaModels, ollama.ModelInfo{
+			Name:       modelName,
+			Model:      modelName,
+			ModifiedAt: modifiedAt,
+			Digest:     hex.EncodeToString(digest[:]),
+			Details: ollama.ModelDetails{
+				Format: "api",
+				Family: modelName,
+			},
+		})
+	}
+
+	c.JSON(200, ollama.ModelListResponse{
+		Models: ollamaModels,
+	})
+}
+
 func ListClaudeModelsByToken(c *gin.Context) {
 	groupName := c.GetString("token_group")
 	if groupName == "" {
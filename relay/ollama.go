@@ -0,0 +1,391 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/requester"
+	providersBase "done-hub/providers/base"
+	"done-hub/providers/ollama"
+	"done-hub/safty"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 返回给客户端的 Ollama 版本号，部分客户端会据此判断接口是否可用
+const ollamaCompatibleVersion = "0.9.0"
+
+// relayOllamaOnly 处理 Ollama 格式的 /api/chat 与 /api/generate 请求
+// 实现 Ollama格式 -> OpenAI格式 -> 上游接口 -> OpenAI响应 -> Ollama格式 的转换
+type relayOllamaOnly struct {
+	relayBase
+	generate    bool
+	chatRequest *types.ChatCompletionRequest
+}
+
+func NewRelayOllamaOnly(c *gin.Context) *relayOllamaOnly {
+	relay := &relayOllamaOnly{
+		relayBase: relayBase{
+			c: c,
+		},
+		generate: strings.HasSuffix(c.Request.URL.Path, "/generate"),
+	}
+
+	return relay
+}
+
+func (r *relayOllamaOnly) setRequest() error {
+	if r.generate {
+		generateRequest := &ollama.ApiGenerateRequest{}
+		if err := common.UnmarshalBodyReusable(r.c, generateRequest); err != nil {
+			return err
+		}
+		if generateRequest.Prompt == "" {
+			return errors.New("prompt is required")
+		}
+		r.chatRequest = generateRequest.ToChatCompletionRequest()
+	} else {
+		chatRequest := &ollama.ApiChatRequest{}
+		if err := common.UnmarshalBodyReusable(r.c, chatRequest); err != nil {
+			return err
+		}
+		if len(chatRequest.Messages) == 0 {
+			return errors.New("messages is required")
+		}
+		r.chatRequest = chatRequest.ToChatCompletionRequest()
+	}
+
+	if r.chatRequest.Model == "" {
+		return errors.New("model is required")
+	}
+
+	r.setOriginalModel(r.chatRequest.Model)
+	// 设置原始模型到 Context，用于统一请求响应模型功能
+	r.c.Set("original_model", r.chatRequest.Model)
+
+	return nil
+}
+
+func (r *relayOllamaOnly) getRequest() interface{} {
+	return r.chatRequest
+}
+
+func (r *relayOllamaOnly) IsStream() bool {
+	return r.chatRequest.Stream
+}
+
+func (r *relayOllamaOnly) getPromptTokens() (int, error) {
+	channel := r.provider.GetChannel()
+	return common.CountTokenMessages(r.chatRequest.Messages, r.modelName, channel.PreCost), nil
+}
+
+func (r *relayOllamaOnly) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	chatProvider, ok := r.provider.(providersBase.ChatInterface)
+	if !ok {
+		err = common.StringErrorWrapperLocal("channel not implemented", "channel_error", http.StatusServiceUnavailable)
+		done = true
+		return
+	}
+
+	r.chatRequest.Model = r.modelName
+	// 内容审查
+	if config.EnableSafe {
+		for _, message := range r.chatRequest.Messages {
+			if message.Content != nil {
+				CheckResult, _ := safty.CheckContent(message.Content)
+				if !CheckResult.IsSafe {
+					err = common.StringErrorWrapperLocal(CheckResult.Reason, CheckResult.Code, http.StatusBadRequest)
+					done = true
+					return
+				}
+			}
+		}
+	}
+
+	if r.chatRequest.Stream {
+		var stream requester.StreamReaderInterface[string]
+		stream, err = chatProvider.CreateChatCompletionStream(r.chatRequest)
+		if err != nil {
+			return
+		}
+
+		var firstResponseTime time.Time
+		firstResponseTime, err = r.convertOpenAIStreamToOllama(stream)
+		r.SetFirstResponseTime(firstResponseTime)
+	} else {
+		var response *types.ChatCompletionResponse
+		response, err = chatProvider.CreateChatCompletion(r.chatRequest)
+		if err != nil {
+			return
+		}
+
+		ollamaResponse := ollama.ConvertChatOpenaiToOllama(response, r.getOriginalModel())
+		ollamaResponse.TotalDuration = r.totalDuration()
+		if r.generate {
+			err = responseJsonClient(r.c, ollamaResponse.ToGenerateResponse())
+		} else {
+			err = responseJsonClient(r.c, ollamaResponse)
+		}
+	}
+
+	if err != nil {
+		done = true
+	}
+
+	return
+}
+
+func (r *relayOllamaOnly) totalDuration() int64 {
+	requestStartTime := r.c.GetTime("requestStartTime")
+	if requestStartTime.IsZero() {
+		return 0
+	}
+
+	return time.Since(requestStartTime).Nanoseconds()
+}
+
+// convertOpenAIStreamToOllama 将 OpenAI 流式响应转换为 Ollama 的 NDJSON 流式响应
+func (r *relayOllamaOnly) convertOpenAIStreamToOllama(stream requester.StreamReaderInterface[string]) (firstResponseTime time.Time, errWithOP *types.OpenAIErrorWithStatusCode) {
+	r.c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+	r.c.Writer.Header().Set("Cache-Control", "no-cache")
+	r.c.Writer.WriteHeader(http.StatusOK)
+
+	dataChan, errChan := stream.Recv()
+	defer stream.Close()
+
+	converter := ollama.NewOpenAIStreamToOllama(r.getOriginalModel())
+	ctx := r.c.Request.Context()
+	clientDisconnected := false
+
+	writeResponse := func(response *ollama.ChatResponse) {
+		if response == nil || clientDisconnected {
+			return
+		}
+
+		var line any = response
+		if r.generate {
+			line = response.ToGenerateResponse()
+		}
+
+		responseBody, err := json.Marshal(line)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			clientDisconnected = true
+		default:
+			r.c.Writer.Write(append(responseBody, '\n'))
+			r.c.Writer.Flush()
+		}
+	}
+
+	for {
+		select {
+		case rawLine, ok := <-dataChan:
+			if !ok {
+				return
+			}
+
+			if firstResponseTime.IsZero() {
+				firstResponseTime = time.Now()
+			}
+
+			data := strings.TrimSpace(strings.TrimPrefix(rawLine, "data: "))
+			if data == "" || data == "[DONE]" {
+				continue
+			}
+
+			var chunk types.ChatCompletionStreamResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				continue
+			}
+
+			// 客户端断开后继续消费数据以确保计费准确，但不写入
+			writeResponse(converter.Convert(&chunk))
+
+		case err := <-errChan:
+			if !errors.Is(err, io.EOF) {
+				errWithOP = common.StringErrorWrapper(err.Error(), "stream_error", 900)
+				logger.LogError(ctx, "Stream err:"+err.Error())
+				if !clientDisconnected {
+					r.HandleStreamError(errWithOP)
+				}
+				return
+			}
+
+			response := converter.Finish(r.provider.GetUsage())
+			response.TotalDuration = r.totalDuration()
+			writeResponse(response)
+			return
+
+		case <-ctx.Done():
+			clientDisconnected = true
+		}
+	}
+}
+
+func (r *relayOllamaOnly) GetError(err *types.OpenAIErrorWithStatusCode) (int, any) {
+	newErr := FilterOpenAIErr(r.c, err)
+
+	return newErr.StatusCode, ollama.OllamaError{
+		Error: newErr.Message,
+	}
+}
+
+func (r *relayOllamaOnly) HandleJsonError(err *types.OpenAIErrorWithStatusCode) {
+	statusCode, response := r.GetError(err)
+	r.c.JSON(statusCode, response)
+}
+
+func (r *relayOllamaOnly) HandleStreamError(err *types.OpenAIErrorWithStatusCode) {
+	_, response := r.GetError(err)
+
+	str, jsonErr := json.Marshal(response)
+	if jsonErr != nil {
+		return
+	}
+	r.c.Writer.Write(append(str, '\n'))
+	r.c.Writer.Flush()
+}
+
+// relayOllamaEmbed 处理 Ollama 格式的 /api/embed 与旧版 /api/embeddings 请求
+type relayOllamaEmbed struct {
+	relayBase
+	legacy  bool
+	request types.EmbeddingRequest
+}
+
+func NewRelayOllamaEmbed(c *gin.Context) *relayOllamaEmbed {
+	relay := &relayOllamaEmbed{
+		legacy: strings.HasSuffix(c.Request.URL.Path, "/embeddings"),
+	}
+	relay.c = c
+	return relay
+}
+
+func (r *relayOllamaEmbed) setRequest() error {
+	embedRequest := &ollama.EmbeddingRequest{}
+	if err := common.UnmarshalBodyReusable(r.c, embedRequest); err != nil {
+		return err
+	}
+
+	r.request.Model = embedRequest.Model
+	r.request.Input = embedRequest.Input
+	if r.legacy {
+		r.request.Input = embedRequest.Prompt
+	}
+
+	if r.request.Model == "" {
+		return errors.New("model is required")
+	}
+
+	r.setOriginalModel(r.request.Model)
+
+	return nil
+}
+
+func (r *relayOllamaEmbed) getPromptTokens() (int, error) {
+	return common.CountTokenInput(r.request.Input, r.modelName), nil
+}
+
+func (r *relayOllamaEmbed) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
+	provider, ok := r.provider.(providersBase.EmbeddingsInterface)
+	if !ok {
+		err = common.StringErrorWrapperLocal("channel not implemented", "channel_error", http.StatusServiceUnavailable)
+		done = true
+		return
+	}
+
+	// 内容审查
+	if config.EnableSafe {
+		if r.request.Input != nil {
+			CheckResult, _ := safty.CheckContent(r.request)
+			if !CheckResult.IsSafe {
+				err = common.StringErrorWrapperLocal(CheckResult.Reason, CheckResult.Code, http.StatusBadRequest)
+				done = true
+				return
+			}
+		}
+	}
+
+	r.request.Model = r.modelName
+
+	response, err := provider.CreateEmbeddings(&r.request)
+	if err != nil {
+		return
+	}
+
+	ollamaResponse := &ollama.EmbeddingResponse{
+		Model: r.getOriginalModel(),
+	}
+	if response.Usage != nil {
+		ollamaResponse.PromptEvalCount = response.Usage.PromptTokens
+	}
+
+	embeddings := make([][]float64, 0, len(response.Data))
+	for _, data := range response.Data {
+		embeddings = append(embeddings, embeddingToFloats(data.Embedding))
+	}
+
+	if r.legacy {
+		if len(embeddings) > 0 {
+			ollamaResponse.Embedding = embeddings[0]
+		}
+	} else {
+		ollamaResponse.Embeddings = embeddings
+	}
+
+	err = responseJsonClient(r.c, ollamaResponse)
+	if err != nil {
+		done = true
+	}
+
+	return
+}
+
+// embeddingToFloats 上游响应解析后的向量可能是 []float64 或 []any
+func embeddingToFloats(embedding any) []float64 {
+	switch value := embedding.(type) {
+	case []float64:
+		return value
+	case []any:
+		floats := make([]float64, 0, len(value))
+		for _, item := range value {
+			if number, ok := item.(float64); ok {
+				floats = append(floats, number)
+			}
+		}
+		return floats
+	}
+
+	return []float64{}
+}
+
+func (r *relayOllamaEmbed) GetError(err *types.OpenAIErrorWithStatusCode) (int, any) {
+	newErr := FilterOpenAIErr(r.c, err)
+
+	return newErr.StatusCode, ollama.OllamaError{
+		Error: newErr.Message,
+	}
+}
+
+func (r *relayOllamaEmbed) HandleJsonError(err *types.OpenAIErrorWithStatusCode) {
+	statusCode, response := r.GetError(err)
+	r.c.JSON(statusCode, response)
+}
+
+// OllamaVersion 返回兼容的 Ollama 版本号
+func OllamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, ollama.VersionResponse{
+		Version: ollamaCompatibleVersion,
+	})
+}
@@ -0,0 +1,19 @@
+package relay
+
+import (
+	"done-hub/model"
+	"done-hub/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordPrefixCacheUsage 按渠道记录上游提示词缓存的命中情况，区分是否经粘性路由选中，用于评估前缀亲和的效果
+func recordPrefixCacheUsage(c *gin.Context, usage *types.Usage) {
+	if usage == nil {
+		return
+	}
+
+	// OpenAI / Gemini / DeepSeek 返回 cached_tokens，Claude 返回 cache_read_input_tokens
+	cachedTokens := max(usage.PromptTokensDetails.CachedTokens, usage.PromptTokensDetails.CachedReadTokens)
+	model.RecordPrefixCacheUsage(c.GetString("channel_group"), c.GetInt("channel_id"), c.GetBool("sticky_session_hit"), usage.PromptTokens, cachedTokens)
+}
@@ -0,0 +1,162 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求体无法识别时按大小粗略估算，平均每个 token 约 4 个字节
+const rateLimitBytesPerToken = 4
+
+// 与 common.CountTokenMessages 一致，每条消息和回复各有 3 个格式 tokens
+const rateLimitTokensPerMessage = 3
+
+// rateLimitEstimateRequest 估算 tokens 需要的字段，兼容 OpenAI、Claude 与 Gemini 格式的请求体
+type rateLimitEstimateRequest struct {
+	Messages            []rateLimitEstimateMessage `json:"messages"`
+	Contents            []rateLimitEstimateMessage `json:"contents"`
+	System              any                        `json:"system"`
+	Prompt              any                        `json:"prompt"`
+	Input               any                        `json:"input"`
+	MaxTokens           int                        `json:"max_tokens"`
+	MaxCompletionTokens int                        `json:"max_completion_tokens"`
+	MaxOutputTokens     int                        `json:"max_output_tokens"`
+	GenerationConfig    struct {
+		MaxOutputTokens int `json:"maxOutputTokens"`
+	} `json:"generationConfig"`
+}
+
+type rateLimitEstimateMessage struct {
+	Content any `json:"content"`
+	Parts   any `json:"parts"`
+}
+
+// rateLimitReservation 本次请求在渠道上预留的额度，请求结束后按实际的 tokens 修正
+type rateLimitReservation struct {
+	channel   *model.Channel
+	modelName string
+	tokens    int
+}
+
+// estimatePromptTokens 按请求中的消息与最大输出 tokens 估算本次请求的 tokens，结果保存在上下文中，重试时请求体可能已被释放
+func estimatePromptTokens(c *gin.Context) int {
+	if tokens, ok := utils.GetGinValue[int](c, "rate_limit_tokens"); ok {
+		return tokens
+	}
+
+	tokens := 0
+	if body, ok := utils.GetGinValue[[]byte](c, config.GinRequestBodyKey); ok {
+		tokens = estimateRequestTokens(body, c.GetString("original_model"))
+	}
+	c.Set("rate_limit_tokens", tokens)
+
+	return tokens
+}
+
+func estimateRequestTokens(body []byte, modelName string) int {
+	var request rateLimitEstimateRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return len(body) / rateLimitBytesPerToken
+	}
+
+	var text strings.Builder
+	messages := 0
+	for _, message := range append(request.Messages, request.Contents...) {
+		messages++
+		collectEstimateText(&text, message.Content)
+		collectEstimateText(&text, message.Parts)
+	}
+	collectEstimateText(&text, request.System)
+	collectEstimateText(&text, request.Prompt)
+	collectEstimateText(&text, request.Input)
+
+	if text.Len() == 0 && messages == 0 {
+		return len(body) / rateLimitBytesPerToken
+	}
+
+	tokens := common.CountTokenText(text.String(), modelName) + (messages+1)*rateLimitTokensPerMessage
+	maxTokens := max(request.MaxTokens, request.MaxCompletionTokens, request.MaxOutputTokens, request.GenerationConfig.MaxOutputTokens)
+
+	return tokens + maxTokens
+}
+
+// collectEstimateText 收集文本内容，图片等其他类型的内容不计入
+func collectEstimateText(text *strings.Builder, value any) {
+	switch v := value.(type) {
+	case string:
+		text.WriteString(v)
+		text.WriteString("\n")
+	case []any:
+		for _, item := range v {
+			collectEstimateText(text, item)
+		}
+	case map[string]any:
+		if itemText, ok := v["text"].(string); ok {
+			text.WriteString(itemText)
+			text.WriteString("\n")
+		}
+		// Responses API 的 input 为消息列表
+		if content, ok := v["content"]; ok {
+			collectEstimateText(text, content)
+		}
+	}
+}
+
+// filterRateLimitedChannels 跳过剩余 RPM/TPM 额度不足以完成本次请求的渠道，与并发已满的渠道一样进入排队等待额度恢复
+// 与 filterSaturatedChannels 相同，额度在构建过滤器时按候选渠道查询
+func filterRateLimitedChannels(c *gin.Context, candidates []*model.Channel, modelName string) model.ChannelsFilterFunc {
+	tokens := estimatePromptTokens(c)
+	limited := make(map[int]bool, len(candidates))
+	for _, channel := range candidates {
+		limited[channel.Id] = !channel.HasRateLimitHeadroom(modelName, tokens)
+	}
+
+	return func(channelId int, choice *model.ChannelChoice) bool {
+		full, ok := limited[channelId]
+		if !ok {
+			full = !choice.Channel.HasRateLimitHeadroom(modelName, tokens)
+		}
+		if !full {
+			return false
+		}
+
+		c.Set("channel_saturated", true)
+		return true
+	}
+}
+
+// reserveChannelRateLimit 选中渠道后预留本次请求的额度
+func reserveChannelRateLimit(c *gin.Context, channel *model.Channel) bool {
+	modelName := c.GetString("matched_model")
+	tokens := estimatePromptTokens(c)
+	if !channel.ReserveRateLimit(modelName, tokens) {
+		return false
+	}
+
+	c.Set("channel_rate_limit", &rateLimitReservation{
+		channel:   channel,
+		modelName: modelName,
+		tokens:    tokens,
+	})
+	return true
+}
+
+// settleChannelRateLimit 请求结束后按实际消耗的 tokens 修正预留的额度，没有统计到用量时保留预估值
+func settleChannelRateLimit(c *gin.Context, totalTokens int) {
+	reservation, ok := utils.GetGinValue[*rateLimitReservation](c, "channel_rate_limit")
+	if !ok || reservation == nil {
+		return
+	}
+	c.Set("channel_rate_limit", nil)
+
+	if totalTokens <= 0 {
+		return
+	}
+	reservation.channel.AdjustRateLimitTokens(reservation.modelName, reservation.tokens, totalTokens)
+}
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"done-hub/common/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateRequestTokens(t *testing.T) {
+	approximate := config.ApproximateTokenEnabled
+	config.ApproximateTokenEnabled = true
+	defer func() { config.ApproximateTokenEnabled = approximate }()
+
+	// 近似计算时每个字节约 0.38 个 token，"hello world\n" 为 4 个 token
+	cases := []struct {
+		name   string
+		body   string
+		tokens int
+	}{
+		{
+			name:   "chat messages with max_tokens",
+			body:   `{"model":"gpt-4o","messages":[{"role":"user","content":"hello world"}],"max_tokens":100}`,
+			tokens: 4 + 2*rateLimitTokensPerMessage + 100,
+		},
+		{
+			name:   "content parts skip images",
+			body:   `{"messages":[{"role":"user","content":[{"type":"text","text":"hello world"},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}],"max_completion_tokens":50}`,
+			tokens: 4 + 2*rateLimitTokensPerMessage + 50,
+		},
+		{
+			name:   "claude system and messages",
+			body:   `{"system":[{"type":"text","text":"hello world"}],"messages":[{"role":"user","content":"hello world"}],"max_tokens":10}`,
+			tokens: 9 + 2*rateLimitTokensPerMessage + 10,
+		},
+		{
+			name:   "gemini contents",
+			body:   `{"contents":[{"role":"user","parts":[{"text":"hello world"}]}],"generationConfig":{"maxOutputTokens":20}}`,
+			tokens: 4 + 2*rateLimitTokensPerMessage + 20,
+		},
+		{
+			name:   "responses input",
+			body:   `{"input":[{"role":"user","content":[{"type":"input_text","text":"hello world"}]}],"max_output_tokens":30}`,
+			tokens: 4 + rateLimitTokensPerMessage + 30,
+		},
+		{
+			name:   "unknown body falls back to size",
+			body:   `{"file":"abcdefghijklmnop"}`,
+			tokens: 6,
+		},
+		{
+			name:   "invalid json falls back to size",
+			body:   `not json at all`,
+			tokens: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.tokens, estimateRequestTokens([]byte(c.body), "gpt-4o"))
+		})
+	}
+}
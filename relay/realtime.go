@@ -7,6 +7,7 @@ import (
 	"done-hub/common/requester"
 	"done-hub/common/utils"
 	"done-hub/metrics"
+	"done-hub/model"
 	providersBase "done-hub/providers/base"
 	"done-hub/relay/relay_util"
 	"done-hub/types"
@@ -22,6 +23,7 @@ type RelayModeChatRealtime struct {
 	relayBase
 	userConn       *websocket.Conn
 	messageHandler requester.MessageHandler
+	bridge         requester.WSBridge
 	providerConn   *websocket.Conn
 	quota          *relay_util.Quota
 	usage          *types.UsageEvent
@@ -47,6 +49,8 @@ func ChatRealtime(c *gin.Context) {
 		common.AbortWithMessage(c, http.StatusInternalServerError, "upgrade_failed")
 		return
 	}
+	// 连接关闭后才归还渠道的并发名额
+	defer releaseChannelConcurrency(c)
 
 	relay := &RelayModeChatRealtime{
 		relayBase: relayBase{
@@ -64,7 +68,12 @@ func ChatRealtime(c *gin.Context) {
 
 	relay.usage = &types.UsageEvent{}
 
-	wsProxy := requester.NewWSProxy(relay.userConn, relay.providerConn, time.Minute*2, relay.messageHandler, relay.usageHandler)
+	var wsProxy *requester.WSProxy
+	if relay.bridge != nil {
+		wsProxy = requester.NewWSBridgeProxy(relay.userConn, relay.providerConn, time.Minute*2, relay.bridge, relay.usageHandler)
+	} else {
+		wsProxy = requester.NewWSProxy(relay.userConn, relay.providerConn, time.Minute*2, relay.messageHandler, relay.usageHandler)
+	}
 
 	wsProxy.Start()
 
@@ -79,7 +88,9 @@ func ChatRealtime(c *gin.Context) {
 
 		logger.LogInfo(relay.c.Request.Context(), fmt.Sprintf("连接由%s关闭", closedBy))
 		wsProxy.Close()
-		relay.quota.Consume(relay.c, relay.usage.ToChatUsage(), false)
+		usage := relay.usage.ToChatUsage()
+		relay.quota.Consume(relay.c, usage, false)
+		settleChannelRateLimit(relay.c, usage.TotalTokens)
 
 	}()
 
@@ -100,21 +111,44 @@ func (r *RelayModeChatRealtime) getProvider() bool {
 	}
 
 	for i := retryTimes; i > 0; i-- {
-		// 找不到直接返回
-		if err := r.setProvider(r.getOriginalModel()); err != nil {
+		// 找不到直接返回，getProvider 被本类型覆盖，使用内嵌的 relayBase 选择渠道
+		if err := setProviderWithConcurrency(&r.relayBase); err != nil {
 			r.abortWithMessage(err.Error())
 			return false
 		}
 
+		channel := r.provider.GetChannel()
+		connectStart := time.Now()
+
+		// 需要转换协议的上游优先判断，部分 provider 内嵌了 OpenAIProvider
+		if bridgeProvider, ok := r.provider.(providersBase.RealtimeBridgeInterface); ok {
+			providerConn, bridge, apiErr := bridgeProvider.CreateRealtimeBridge(r.modelName)
+			if apiErr != nil {
+				r.connectFailed(channel, connectStart, apiErr)
+				r.skipChannelIds(channel.Id)
+				logger.LogError(r.c.Request.Context(), fmt.Sprintf("using channel #%d(%s) Error: %s to retry (remain times %d)", channel.Id, channel.Name, apiErr.Error(), i))
+				metrics.RecordProvider(r.c, apiErr.StatusCode)
+
+				continue
+			}
+
+			r.bridge = bridge
+			r.providerConn = providerConn
+			recordChannelAttempt(r.c, channel, connectStart, time.Time{}, nil)
+			metrics.RecordProvider(r.c, 200)
+			return true
+		}
+
 		realtimeProvider, ok := r.provider.(providersBase.RealtimeInterface)
 		if !ok {
+			model.ChannelGroup.ReleaseCircuitTrial(r.c)
 			r.abortWithMessage("channel not implemented")
 			return false
 		}
-		channel := r.provider.GetChannel()
 
 		providerConn, messageHandler, apiErr := realtimeProvider.CreateChatRealtime(r.modelName)
 		if apiErr != nil {
+			r.connectFailed(channel, connectStart, apiErr)
 			r.skipChannelIds(channel.Id)
 			logger.LogError(r.c.Request.Context(), fmt.Sprintf("using channel #%d(%s) Error: %s to retry (remain times %d)", channel.Id, channel.Name, apiErr.Error(), i))
 			metrics.RecordProvider(r.c, apiErr.StatusCode)
@@ -126,10 +160,12 @@ func (r *RelayModeChatRealtime) getProvider() bool {
 		r.providerConn = providerConn
 
 		if r.getRealtimeFirstMessage() {
+			recordChannelAttempt(r.c, channel, connectStart, time.Time{}, nil)
 			metrics.RecordProvider(r.c, 200)
 			return true
 		}
 
+		r.connectFailed(channel, connectStart, common.StringErrorWrapper("read first message failed", "channel_error", http.StatusBadGateway))
 		r.skipChannelIds(channel.Id)
 	}
 
@@ -137,6 +173,12 @@ func (r *RelayModeChatRealtime) getProvider() bool {
 	return false
 }
 
+// connectFailed 记录连接失败并归还本次尝试占用的限流额度，并发名额在下一次选择渠道时归还
+func (r *RelayModeChatRealtime) connectFailed(channel *model.Channel, connectStart time.Time, apiErr *types.OpenAIErrorWithStatusCode) {
+	recordChannelAttempt(r.c, channel, connectStart, time.Time{}, apiErr)
+	settleChannelRateLimit(r.c, 0)
+}
+
 func (r *RelayModeChatRealtime) skipChannelIds(channelId int) {
 	skipChannelIds, ok := utils.GetGinValue[[]int](r.c, "skip_channel_ids")
 	if !ok {
@@ -5,6 +5,7 @@ import (
 	"done-hub/common"
 	"done-hub/common/config"
 	"done-hub/common/logger"
+	"done-hub/common/utils"
 	"done-hub/model"
 	"done-hub/types"
 	"errors"
@@ -30,6 +31,17 @@ type Quota struct {
 	userId           int
 	channelId        int
 	tokenId          int
+	batchId          string
+	cacheHit         bool
+	streamSegment    int
+	mcpRound         int
+	mcpTools         []string
+	contextSummary   bool
+	virtualModel     string
+	virtualChain     []string
+	attemptCount     int
+	hedgeLost        bool
+	keyIndex         int // 多密钥渠道中提供服务的密钥序号，-1 表示不是多密钥渠道
 	HandelStatus     bool
 
 	startTime         time.Time
@@ -48,6 +60,7 @@ func NewQuota(c *gin.Context, modelName string, promptTokens int) *Quota {
 		tokenId:       c.GetInt("token_id"),
 		HandelStatus:  false,
 		isBackupGroup: isBackupGroup, // 记录是否使用备用分组
+		keyIndex:      -1,
 	}
 
 	quota.price = *model.PricingInstance.GetPrice(quota.modelName)
@@ -64,6 +77,26 @@ func NewQuota(c *gin.Context, modelName string, promptTokens int) *Quota {
 	}
 
 	quota.groupRatio = c.GetFloat64("group_ratio") // 这里的倍率已经在 common.go 中正确设置了
+	quota.batchId = c.GetString("batch_id")
+	if quota.batchId != "" {
+		// 批量任务按实际使用的分组叠加折扣
+		quota.groupRatio *= model.GlobalUserGroupRatio.GetBatchRatio(c.GetString("token_group"))
+	}
+	quota.cacheHit = c.GetBool("response_cache_hit")
+	quota.streamSegment = c.GetInt("stream_segment")
+	quota.mcpRound = c.GetInt("mcp_round")
+	quota.mcpTools = c.GetStringSlice("mcp_tools")
+	quota.contextSummary = c.GetBool("context_summary")
+	quota.virtualModel = c.GetString("virtual_model")
+	quota.virtualChain = append([]string(nil), c.GetStringSlice("virtual_model_chain")...)
+	quota.attemptCount = c.GetInt("attempt_count")
+	if keyIndex, ok := utils.GetGinValue[int](c, "channel_key_index"); ok {
+		quota.keyIndex = keyIndex
+	}
+	if quota.cacheHit {
+		// 命中响应缓存时按分组的缓存倍率计费
+		quota.groupRatio *= model.GlobalUserGroupRatio.GetCacheRatio(c.GetString("token_group"))
+	}
 	quota.inputRatio = quota.price.GetInput() * quota.groupRatio
 	quota.outputRatio = quota.price.GetOutput() * quota.groupRatio
 
@@ -208,6 +241,35 @@ func (q *Quota) Consume(c *gin.Context, usage *types.Usage, isStream bool) {
 	}(c.Request.Context())
 }
 
+// ConsumeHedgeLoser 对冲请求中落败的一方只归还预扣额度，并记录一条不计费的日志
+func (q *Quota) ConsumeHedgeLoser(c *gin.Context, usage *types.Usage, isStream bool) {
+	q.Undo(c)
+
+	tokenName := c.GetString("token_name")
+	sourceIp := c.ClientIP()
+	q.startTime = c.GetTime("requestStartTime")
+	q.hedgeLost = true
+	q.attemptCount = max(q.attemptCount, 1)
+
+	go func(ctx context.Context) {
+		model.RecordConsumeLog(
+			ctx,
+			q.userId,
+			q.channelId,
+			usage.PromptTokens,
+			usage.CompletionTokens,
+			q.modelName,
+			tokenName,
+			0,
+			"",
+			q.getRequestTime(),
+			isStream,
+			q.GetLogMeta(usage),
+			sourceIp,
+		)
+	}(c.Request.Context())
+}
+
 func (q *Quota) GetInputRatio() float64 {
 	return q.inputRatio
 }
@@ -242,6 +304,50 @@ func (q *Quota) GetLogMeta(usage *types.Usage) map[string]any {
 		meta["extra_billing"] = q.extraBillingData
 	}
 
+	if q.batchId != "" {
+		meta["batch_id"] = q.batchId
+	}
+
+	if q.cacheHit {
+		meta["response_cache_hit"] = true
+	}
+
+	// 流式输出中途断开后在其他渠道续写的片段
+	if q.streamSegment > 0 {
+		meta["stream_segment"] = q.streamSegment
+	}
+
+	// 网关执行 MCP 工具时的上游请求轮次，以及这一轮之前执行的工具
+	if q.mcpRound > 0 {
+		meta["mcp_round"] = q.mcpRound
+		if len(q.mcpTools) > 0 {
+			meta["mcp_tools"] = q.mcpTools
+		}
+	}
+
+	// 对话超出上下文长度时总结被裁剪内容的请求
+	if q.contextSummary {
+		meta["context_summary"] = true
+	}
+
+	if q.keyIndex >= 0 {
+		meta["key_index"] = q.keyIndex
+	}
+
+	// 对冲请求与重试中的第几次尝试，落败的对冲请求不计费
+	if q.hedgeLost {
+		meta["hedge_lost"] = true
+		meta["attempt_count"] = q.attemptCount
+	} else if q.attemptCount > 1 {
+		meta["attempt_count"] = q.attemptCount
+	}
+
+	// 虚拟模型以及按顺序尝试过的真实模型，最后一个为实际提供服务的模型
+	if q.virtualModel != "" {
+		meta["virtual_model"] = q.virtualModel
+		meta["virtual_model_chain"] = q.virtualChain
+	}
+
 	return meta
 }
 
@@ -71,6 +71,16 @@ func (converter *OpenAIResponsesStreamConverter) initializeResponse(request *typ
 	}
 }
 
+// SetResponseID 指定响应ID，未指定时使用上游返回的ID
+func (converter *OpenAIResponsesStreamConverter) SetResponseID(id string) {
+	converter.responses.ID = id
+}
+
+// GetResponses 获取流结束后汇总的完整响应
+func (converter *OpenAIResponsesStreamConverter) GetResponses() *types.OpenAIResponsesResponses {
+	return converter.responses
+}
+
 func (converter *OpenAIResponsesStreamConverter) ProcessStreamData(jsonStr string) {
 	if jsonStr == "[DONE]" {
 		converter.finalizeStream()
@@ -85,7 +95,9 @@ func (converter *OpenAIResponsesStreamConverter) ProcessStreamData(jsonStr strin
 
 	// 第一次响应创建response.created
 	if converter.isFirstResponse {
-		converter.responses.ID = response.ID
+		if converter.responses.ID == "" {
+			converter.responses.ID = response.ID
+		}
 		converter.responses.CreatedAt = response.Created
 		converter.responses.Model = response.Model
 		converter.sendStreamResponse("response.created", converter.populateResponseData)
@@ -21,6 +21,8 @@ func RelayRerank(c *gin.Context) {
 		c.Set(config.GinProcessedBodyKey, nil)
 		c.Set(config.GinProcessedBodyIsVertexAI, nil)
 	}()
+	// 请求结束后归还渠道的并发名额
+	defer releaseChannelConcurrency(c)
 
 	relay := NewRelayRerank(c)
 
@@ -29,13 +31,29 @@ func RelayRerank(c *gin.Context) {
 		return
 	}
 
-	if err := relay.setProvider(relay.getOriginalModel()); err != nil {
-		common.AbortWithErr(c, http.StatusServiceUnavailable, &types.RerankError{Detail: err.Error()})
+	cacher := newResponseCacher(relay)
+	hit, cacheErr := cacher.replay(relay)
+	if cacheErr != nil {
+		relayRerankResponseWithErr(c, cacheErr)
+		return
+	}
+	if hit {
 		return
 	}
 
+	if err := setProviderWithConcurrency(relay); err != nil {
+		statusCode := http.StatusServiceUnavailable
+		if isChannelQueueError(err) {
+			statusCode = http.StatusTooManyRequests
+		}
+		common.AbortWithErr(c, statusCode, &types.RerankError{Detail: err.Error()})
+		return
+	}
+	cacher.capture(c)
+
 	apiErr, done := RelayHandler(relay)
 	if apiErr == nil {
+		cacher.save(relay)
 		return
 	}
 
@@ -74,8 +92,10 @@ func RelayRerank(c *gin.Context) {
 	for i := actualRetryTimes; i > 0; i-- {
 		// 冻结通道
 		shouldCooldowns(c, channel, apiErr)
-		if err := relay.setProvider(relay.getOriginalModel()); err != nil {
-			continue
+		if err := setProviderWithConcurrency(relay); err != nil {
+			logger.LogError(c.Request.Context(), fmt.Sprintf("retry_provider_error model=%s channel_id=%d error=\"%s\"",
+				modelName, channel.Id, err.Error()))
+			break
 		}
 
 		channel = relay.getProvider().GetChannel()
@@ -110,6 +130,7 @@ func RelayRerank(c *gin.Context) {
 			// 重试成功
 			logger.LogInfo(c.Request.Context(), fmt.Sprintf("retry_success model=%s channel_id=%d attempt=%d/%d total_channels=%d",
 				modelName, channel.Id, attemptCount, actualRetryTimes, c.GetInt("total_channels_at_start")))
+			cacher.save(relay)
 			return
 		}
 
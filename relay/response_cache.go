@@ -0,0 +1,225 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"done-hub/common/cache"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/model"
+	"done-hub/relay/relay_util"
+	"done-hub/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type responseCacheEntry struct {
+	Body             string `json:"body"`
+	ModelName        string `json:"model_name"` // 保存时实际计费的模型，命中时还没有选择渠道，无法得到映射后的模型
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// responseCacher 对确定性请求做精确匹配缓存，key 由用户、模型和规范化后的请求体生成
+type responseCacher struct {
+	key    string
+	ttl    time.Duration
+	writer *responseCacheWriter
+}
+
+// responseCacheWriter 在写给客户端的同时记录响应内容
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	maxSize  int
+	overflow bool
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseCacheWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+
+	if w.body.Len()+len(data) > w.maxSize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+
+	w.body.Write(data)
+}
+
+// newResponseCacher 令牌或分组开启了响应缓存且请求是确定性的时候返回缓存器，否则返回 nil
+// 需要在 setRequest 之后、send 修改请求之前调用
+func newResponseCacher(relay RelayBaseInterface) *responseCacher {
+	c := relay.getContext()
+	ttl := getResponseCacheTTL(c)
+	if ttl <= 0 || !isResponseCacheable(relay) {
+		return nil
+	}
+
+	body, err := json.Marshal(relay.getRequest())
+	if err != nil {
+		return nil
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.URL.Path))
+	hash.Write(body)
+
+	return &responseCacher{
+		key: fmt.Sprintf("response_cache:%d:%s:%s", c.GetInt("id"), relay.getOriginalModel(), hex.EncodeToString(hash.Sum(nil))),
+		ttl: ttl,
+	}
+}
+
+// getResponseCacheTTL 令牌设置优先，其次是分组设置，返回 0 表示不缓存
+func getResponseCacheTTL(c *gin.Context) time.Duration {
+	ttl := config.ResponseCacheTTL
+	enabled := false
+
+	if tokenSetting, ok := c.Get("token_setting"); ok {
+		if setting, ok := tokenSetting.(*model.TokenSetting); ok && setting != nil && setting.ResponseCache.Enabled {
+			enabled = true
+			if setting.ResponseCache.TTL > 0 {
+				ttl = setting.ResponseCache.TTL
+			}
+		}
+	}
+
+	if !enabled {
+		groupName := c.GetString("token_group")
+		if groupName == "" {
+			groupName = c.GetString("group")
+		}
+		enabled = model.GlobalUserGroupRatio.IsCacheEnabled(groupName)
+	}
+
+	if !enabled || ttl <= 0 {
+		return 0
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+// isResponseCacheable 只缓存结果确定的请求：embeddings、rerank 以及 temperature 为 0 的单条 chat
+func isResponseCacheable(relay RelayBaseInterface) bool {
+	switch r := relay.(type) {
+	case *relayEmbeddings, *relayRerank:
+		return true
+	case *relayChat:
+		request := r.chatRequest
+		if request.N != nil && *request.N > 1 {
+			return false
+		}
+		return request.Temperature != nil && *request.Temperature == 0
+	default:
+		return false
+	}
+}
+
+// replay 命中缓存时直接返回缓存内容并按缓存倍率计费，余额不足时返回错误且不输出缓存内容
+// 需要在选择渠道之前调用，命中缓存的请求不占用渠道的并发、限流和熔断试探名额
+func (rc *responseCacher) replay(relay RelayBaseInterface) (bool, *types.OpenAIErrorWithStatusCode) {
+	if rc == nil {
+		return false, nil
+	}
+
+	c := relay.getContext()
+	entry, err := cache.GetResponseCache[responseCacheEntry](rc.key)
+	if err != nil {
+		c.Header("X-Cache", "MISS")
+		return false, nil
+	}
+
+	// 响应并未经过渠道，不计入渠道的用量
+	c.Set("channel_id", 0)
+
+	usage := &types.Usage{
+		PromptTokens:     entry.PromptTokens,
+		CompletionTokens: entry.CompletionTokens,
+		TotalTokens:      entry.PromptTokens + entry.CompletionTokens,
+	}
+
+	modelName := entry.ModelName
+	if modelName == "" {
+		modelName = relay.getOriginalModel()
+	}
+
+	quota := relay_util.NewQuota(c, modelName, usage.PromptTokens)
+	if quotaErr := quota.PreQuotaConsumption(); quotaErr != nil {
+		return true, quotaErr
+	}
+
+	c.Header("X-Cache", "HIT")
+	c.Set("response_cache_hit", true)
+	responseCache(c, entry.Body, relay.IsStream())
+	quota.Consume(c, usage, relay.IsStream())
+
+	logger.LogInfo(c.Request.Context(), fmt.Sprintf("response_cache_hit model=%s prompt_tokens=%d completion_tokens=%d", relay.getOriginalModel(), usage.PromptTokens, usage.CompletionTokens))
+
+	return true, nil
+}
+
+// capture 开始记录写给客户端的响应，需要在发送请求前调用
+func (rc *responseCacher) capture(c *gin.Context) {
+	if rc == nil {
+		return
+	}
+
+	rc.writer = &responseCacheWriter{
+		ResponseWriter: c.Writer,
+		maxSize:        config.ResponseCacheMaxSize * 1024,
+	}
+	c.Writer = rc.writer
+}
+
+// save 请求成功后保存响应，心跳内容不会被缓存
+func (rc *responseCacher) save(relay RelayBaseInterface) {
+	if rc == nil || rc.writer == nil || rc.writer.overflow || rc.writer.Status() != http.StatusOK {
+		return
+	}
+
+	body := rc.writer.body.String()
+	if relay.IsStream() {
+		body = strings.ReplaceAll(body, relay_util.HeartbeatStreamText, "")
+	} else {
+		body = strings.TrimLeft(body, relay_util.HeartbeatJsonText)
+	}
+
+	if body == "" {
+		return
+	}
+
+	usage := relay.getProvider().GetUsage()
+	if usage == nil {
+		return
+	}
+
+	entry := responseCacheEntry{
+		Body:             body,
+		ModelName:        relay.getModelName(),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}
+
+	if err := cache.SetResponseCache(rc.key, entry, rc.ttl); err != nil {
+		logger.LogWarn(relay.getContext().Request.Context(), "response_cache_save_failed error=\""+err.Error()+"\"")
+	}
+}
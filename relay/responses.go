@@ -3,10 +3,12 @@ package relay
 import (
 	"done-hub/common"
 	"done-hub/common/requester"
+	"done-hub/common/utils"
 	providersBase "done-hub/providers/base"
 	"done-hub/relay/relay_util"
 	"done-hub/types"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -18,6 +20,9 @@ import (
 type relayResponses struct {
 	relayBase
 	responsesRequest types.OpenAIResponsesRequest
+	// 通过本地保存的响应续接对话时，原始的 previous_response_id 与本轮输入
+	previousResponseId string
+	currentInput       []types.InputResponses
 }
 
 func NewRelayResponses(c *gin.Context) *relayResponses {
@@ -33,7 +38,7 @@ func (r *relayResponses) setRequest() error {
 
 	r.setOriginalModel(r.responsesRequest.Model)
 
-	return nil
+	return r.loadPreviousResponses()
 }
 
 func (r *relayResponses) getRequest() interface{} {
@@ -104,14 +109,28 @@ func (r *relayResponses) compatibleSend(chatProvider providersBase.ChatInterface
 		return common.ErrorWrapperLocal(err, "invalid_claude_config", http.StatusInternalServerError), true
 	}
 
+	// previous_response_id 不是本地保存的响应，兼容模式无法续接
+	if r.responsesRequest.PreviousResponseID != "" {
+		return common.StringErrorWrapperLocal(fmt.Sprintf("Previous response with id '%s' not found.", r.responsesRequest.PreviousResponseID), "invalid_request_error", http.StatusNotFound), true
+	}
+
+	responseId := ""
+	if r.shouldStore() {
+		responseId = "resp_" + utils.GetUUID()
+	}
+
 	if r.responsesRequest.Stream {
 		var response requester.StreamReaderInterface[string]
 		response, errWithCode = chatProvider.CreateChatCompletionStream(chatReq)
 		if errWithCode != nil {
 			return
 		}
-		firstResponseTime := r.chatToResponseStreamClient(response)
+		firstResponseTime, responseResp := r.chatToResponseStreamClient(response, responseId)
 		r.SetFirstResponseTime(firstResponseTime)
+
+		if responseId != "" && responseResp != nil {
+			r.storeResponse(responseResp)
+		}
 	} else {
 		var response *types.ChatCompletionResponse
 		response, errWithCode = chatProvider.CreateChatCompletion(chatReq)
@@ -120,6 +139,11 @@ func (r *relayResponses) compatibleSend(chatProvider providersBase.ChatInterface
 		}
 
 		responseResp := response.ToResponses(&r.responsesRequest)
+		responseResp.PreviousResponseID = r.previousResponseId
+		if responseId != "" {
+			responseResp.ID = responseId
+			r.storeResponse(responseResp)
+		}
 		responseJsonClient(r.c, responseResp)
 	}
 
@@ -130,8 +154,8 @@ func (r *relayResponses) compatibleSend(chatProvider providersBase.ChatInterface
 	return
 }
 
-// 将chat转换成兼容的responses流处理
-func (r *relayResponses) chatToResponseStreamClient(stream requester.StreamReaderInterface[string]) (firstResponseTime time.Time) {
+// 将chat转换成兼容的responses流处理，正常结束时返回汇总的完整响应
+func (r *relayResponses) chatToResponseStreamClient(stream requester.StreamReaderInterface[string], responseId string) (firstResponseTime time.Time, responses *types.OpenAIResponsesResponses) {
 	requester.SetEventStreamHeaders(r.c)
 	dataChan, errChan := stream.Recv()
 
@@ -145,6 +169,10 @@ func (r *relayResponses) chatToResponseStreamClient(stream requester.StreamReade
 	var isFirstResponse bool
 
 	converter := relay_util.NewOpenAIResponsesStreamConverter(r.c, &r.responsesRequest, r.provider.GetUsage())
+	if responseId != "" {
+		converter.SetResponseID(responseId)
+	}
+	converter.GetResponses().PreviousResponseID = r.previousResponseId
 
 	// 在新的goroutine中处理stream数据
 	gopool.Go(func() {
@@ -186,6 +214,7 @@ func (r *relayResponses) chatToResponseStreamClient(stream requester.StreamReade
 				} else {
 					// 要发送最后的完成状态
 					converter.ProcessStreamData("[DONE]")
+					responses = converter.GetResponses()
 				}
 				return
 			}
@@ -194,5 +223,5 @@ func (r *relayResponses) chatToResponseStreamClient(stream requester.StreamReade
 
 	// 等待处理完成
 	<-done
-	return firstResponseTime
+	return firstResponseTime, responses
 }
@@ -0,0 +1,153 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 续接对话时最多向前追溯的响应数量
+const responsesMaxChainDepth = 100
+
+// RetrieveResponse 获取兼容模式下保存的响应
+func RetrieveResponse(c *gin.Context) {
+	stored := getStoredResponse(c)
+	if stored == nil {
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", []byte(stored.Response))
+}
+
+// DeleteResponse 删除兼容模式下保存的响应，之后无法再以它作为 previous_response_id
+func DeleteResponse(c *gin.Context) {
+	stored := getStoredResponse(c)
+	if stored == nil {
+		return
+	}
+
+	if err := stored.Delete(); err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, &types.OpenAIResponsesDeleteResponse{
+		ID:      stored.ResponseId,
+		Object:  "response",
+		Deleted: true,
+	})
+}
+
+func getStoredResponse(c *gin.Context) *model.StoredResponse {
+	responseId := c.Param("id")
+	stored, err := model.GetStoredResponse(c.GetInt("token_id"), responseId)
+	if err != nil {
+		common.AbortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	if stored == nil {
+		common.AbortWithMessage(c, http.StatusNotFound, fmt.Sprintf("Response with id '%s' not found.", responseId))
+		return nil
+	}
+
+	return stored
+}
+
+// loadPreviousResponses previous_response_id 为本地保存的响应时，将整条对话链展开为完整输入
+// 不是本地保存的响应则保持原样，交给原生支持 Responses 的渠道处理
+func (r *relayResponses) loadPreviousResponses() error {
+	previousResponseId := r.responsesRequest.PreviousResponseID
+	if previousResponseId == "" {
+		return nil
+	}
+
+	chain, err := model.GetStoredResponseChain(r.c.GetInt("token_id"), previousResponseId, responsesMaxChainDepth)
+	if err != nil {
+		return err
+	}
+
+	if len(chain) == 0 {
+		return nil
+	}
+
+	currentInput, err := r.responsesRequest.ParseInput()
+	if err != nil {
+		return err
+	}
+
+	inputs := make([]types.InputResponses, 0)
+	for _, stored := range chain {
+		inputs = append(inputs, storedResponseToInputs(stored)...)
+	}
+	inputs = append(inputs, currentInput...)
+
+	r.previousResponseId = previousResponseId
+	r.currentInput = currentInput
+	r.responsesRequest.PreviousResponseID = ""
+	r.responsesRequest.Input = inputs
+
+	return nil
+}
+
+// shouldStore 与 OpenAI 保持一致，未指定 store 时默认保存
+func (r *relayResponses) shouldStore() bool {
+	return r.responsesRequest.Store == nil || *r.responsesRequest.Store
+}
+
+func (r *relayResponses) storeResponse(response *types.OpenAIResponsesResponses) {
+	currentInput := r.currentInput
+	if r.previousResponseId == "" {
+		currentInput, _ = r.responsesRequest.ParseInput()
+	}
+
+	inputData, err := json.Marshal(currentInput)
+	if err != nil {
+		return
+	}
+
+	responseData, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	stored := &model.StoredResponse{
+		ResponseId:         response.ID,
+		UserId:             r.c.GetInt("id"),
+		TokenId:            r.c.GetInt("token_id"),
+		PreviousResponseId: r.previousResponseId,
+		Model:              r.originalModel,
+		Input:              string(inputData),
+		Response:           string(responseData),
+		CreatedAt:          utils.GetTimestamp(),
+	}
+
+	if err := stored.Insert(); err != nil {
+		logger.LogError(r.c.Request.Context(), fmt.Sprintf("response_store_failed response_id=%s error=\"%s\"", response.ID, err.Error()))
+	}
+}
+
+func storedResponseToInputs(stored *model.StoredResponse) []types.InputResponses {
+	inputs := make([]types.InputResponses, 0)
+	_ = json.Unmarshal([]byte(stored.Input), &inputs)
+
+	var response types.OpenAIResponsesResponses
+	if err := json.Unmarshal([]byte(stored.Response), &response); err != nil {
+		return inputs
+	}
+
+	for _, output := range response.Output {
+		if input := output.ToInputResponses(); input != nil {
+			inputs = append(inputs, *input)
+		}
+	}
+
+	return inputs
+}
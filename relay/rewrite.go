@@ -0,0 +1,135 @@
+package relay
+
+import (
+	"bytes"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/rewrite"
+	"done-hub/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyChannelRewriteRules 每次选择渠道后按该渠道的 request 规则改写请求体，并重新解析请求
+// 规则总是作用于客户端的原始请求体，上一次尝试改写过而本次渠道没有命中的规则时恢复原始请求体
+func applyChannelRewriteRules(relay RelayBaseInterface) error {
+	c := relay.getContext()
+	if c.Request.Method != http.MethodPost || !strings.Contains(c.ContentType(), "json") {
+		return nil
+	}
+
+	channel := relay.getProvider().GetChannel()
+	rules := channel.LoadedRewriteRules()
+	rewrittenBy := c.GetInt("rewrite_channel_id")
+	if len(rules) == 0 && rewrittenBy == 0 {
+		return nil
+	}
+
+	original, ok := getOriginalRequestBody(c)
+	if !ok {
+		return nil
+	}
+
+	body := original
+	appliedBy := 0
+	if len(rules) > 0 {
+		var requestMap map[string]any
+		if err := json.Unmarshal(original, &requestMap); err != nil {
+			return nil
+		}
+		// 上游使用的模型由渠道的模型映射决定，规则不能修改 model 字段
+		requestModel, hasModel := requestMap["model"]
+		if rewrite.Apply(rules, rewrite.PhaseRequest, relay.getOriginalModel(), requestMap) > 0 {
+			if hasModel {
+				requestMap["model"] = requestModel
+			}
+			rewritten, err := json.Marshal(requestMap)
+			if err != nil {
+				return nil
+			}
+			body = rewritten
+			appliedBy = channel.Id
+		}
+	}
+
+	if appliedBy == 0 && rewrittenBy == 0 {
+		return nil
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	c.Set(config.GinProcessedBodyKey, nil)
+	c.Set(config.GinProcessedBodyIsVertexAI, nil)
+	// 重新解析前清空上一次解析的结果，被规则删除的字段不会残留
+	if request := reflect.ValueOf(relay.getRequest()); request.Kind() == reflect.Pointer && !request.IsNil() {
+		request.Elem().Set(reflect.Zero(request.Elem().Type()))
+	}
+	if err := relay.setRequest(); err != nil {
+		// 不会向该渠道发出请求，归还已经占用的名额
+		releaseChannelConcurrency(c)
+		settleChannelRateLimit(c, 0)
+		model.ChannelGroup.ReleaseCircuitTrial(c)
+		return fmt.Errorf("request rewritten by channel %d is invalid: %s", channel.Id, err.Error())
+	}
+	c.Set("rewrite_channel_id", appliedBy)
+
+	return nil
+}
+
+// getOriginalRequestBody 第一次改写前保存客户端的请求体，之后的尝试都基于它改写
+func getOriginalRequestBody(c *gin.Context) ([]byte, bool) {
+	if body, ok := c.Get("rewrite_original_body"); ok {
+		if bodyBytes, ok := body.([]byte); ok {
+			return bodyBytes, true
+		}
+	}
+
+	body, ok := c.Get(config.GinRequestBodyKey)
+	if !ok {
+		return nil, false
+	}
+	bodyBytes, ok := body.([]byte)
+	if !ok || len(bodyBytes) == 0 {
+		return nil, false
+	}
+
+	c.Set("rewrite_original_body", bodyBytes)
+	return bodyBytes, true
+}
+
+// rewriteResponseBody 按实际使用渠道的 response 规则改写非流式响应
+func rewriteResponseBody(c *gin.Context, body []byte) []byte {
+	channel := model.ChannelGroup.GetChannel(c.GetInt("channel_id"))
+	if channel == nil {
+		return body
+	}
+
+	rules := channel.LoadedRewriteRules()
+	if len(rules) == 0 {
+		return body
+	}
+
+	var responseMap map[string]any
+	if err := json.Unmarshal(body, &responseMap); err != nil {
+		return body
+	}
+
+	if rewrite.Apply(rules, rewrite.PhaseResponse, c.GetString("original_model"), responseMap) == 0 {
+		return body
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(responseMap); err != nil {
+		logger.LogError(c.Request.Context(), "rewrite_response_failed:"+err.Error())
+		return body
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
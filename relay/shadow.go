@@ -0,0 +1,254 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/model"
+	"done-hub/providers"
+	providersBase "done-hub/providers/base"
+	"done-hub/relay/relay_util"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 正在进行的影子请求数量
+var shadowRunning atomic.Int64
+
+// shadowMirror 按规则把一部分对话请求异步复制到影子渠道，客户端只会收到主请求的响应
+// 影子请求不计费，也不会冻结或禁用影子渠道
+type shadowMirror struct {
+	rule    *model.ShadowRule
+	channel *model.Channel
+	request *types.ChatCompletionRequest
+	writer  *shadowWriter
+}
+
+// shadowWriter 在写给客户端的同时记录主请求的响应，超出长度的部分不记录
+type shadowWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	maxSize int
+}
+
+func (w *shadowWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *shadowWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *shadowWriter) capture(data []byte) {
+	if remain := w.maxSize - w.body.Len(); remain > 0 {
+		w.body.Write(data[:min(remain, len(data))])
+	}
+}
+
+// newShadowMirror 对话请求命中影子流量规则并被抽中时返回 shadowMirror，否则返回 nil
+// 需要在 setProvider 之后、send 修改请求之前调用
+func newShadowMirror(relay RelayBaseInterface) *shadowMirror {
+	chat, ok := relay.(*relayChat)
+	if !ok {
+		return nil
+	}
+
+	c := relay.getContext()
+	rule := model.GlobalShadowRules.Find(c.GetString("token_group"), relay.getOriginalModel())
+	if rule == nil || rand.Intn(100) >= rule.Percent {
+		return nil
+	}
+
+	channel := model.ChannelGroup.GetChannel(rule.ChannelId)
+	if channel == nil || channel.Status != config.ChannelStatusEnabled {
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("shadow_skip rule_id=%d channel_id=%d reason=\"channel not available\"", rule.Id, rule.ChannelId))
+		return nil
+	}
+
+	body, err := json.Marshal(chat.chatRequest)
+	if err != nil {
+		return nil
+	}
+	request := &types.ChatCompletionRequest{}
+	if err := json.Unmarshal(body, request); err != nil {
+		return nil
+	}
+
+	writer := &shadowWriter{
+		ResponseWriter: c.Writer,
+		maxSize:        config.ShadowMaxResponseSize * 1024,
+	}
+	c.Writer = writer
+
+	return &shadowMirror{
+		rule:    rule,
+		channel: channel,
+		request: request,
+		writer:  writer,
+	}
+}
+
+// run 主请求成功后异步发起影子请求，并保存两者的对比记录
+func (s *shadowMirror) run(relay RelayBaseInterface) {
+	if s == nil {
+		return
+	}
+
+	c := relay.getContext()
+	if shadowRunning.Add(1) > int64(config.ShadowMaxConcurrent) {
+		shadowRunning.Add(-1)
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("shadow_skip rule_id=%d channel_id=%d reason=\"too many shadow requests\"", s.rule.Id, s.channel.Id))
+		return
+	}
+
+	primary := relay.getProvider()
+	comparison := &model.ShadowComparison{
+		RuleId:           s.rule.Id,
+		RequestId:        c.GetString(logger.RequestIdKey),
+		UserId:           c.GetInt("id"),
+		Group:            c.GetString("token_group"),
+		Model:            relay.getOriginalModel(),
+		IsStream:         relay.IsStream(),
+		PrimaryChannelId: primary.GetChannel().Id,
+		PrimaryModel:     c.GetString("new_model"),
+		PrimaryLatency:   time.Since(c.GetTime("requestStartTime")).Milliseconds(),
+		PrimaryResponse:  s.primaryResponse(relay.IsStream()),
+		ShadowChannelId:  s.channel.Id,
+	}
+	if usage := primary.GetUsage(); usage != nil {
+		comparison.PrimaryPromptTokens = usage.PromptTokens
+		comparison.PrimaryCompletionTokens = usage.CompletionTokens
+	}
+
+	// 影子请求与普通请求一样占用渠道的并发名额与 RPM/TPM 额度，渠道已满时不发起
+	shadowContext := c.Copy()
+	shadowContext.Set("channel_concurrency_lease", nil)
+	shadowContext.Set("channel_rate_limit", nil)
+	shadowContext.Set("matched_model", s.modelName(comparison.Model))
+	if !acquireChannelConcurrency(shadowContext, s.channel) || !reserveChannelRateLimit(shadowContext, s.channel) {
+		releaseChannelConcurrency(shadowContext)
+		shadowRunning.Add(-1)
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("shadow_skip rule_id=%d channel_id=%d reason=\"channel saturated\"", s.rule.Id, s.channel.Id))
+		return
+	}
+
+	go func() {
+		defer shadowRunning.Add(-1)
+		defer releaseChannelConcurrency(shadowContext)
+		s.execute(shadowContext, comparison)
+		settleChannelRateLimit(shadowContext, comparison.ShadowPromptTokens+comparison.ShadowCompletionTokens)
+
+		if err := model.CreateShadowComparison(comparison); err != nil {
+			logger.SysError("failed to save shadow comparison: " + err.Error())
+		}
+	}()
+}
+
+func (s *shadowMirror) execute(c *gin.Context, comparison *model.ShadowComparison) {
+	ctx := context.WithValue(context.Background(), logger.RequestIdKey, comparison.RequestId)
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Set("channel_id", s.channel.Id)
+	c.Set("channel_type", s.channel.Type)
+	c.Set("is_stream", false)
+
+	modelName := s.modelName(comparison.Model)
+
+	provider := providers.GetProvider(s.channel, c)
+	if provider == nil {
+		comparison.ShadowError = "channel not found"
+		return
+	}
+	provider.SetOriginalModel(modelName)
+
+	// 渠道默认使用不会取消的 context，影子请求需要单独限制超时时间
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(config.ShadowTimeout)*time.Second)
+	defer cancel()
+	if requester := provider.GetRequester(); requester != nil {
+		requester.Context = timeoutCtx
+	}
+
+	newModelName, err := provider.ModelMappingHandler(modelName)
+	if err != nil {
+		comparison.ShadowError = err.Error()
+		return
+	}
+	newModelName = strings.TrimPrefix(newModelName, "+")
+	comparison.ShadowModel = newModelName
+
+	chatProvider, ok := provider.(providersBase.ChatInterface)
+	if !ok {
+		comparison.ShadowError = "channel not implemented"
+		return
+	}
+
+	// 影子请求总是非流式，便于保存完整的响应
+	request := s.request
+	request.Model = newModelName
+	request.Stream = false
+	request.StreamOptions = nil
+
+	usage := &types.Usage{
+		PromptTokens: common.CountTokenMessages(request.Messages, newModelName, s.channel.PreCost),
+	}
+	provider.SetUsage(usage)
+
+	startTime := time.Now()
+	response, errWithCode := chatProvider.CreateChatCompletion(request)
+	comparison.ShadowLatency = time.Since(startTime).Milliseconds()
+	comparison.ShadowPromptTokens = usage.PromptTokens
+	comparison.ShadowCompletionTokens = usage.CompletionTokens
+
+	if errWithCode != nil {
+		comparison.ShadowStatusCode = errWithCode.StatusCode
+		comparison.ShadowError = utils.TruncateBase64InMessage(errWithCode.Message)
+		logger.LogWarn(ctx, fmt.Sprintf("shadow_failed rule_id=%d channel_id=%d model=%s status_code=%d error=\"%s\"",
+			s.rule.Id, s.channel.Id, newModelName, errWithCode.StatusCode, comparison.ShadowError))
+		return
+	}
+
+	comparison.ShadowStatusCode = 200
+	if body, err := json.Marshal(response); err == nil {
+		comparison.ShadowResponse = truncateShadowResponse(string(body))
+	}
+}
+
+// modelName 规则没有指定影子模型时使用主请求的模型
+func (s *shadowMirror) modelName(primaryModel string) string {
+	if s.rule.ShadowModel != "" {
+		return s.rule.ShadowModel
+	}
+
+	return primaryModel
+}
+
+// primaryResponse 主请求写给客户端的内容，去掉心跳
+func (s *shadowMirror) primaryResponse(isStream bool) string {
+	body := strings.ToValidUTF8(s.writer.body.String(), "")
+	if isStream {
+		return strings.ReplaceAll(body, relay_util.HeartbeatStreamText, "")
+	}
+
+	return strings.TrimLeft(body, relay_util.HeartbeatJsonText)
+}
+
+func truncateShadowResponse(body string) string {
+	maxSize := config.ShadowMaxResponseSize * 1024
+	if len(body) <= maxSize {
+		return body
+	}
+
+	return strings.ToValidUTF8(body[:maxSize], "")
+}
@@ -0,0 +1,390 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/requester"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"done-hub/relay/relay_util"
+	"done-hub/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 不支持 assistant 预填充的渠道，在已输出的内容后追加这段提示让模型继续输出
+const streamContinuationPrompt = "Your previous response was interrupted. Continue exactly from where it stopped, without repeating any text that was already written and without any preamble."
+
+// chatStreamFailover 上游在流式输出中途断开时，保留已经输出的内容，在其他渠道上续写并拼接到同一个 SSE 流中
+// 第一段的用量由 RelayHandler 结算，续写的每一段在结束时按各自的渠道单独结算
+// 开始续写后各渠道的结果由这里分别记录，RelayHandler 不再记录
+type chatStreamFailover struct {
+	relay      *relayChat
+	firstUsage *types.Usage
+	remain     int
+
+	sync.Mutex
+	stream       requester.StreamReaderInterface[string]
+	segments     []*types.Usage
+	segmentStart time.Time
+	switched     bool
+
+	content     strings.Builder
+	id          string
+	created     any
+	model       string
+	unsupported bool
+	finished    bool
+
+	dataChan chan string
+	errChan  chan error
+}
+
+// newChatStreamFailover 只处理单条 choice 的普通对话，工具调用无法可靠地拼接
+// 网关执行 MCP 工具时对话由多轮组成，同样不续写
+func newChatStreamFailover(r *relayChat, stream requester.StreamReaderInterface[string]) requester.StreamReaderInterface[string] {
+	if config.StreamFailoverTimes <= 0 || (r.chatRequest.N != nil && *r.chatRequest.N > 1) || r.mcp != nil {
+		return stream
+	}
+
+	if r.c.GetInt("specific_channel_id") > 0 && !r.c.GetBool("specific_channel_id_ignore") {
+		return stream
+	}
+
+	failover := &chatStreamFailover{
+		relay:        r,
+		firstUsage:   r.provider.GetUsage(),
+		remain:       config.StreamFailoverTimes,
+		stream:       stream,
+		segmentStart: time.Now(),
+		dataChan:     make(chan string),
+		errChan:      make(chan error),
+	}
+	r.failover = failover
+
+	return failover
+}
+
+func (s *chatStreamFailover) Recv() (<-chan string, <-chan error) {
+	common.SafeGoroutine(s.forward)
+
+	return s.dataChan, s.errChan
+}
+
+func (s *chatStreamFailover) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stream.Close()
+}
+
+func (s *chatStreamFailover) forward() {
+	for {
+		s.Lock()
+		stream := s.stream
+		s.Unlock()
+
+		dataChan, errChan := stream.Recv()
+		err := s.pipe(dataChan, errChan)
+
+		if len(s.segments) > 0 {
+			s.consumeSegment(s.segments[len(s.segments)-1])
+		}
+
+		if errors.Is(err, io.EOF) || !s.canContinue() {
+			if len(s.segments) > 0 {
+				s.finishSegment(err)
+			}
+			s.errChan <- err
+			return
+		}
+
+		next := s.continueOnNextChannel(err)
+		if next == nil {
+			s.errChan <- err
+			return
+		}
+
+		s.Lock()
+		s.stream.Close()
+		s.stream = next
+		s.Unlock()
+	}
+}
+
+// pipe 转发一段上游响应，返回这一段结束时的错误
+func (s *chatStreamFailover) pipe(dataChan <-chan string, errChan <-chan error) error {
+	for {
+		select {
+		case data, ok := <-dataChan:
+			if !ok {
+				return io.EOF
+			}
+			s.dataChan <- s.record(data)
+		case err, ok := <-errChan:
+			if !ok {
+				return io.EOF
+			}
+			return err
+		}
+	}
+}
+
+// record 记录已经输出的内容，续写的片段会改写 id、model 等字段，让客户端看到的是同一个响应
+func (s *chatStreamFailover) record(data string) string {
+	var chunk types.ChatCompletionStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return data
+	}
+
+	continued := len(s.segments) > 0
+	if s.id == "" {
+		s.id, s.created, s.model = chunk.ID, chunk.Created, chunk.Model
+	}
+
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		if choice.Index != 0 || len(choice.Delta.ToolCalls) > 0 || choice.Delta.FunctionCall != nil {
+			s.unsupported = true
+		}
+		if choice.FinishReason != nil {
+			s.finished = true
+		}
+		s.content.WriteString(choice.Delta.Content)
+
+		if continued {
+			choice.Delta.Role = ""
+		}
+	}
+
+	if !continued {
+		return data
+	}
+
+	chunk.ID, chunk.Created, chunk.Model = s.id, s.created, s.model
+	responseBody, err := json.Marshal(chunk)
+	if err != nil {
+		return data
+	}
+
+	return string(responseBody)
+}
+
+func (s *chatStreamFailover) canContinue() bool {
+	if s.remain <= 0 || s.unsupported || s.finished {
+		return false
+	}
+
+	// 客户端已经断开或者对冲请求已经落败时不再续写
+	return s.relay.c.Request.Context().Err() == nil && !isHedgeLoser(s.relay.c)
+}
+
+// continueOnNextChannel 跳过断开的渠道，把已输出的内容作为上下文在下一个渠道上重新发起流式请求
+// 断开的渠道按上游错误记录结果并计入熔断，下一个渠道与重试一样需要占用并发名额和限流额度
+func (s *chatStreamFailover) continueOnNextChannel(streamErr error) requester.StreamReaderInterface[string] {
+	r := s.relay
+	ctx := r.c.Request.Context()
+	modelName := r.c.GetString("new_model")
+	partial := s.content.String()
+
+	s.switched = true
+	for s.remain > 0 {
+		s.remain--
+
+		channel := r.provider.GetChannel()
+		s.failSegment(streamErr)
+
+		if err := setProviderWithConcurrency(r); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("stream_failover_failed model=%s channel_id=%d reason=\"%s\"", modelName, channel.Id, err.Error()))
+			return nil
+		}
+		if err := applyChannelRewriteRules(r); err != nil {
+			s.abandonSegment()
+			logger.LogError(ctx, fmt.Sprintf("stream_failover_failed model=%s channel_id=%d reason=\"%s\"", modelName, channel.Id, err.Error()))
+			return nil
+		}
+		s.segmentStart = time.Now()
+
+		nextChannel := r.provider.GetChannel()
+		chatProvider, ok := r.provider.(providersBase.ChatInterface)
+		if !ok {
+			streamErr = errors.New("channel not implemented")
+			continue
+		}
+
+		request := r.continuationRequest(partial)
+		if request == nil {
+			s.abandonSegment()
+			return nil
+		}
+
+		usage := &types.Usage{
+			PromptTokens: common.CountTokenMessages(request.Messages, r.modelName, nextChannel.PreCost),
+		}
+		r.provider.SetUsage(usage)
+
+		logger.LogWarn(ctx, fmt.Sprintf("stream_failover model=%s channel_id=%d next_channel_id=%d segment=%d partial_length=%d error=\"%s\"",
+			modelName, channel.Id, nextChannel.Id, len(s.segments)+2, len(partial), streamErr.Error()))
+
+		stream, errWithCode := chatProvider.CreateChatCompletionStream(request)
+		if errWithCode != nil {
+			streamErr = errors.New(errWithCode.Message)
+			continue
+		}
+
+		s.segments = append(s.segments, usage)
+		return stream
+	}
+
+	// 续写次数用完时最后一个渠道的请求同样失败了
+	s.failSegment(streamErr)
+
+	return nil
+}
+
+// failSegment 当前片段所在的渠道按上游错误记录结果并计入熔断，延迟从片段开始时计算
+func (s *chatStreamFailover) failSegment(streamErr error) {
+	r := s.relay
+	channel := r.provider.GetChannel()
+	apiErr := common.StringErrorWrapper(streamErr.Error(), "stream_error", http.StatusBadGateway)
+	recordChannelResult(r, s.segmentStart, apiErr)
+	settleChannelRateLimit(r.c, s.lastSegmentTokens())
+	go processChannelRelayError(r.c.Request.Context(), channel.Id, channel.Name, apiErr, channel.Type)
+	shouldCooldowns(r.c, channel, apiErr)
+}
+
+// abandonSegment 没有向选中的渠道发送请求，归还熔断试探名额和限流额度
+func (s *chatStreamFailover) abandonSegment() {
+	model.ChannelGroup.ReleaseCircuitTrial(s.relay.c)
+	settleChannelRateLimit(s.relay.c, 0)
+}
+
+// finishSegment 记录最后一段续写的结果，客户端断开或对冲落败时不计入渠道
+func (s *chatStreamFailover) finishSegment(err error) {
+	r := s.relay
+	if errors.Is(err, io.EOF) {
+		recordChannelResult(r, s.segmentStart, nil)
+		return
+	}
+
+	if r.c.Request.Context().Err() != nil || isHedgeLoser(r.c) {
+		model.ChannelGroup.ReleaseCircuitTrial(r.c)
+		return
+	}
+
+	s.failSegment(err)
+}
+
+// streamFailoverRecorded 开始续写后各渠道的结果已经在续写时分别记录
+func streamFailoverRecorded(relay RelayBaseInterface) bool {
+	chat, ok := relay.(*relayChat)
+	return ok && chat.failover != nil && chat.failover.switched
+}
+
+// consumeSegment 续写的片段按所在渠道单独结算，日志中记录片段序号
+func (s *chatStreamFailover) consumeSegment(usage *types.Usage) {
+	r := s.relay
+	if usage.CompletionTokens == 0 && usage.TextBuilder.Len() > 0 {
+		usage.CompletionTokens = common.CountTokenText(usage.TextBuilder.String(), r.getModelName())
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	settleChannelRateLimit(r.c, usage.PromptTokens+usage.CompletionTokens)
+
+	r.c.Set("stream_segment", len(s.segments)+1)
+	quota := relay_util.NewQuota(r.c, r.getModelName(), usage.PromptTokens)
+	quota.Consume(r.c, usage, true)
+}
+
+// lastSegmentTokens 当前片段已经消耗的 tokens，用于修正所在渠道预留的限流额度
+func (s *chatStreamFailover) lastSegmentTokens() int {
+	usage := s.firstUsage
+	if len(s.segments) > 0 {
+		usage = s.segments[len(s.segments)-1]
+	}
+
+	completionTokens := usage.CompletionTokens
+	if completionTokens == 0 && usage.TextBuilder.Len() > 0 {
+		completionTokens = common.CountTokenText(usage.TextBuilder.String(), s.relay.getModelName())
+	}
+
+	return usage.PromptTokens + completionTokens
+}
+
+// totalUsage 返回给客户端的用量包含所有片段，提示词按原始请求计算
+func (s *chatStreamFailover) totalUsage() *types.Usage {
+	usage := &types.Usage{
+		PromptTokens:     s.firstUsage.PromptTokens,
+		CompletionTokens: s.firstUsage.CompletionTokens,
+	}
+	if usage.CompletionTokens == 0 && s.firstUsage.TextBuilder.Len() > 0 {
+		usage.CompletionTokens = common.CountTokenText(s.firstUsage.TextBuilder.String(), s.relay.getModelName())
+	}
+
+	for _, segment := range s.segments {
+		completionTokens := segment.CompletionTokens
+		if completionTokens == 0 && segment.TextBuilder.Len() > 0 {
+			completionTokens = common.CountTokenText(segment.TextBuilder.String(), s.relay.getModelName())
+		}
+		usage.CompletionTokens += completionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return usage
+}
+
+// continuationRequest 支持预填充的渠道直接以 assistant 消息结尾，其余渠道追加一条继续输出的提示
+func (r *relayChat) continuationRequest(partial string) *types.ChatCompletionRequest {
+	request := r.chatRequest
+	request.Model = r.modelName
+
+	if partial == "" {
+		return &request
+	}
+
+	if request.MaxTokens > 0 || request.MaxCompletionTokens > 0 {
+		partialTokens := common.CountTokenText(partial, r.modelName)
+		if request.MaxTokens > 0 {
+			if request.MaxTokens -= partialTokens; request.MaxTokens <= 0 {
+				return nil
+			}
+		}
+		if request.MaxCompletionTokens > 0 {
+			if request.MaxCompletionTokens -= partialTokens; request.MaxCompletionTokens <= 0 {
+				return nil
+			}
+		}
+	}
+
+	messages := make([]types.ChatCompletionMessage, 0, len(r.chatRequest.Messages)+2)
+	messages = append(messages, r.chatRequest.Messages...)
+
+	switch r.provider.GetChannel().Type {
+	case config.ChannelTypeAnthropic, config.ChannelTypeBedrock, config.ChannelTypeClaudeCode:
+		// Claude 不允许预填充内容以空白结尾
+		messages = append(messages, types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleAssistant,
+			Content: strings.TrimRight(partial, " \t\r\n"),
+		})
+	default:
+		messages = append(messages,
+			types.ChatCompletionMessage{
+				Role:    types.ChatMessageRoleAssistant,
+				Content: partial,
+			},
+			types.ChatCompletionMessage{
+				Role:    types.ChatMessageRoleUser,
+				Content: streamContinuationPrompt,
+			},
+		)
+	}
+	request.Messages = messages
+
+	return &request
+}
@@ -0,0 +1,159 @@
+package relay
+
+import (
+	"done-hub/common"
+	"done-hub/common/config"
+	"done-hub/common/jsonschema"
+	"done-hub/common/logger"
+	providersBase "done-hub/providers/base"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const structuredOutputRepairPrompt = "Your previous reply does not match the required JSON schema.\nErrors: %s\nSchema: %s\nReply again with only the corrected JSON, without any explanation or markdown."
+
+// getStructuredOutputSchema 请求使用 json_schema 格式输出时返回规范化后的 schema
+func getStructuredOutputSchema(request *types.ChatCompletionRequest) (schema any, schemaText string, ok bool) {
+	format := request.ResponseFormat
+	if format == nil || format.Type != "json_schema" || format.JsonSchema == nil || format.JsonSchema.Schema == nil {
+		return nil, "", false
+	}
+
+	// schema 可能来自其他格式的转换，重新解析一次保证是 map[string]any 与 float64
+	body, err := json.Marshal(format.JsonSchema.Schema)
+	if err != nil {
+		return nil, "", false
+	}
+	if err := json.Unmarshal(body, &schema); err != nil {
+		return nil, "", false
+	}
+
+	return schema, string(body), true
+}
+
+// enforceStructuredOutput 部分渠道会忽略 response_format，由网关校验非流式响应，失败时可以在同一渠道上请求模型修复
+func (r *relayChat) enforceStructuredOutput(chatProvider providersBase.ChatInterface, response *types.ChatCompletionResponse) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	if !config.StructuredOutputValidation {
+		return response, nil
+	}
+
+	schema, schemaText, ok := getStructuredOutputSchema(&r.chatRequest)
+	if !ok {
+		return response, nil
+	}
+
+	ctx := r.c.Request.Context()
+	channel := r.provider.GetChannel()
+	repairTimes := config.StructuredOutputRepairTimes
+	// 多个 choice 时无法确定修复哪一个，只做校验
+	if r.chatRequest.N != nil && *r.chatRequest.N > 1 {
+		repairTimes = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		content, validationErr := validateStructuredOutput(response, schema)
+		if validationErr == nil {
+			if attempt > 0 {
+				logger.LogInfo(ctx, fmt.Sprintf("structured_output_repaired model=%s channel_id=%d attempt=%d", r.modelName, channel.Id, attempt))
+			}
+			return response, nil
+		}
+
+		if attempt >= repairTimes {
+			logger.LogWarn(ctx, fmt.Sprintf("structured_output_invalid model=%s channel_id=%d repair_times=%d error=\"%s\"",
+				r.modelName, channel.Id, attempt, validationErr.Error()))
+			return nil, common.StringErrorWrapperLocal("response does not match the requested json_schema: "+validationErr.Error(), "json_schema_validation_failed", http.StatusUnprocessableEntity)
+		}
+
+		logger.LogWarn(ctx, fmt.Sprintf("structured_output_repair model=%s channel_id=%d attempt=%d error=\"%s\"",
+			r.modelName, channel.Id, attempt+1, validationErr.Error()))
+
+		// 修复请求会覆盖渠道记录的用量，需要把之前的用量加回去
+		usage := r.provider.GetUsage()
+		promptTokens, completionTokens := usage.PromptTokens, usage.CompletionTokens
+
+		var errWithCode *types.OpenAIErrorWithStatusCode
+		response, errWithCode = chatProvider.CreateChatCompletion(r.repairRequest(content, validationErr, schemaText))
+
+		usage.PromptTokens += promptTokens
+		usage.CompletionTokens += completionTokens
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+		response.Usage = usage
+	}
+}
+
+func (r *relayChat) repairRequest(content string, validationErr error, schemaText string) *types.ChatCompletionRequest {
+	request := r.chatRequest
+
+	messages := make([]types.ChatCompletionMessage, 0, len(r.chatRequest.Messages)+2)
+	messages = append(messages, r.chatRequest.Messages...)
+	messages = append(messages,
+		types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleAssistant,
+			Content: content,
+		},
+		types.ChatCompletionMessage{
+			Role:    types.ChatMessageRoleUser,
+			Content: fmt.Sprintf(structuredOutputRepairPrompt, validationErr.Error(), schemaText),
+		},
+	)
+	request.Messages = messages
+
+	return &request
+}
+
+// validateStructuredOutput 校验每个 choice 的内容，去掉 markdown 代码块后合法的内容会写回响应
+// 返回第一个不合法的内容，用于修复请求
+func validateStructuredOutput(response *types.ChatCompletionResponse, schema any) (string, error) {
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("response has no choices")
+	}
+
+	for i := range response.Choices {
+		message := &response.Choices[i].Message
+		// 模型拒绝回答或者调用工具时没有需要校验的内容
+		if message.Refusal != "" || len(message.ToolCalls) > 0 {
+			continue
+		}
+
+		content := message.StringContent()
+		trimmed := trimJSONCodeFence(content)
+
+		var value any
+		if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+			return content, fmt.Errorf("choice %d is not valid JSON: %s", i, err.Error())
+		}
+
+		if err := jsonschema.Validate(schema, value); err != nil {
+			return content, fmt.Errorf("choice %d: %s", i, err.Error())
+		}
+
+		if trimmed != content {
+			message.Content = trimmed
+		}
+	}
+
+	return "", nil
+}
+
+// trimJSONCodeFence 去掉模型常见的 ```json 代码块包装
+func trimJSONCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") || !strings.HasSuffix(content, "```") || len(content) < 6 {
+		return content
+	}
+
+	content = strings.TrimSuffix(content[3:], "```")
+	if index := strings.Index(content, "\n"); index >= 0 {
+		content = content[index+1:]
+	}
+
+	return strings.TrimSpace(content)
+}
@@ -103,7 +103,7 @@ func RelayTaskSubmit(c *gin.Context) {
 	logger.LogError(c.Request.Context(), fmt.Sprintf("retry_start model=%s channel_id=%d total_channels=%d config_max_retries=%d actual_max_retries=%d status_code=%d error=\"%s\"",
 		modelName, channel.Id, totalChannelsAtStart, retryTimes, actualRetryTimes, taskErr.StatusCode, taskErr.Message))
 	for i := actualRetryTimes; i > 0; i-- {
-		model.ChannelGroup.SetCooldowns(channel.Id, taskAdaptor.GetModelName())
+		model.ChannelGroup.OpenCircuit(channel.Id, taskAdaptor.GetModelName(), 0, "task_failed")
 		taskErr = taskAdaptor.SetProvider()
 		if taskErr != nil {
 			continue
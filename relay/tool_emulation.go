@@ -0,0 +1,477 @@
+package relay
+
+import (
+	"done-hub/common/requester"
+	"done-hub/common/utils"
+	"done-hub/types"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	toolCallStartTag = "<tool_call>"
+	toolCallEndTag   = "</tool_call>"
+)
+
+const toolEmulationPrompt = `You have access to the following tools:
+<tools>
+%s
+</tools>
+
+To call a tool, reply with a block in exactly this format, one block per call:
+<tool_call>
+{"name": "<tool name>", "arguments": {<arguments as a JSON object>}}
+</tool_call>
+Tool results will be sent back to you inside <tool_response> blocks. If no tool is needed, answer normally without any <tool_call> block.`
+
+var toolCallRegex = regexp.MustCompile(`(?s)<tool_call>(.*?)</tool_call>`)
+
+type emulatedTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type emulatedToolCall struct {
+	Name       string `json:"name"`
+	Arguments  any    `json:"arguments"`
+	Parameters any    `json:"parameters,omitempty"`
+}
+
+// toolEmulationRequest 渠道对该模型开启了工具调用模拟时，返回把工具定义写入提示词后的请求，否则返回原请求
+func (r *relayChat) toolEmulationRequest() *types.ChatCompletionRequest {
+	if len(r.chatRequest.Tools) == 0 && len(r.chatRequest.Functions) == 0 {
+		return &r.chatRequest
+	}
+
+	if !r.provider.GetChannel().EmulateTools(r.modelName) {
+		return &r.chatRequest
+	}
+
+	return buildToolEmulationRequest(&r.chatRequest)
+}
+
+// buildToolEmulationRequest 去掉原生的工具字段，工具定义写入系统提示词，历史中的工具调用与结果转换为文本
+func buildToolEmulationRequest(request *types.ChatCompletionRequest) *types.ChatCompletionRequest {
+	emulated := *request
+	emulated.Tools = nil
+	emulated.ToolChoice = nil
+	emulated.ParallelToolCalls = false
+	emulated.Functions = nil
+	emulated.FunctionCall = nil
+
+	messages := convertToolMessages(request.Messages)
+
+	toolChoice := request.ToolChoice
+	if toolChoice == nil {
+		toolChoice = request.FunctionCall
+	}
+	if choice, ok := toolChoice.(string); ok && choice == "none" {
+		emulated.Messages = messages
+		return &emulated
+	}
+
+	tools := make([]emulatedTool, 0, len(request.Tools)+len(request.Functions))
+	for _, tool := range request.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			continue
+		}
+		tools = append(tools, emulatedTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	for _, function := range request.Functions {
+		tools = append(tools, emulatedTool{
+			Name:        function.Name,
+			Description: function.Description,
+			Parameters:  function.Parameters,
+		})
+	}
+
+	toolsJson, _ := json.Marshal(tools)
+	prompt := fmt.Sprintf(toolEmulationPrompt, string(toolsJson))
+	if instruction := toolChoiceInstruction(toolChoice); instruction != "" {
+		prompt += "\n" + instruction
+	}
+
+	emulated.Messages = prependSystemPrompt(messages, prompt)
+
+	return &emulated
+}
+
+func toolChoiceInstruction(toolChoice any) string {
+	switch choice := toolChoice.(type) {
+	case string:
+		if choice == "required" {
+			return "You must call at least one tool in this reply."
+		}
+	case map[string]any:
+		name, _ := choice["name"].(string)
+		if function, ok := choice["function"].(map[string]any); ok {
+			name, _ = function["name"].(string)
+		}
+		if name != "" {
+			return fmt.Sprintf("You must call the tool %q in this reply.", name)
+		}
+	}
+
+	return ""
+}
+
+func prependSystemPrompt(messages []types.ChatCompletionMessage, prompt string) []types.ChatCompletionMessage {
+	if len(messages) > 0 && messages[0].Role == types.ChatMessageRoleSystem {
+		if content, ok := messages[0].Content.(string); ok {
+			messages[0].Content = prompt + "\n\n" + content
+			return messages
+		}
+	}
+
+	return append([]types.ChatCompletionMessage{{
+		Role:    types.ChatMessageRoleSystem,
+		Content: prompt,
+	}}, messages...)
+}
+
+// convertToolMessages 将 assistant 的工具调用写成 <tool_call> 文本，tool 消息转换为 user 消息，连续的工具结果会合并
+func convertToolMessages(messages []types.ChatCompletionMessage) []types.ChatCompletionMessage {
+	converted := make([]types.ChatCompletionMessage, 0, len(messages))
+	toolNames := make(map[string]string)
+
+	for _, message := range messages {
+		switch message.Role {
+		case types.ChatMessageRoleAssistant:
+			message.FuncToToolCalls()
+			if len(message.ToolCalls) == 0 {
+				converted = append(converted, message)
+				continue
+			}
+
+			var builder strings.Builder
+			builder.WriteString(message.StringContent())
+			for _, toolCall := range message.ToolCalls {
+				if toolCall.Function == nil {
+					continue
+				}
+				toolNames[toolCall.Id] = toolCall.Function.Name
+				writeEmulatedToolCall(&builder, toolCall.Function)
+			}
+
+			converted = append(converted, types.ChatCompletionMessage{
+				Role:    types.ChatMessageRoleAssistant,
+				Content: strings.TrimSpace(builder.String()),
+			})
+
+		case types.ChatMessageRoleTool, types.ChatMessageRoleFunction:
+			name := toolNames[message.ToolCallID]
+			if name == "" && message.Name != nil {
+				name = *message.Name
+			}
+			response := fmt.Sprintf("<tool_response name=%q>\n%s\n</tool_response>", name, message.StringContent())
+
+			last := len(converted) - 1
+			if last >= 0 && converted[last].Role == types.ChatMessageRoleUser && strings.HasPrefix(converted[last].StringContent(), "<tool_response") {
+				converted[last].Content = converted[last].StringContent() + "\n" + response
+				continue
+			}
+
+			converted = append(converted, types.ChatCompletionMessage{
+				Role:    types.ChatMessageRoleUser,
+				Content: response,
+			})
+
+		default:
+			converted = append(converted, message)
+		}
+	}
+
+	return converted
+}
+
+func writeEmulatedToolCall(builder *strings.Builder, function *types.ChatCompletionToolCallsFunction) {
+	var arguments any = map[string]any{}
+	if function.Arguments != "" {
+		if err := json.Unmarshal([]byte(function.Arguments), &arguments); err != nil {
+			arguments = function.Arguments
+		}
+	}
+
+	call, _ := json.Marshal(emulatedToolCall{
+		Name:      function.Name,
+		Arguments: arguments,
+	})
+
+	builder.WriteString("\n" + toolCallStartTag + "\n")
+	builder.Write(call)
+	builder.WriteString("\n" + toolCallEndTag)
+}
+
+// parseEmulatedToolCall 解析 <tool_call> 中的内容，arguments 统一转换为 JSON 字符串
+func parseEmulatedToolCall(content string, index int) *types.ChatCompletionToolCalls {
+	content = strings.TrimSpace(trimJSONCodeFence(content))
+
+	var call emulatedToolCall
+	if err := json.Unmarshal([]byte(content), &call); err != nil || call.Name == "" {
+		return nil
+	}
+
+	arguments := call.Arguments
+	if arguments == nil {
+		arguments = call.Parameters
+	}
+
+	argumentsText, ok := arguments.(string)
+	if !ok {
+		if arguments == nil {
+			arguments = map[string]any{}
+		}
+		argumentsJson, _ := json.Marshal(arguments)
+		argumentsText = string(argumentsJson)
+	}
+
+	return &types.ChatCompletionToolCalls{
+		Id:    "call_" + utils.GetRandomString(24),
+		Type:  "function",
+		Index: index,
+		Function: &types.ChatCompletionToolCallsFunction{
+			Name:      call.Name,
+			Arguments: argumentsText,
+		},
+	}
+}
+
+// applyEmulatedToolCalls 从非流式响应的文本中解析出工具调用
+func applyEmulatedToolCalls(response *types.ChatCompletionResponse, request *types.ChatCompletionRequest) {
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		content := choice.Message.StringContent()
+		if !strings.Contains(content, toolCallStartTag) {
+			continue
+		}
+
+		var toolCalls []*types.ChatCompletionToolCalls
+		text := toolCallRegex.ReplaceAllStringFunc(content, func(block string) string {
+			inner := strings.TrimSuffix(strings.TrimPrefix(block, toolCallStartTag), toolCallEndTag)
+			toolCall := parseEmulatedToolCall(inner, len(toolCalls))
+			if toolCall == nil {
+				return block
+			}
+			toolCalls = append(toolCalls, toolCall)
+			return ""
+		})
+
+		if len(toolCalls) == 0 {
+			continue
+		}
+
+		text = strings.TrimSpace(text)
+		choice.Message.Content = nil
+		if text != "" {
+			choice.Message.Content = text
+		}
+		choice.Message.ToolCalls = toolCalls
+		choice.FinishReason = types.FinishReasonToolCalls
+		choice.CheckChoice(request)
+	}
+}
+
+// toolEmulationStream 在流式响应中识别 <tool_call> 块，转换为 tool_calls 增量，其余文本照常输出
+type toolEmulationStream struct {
+	stream    requester.StreamReaderInterface[string]
+	request   *types.ChatCompletionRequest
+	parser    toolCallStreamParser
+	lastChunk types.ChatCompletionStreamResponse
+
+	dataChan chan string
+	errChan  chan error
+}
+
+func newToolEmulationStream(stream requester.StreamReaderInterface[string], request *types.ChatCompletionRequest) requester.StreamReaderInterface[string] {
+	return &toolEmulationStream{
+		stream:   stream,
+		request:  request,
+		dataChan: make(chan string),
+		errChan:  make(chan error),
+	}
+}
+
+func (s *toolEmulationStream) Recv() (<-chan string, <-chan error) {
+	dataChan, errChan := s.stream.Recv()
+
+	go func() {
+		for {
+			select {
+			case data, ok := <-dataChan:
+				if !ok {
+					s.finish(io.EOF)
+					return
+				}
+				if converted := s.convert(data); converted != "" {
+					s.dataChan <- converted
+				}
+			case err, ok := <-errChan:
+				if !ok {
+					err = io.EOF
+				}
+				s.finish(err)
+				return
+			}
+		}
+	}()
+
+	return s.dataChan, s.errChan
+}
+
+// finish 上游没有返回 finish_reason 就结束时，输出缓存中剩余的内容
+func (s *toolEmulationStream) finish(err error) {
+	text, toolCalls := s.parser.flush()
+	if text != "" || len(toolCalls) > 0 {
+		chunk := s.lastChunk
+		choice := types.ChatCompletionStreamChoice{
+			Delta: types.ChatCompletionStreamChoiceDelta{
+				Content:   text,
+				ToolCalls: toolCalls,
+			},
+		}
+		if len(toolCalls) > 0 {
+			choice.FinishReason = types.FinishReasonToolCalls
+		}
+		choice.CheckChoice(s.request)
+		chunk.Choices = []types.ChatCompletionStreamChoice{choice}
+		chunk.Usage = nil
+
+		if responseBody, marshalErr := json.Marshal(chunk); marshalErr == nil {
+			s.dataChan <- string(responseBody)
+		}
+	}
+
+	s.errChan <- err
+}
+
+func (s *toolEmulationStream) Close() {
+	s.stream.Close()
+}
+
+func (s *toolEmulationStream) convert(data string) string {
+	var chunk types.ChatCompletionStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+		return data
+	}
+
+	s.lastChunk = types.ChatCompletionStreamResponse{
+		ID:      chunk.ID,
+		Object:  chunk.Object,
+		Created: chunk.Created,
+		Model:   chunk.Model,
+	}
+
+	choice := &chunk.Choices[0]
+	text, toolCalls := s.parser.feed(choice.Delta.Content)
+	if choice.FinishReason != nil {
+		rest, restCalls := s.parser.flush()
+		text += rest
+		toolCalls = append(toolCalls, restCalls...)
+		if s.parser.count > 0 {
+			choice.FinishReason = types.FinishReasonToolCalls
+		}
+	}
+
+	// 工具调用之后的空白文本没有意义
+	if s.parser.count > 0 && strings.TrimSpace(text) == "" {
+		text = ""
+	}
+
+	// 内容全部被缓存时不输出这个片段
+	if choice.Delta.Content != "" && text == "" && len(toolCalls) == 0 && choice.FinishReason == nil &&
+		choice.Delta.ReasoningContent == "" && chunk.Usage == nil {
+		return ""
+	}
+
+	choice.Delta.Content = text
+	choice.Delta.ToolCalls = toolCalls
+	choice.CheckChoice(s.request)
+
+	responseBody, err := json.Marshal(chunk)
+	if err != nil {
+		return data
+	}
+
+	return string(responseBody)
+}
+
+// toolCallStreamParser 可能是标签开头的文本会先缓存，直到能确定是否为工具调用
+type toolCallStreamParser struct {
+	pending string
+	inCall  bool
+	count   int
+}
+
+func (p *toolCallStreamParser) feed(text string) (output string, toolCalls []*types.ChatCompletionToolCalls) {
+	p.pending += text
+
+	for {
+		if p.inCall {
+			index := strings.Index(p.pending, toolCallEndTag)
+			if index < 0 {
+				return
+			}
+
+			if toolCall := parseEmulatedToolCall(p.pending[:index], p.count); toolCall != nil {
+				toolCalls = append(toolCalls, toolCall)
+				p.count++
+			} else {
+				output += toolCallStartTag + p.pending[:index+len(toolCallEndTag)]
+			}
+			p.pending = p.pending[index+len(toolCallEndTag):]
+			p.inCall = false
+			continue
+		}
+
+		index := strings.Index(p.pending, toolCallStartTag)
+		if index >= 0 {
+			output += p.pending[:index]
+			p.pending = p.pending[index+len(toolCallStartTag):]
+			p.inCall = true
+			continue
+		}
+
+		keep := partialTagLength(p.pending, toolCallStartTag)
+		output += p.pending[:len(p.pending)-keep]
+		p.pending = p.pending[len(p.pending)-keep:]
+		return
+	}
+}
+
+// flush 响应结束时输出剩余内容，未闭合的工具调用如果能解析也按工具调用处理
+func (p *toolCallStreamParser) flush() (output string, toolCalls []*types.ChatCompletionToolCalls) {
+	if p.inCall {
+		if toolCall := parseEmulatedToolCall(p.pending, p.count); toolCall != nil {
+			toolCalls = append(toolCalls, toolCall)
+			p.count++
+		} else {
+			output = toolCallStartTag + p.pending
+		}
+	} else {
+		output = p.pending
+	}
+
+	p.pending = ""
+	p.inCall = false
+
+	return
+}
+
+// partialTagLength 返回文本末尾与标签开头重合的长度
+func partialTagLength(text, tag string) int {
+	for length := len(tag) - 1; length > 0; length-- {
+		if strings.HasSuffix(text, tag[:length]) {
+			return length
+		}
+	}
+
+	return 0
+}
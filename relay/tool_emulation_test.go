@@ -0,0 +1,246 @@
+package relay
+
+import (
+	"done-hub/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEmulatedToolCall(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		toolName  string
+		arguments string
+	}{
+		{
+			name:      "object arguments",
+			content:   `{"name": "get_weather", "arguments": {"city": "Paris"}}`,
+			toolName:  "get_weather",
+			arguments: `{"city":"Paris"}`,
+		},
+		{
+			name:      "surrounding whitespace and code fence",
+			content:   "\n```json\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n```\n",
+			toolName:  "get_weather",
+			arguments: `{"city":"Paris"}`,
+		},
+		{
+			name:      "string arguments are kept as is",
+			content:   `{"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}`,
+			toolName:  "get_weather",
+			arguments: `{"city":"Paris"}`,
+		},
+		{
+			name:      "parameters instead of arguments",
+			content:   `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+			toolName:  "get_weather",
+			arguments: `{"city":"Paris"}`,
+		},
+		{
+			name:      "missing arguments",
+			content:   `{"name": "list_files"}`,
+			toolName:  "list_files",
+			arguments: `{}`,
+		},
+		{
+			name:    "malformed json",
+			content: `{"name": "get_weather", "arguments": {"city": "Paris"}`,
+		},
+		{
+			name:    "missing name",
+			content: `{"arguments": {"city": "Paris"}}`,
+		},
+		{
+			name:    "plain text",
+			content: `I will call get_weather now`,
+		},
+		{
+			name:    "empty",
+			content: ``,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toolCall := parseEmulatedToolCall(c.content, 2)
+			if c.toolName == "" {
+				assert.Nil(t, toolCall)
+				return
+			}
+
+			if assert.NotNil(t, toolCall) {
+				assert.Equal(t, 2, toolCall.Index)
+				assert.Equal(t, "function", toolCall.Type)
+				assert.NotEmpty(t, toolCall.Id)
+				assert.Equal(t, c.toolName, toolCall.Function.Name)
+				assert.JSONEq(t, c.arguments, toolCall.Function.Arguments)
+			}
+		})
+	}
+}
+
+func TestApplyEmulatedToolCalls(t *testing.T) {
+	cases := []struct {
+		name         string
+		content      string
+		text         any
+		toolNames    []string
+		finishReason any
+	}{
+		{
+			name:         "text and two calls",
+			content:      "Let me check.\n<tool_call>\n{\"name\": \"a\", \"arguments\": {}}\n</tool_call>\n<tool_call>{\"name\": \"b\", \"arguments\": {\"x\": 1}}</tool_call>",
+			text:         "Let me check.",
+			toolNames:    []string{"a", "b"},
+			finishReason: types.FinishReasonToolCalls,
+		},
+		{
+			name:         "only a call",
+			content:      "<tool_call>{\"name\": \"a\", \"arguments\": {}}</tool_call>",
+			text:         nil,
+			toolNames:    []string{"a"},
+			finishReason: types.FinishReasonToolCalls,
+		},
+		{
+			name:         "malformed block stays in the text",
+			content:      "<tool_call>{\"name\": \"a\", </tool_call> and <tool_call>{\"name\": \"b\"}</tool_call>",
+			text:         "<tool_call>{\"name\": \"a\", </tool_call> and",
+			toolNames:    []string{"b"},
+			finishReason: types.FinishReasonToolCalls,
+		},
+		{
+			name:         "only malformed blocks",
+			content:      "<tool_call>not json</tool_call>",
+			text:         "<tool_call>not json</tool_call>",
+			finishReason: types.FinishReasonStop,
+		},
+		{
+			name:         "unclosed block is not parsed",
+			content:      "<tool_call>{\"name\": \"a\", \"arguments\": {}}",
+			text:         "<tool_call>{\"name\": \"a\", \"arguments\": {}}",
+			finishReason: types.FinishReasonStop,
+		},
+		{
+			name:         "no tool call",
+			content:      "Hello",
+			text:         "Hello",
+			finishReason: types.FinishReasonStop,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := &types.ChatCompletionResponse{
+				Choices: []types.ChatCompletionChoice{{
+					Message: types.ChatCompletionMessage{
+						Role:    types.ChatMessageRoleAssistant,
+						Content: c.content,
+					},
+					FinishReason: types.FinishReasonStop,
+				}},
+			}
+
+			applyEmulatedToolCalls(response, &types.ChatCompletionRequest{})
+
+			choice := response.Choices[0]
+			assert.Equal(t, c.text, choice.Message.Content)
+			assert.Equal(t, c.finishReason, choice.FinishReason)
+
+			names := make([]string, 0, len(choice.Message.ToolCalls))
+			for i, toolCall := range choice.Message.ToolCalls {
+				assert.Equal(t, i, toolCall.Index)
+				names = append(names, toolCall.Function.Name)
+			}
+			assert.Equal(t, len(c.toolNames), len(names))
+			if len(c.toolNames) > 0 {
+				assert.Equal(t, c.toolNames, names)
+			}
+		})
+	}
+}
+
+func TestToolCallStreamParser(t *testing.T) {
+	cases := []struct {
+		name      string
+		chunks    []string
+		output    string
+		toolNames []string
+	}{
+		{
+			name:   "plain text passes through",
+			chunks: []string{"Hello", ", world"},
+			output: "Hello, world",
+		},
+		{
+			name:      "call split across chunks",
+			chunks:    []string{"Sure <tool", "_call>{\"name\": \"a\", ", "\"arguments\": {\"x\": 1}}</tool", "_call> done"},
+			output:    "Sure  done",
+			toolNames: []string{"a"},
+		},
+		{
+			name:   "partial tag that turns out to be text",
+			chunks: []string{"a <tool", "box> b"},
+			output: "a <toolbox> b",
+		},
+		{
+			name:   "partial tag at the end of the stream",
+			chunks: []string{"a <tool_ca"},
+			output: "a <tool_ca",
+		},
+		{
+			name:   "malformed call is written as text",
+			chunks: []string{"<tool_call>{\"name\": }</tool_call>ok"},
+			output: "<tool_call>{\"name\": }</tool_call>ok",
+		},
+		{
+			name:      "unclosed call parsed on flush",
+			chunks:    []string{"<tool_call>{\"name\": \"a\", \"arguments\": {}}"},
+			toolNames: []string{"a"},
+		},
+		{
+			name:   "unclosed garbage written on flush",
+			chunks: []string{"<tool_call>{\"name\": \"a\", \"argu"},
+			output: "<tool_call>{\"name\": \"a\", \"argu",
+		},
+		{
+			name:      "two calls in one chunk",
+			chunks:    []string{"<tool_call>{\"name\": \"a\"}</tool_call><tool_call>{\"name\": \"b\"}</tool_call>"},
+			toolNames: []string{"a", "b"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var parser toolCallStreamParser
+			var output string
+			var names []string
+
+			collect := func(text string, toolCalls []*types.ChatCompletionToolCalls) {
+				output += text
+				for _, toolCall := range toolCalls {
+					assert.Equal(t, len(names), toolCall.Index)
+					names = append(names, toolCall.Function.Name)
+				}
+			}
+
+			for _, chunk := range c.chunks {
+				collect(parser.feed(chunk))
+			}
+			collect(parser.flush())
+
+			assert.Equal(t, c.output, output)
+			assert.Equal(t, c.toolNames, names)
+			assert.Equal(t, len(c.toolNames), parser.count)
+		})
+	}
+}
+
+func TestPartialTagLength(t *testing.T) {
+	assert.Equal(t, 0, partialTagLength("hello", toolCallStartTag))
+	assert.Equal(t, 1, partialTagLength("hello <", toolCallStartTag))
+	assert.Equal(t, 5, partialTagLength("hello <tool", toolCallStartTag))
+	assert.Equal(t, 0, partialTagLength("hello <tool_call>", toolCallStartTag))
+	assert.Equal(t, 0, partialTagLength("", toolCallStartTag))
+}
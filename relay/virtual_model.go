@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"done-hub/common/config"
+	"done-hub/common/logger"
+	"done-hub/common/utils"
+	"done-hub/model"
+	providersBase "done-hub/providers/base"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// virtualModelRoute 虚拟模型在一次请求中的降级进度，保存在上下文中，重试时沿着模型链继续
+type virtualModelRoute struct {
+	Name     string
+	Models   []string
+	Index    int      // 当前使用的真实模型
+	Attempts int      // 当前模型已经选择渠道的次数
+	Path     []string // 实际尝试过的真实模型，记录到日志中
+}
+
+func getVirtualModelRoute(c *gin.Context) *virtualModelRoute {
+	route, _ := utils.GetGinValue[*virtualModelRoute](c, "virtual_model_route")
+	return route
+}
+
+// getVirtualModelProvider 当前真实模型没有可用渠道或用完了重试次数时，换用链中的下一个模型
+// 每个真实模型最多选择 RetryTimes+1 次渠道，与普通请求的重试次数一致
+func getVirtualModelProvider(c *gin.Context, name string, chain []string) (providersBase.ProviderInterface, string, error) {
+	route := getVirtualModelRoute(c)
+	if route == nil || route.Name != name {
+		route = &virtualModelRoute{Name: name, Models: chain}
+		c.Set("virtual_model_route", route)
+	}
+
+	maxAttempts := config.RetryTimes + 1
+	var lastErr error
+	for ; route.Index < len(route.Models); route.next(c) {
+		if route.Attempts >= maxAttempts {
+			continue
+		}
+
+		realModel := route.Models[route.Index]
+		provider, newModelName, err := getProviderByModel(c, realModel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if provider == nil {
+			// 分组不存在，已经写入了错误响应
+			return nil, "", nil
+		}
+
+		route.Attempts++
+		if len(route.Path) == 0 || route.Path[len(route.Path)-1] != realModel {
+			route.Path = append(route.Path, realModel)
+		}
+		c.Set("virtual_model", route.Name)
+		c.Set("virtual_model_chain", route.Path)
+
+		return provider, newModelName, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("虚拟模型 %s 的所有模型均不可用", name)
+	}
+	return nil, "", lastErr
+}
+
+// next 切换到链中的下一个模型，上一个模型失败的渠道可能仍然可以提供下一个模型
+func (r *virtualModelRoute) next(c *gin.Context) {
+	if r.Index < len(r.Models) && r.Attempts > 0 {
+		logger.LogWarn(c.Request.Context(), fmt.Sprintf("virtual_model_fallback model=%s from=%s attempts=%d",
+			r.Name, r.Models[r.Index], r.Attempts))
+	}
+
+	r.Index++
+	r.Attempts = 0
+	c.Set("skip_channel_ids", []int{})
+}
+
+// virtualModelRetryBudget 虚拟模型的可用渠道数为链中剩余模型的渠道数之和
+// 重试次数为每个模型可尝试的渠道数之和减去首次请求
+func virtualModelRetryBudget(c *gin.Context, groupName string) (totalChannels int, retryTimes int, ok bool) {
+	route := getVirtualModelRoute(c)
+	if route == nil {
+		return 0, 0, false
+	}
+
+	attempts := 0
+	for _, modelName := range route.Models[route.Index:] {
+		matchedModelName, err := model.ChannelGroup.GetMatchedModelName(groupName, modelName)
+		if err != nil {
+			continue
+		}
+
+		channels := model.ChannelGroup.CountAvailableChannels(groupName, matchedModelName)
+		totalChannels += channels
+		attempts += min(channels, config.RetryTimes+1)
+	}
+
+	return totalChannels, max(attempts-1, 0), true
+}
@@ -163,6 +163,38 @@ func SetApiRouter(router *gin.Engine) {
 			modelInfoRoute.DELETE("/:id", controller.DeleteModelInfo)
 		}
 
+		mcpServerRoute := apiRouter.Group("/mcp_server")
+		mcpServerRoute.Use(middleware.AdminAuth())
+		{
+			mcpServerRoute.GET("/", controller.GetAllMcpServers)
+			mcpServerRoute.GET("/:id", controller.GetMcpServer)
+			mcpServerRoute.GET("/:id/tools", controller.GetMcpServerTools)
+			mcpServerRoute.POST("/", controller.CreateMcpServer)
+			mcpServerRoute.PUT("/", controller.UpdateMcpServer)
+			mcpServerRoute.DELETE("/:id", controller.DeleteMcpServer)
+		}
+
+		virtualModelRoute := apiRouter.Group("/virtual_model")
+		virtualModelRoute.Use(middleware.AdminAuth())
+		{
+			virtualModelRoute.GET("/", controller.GetAllVirtualModels)
+			virtualModelRoute.GET("/:id", controller.GetVirtualModel)
+			virtualModelRoute.POST("/", controller.CreateVirtualModel)
+			virtualModelRoute.PUT("/", controller.UpdateVirtualModel)
+			virtualModelRoute.DELETE("/:id", controller.DeleteVirtualModel)
+		}
+
+		shadowRoute := apiRouter.Group("/shadow")
+		shadowRoute.Use(middleware.AdminAuth())
+		{
+			shadowRoute.GET("/rule", controller.GetAllShadowRules)
+			shadowRoute.GET("/rule/:id", controller.GetShadowRule)
+			shadowRoute.POST("/rule", controller.CreateShadowRule)
+			shadowRoute.PUT("/rule", controller.UpdateShadowRule)
+			shadowRoute.DELETE("/rule/:id", controller.DeleteShadowRule)
+			shadowRoute.GET("/comparison", controller.GetShadowComparisonsList)
+		}
+
 		userGroup := apiRouter.Group("/user_group")
 		userGroup.Use(middleware.AdminAuth())
 		{
@@ -180,11 +212,18 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/", controller.GetChannelsList)
 			channelRoute.GET("/models", relay.ListModelsForAdmin)
 			channelRoute.POST("/provider_models_list", controller.GetModelList)
+			channelRoute.POST("/rewrite/dry_run", controller.DryRunChannelRewrite)
+			channelRoute.GET("/balance_weights", controller.GetChannelBalanceWeights)
+			channelRoute.GET("/cache_stats", controller.GetChannelCacheStats)
+			channelRoute.GET("/schedule_calendar", controller.GetChannelScheduleCalendar)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/rate_limit/:id", controller.GetChannelRateLimit)
+			channelRoute.GET("/keys/:id", controller.GetChannelKeys)
+			channelRoute.PUT("/keys/:id", controller.UpdateChannelKeyStatus)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.PUT("/batch/azure_api", controller.BatchUpdateChannelsAzureApi)
@@ -3,6 +3,8 @@ package router
 import (
 	"done-hub/middleware"
 	"done-hub/relay"
+	"done-hub/relay/batch"
+	"done-hub/relay/files"
 	"done-hub/relay/midjourney"
 	"done-hub/relay/task"
 	"done-hub/relay/task/kling"
@@ -21,6 +23,7 @@ func SetRelayRouter(router *gin.Engine) {
 	setGeminiRouter(router)
 	setRecraftRouter(router)
 	setKlingRouter(router)
+	setOllamaRouter(router)
 }
 
 func setOpenAIRouter(router *gin.Engine) {
@@ -36,6 +39,8 @@ func setOpenAIRouter(router *gin.Engine) {
 		relayV1Router.POST("/completions", relay.Relay)
 		relayV1Router.POST("/chat/completions", relay.Relay)
 		relayV1Router.POST("/responses", relay.Relay)
+		relayV1Router.GET("/responses/:id", relay.RetrieveResponse)
+		relayV1Router.DELETE("/responses/:id", relay.DeleteResponse)
 		// relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", relay.Relay)
 		relayV1Router.POST("/images/edits", relay.Relay)
@@ -49,16 +54,26 @@ func setOpenAIRouter(router *gin.Engine) {
 		relayV1Router.POST("/rerank", relay.RelayRerank)
 		relayV1Router.GET("/realtime", relay.ChatRealtime)
 
+		relayV1Router.POST("/batches", batch.CreateBatch)
+		relayV1Router.GET("/batches", batch.ListBatches)
+		relayV1Router.GET("/batches/:id", batch.RetrieveBatch)
+		relayV1Router.POST("/batches/:id/cancel", batch.CancelBatch)
+		relayV1Router.GET("/batches/:id/output", batch.GetBatchOutput)
+		relayV1Router.GET("/batches/:id/errors", batch.GetBatchErrors)
+
+		relayV1Router.POST("/files", files.UploadFile)
+		relayV1Router.GET("/files", files.ListFiles)
+		relayV1Router.GET("/files/:id", files.RetrieveFile)
+		relayV1Router.DELETE("/files/:id", files.DeleteFile)
+		relayV1Router.GET("/files/:id/content", files.GetFileContent)
+
 		relayV1Router.Use(middleware.SpecifiedChannel())
 		{
-			relayV1Router.Any("/files", relay.RelayOnly)
-			relayV1Router.Any("/files/*any", relay.RelayOnly)
 			relayV1Router.Any("/fine_tuning/*any", relay.RelayOnly)
 			relayV1Router.Any("/assistants", relay.RelayOnly)
 			relayV1Router.Any("/assistants/*any", relay.RelayOnly)
 			relayV1Router.Any("/threads", relay.RelayOnly)
 			relayV1Router.Any("/threads/*any", relay.RelayOnly)
-			relayV1Router.Any("/batches/*any", relay.RelayOnly)
 			relayV1Router.Any("/vector_stores/*any", relay.RelayOnly)
 			relayV1Router.DELETE("/models/:model", relay.RelayOnly)
 		}
@@ -113,6 +128,7 @@ func setClaudeRouter(router *gin.Engine) {
 	relayV1Router.Use(middleware.APIEnabled("claude"), middleware.RelayCluadePanicRecover(), middleware.ClaudeAuth(), middleware.ContextUserId(), middleware.Distribute(), middleware.DynamicRedisRateLimiter())
 	{
 		relayV1Router.POST("/messages", relay.Relay)
+		relayV1Router.POST("/messages/count_tokens", relay.CountClaudeTokens)
 		relayV1Router.GET("/models", relay.ListClaudeModelsByToken)
 	}
 }
@@ -150,3 +166,16 @@ func setKlingRouter(router *gin.Engine) {
 		relayKlingRouter.POST("/v1/:class/:action", task.RelayTaskSubmit)
 	}
 }
+
+func setOllamaRouter(router *gin.Engine) {
+	relayOllamaRouter := router.Group("/ollama/api")
+	relayOllamaRouter.GET("/version", relay.OllamaVersion)
+	relayOllamaRouter.Use(middleware.APIEnabled("ollama"), middleware.RelayPanicRecover(), middleware.OpenaiAuth(), middleware.ContextUserId(), middleware.Distribute(), middleware.DynamicRedisRateLimiter())
+	{
+		relayOllamaRouter.POST("/chat", relay.Relay)
+		relayOllamaRouter.POST("/generate", relay.Relay)
+		relayOllamaRouter.POST("/embed", relay.Relay)
+		relayOllamaRouter.POST("/embeddings", relay.Relay)
+		relayOllamaRouter.GET("/tags", relay.ListOllamaModelsByToken)
+	}
+}
@@ -13,6 +13,36 @@ const (
 	EventTypeError          = "error"
 )
 
+// 转换协议时使用的 OpenAI Realtime 事件
+const (
+	EventTypeSessionUpdate                    = "session.update"
+	EventTypeSessionUpdated                   = "session.updated"
+	EventTypeInputAudioBufferAppend           = "input_audio_buffer.append"
+	EventTypeInputAudioBufferCommit           = "input_audio_buffer.commit"
+	EventTypeInputAudioBufferClear            = "input_audio_buffer.clear"
+	EventTypeInputAudioBufferCommitted        = "input_audio_buffer.committed"
+	EventTypeInputAudioBufferCleared          = "input_audio_buffer.cleared"
+	EventTypeInputAudioBufferSpeechStarted    = "input_audio_buffer.speech_started"
+	EventTypeConversationItemCreate           = "conversation.item.create"
+	EventTypeConversationItemCreated          = "conversation.item.created"
+	EventTypeInputAudioTranscriptionDelta     = "conversation.item.input_audio_transcription.delta"
+	EventTypeInputAudioTranscriptionCompleted = "conversation.item.input_audio_transcription.completed"
+	EventTypeResponseCreate                   = "response.create"
+	EventTypeResponseCancel                   = "response.cancel"
+	EventTypeResponseCreated                  = "response.created"
+	EventTypeResponseOutputItemAdded          = "response.output_item.added"
+	EventTypeResponseOutputItemDone           = "response.output_item.done"
+	EventTypeResponseContentPartAdded         = "response.content_part.added"
+	EventTypeResponseContentPartDone          = "response.content_part.done"
+	EventTypeResponseTextDelta                = "response.text.delta"
+	EventTypeResponseTextDone                 = "response.text.done"
+	EventTypeResponseAudioDelta               = "response.audio.delta"
+	EventTypeResponseAudioDone                = "response.audio.done"
+	EventTypeResponseAudioTranscriptDelta     = "response.audio_transcript.delta"
+	EventTypeResponseAudioTranscriptDone      = "response.audio_transcript.done"
+	EventTypeResponseFunctionCallArgsDone     = "response.function_call_arguments.done"
+)
+
 type Event struct {
 	EventId     string         `json:"event_id"`
 	Type        string         `json:"type"`
@@ -62,10 +92,11 @@ func (e *Event) Error() string {
 }
 
 type ResponseEvent struct {
-	ID     string      `json:"id"`
-	Object string      `json:"object"`
-	Status string      `json:"status"`
-	Usage  *UsageEvent `json:"usage,omitempty"`
+	ID     string         `json:"id"`
+	Object string         `json:"object"`
+	Status string         `json:"status"`
+	Output []RealtimeItem `json:"output,omitempty"`
+	Usage  *UsageEvent    `json:"usage,omitempty"`
 }
 
 type UsageEvent struct {
@@ -129,3 +160,77 @@ func (u *UsageEvent) Merge(other *UsageEvent) {
 	u.InputTokenDetails.Merge(&other.InputTokenDetails)
 	u.OutputTokenDetails.Merge(&other.OutputTokenDetails)
 }
+
+type RealtimeSession struct {
+	ID                      string          `json:"id,omitempty"`
+	Object                  string          `json:"object,omitempty"`
+	Model                   string          `json:"model,omitempty"`
+	Modalities              []string        `json:"modalities,omitempty"`
+	Instructions            string          `json:"instructions,omitempty"`
+	Voice                   string          `json:"voice,omitempty"`
+	InputAudioFormat        string          `json:"input_audio_format,omitempty"`
+	OutputAudioFormat       string          `json:"output_audio_format,omitempty"`
+	InputAudioTranscription json.RawMessage `json:"input_audio_transcription,omitempty"`
+	TurnDetection           json.RawMessage `json:"turn_detection,omitempty"`
+	Tools                   []RealtimeTool  `json:"tools,omitempty"`
+	ToolChoice              any             `json:"tool_choice,omitempty"`
+	Temperature             *float64        `json:"temperature,omitempty"`
+	MaxResponseOutputTokens any             `json:"max_response_output_tokens,omitempty"`
+}
+
+type RealtimeTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type RealtimeItem struct {
+	ID        string            `json:"id,omitempty"`
+	Object    string            `json:"object,omitempty"`
+	Type      string            `json:"type"`
+	Status    string            `json:"status,omitempty"`
+	Role      string            `json:"role,omitempty"`
+	Content   []RealtimeContent `json:"content,omitempty"`
+	CallID    string            `json:"call_id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+	Output    string            `json:"output,omitempty"`
+}
+
+type RealtimeContent struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	Audio      string `json:"audio,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// RealtimeClientEvent 客户端发送的事件
+type RealtimeClientEvent struct {
+	EventId  string           `json:"event_id,omitempty"`
+	Type     string           `json:"type"`
+	Session  *RealtimeSession `json:"session,omitempty"`
+	Audio    string           `json:"audio,omitempty"`
+	Item     *RealtimeItem    `json:"item,omitempty"`
+	Response json.RawMessage  `json:"response,omitempty"`
+}
+
+// RealtimeServerEvent 服务端发送的事件，只包含转换协议时用到的字段
+type RealtimeServerEvent struct {
+	EventId      string           `json:"event_id"`
+	Type         string           `json:"type"`
+	Session      *RealtimeSession `json:"session,omitempty"`
+	Response     *ResponseEvent   `json:"response,omitempty"`
+	ResponseId   string           `json:"response_id,omitempty"`
+	ItemId       string           `json:"item_id,omitempty"`
+	OutputIndex  *int             `json:"output_index,omitempty"`
+	ContentIndex *int             `json:"content_index,omitempty"`
+	Item         *RealtimeItem    `json:"item,omitempty"`
+	Part         *RealtimeContent `json:"part,omitempty"`
+	Delta        string           `json:"delta,omitempty"`
+	Text         *string          `json:"text,omitempty"`
+	Transcript   *string          `json:"transcript,omitempty"`
+	CallId       string           `json:"call_id,omitempty"`
+	Name         string           `json:"name,omitempty"`
+	Arguments    string           `json:"arguments,omitempty"`
+}
@@ -558,6 +558,51 @@ func (m ResponsesOutput) GetSummaryString() string {
 	return summary
 }
 
+// ToInputResponses 将输出项转换为后续请求的输入项，用于根据已保存的响应重建对话
+// 只保留转换为 chat 时需要的消息与函数调用，其它类型返回 nil
+func (m ResponsesOutput) ToInputResponses() *InputResponses {
+	switch m.Type {
+	case InputTypeMessage:
+		content := m.StringContent()
+		if content == "" {
+			contents := make([]ContentResponses, 0)
+			contentBytes, _ := json.Marshal(m.Content)
+			if json.Unmarshal(contentBytes, &contents) == nil {
+				for _, item := range contents {
+					if item.Type == ContentTypeOutputText {
+						content += item.Text
+					}
+				}
+			}
+		}
+
+		if content == "" {
+			return nil
+		}
+
+		return &InputResponses{
+			Type: InputTypeMessage,
+			Role: "assistant",
+			Content: []ContentResponses{{
+				Type: ContentTypeOutputText,
+				Text: content,
+			}},
+		}
+	case InputTypeFunctionCall:
+		input := &InputResponses{
+			Type:   InputTypeFunctionCall,
+			CallID: m.CallID,
+			Name:   m.Name,
+		}
+		if m.Arguments != nil {
+			input.Arguments = *m.Arguments
+		}
+		return input
+	default:
+		return nil
+	}
+}
+
 type IncompleteDetail struct {
 	Reason string `json:"reason,omitempty"`
 }
@@ -595,6 +640,12 @@ type ResponsesOutput struct {
 	RevisedPrompt any    `json:"revised_prompt,omitempty"` // The revised prompt for the image generation call.
 }
 
+type OpenAIResponsesDeleteResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
 type ResponsesOutputToolCall struct {
 	ID string `json:"id"`
 }
@@ -42,7 +42,7 @@ import CheckBoxOutlineBlankIcon from '@mui/icons-material/CheckBoxOutlineBlank'
 import CheckBoxIcon from '@mui/icons-material/CheckBox'
 import { useTranslation } from 'react-i18next'
 import useCustomizeT from 'hooks/useCustomizeT'
-import { PreCostType } from '../type/other'
+import { KeySelectionType, PreCostType } from '../type/other'
 import MapInput from './MapInput'
 import ListInput from './ListInput'
 import ModelSelectorModal from './ModelSelectorModal'
@@ -73,7 +73,13 @@ const getValidationSchema = (t) =>
     }),
     model_mapping: Yup.array(),
     model_headers: Yup.array(),
-    custom_parameter: Yup.string().nullable()
+    custom_parameter: Yup.string().nullable(),
+    rewrite_rules: Yup.string().nullable(),
+    max_concurrency: Yup.number().min(0).integer(),
+    rate_limits: Yup.string().nullable(),
+    multi_key: Yup.boolean(),
+    key_selection: Yup.string().nullable(),
+    schedules: Yup.string().nullable()
   })
 
 const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, modelOptions, prices }) => {
@@ -816,10 +822,52 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
       }
     }
 
+    if (values.rewrite_rules) {
+      try {
+        if (!Array.isArray(JSON.parse(values.rewrite_rules))) {
+          showError('rewrite_rules must be a JSON array')
+          return
+        }
+      } catch (error) {
+        showError('Error parsing rewrite_rules: ' + error.message)
+        return
+      }
+    }
+
+    if (values.rate_limits) {
+      try {
+        const rateLimits = JSON.parse(values.rate_limits)
+        if (typeof rateLimits !== 'object' || rateLimits === null || Array.isArray(rateLimits)) {
+          showError('rate_limits must be a JSON object')
+          return
+        }
+      } catch (error) {
+        showError('Error parsing rate_limits: ' + error.message)
+        return
+      }
+    }
+
+    if (values.schedules) {
+      try {
+        const schedules = JSON.parse(values.schedules)
+        if (typeof schedules !== 'object' || schedules === null || Array.isArray(schedules)) {
+          showError('schedules must be a JSON object')
+          return
+        }
+      } catch (error) {
+        showError('Error parsing schedules: ' + error.message)
+        return
+      }
+    }
+
     if (values.disabled_stream) {
       values.disabled_stream = removeDuplicates(values.disabled_stream)
     }
 
+    if (values.emulated_tools) {
+      values.emulated_tools = removeDuplicates(values.emulated_tools)
+    }
+
     // 获取现有的模型 ID
     const existingModelIds = values.models.map((model) => model.id)
 
@@ -958,6 +1006,36 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
           data.custom_parameter = ''
         }
 
+        if (data.rewrite_rules) {
+          try {
+            data.rewrite_rules = JSON.stringify(JSON.parse(data.rewrite_rules), null, 2)
+          } catch (error) {
+            // If parsing fails, keep the original string
+          }
+        } else {
+          data.rewrite_rules = ''
+        }
+
+        if (data.rate_limits) {
+          try {
+            data.rate_limits = JSON.stringify(JSON.parse(data.rate_limits), null, 2)
+          } catch (error) {
+            // If parsing fails, keep the original string
+          }
+        } else {
+          data.rate_limits = ''
+        }
+
+        if (data.schedules) {
+          try {
+            data.schedules = JSON.stringify(JSON.parse(data.schedules), null, 2)
+          } catch (error) {
+            // If parsing fails, keep the original string
+          }
+        } else {
+          data.schedules = ''
+        }
+
         data.base_url = data.base_url ?? ''
         data.is_edit = true
         if (data.plugin === null) {
@@ -1411,7 +1489,7 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
                 </Container>
                 <FormControl fullWidth error={Boolean(touched.key && errors.key)}
                              sx={{ ...theme.typography.otherInput }}>
-                  {!batchAdd ? (
+                  {!batchAdd && !values.multi_key ? (
                     <>
                       <InputLabel htmlFor="channel-key-label">{customizeT(inputLabel.key)}</InputLabel>
                       <OutlinedInput
@@ -1813,6 +1891,65 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
                     )}
                   </FormControl>
                 )}
+                {inputPrompt.rewrite_rules && (
+                  <FormControl
+                    fullWidth
+                    error={Boolean(touched.rewrite_rules && errors.rewrite_rules)}
+                    sx={{ ...theme.typography.otherInput }}
+                  >
+                    <InputLabel shrink htmlFor="channel-rewrite_rules-label">
+                      {customizeT(inputLabel.rewrite_rules)}
+                    </InputLabel>
+                    <Box
+                      sx={{
+                        border: '1px solid',
+                        borderColor: touched.rewrite_rules && errors.rewrite_rules ? 'error.main' : 'divider',
+                        borderRadius: 1,
+                        overflow: 'hidden',
+                        marginTop: 2, // Add some margin for the label
+                        resize: 'vertical',
+                        height: '200px',
+                        minHeight: '100px',
+                        '&:hover': {
+                          borderColor: 'primary.main'
+                        },
+                        '&:focus-within': {
+                          borderColor: 'primary.main',
+                          borderWidth: 2
+                        }
+                      }}
+                    >
+                      <Editor
+                        height="100%"
+                        language="json"
+                        theme={theme.palette.mode === 'dark' ? 'vs-dark' : 'light'}
+                        value={values.rewrite_rules}
+                        options={{
+                          minimap: { enabled: false },
+                          scrollBeyondLastLine: false,
+                          automaticLayout: true,
+                          fontSize: 14,
+                          lineNumbers: 'on',
+                          folding: true,
+                          formatOnPaste: true,
+                          formatOnType: true
+                        }}
+                        onChange={(value) => {
+                          setFieldValue('rewrite_rules', value);
+                        }}
+                      />
+                    </Box>
+                    {touched.rewrite_rules && errors.rewrite_rules ? (
+                      <FormHelperText error id="helper-tex-channel-rewrite_rules-label">
+                        {errors.rewrite_rules}
+                      </FormHelperText>
+                    ) : (
+                      <FormHelperText id="helper-tex-channel-rewrite_rules-label">
+                        {customizeT(inputPrompt.rewrite_rules)}
+                      </FormHelperText>
+                    )}
+                  </FormControl>
+                )}
                 {inputPrompt.disabled_stream && (
                   <FormControl
                     fullWidth
@@ -1833,6 +1970,26 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
                     />
                   </FormControl>
                 )}
+                {inputPrompt.emulated_tools && (
+                  <FormControl
+                    fullWidth
+                    error={Boolean(touched.emulated_tools && errors.emulated_tools)}
+                    sx={{ ...theme.typography.otherInput }}
+                  >
+                    <ListInput
+                      listValue={values.emulated_tools}
+                      onChange={(newValue) => {
+                        setFieldValue('emulated_tools', newValue)
+                      }}
+                      disabled={hasTag}
+                      error={Boolean(touched.emulated_tools && errors.emulated_tools)}
+                      label={{
+                        name: customizeT(inputLabel.emulated_tools),
+                        itemName: customizeT(inputPrompt.emulated_tools)
+                      }}
+                    />
+                  </FormControl>
+                )}
 
                 <FormControl fullWidth error={Boolean(touched.proxy && errors.proxy)}
                              sx={{ ...theme.typography.otherInput }}>
@@ -1941,6 +2098,189 @@ const EditModal = ({ open, channelId, onCancel, onOk, groupOptions, isTag, model
                     )}
                   </FormControl>
                 )}
+                {inputPrompt.max_concurrency && (
+                  <FormControl fullWidth error={Boolean(touched.max_concurrency && errors.max_concurrency)}
+                               sx={{ ...theme.typography.otherInput }}>
+                    <InputLabel htmlFor="channel-max_concurrency-label">{customizeT(inputLabel.max_concurrency)}</InputLabel>
+                    <OutlinedInput
+                      id="channel-max_concurrency-label"
+                      label={customizeT(inputLabel.max_concurrency)}
+                      type="number"
+                      value={values.max_concurrency}
+                      name="max_concurrency"
+                      onBlur={handleBlur}
+                      onChange={handleChange}
+                      inputProps={{ min: 0 }}
+                      aria-describedby="helper-text-channel-max_concurrency-label"
+                    />
+                    {touched.max_concurrency && errors.max_concurrency ? (
+                      <FormHelperText error id="helper-tex-channel-max_concurrency-label">
+                        {errors.max_concurrency}
+                      </FormHelperText>
+                    ) : (
+                      <FormHelperText
+                        id="helper-tex-channel-max_concurrency-label"> {customizeT(inputPrompt.max_concurrency)} </FormHelperText>
+                    )}
+                  </FormControl>
+                )}
+                {inputPrompt.rate_limits && (
+                  <FormControl
+                    fullWidth
+                    error={Boolean(touched.rate_limits && errors.rate_limits)}
+                    sx={{ ...theme.typography.otherInput }}
+                  >
+                    <InputLabel shrink htmlFor="channel-rate_limits-label">
+                      {customizeT(inputLabel.rate_limits)}
+                    </InputLabel>
+                    <Box
+                      sx={{
+                        border: '1px solid',
+                        borderColor: touched.rate_limits && errors.rate_limits ? 'error.main' : 'divider',
+                        borderRadius: 1,
+                        overflow: 'hidden',
+                        marginTop: 2, // Add some margin for the label
+                        resize: 'vertical',
+                        height: '150px',
+                        minHeight: '100px',
+                        '&:hover': {
+                          borderColor: 'primary.main'
+                        },
+                        '&:focus-within': {
+                          borderColor: 'primary.main',
+                          borderWidth: 2
+                        }
+                      }}
+                    >
+                      <Editor
+                        height="100%"
+                        language="json"
+                        theme={theme.palette.mode === 'dark' ? 'vs-dark' : 'light'}
+                        value={values.rate_limits}
+                        options={{
+                          minimap: { enabled: false },
+                          scrollBeyondLastLine: false,
+                          automaticLayout: true,
+                          fontSize: 14,
+                          lineNumbers: 'on',
+                          folding: true,
+                          formatOnPaste: true,
+                          formatOnType: true
+                        }}
+                        onChange={(value) => {
+                          setFieldValue('rate_limits', value);
+                        }}
+                      />
+                    </Box>
+                    {touched.rate_limits && errors.rate_limits ? (
+                      <FormHelperText error id="helper-tex-channel-rate_limits-label">
+                        {errors.rate_limits}
+                      </FormHelperText>
+                    ) : (
+                      <FormHelperText id="helper-tex-channel-rate_limits-label">
+                        {customizeT(inputPrompt.rate_limits)}
+                      </FormHelperText>
+                    )}
+                  </FormControl>
+                )}
+                {inputPrompt.schedules && (
+                  <FormControl
+                    fullWidth
+                    error={Boolean(touched.schedules && errors.schedules)}
+                    sx={{ ...theme.typography.otherInput }}
+                  >
+                    <InputLabel shrink htmlFor="channel-schedules-label">
+                      {customizeT(inputLabel.schedules)}
+                    </InputLabel>
+                    <Box
+                      sx={{
+                        border: '1px solid',
+                        borderColor: touched.schedules && errors.schedules ? 'error.main' : 'divider',
+                        borderRadius: 1,
+                        overflow: 'hidden',
+                        marginTop: 2, // Add some margin for the label
+                        resize: 'vertical',
+                        height: '150px',
+                        minHeight: '100px',
+                        '&:hover': {
+                          borderColor: 'primary.main'
+                        },
+                        '&:focus-within': {
+                          borderColor: 'primary.main',
+                          borderWidth: 2
+                        }
+                      }}
+                    >
+                      <Editor
+                        height="100%"
+                        language="json"
+                        theme={theme.palette.mode === 'dark' ? 'vs-dark' : 'light'}
+                        value={values.schedules}
+                        options={{
+                          minimap: { enabled: false },
+                          scrollBeyondLastLine: false,
+                          automaticLayout: true,
+                          fontSize: 14,
+                          lineNumbers: 'on',
+                          folding: true,
+                          formatOnPaste: true,
+                          formatOnType: true
+                        }}
+                        onChange={(value) => {
+                          setFieldValue('schedules', value);
+                        }}
+                      />
+                    </Box>
+                    {touched.schedules && errors.schedules ? (
+                      <FormHelperText error id="helper-tex-channel-schedules-label">
+                        {errors.schedules}
+                      </FormHelperText>
+                    ) : (
+                      <FormHelperText id="helper-tex-channel-schedules-label">
+                        {customizeT(inputPrompt.schedules)}
+                      </FormHelperText>
+                    )}
+                  </FormControl>
+                )}
+                {inputPrompt.multi_key && (
+                  <FormControl fullWidth>
+                    <FormControlLabel
+                      control={
+                        <Switch
+                          checked={Boolean(values.multi_key)}
+                          onChange={(event) => {
+                            setFieldValue('multi_key', event.target.checked)
+                          }}
+                        />
+                      }
+                      label={customizeT(inputLabel.multi_key)}
+                    />
+                    <FormHelperText id="helper-tex-multi_key-label">{customizeT(inputPrompt.multi_key)}</FormHelperText>
+                  </FormControl>
+                )}
+                {inputPrompt.key_selection && values.multi_key && (
+                  <FormControl fullWidth sx={{ ...theme.typography.otherInput }}>
+                    <InputLabel htmlFor="channel-key_selection-label">{customizeT(inputLabel.key_selection)}</InputLabel>
+                    <Select
+                      id="channel-key_selection-label"
+                      label={customizeT(inputLabel.key_selection)}
+                      value={values.key_selection || 'round_robin'}
+                      name="key_selection"
+                      onBlur={handleBlur}
+                      onChange={handleChange}
+                    >
+                      {KeySelectionType.map((option) => {
+                        return (
+                          <MenuItem key={option.value} value={option.value}>
+                            {customizeT(option.label)}
+                          </MenuItem>
+                        )
+                      })}
+                    </Select>
+                    <FormHelperText id="helper-tex-channel-key_selection-label">
+                      {customizeT(inputPrompt.key_selection)}
+                    </FormHelperText>
+                  </FormControl>
+                )}
                 {inputPrompt.compatible_response && (
                   <FormControl fullWidth>
                     <FormControlLabel
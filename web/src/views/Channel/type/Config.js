@@ -10,13 +10,20 @@ const defaultConfig = {
     model_mapping: [],
     model_headers: [],
     custom_parameter: '',
+    rewrite_rules: '',
     models: [],
     groups: ['default'],
     plugin: {},
     tag: '',
     only_chat: false,
     pre_cost: 1,
+    max_concurrency: 0,
+    rate_limits: '',
+    schedules: '',
+    multi_key: false,
+    key_selection: 'round_robin',
     disabled_stream: [],
+    emulated_tools: [],
     compatible_response: false
   },
   inputLabel: {
@@ -31,12 +38,19 @@ const defaultConfig = {
     model_mapping: '模型映射关系',
     model_headers: '自定义模型请求头',
     custom_parameter: '额外参数',
+    rewrite_rules: '改写规则',
     groups: '用户组',
     only_chat: '仅支持聊天',
     tag: '标签',
     provider_models_list: '',
     pre_cost: '预计费选项',
+    max_concurrency: '最大并发数',
+    rate_limits: 'RPM/TPM 额度',
+    schedules: '可用时间窗口',
+    multi_key: '多密钥模式',
+    key_selection: '密钥选择方式',
     disabled_stream: '禁用流式的模型',
+    emulated_tools: '模拟工具调用的模型',
     compatible_response: '兼容Response API'
   },
   prompt: {
@@ -54,13 +68,24 @@ const defaultConfig = {
     model_headers: '自定义模型请求头，例如：{"key": "value"}',
     custom_parameter:
       '支持通过 JSON 注入额外参数（可嵌套）。可用控制项：overwrite：设为 true 覆盖同名字段，未设置或 false 时仅补充缺失字段；per_model：设为 true 后按模型名进行参数覆盖，如 {"per_model":true,"gpt-3.5-turbo":{"temperature": 0.7},"gpt-4":{"temperature": 0.5}}；pre_add：设为 true 时在请求入口即完成参数覆盖，否则会在发送请求前再进行参数覆盖，适用于所有渠道（含 Claude、Gemini），如 {"pre_add":true,"overwrite":true,"stream":false}。',
+    rewrite_rules:
+      '请求/响应改写规则，JSON 数组，按顺序执行。op 可选 set、default（字段不存在时设置）、delete、rename（在同一对象中改名为 to）；path 支持 $.a.b、$.messages[*].name、$.tools[0]、$.metadata["user.id"]；phase 为 request 或 response（仅作用于非流式响应）；models 限定生效的模型，支持 * 通配。例如：[{"op":"rename","path":"$.max_tokens","to":"max_completion_tokens","models":["o1*"]},{"op":"delete","path":"$.usage.prompt_tokens_details","phase":"response"}]',
     groups: '请选择该渠道所支持的用户组',
     only_chat: '如果选择了仅支持聊天，那么遇到有函数调用的请求会跳过该渠道',
     provider_models_list: '必须填写所有数据后才能获取模型列表',
     tag: '你可以为你的渠道打一个标签，打完标签后，可以通过标签进行批量管理渠道，注意：设置标签后某些设置只能通过渠道标签修改，无法在渠道列表中修改。',
     pre_cost:
       '这里选择预计费选项，用于预估费用，如果你觉得计算图片占用太多资源，可以选择关闭图片计费。但是请注意：有些渠道在stream下是不会返回tokens的，这会导致输入tokens计算错误。',
+    max_concurrency: '渠道同时进行的请求上限，0 表示不限制。并发已满时会选择其他渠道，所有渠道都已满时请求排队等待',
+    multi_key:
+      '多密钥模式下密钥框中每行一个密钥，所有密钥保存在同一个渠道中按选择方式轮换使用。密钥被限流时暂停使用，鉴权失败或额度耗尽时自动禁用该密钥，所有密钥都不可用时才禁用渠道。关闭时每行一个密钥会创建多个渠道',
+    key_selection: '轮询按顺序依次使用可用的密钥，随机每次随机选择一个可用的密钥',
+    rate_limits:
+      '渠道上游的 RPM/TPM 额度，JSON 对象，0 或不填表示不限制。models 为单个模型设置额度，与渠道整体额度同时生效，支持以 * 结尾的通配。选择渠道时会跳过剩余额度不足的渠道，例如：{"rpm":500,"tpm":150000,"models":{"gpt-4o*":{"rpm":100,"tpm":30000}}}',
+    schedules:
+      '渠道可用的时间窗口，JSON 对象，不填表示全天可用。active 与 inactive 为 cron 表达式（分 时 日 月 周）列表，表达式匹配的每一分钟分别为可用时间和维护时间，维护时间优先；timezone 为时区，默认使用服务器时区；models 为单个模型设置时间窗口，与渠道整体的时间窗口同时生效，支持以 * 结尾的通配。例如：{"timezone":"Asia/Shanghai","active":["* 0-7 * * *"],"inactive":["0-29 3 * * 0"],"models":{"gpt-4o*":{"active":["* 1-5 * * *"]}}}',
     disabled_stream: '这里填写禁用流式的模型，注意：如果填写了禁用流式的模型，那么这些模型在流式请求时会跳过该渠道',
+    emulated_tools: '这里填写不支持原生工具调用的模型，* 表示全部模型。工具定义会写入系统提示词，再从模型回复中解析出工具调用',
     compatible_response: '兼容Response API'
   },
   modelGroup: 'OpenAI'
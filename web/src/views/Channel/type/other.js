@@ -3,3 +3,8 @@ export const PreCostType = [
   { value: 2, label: '不计算图片' },
   { value: 3, label: '全部不计算' }
 ];
+
+export const KeySelectionType = [
+  { value: 'round_robin', label: '轮询' },
+  { value: 'random', label: '随机' }
+];
@@ -66,6 +66,7 @@ const OperationSetting = () => {
     CFWorkerImageKey: '',
     ClaudeAPIEnabled: 'true',
     GeminiAPIEnabled: 'true',
+    OllamaAPIEnabled: 'true',
     DisableChannelKeywords: '',
     EnableSafe: 'false',
     SafeToolName: '',
@@ -651,6 +652,18 @@ const OperationSetting = () => {
                 />
               }
             />
+            <FormControlLabel
+              sx={{ marginLeft: '0px' }}
+              label={t('setting_index.operationSettings.otherSettings.ollamaAPIEnabled')}
+              control={
+                <Checkbox
+                  checked={dataLoaded ? inputs.OllamaAPIEnabled === 'true' : false}
+                  onChange={handleInputChange}
+                  name="OllamaAPIEnabled"
+                  disabled={!dataLoaded || loading}
+                />
+              }
+            />
           </Stack>
           <Stack spacing={2}>
             <Alert severity="info">{t('setting_index.operationSettings.otherSettings.alert')}</Alert>